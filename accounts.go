@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mitchellh/go-homedir"
+	"google.golang.org/api/drive/v3"
+)
+
+// accountConfig describes one Google account to verify, as configured in
+// accounts.json in the config directory:
+//
+//	{"work": {"local": "~/Work Drive", "remote": "/"}, "personal": {"local": "~/Google Drive"}}
+//
+// Each account authorizes separately and keeps its token in token-<name>.json.
+type accountConfig struct {
+	Name   string `json:"-"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// accountResult is the outcome of verifying one account
+type accountResult struct {
+	Account *accountConfig
+	Result  *verifyResult
+	Err     error
+	// SanityProblems are the ways the account failed --require-mounted (in
+	// which case it wasn't verified) or the sanity check
+	SanityProblems []string
+}
+
+func loadAccounts(configDir string, names []string) ([]*accountConfig, error) {
+	configPath := filepath.Join(configDir, "accounts.json")
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read account settings: %v", err)
+	}
+	all := make(map[string]*accountConfig)
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", configPath, err)
+	}
+	var accounts []*accountConfig
+	for _, name := range names {
+		account, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("Account %q not found in %s", name, configPath)
+		}
+		account.Name = name
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// runAccounts verifies each account's remote root against its own local
+// root, using template for all other settings and checking each one's
+// results with sanity, then prints each account's results and a combined
+// summary. It returns the process exit code.
+func runAccounts(configDir string, names []string, parallel bool, template verifyConfig, selection selectiveSync, sanity sanityCheck, requireMounted bool, jsonPath string) int {
+	accounts, err := loadAccounts(configDir, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	// authorize up front, since authorizing a new account is interactive
+	services := make([]*drive.Service, len(accounts))
+	for i, account := range accounts {
+		services[i], err = NewDriveService(filepath.Join(configDir, "credentials.json"), filepath.Join(configDir, fmt.Sprintf("token-%s.json", account.Name)), template.DriveScope)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to connect account %s: %v\n", account.Name, err)
+			return 1
+		}
+	}
+
+	results := make([]*accountResult, len(accounts))
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		verify := func(i int, account *accountConfig) {
+			results[i] = verifyAccount(services[i], account, template, selection, sanity, requireMounted)
+		}
+		if parallel {
+			wg.Add(1)
+			go func(i int, account *accountConfig) {
+				defer wg.Done()
+				verify(i, account)
+			}(i, account)
+		} else {
+			fmt.Printf("Verifying account %s...\n", account.Name)
+			verify(i, account)
+		}
+	}
+	wg.Wait()
+
+	exitCode := 0
+	for _, res := range results {
+		fmt.Printf("\n=== Account %s: %s -> %s ===\n\n", res.Account.Name, res.Account.Remote, res.Account.Local)
+		if res.Err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to verify account %s: %v\n", res.Account.Name, res.Err)
+			if hint := failureHint(res.Err); hint != "" {
+				fmt.Fprintln(os.Stderr, hint)
+			}
+			exitCode = 1
+			continue
+		}
+		if len(res.SanityProblems) > 0 {
+			printSanityProblems(res.SanityProblems)
+			if res.Result != nil {
+				res.Result.Comparison.PrintSummary()
+			}
+			if exitCode != 1 {
+				exitCode = exitSanityCheck
+			}
+			continue
+		}
+		comparison := res.Result.Comparison
+		comparison.PrintResults(false)
+		if comparison.IsSuspect() {
+			comparison.PrintSuspectWarning()
+			if exitCode == 0 {
+				exitCode = exitConfigSuspect
+			}
+		} else if !comparison.IsSuccessful() {
+			exitCode = exitSyncFailure
+		}
+	}
+
+	fmt.Println("\nACCOUNTS:")
+	for _, res := range results {
+		if res.Err != nil {
+			fmt.Printf("%s: error\n", res.Account.Name)
+			continue
+		} else if res.Result == nil {
+			fmt.Printf("%s: not mounted\n", res.Account.Name)
+			continue
+		}
+		comparison := res.Result.Comparison
+		status := "ok"
+		if len(res.SanityProblems) > 0 {
+			status = "sanity check failed"
+		} else if comparison.IsSuspect() {
+			status = "suspect"
+		} else if !comparison.IsSuccessful() {
+			status = "FAILED"
+		}
+		fmt.Printf("%s: %s, %d/%d files matched\n", res.Account.Name, status, comparison.Matches, comparison.Matches+comparison.Misses)
+	}
+
+	if jsonPath != "" {
+		if err := writeAccountsJSONFile(jsonPath, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write JSON results: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\nWrote JSON results to %s\n", jsonPath)
+	}
+	return exitCode
+}
+
+func verifyAccount(srv *drive.Service, account *accountConfig, template verifyConfig, selection selectiveSync, sanity sanityCheck, requireMounted bool) *accountResult {
+	result := &accountResult{Account: account}
+	localArg, err := homedir.Expand(account.Local)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	config := template
+	config.LocalRoot, config.RemoteRoot, config.LocalDirs, err = resolveRoots(localArg, account.Remote, selection)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	account.Local, account.Remote = config.LocalRoot, config.RemoteRoot
+	if requireMounted {
+		if err := checkMounted(config.LocalRoot); err != nil {
+			result.SanityProblems = []string{err.Error()}
+			return result
+		}
+	}
+
+	result.Result, result.Err = runVerification(context.Background(), srv, &config, newScanProgress())
+	if result.Err == nil && !result.Result.Partial {
+		result.SanityProblems = sanity.problems(result.Result.Comparison)
+	}
+	return result
+}
+
+func writeAccountsJSONFile(path string, results []*accountResult) error {
+	reports := make(map[string]interface{})
+	for _, res := range results {
+		if res.Err != nil {
+			reports[res.Account.Name] = map[string]string{"error": res.Err.Error()}
+		} else if res.Result == nil {
+			// not mounted
+			reports[res.Account.Name] = map[string]string{"error": res.SanityProblems[0]}
+		} else {
+			reports[res.Account.Name] = res.Result.Comparison.JSONReport(res.Result.Stats)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{"accounts": reports}); err != nil {
+		return err
+	}
+	return f.Close()
+}
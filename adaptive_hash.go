@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// remoteHashes records which remote paths have a checksum as Google Drive is
+// listed, so that with --adaptive-hash local files whose remote copy has
+// none (Google Docs and the like, and the odd ordinary file) aren't read:
+// they'd only be checked for being there anyway. Local files wait for the
+// listing before being hashed. Every listed path is kept in memory, even
+// when the manifests spill to disk.
+type remoteHashes struct {
+	lock sync.Mutex
+	// hashed is whether any remote file at each path has a checksum
+	hashed map[string]bool
+	ready  chan struct{}
+	// complete is false if the listing failed, in which case every local
+	// file is hashed
+	complete bool
+	// skipped counts local files that weren't hashed
+	skipped int
+}
+
+func newRemoteHashes() *remoteHashes {
+	return &remoteHashes{hashed: make(map[string]bool), ready: make(chan struct{})}
+}
+
+// add records a remote file that will be compared
+func (r *remoteHashes) add(file *File) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hashed[file.Path] = r.hashed[file.Path] || !isHashUnavailable(file)
+}
+
+// finish marks the listing done, letting local hashing continue
+func (r *remoteHashes) finish(complete bool) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	r.complete = complete
+	r.lock.Unlock()
+	close(r.ready)
+}
+
+// needed reports whether the local file at path should be hashed, waiting
+// for the remote listing to finish. Files missing remotely are still hashed,
+// since they may have been moved.
+func (r *remoteHashes) needed(ctx context.Context, path string) bool {
+	if r == nil {
+		return true
+	}
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		return true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	hashed, listed := r.hashed[path]
+	if !r.complete || !listed || hashed {
+		return true
+	}
+	r.skipped++
+	return false
+}
+
+// Skipped counts local files that weren't hashed
+func (r *remoteHashes) Skipped() int {
+	if r == nil {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.skipped
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+)
+
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+type authOptions struct {
+	Account             string `long:"account" description:"Use this account from accounts.json, whose token is in token-<name>.json"`
+	Force               bool   `long:"force" description:"With login, authorize again even if a token is already saved"`
+	TokenStore          string `long:"token-store" description:"Where the Google Drive token is kept: file (token.json in the config directory) or keychain (the OS credential store)" choice:"file" choice:"keychain" default:"file"`
+	TokenPassphraseFile string `long:"token-passphrase-file" description:"Encrypt the token file with the passphrase (or key) in this file, for systems without a keychain"`
+	Scope               string `long:"scope" description:"With login, the read-only access to authorize: metadata (names and checksums) or readonly (file contents too, for --spot-check)" choice:"readonly" choice:"metadata" default:"metadata"`
+}
+
+// authActions are what the auth subcommand can do; login is the default
+var authActions = map[string]func(credentialsPath, tokenPath string, opts authOptions) int{
+	"login":   authLogin,
+	"status":  authStatus,
+	"refresh": authRefresh,
+	"revoke":  authRevoke,
+}
+
+// runAuth manages the saved Google Drive token: authorizing ahead of
+// (possibly unattended) runs, checking it, refreshing it or revoking it. It
+// returns the process exit code.
+func runAuth(args []string) int {
+	action := "login"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	var opts authOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "auth [login|status|refresh|revoke] [OPTIONS]"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Extra arguments provided!")
+		return 1
+	}
+	run, ok := authActions[action]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown auth action %q (expected login, status, refresh or revoke)\n", action)
+		return 1
+	}
+	if tokens, err = newTokenStore(opts.TokenStore, opts.TokenPassphraseFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	configDir := getConfigDir()
+	tokenPath := filepath.Join(configDir, "token.json")
+	if opts.Account != "" {
+		tokenPath = filepath.Join(configDir, fmt.Sprintf("token-%s.json", opts.Account))
+	}
+	return run(filepath.Join(configDir, "credentials.json"), tokenPath, opts)
+}
+
+// authLogin completes the OAuth flow if there's no saved token (or force is
+// set), then checks the token works
+func authLogin(credentialsPath, tokenPath string, opts authOptions) int {
+	if _, err := os.Stat(credentialsPath); os.IsNotExist(err) {
+		printCredentialsHelp(credentialsPath)
+		return 1
+	}
+	if opts.Force {
+		if err := tokens.Remove(tokenPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Unable to remove saved token: %v\n", err)
+			return 1
+		}
+	}
+
+	srv, err := NewDriveService(credentialsPath, tokenPath, opts.Scope)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to Google Drive: %v\n", err)
+		return 1
+	}
+	// make a request so a revoked or expired token is caught now
+	about, err := srv.About.Get().Fields("user(displayName,emailAddress)").Do()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to access Google Drive: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Authorized as %s (%s) with %s access; token saved to %s\n", about.User.DisplayName, about.User.EmailAddress, opts.Scope, tokens.Describe(tokenPath))
+	return 0
+}
+
+// authStatus prints the saved token's expiry and scopes, and whether it still
+// works
+func authStatus(credentialsPath, tokenPath string, opts authOptions) int {
+	config, tok, code := loadSavedToken(credentialsPath, tokenPath, opts.Scope)
+	if config == nil {
+		return code
+	}
+	fmt.Printf("Token: %s\n", tokens.Describe(tokenPath))
+	if tok.Expiry.IsZero() {
+		fmt.Println("Access token: no expiry recorded")
+	} else if time.Now().After(tok.Expiry) {
+		fmt.Printf("Access token: expired %s\n", tok.Expiry.Format(time.RFC1123))
+	} else {
+		fmt.Printf("Access token: expires %s (in %s)\n", tok.Expiry.Format(time.RFC1123), time.Until(tok.Expiry).Round(time.Second))
+	}
+	if tok.RefreshToken == "" {
+		fmt.Println("Refresh token: none; run auth login --force once the access token expires")
+	} else {
+		fmt.Println("Refresh token: saved")
+	}
+
+	source := config.TokenSource(context.Background(), tok)
+	granted, err := grantedScopes(source)
+	if err != nil {
+		fmt.Printf("Access: failed (%v); run auth login --force\n", err)
+		return 1
+	}
+	fmt.Printf("Scopes: %s\n", strings.Join(granted, " "))
+	srv, err := drive.New(oauth2.NewClient(context.Background(), source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to retrieve Drive client: %v\n", err)
+		return 1
+	}
+	about, err := srv.About.Get().Fields("user(displayName,emailAddress)").Do()
+	if err != nil {
+		fmt.Printf("Access: failed (%v); run auth login --force\n", err)
+		return 1
+	}
+	fmt.Printf("Access: working, as %s (%s)\n", about.User.DisplayName, about.User.EmailAddress)
+	return 0
+}
+
+// authRefresh exchanges the refresh token for a new access token and saves
+// it
+func authRefresh(credentialsPath, tokenPath string, opts authOptions) int {
+	config, tok, code := loadSavedToken(credentialsPath, tokenPath, opts.Scope)
+	if config == nil {
+		return code
+	}
+	if tok.RefreshToken == "" {
+		fmt.Fprintf(os.Stderr, "%s has no refresh token; run auth login --force\n", tokens.Describe(tokenPath))
+		return 1
+	}
+	// without an access token, the token source has to refresh
+	refreshed, err := config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: tok.RefreshToken}).Token()
+	if err != nil {
+		printFailure(fmt.Errorf("Unable to refresh token: %w", err))
+		return 1
+	}
+	if err := saveToken(tokenPath, refreshed); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("Refreshed; access token expires %s\n", refreshed.Expiry.Format(time.RFC1123))
+	return 0
+}
+
+// authRevoke revokes the saved token with Google, so it can't be used even
+// if copied elsewhere, then deletes it
+func authRevoke(credentialsPath, tokenPath string, _ authOptions) int {
+	tok, err := tokens.Load(tokenPath)
+	if os.IsNotExist(err) {
+		fmt.Printf("No token saved at %s\n", tokens.Describe(tokenPath))
+		return 0
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read token: %v\n", err)
+		return 1
+	}
+	// revoking the refresh token revokes its access tokens too
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	resp, err := http.PostForm(revokeURL, url.Values{"token": {token}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to revoke token: %v\n", err)
+		return 1
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		fmt.Println("Revoked token with Google")
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "invalid_token"):
+		fmt.Println("Token was already expired or revoked")
+	default:
+		fmt.Fprintf(os.Stderr, "Unable to revoke token: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+	if err := tokens.Remove(tokenPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to remove saved token: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Removed %s\n", tokens.Describe(tokenPath))
+	return 0
+}
+
+// loadSavedToken reads the OAuth client and saved token, printing why and
+// returning a nil config and the exit code if either is missing
+func loadSavedToken(credentialsPath, tokenPath string, scope string) (*oauth2.Config, *oauth2.Token, int) {
+	if _, err := os.Stat(credentialsPath); os.IsNotExist(err) {
+		printCredentialsHelp(credentialsPath)
+		return nil, nil, 1
+	}
+	config, err := loadOAuthConfig(credentialsPath, scope)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil, nil, 1
+	}
+	tok, err := tokens.Load(tokenPath)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Not authorized: no token saved at %s; run auth login\n", tokens.Describe(tokenPath))
+		return nil, nil, 1
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read token: %v\n", err)
+		return nil, nil, 1
+	}
+	return config, tok, 0
+}
+
+func printCredentialsHelp(credentialsPath string) {
+	fmt.Fprintf(os.Stderr, `No OAuth client found at %s. To create one:
+
+  1. In the Google Cloud console (https://console.cloud.google.com), create
+     a project and enable the Google Drive API for it.
+  2. Under APIs & Services > OAuth consent screen, set up the consent screen
+     and add your account as a test user.
+  3. Under APIs & Services > Credentials, create an OAuth client ID of type
+     "Desktop app" and download its JSON.
+  4. Save the JSON as %s.
+
+Then run auth login again.
+`, credentialsPath, credentialsPath)
+}
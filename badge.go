@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"time"
+)
+
+const badgeLabel = "drive sync"
+
+// Approximate width of a character in the badge font (11px Verdana)
+const badgeCharWidth = 7
+
+// BadgeSVG renders a shields.io-style status badge for the comparison
+func (mc *ManifestComparison) BadgeSVG(date time.Time) string {
+	total := mc.Matches + mc.Misses
+	message := fmt.Sprintf("passing %d/%d", mc.Matches, total)
+	color := "#4c1"
+	if mc.IsSuspect() {
+		message = fmt.Sprintf("suspect 0/%d", total)
+		color = "#dfb317"
+	} else if !mc.IsSuccessful() {
+		message = fmt.Sprintf("failing %d/%d", mc.Matches, total)
+		color = "#e05d44"
+	}
+	message += " · " + date.Format("2006-01-02")
+
+	labelWidth := len(badgeLabel)*badgeCharWidth + 10
+	messageWidth := len([]rune(message))*badgeCharWidth + 10
+	width := labelWidth + messageWidth
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s: %[3]s">
+<title>%[2]s: %[3]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="%[4]d" height="20" fill="#555"/>
+<rect x="%[4]d" width="%[5]d" height="20" fill="%[6]s"/>
+<rect width="%[1]d" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[2]s</text>
+<text x="%[7]d" y="14">%[2]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text>
+<text x="%[8]d" y="14">%[3]s</text>
+</g>
+</svg>
+`, width, html.EscapeString(badgeLabel), html.EscapeString(message), labelWidth, messageWidth, color, labelWidth/2, labelWidth+messageWidth/2)
+}
+
+// WriteBadgeFile writes the status badge SVG to path
+func (mc *ManifestComparison) WriteBadgeFile(path string) error {
+	return ioutil.WriteFile(path, []byte(mc.BadgeSVG(time.Now())), 0644)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// baselineEntry is a known, accepted difference. Path ending in / covers
+// everything under a folder; Status and Hash, if given, must also match (Hash
+// against either side's content hash).
+type baselineEntry struct {
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status,omitempty"`
+	Hash   string     `json:"hash,omitempty"`
+}
+
+// baseline is a set of accepted differences, read from a JSON array of
+// baselineEntry
+type baseline struct {
+	files   map[string][]*baselineEntry
+	folders []*baselineEntry
+}
+
+func loadBaseline(path string) (*baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*baselineEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Unable to parse baseline %s: %v", path, err)
+	}
+	b := &baseline{files: make(map[string][]*baselineEntry)}
+	for _, entry := range entries {
+		// manifest paths are lowercased and normalized
+		entry.Path = normalizeUnicodeCharacters(strings.ToLower(strings.TrimPrefix(entry.Path, "/")))
+		if entry.Path == "" {
+			continue
+		}
+		if strings.HasSuffix(entry.Path, "/") {
+			b.folders = append(b.folders, entry)
+		} else {
+			b.files[entry.Path] = append(b.files[entry.Path], entry)
+		}
+	}
+	return b, nil
+}
+
+// Len returns the number of entries in the baseline
+func (b *baseline) Len() int {
+	count := len(b.folders)
+	for _, entries := range b.files {
+		count += len(entries)
+	}
+	return count
+}
+
+func (b *baseline) covers(result *FileResult) bool {
+	for _, entry := range b.files[result.Path] {
+		if entry.matches(result) {
+			return true
+		}
+	}
+	for _, entry := range b.folders {
+		if strings.HasPrefix(result.Path, entry.Path) && entry.matches(result) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *baselineEntry) matches(result *FileResult) bool {
+	if e.Status != "" && e.Status != result.Status {
+		return false
+	}
+	if e.Hash == "" {
+		return true
+	}
+	return (result.Local != nil && result.Local.ContentHash == e.Hash) || (result.Remote != nil && result.Remote.ContentHash == e.Hash)
+}
+
+// Acknowledge moves differences covered by the baseline to Acknowledged,
+// where they don't count towards Misses
+func (mc *ManifestComparison) Acknowledge(b *baseline) {
+	var onlyLocal, onlyRemote []*File
+	for _, file := range mc.OnlyLocal {
+		if !mc.acknowledge(b, &FileResult{Path: file.Path, Status: StatusOnlyLocal, Local: file}) {
+			onlyLocal = append(onlyLocal, file)
+		}
+	}
+	for _, file := range mc.OnlyRemote {
+		if !mc.acknowledge(b, &FileResult{Path: file.Path, Status: StatusOnlyRemote, Remote: file}) {
+			onlyRemote = append(onlyRemote, file)
+		}
+	}
+	mc.OnlyLocal, mc.OnlyRemote = onlyLocal, onlyRemote
+
+	var mismatched, emptyLocal []*FilePair
+	for _, pair := range mc.ContentMismatch {
+		if !mc.acknowledge(b, &FileResult{Path: pair.Local.Path, Status: StatusMismatch, Remote: pair.Remote, Local: pair.Local, Stale: pair.Stale, Newer: pair.Newer()}) {
+			mismatched = append(mismatched, pair)
+		}
+	}
+	for _, pair := range mc.EmptyLocal {
+		if !mc.acknowledge(b, &FileResult{Path: pair.Local.Path, Status: StatusEmptyLocal, Remote: pair.Remote, Local: pair.Local}) {
+			emptyLocal = append(emptyLocal, pair)
+		}
+	}
+	mc.ContentMismatch, mc.EmptyLocal = mismatched, emptyLocal
+
+	var deleted []*DeletedFile
+	for _, file := range mc.DeletedRemotely {
+		if !mc.acknowledge(b, &FileResult{Path: file.Local.Path, Status: StatusDeleted, Remote: file.Remote, Local: file.Local}) {
+			deleted = append(deleted, file)
+		}
+	}
+	mc.DeletedRemotely = deleted
+}
+
+func (mc *ManifestComparison) acknowledge(b *baseline, result *FileResult) bool {
+	if !b.covers(result) {
+		return false
+	}
+	mc.Acknowledged = append(mc.Acknowledged, result)
+	mc.Misses--
+	return true
+}
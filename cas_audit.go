@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Content-addressable store audit: verifies Drive contents against a local
+// archive where files are stored by content hash (e.g. by a dedup backup
+// tool) alongside an index mapping original paths to hashes.
+//
+// The index uses md5sum output format, one "<md5>  <path>" entry per line.
+// Objects are looked up as <store>/<md5> or <store>/<first 2 chars>/<md5>.
+
+var casIndexLineRegexp = regexp.MustCompile(`^([0-9a-fA-F]{32})\s+\*?(.+)$`)
+
+// CASIndexMismatch records a path whose indexed hash differs from Drive
+type CASIndexMismatch struct {
+	Path       string
+	IndexHash  string
+	RemoteHash string
+}
+
+// CASAudit records the results of auditing a content-addressable store
+type CASAudit struct {
+	Verified int
+	// remote files whose content isn't in the store at all
+	MissingObjects []*File
+	// objects whose contents don't match their hash (only checked when hashing)
+	CorruptObjects []string
+	// remote files whose content is stored but whose path isn't indexed
+	NotIndexed []*File
+	// paths indexed with a different hash than Drive has
+	IndexMismatch []*CASIndexMismatch
+	// indexed paths that don't exist in Drive
+	IndexOnly []string
+}
+
+func (a *CASAudit) Misses() int {
+	return len(a.MissingObjects) + len(a.CorruptObjects) + len(a.NotIndexed) + len(a.IndexMismatch) + len(a.IndexOnly)
+}
+
+func (a *CASAudit) IsSuccessful() bool {
+	return a.Misses() == 0
+}
+
+// readCASIndex reads an md5sum-format index into a map of normalized path to hash
+func readCASIndex(indexPath string) (map[string]string, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := casIndexLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("%s:%d: expected \"<md5>  <path>\"", indexPath, lineNumber)
+		}
+		relPath := strings.TrimPrefix(filepath.ToSlash(match[2]), "./")
+		index[strings.ToLower(normalizeUnicodeCharacters(relPath))] = strings.ToLower(match[1])
+	}
+	return index, scanner.Err()
+}
+
+// casObjectPath returns the path of the stored object for a hash, or "" if
+// it isn't in the store
+func casObjectPath(storeRoot string, hash string) string {
+	candidates := []string{filepath.Join(storeRoot, hash)}
+	if len(hash) > 2 {
+		candidates = append(candidates, filepath.Join(storeRoot, hash[:2], hash))
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// auditCASStore matches remote files against the store by hash first, then
+// checks the path index for consistency with Drive
+func auditCASStore(remoteManifest manifestReader, storeRoot string, index map[string]string, verifyObjects bool) (*CASAudit, error) {
+	audit := &CASAudit{}
+	// cache per hash, since deduplicated content is shared between paths
+	objectStatus := make(map[string]bool)
+	remotePaths := make(map[string]bool)
+
+	for file := remoteManifest.PopOrNil(); file != nil; file = remoteManifest.PopOrNil() {
+		remotePaths[file.Path] = true
+
+		stored, checked := objectStatus[file.ContentHash]
+		if !checked {
+			objectPath := casObjectPath(storeRoot, file.ContentHash)
+			stored = objectPath != ""
+			if stored && verifyObjects {
+				hash, err := hashLocalFile(context.Background(), objectPath, hashMD5, nil)
+				if err != nil {
+					return nil, err
+				}
+				if hash != file.ContentHash {
+					audit.CorruptObjects = append(audit.CorruptObjects, objectPath)
+					stored = false
+				}
+			}
+			objectStatus[file.ContentHash] = stored
+		}
+		if !stored {
+			audit.MissingObjects = append(audit.MissingObjects, file)
+			continue
+		}
+
+		indexHash, indexed := index[file.Path]
+		if !indexed {
+			audit.NotIndexed = append(audit.NotIndexed, file)
+		} else if indexHash != file.ContentHash {
+			audit.IndexMismatch = append(audit.IndexMismatch, &CASIndexMismatch{
+				Path:       file.Path,
+				IndexHash:  indexHash,
+				RemoteHash: file.ContentHash,
+			})
+		} else {
+			audit.Verified++
+		}
+	}
+
+	for indexPath := range index {
+		if !remotePaths[indexPath] {
+			audit.IndexOnly = append(audit.IndexOnly, indexPath)
+		}
+	}
+	sort.Strings(audit.IndexOnly)
+
+	return audit, nil
+}
+
+func (a *CASAudit) PrintResults() {
+	if a.IsSuccessful() {
+		fmt.Printf("✅ SUCCESS: verified content-addressable store.\n")
+	} else {
+		fmt.Printf("❌ FAILURE: %d store or index problems detected.\n", a.Misses())
+	}
+	fmt.Println("")
+
+	printFileList(a.MissingObjects, "Files missing from store")
+	printStringList(a.CorruptObjects, "Corrupt store objects")
+	printFileList(a.NotIndexed, "Stored files missing from index")
+	fmt.Printf("Index hash mismatches: %d\n\n", len(a.IndexMismatch))
+	for _, mismatch := range a.IndexMismatch {
+		fmt.Printf("%s (index %s, remote %s)\n", mismatch.Path, mismatch.IndexHash, mismatch.RemoteHash)
+	}
+	if len(a.IndexMismatch) > 0 {
+		fmt.Print("\n\n")
+	}
+	printStringList(a.IndexOnly, "Indexed paths not in remote")
+
+	// each remote file lands in exactly one of these
+	total := a.Verified + len(a.MissingObjects) + len(a.NotIndexed) + len(a.IndexMismatch)
+	fmt.Println("SUMMARY:")
+	fmt.Printf("Remote files verified: %d/%d\n", a.Verified, total)
+}
+
+// runCASAudit lists remoteRoot and audits it against the store, printing
+// results and returning the process exit code
+func runCASAudit(srv *drive.Service, remoteRoot string, storeRoot string, indexPath string, verifyObjects bool, rules *pathRules) int {
+	if indexPath == "" {
+		fmt.Fprintln(os.Stderr, "--cas-index is required when using --cas-store")
+		return 1
+	}
+	if remoteRoot == "" || remoteRoot[0] != '/' {
+		remoteRoot = "/" + remoteRoot
+	}
+	index, err := readCASIndex(indexPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	fmt.Printf("Auditing Google Drive directory \"%v\" against content-addressable store \"%v\"\n", remoteRoot, storeRoot)
+	if verifyObjects {
+		fmt.Println("Checking content hashes of stored objects.")
+	}
+	fmt.Println("")
+
+	listing := NewDriveListing(srv, remoteRoot, nil)
+	progress := newScanProgress()
+	remoteManifest, err := getRemoteManifest(context.Background(), progress, &driveProvider{listing: listing}, &verifyConfig{RemoteRoot: remoteRoot, Local: localScanOptions{PathRules: rules}})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("Generated manifest for %d remote files, read %d index entries\n\n", remoteManifest.Len(), len(index))
+
+	audit, err := auditCASStore(remoteManifest, storeRoot, index, verifyObjects)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	audit.PrintResults()
+	if !audit.IsSuccessful() {
+		return exitSyncFailure
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// getattrlist (see sys/attr.h) reports an APFS file's clone ID, shared by
+// files cloned from one another, and whether it may share blocks with one
+const (
+	attrCmnReturnedAttrs = 0x80000000
+	attrCmnExtCloneID    = 0x00000100
+	attrCmnExtExtFlags   = 0x00000200
+	fsoptAttrCmnExtended = 0x00000020
+	efMayShareBlocks     = 0x00000001
+)
+
+type attrList struct {
+	bitmapCount uint16
+	reserved    uint16
+	commonAttr  uint32
+	volAttr     uint32
+	dirAttr     uint32
+	fileAttr    uint32
+	forkAttr    uint32
+}
+
+// cloneKey identifies a file's contents by its APFS clone ID, along with
+// its size and modification time, since clones keep their ID after one of
+// them is changed
+func cloneKey(path string, info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return "", false
+	}
+	list := attrList{
+		bitmapCount: unix.ATTR_BIT_MAP_COUNT,
+		commonAttr:  attrCmnReturnedAttrs,
+		forkAttr:    attrCmnExtCloneID | attrCmnExtExtFlags,
+	}
+	// length, the returned attribute set, then the clone ID and flags
+	var buf [4 + 20 + 8 + 8]byte
+	_, _, errno := unix.Syscall6(unix.SYS_GETATTRLIST, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&list)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), fsoptAttrCmnExtended, 0)
+	if errno != 0 {
+		return "", false
+	}
+	// attributes the file system doesn't support are left out
+	returnedFork := binary.LittleEndian.Uint32(buf[20:24])
+	if binary.LittleEndian.Uint32(buf[0:4]) < uint32(len(buf)) || returnedFork&(attrCmnExtCloneID|attrCmnExtExtFlags) != attrCmnExtCloneID|attrCmnExtExtFlags {
+		return "", false
+	}
+	cloneID := binary.LittleEndian.Uint64(buf[24:32])
+	flags := binary.LittleEndian.Uint64(buf[32:40])
+	if cloneID == 0 || flags&efMayShareBlocks == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", uint64(stat.Dev), cloneID, info.Size(), info.ModTime().UnixNano()), true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// FIEMAP (see linux/fiemap.h) maps a file's extents to their location on
+// disk. Reflinked copies share every extent, at the same physical offsets.
+const (
+	fsIocFiemap = 0xc020660b
+	// most clones of large assets are a handful of extents; files with more
+	// are just hashed
+	fiemapMaxExtents = 64
+
+	fiemapExtentLast = 0x1
+	// extents whose location or contents FIEMAP can't vouch for: not yet
+	// allocated, compressed or encrypted (which may only partly be used),
+	// inline, or preallocated
+	fiemapExtentUnreliable = 0x2 | 0x4 | 0x8 | 0x80 | 0x100 | 0x200 | 0x400 | 0x800
+	fiemapExtentShared     = 0x2000
+)
+
+type fiemapHeader struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+}
+
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+type fiemapRequest struct {
+	header  fiemapHeader
+	extents [fiemapMaxExtents]fiemapExtent
+}
+
+// cloneKey identifies a file's contents by the extents they're stored in,
+// if every extent is shared with another file (so it's copy-on-write and
+// can't change without moving)
+func cloneKey(path string, info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	req := &fiemapRequest{header: fiemapHeader{length: ^uint64(0), extentCount: fiemapMaxExtents}}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(req))); errno != 0 {
+		// e.g. file systems without FIEMAP
+		return "", false
+	}
+	mapped := int(req.header.mappedExtents)
+	if mapped == 0 || req.extents[mapped-1].flags&fiemapExtentLast == 0 {
+		return "", false
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d:%d", uint64(stat.Dev), info.Size())
+	for _, extent := range req.extents[:mapped] {
+		if extent.flags&fiemapExtentShared == 0 || extent.flags&fiemapExtentUnreliable != 0 {
+			return "", false
+		}
+		fmt.Fprintf(&key, ":%d+%d@%d", extent.logical, extent.length, extent.physical)
+	}
+	return key.String(), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// cloneKey finds nothing; clones are only detected on Linux and macOS
+func cloneKey(path string, info os.FileInfo) (string, bool) {
+	return "", false
+}
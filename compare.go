@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// runCompare compares two saved manifests without touching Google Drive or
+// the local disk:
+//
+//	googledrive-sync-verifier compare remote.json.gz local.json.gz
+//
+// The first manifest takes the remote side of the comparison and the second
+// the local side, so two remote snapshots can be diffed as well, with "only in
+// remote" meaning removed since the first snapshot. It returns the process
+// exit code.
+func runCompare(args []string) int {
+	var opts struct {
+		Synology        bool     `long:"synology" description:"Shorthand for --client synology"`
+		Client          string   `long:"client" description:"Sync client whose renaming and possible matches to expect: drive-desktop, synology, insync, rclone or none" default:"drive-desktop"`
+		Presets         []string `long:"preset" description:"Apply a further preset's path transformations on top of --client; can be given more than once"`
+		PathRules       string   `long:"path-rules" description:"JSON file of extra path transformation rules"`
+		Verbose         bool     `short:"v" long:"verbose" description:"Show full file listings even if the comparison looks misconfigured"`
+		TopProblems     int      `long:"top-problems" description:"Also list the largest N files in each problem category, by size"`
+		FileTypes       bool     `long:"file-types" description:"Also break down results by file extension and size, to spot file types a sync client mishandles"`
+		LocalDuplicates bool     `long:"local-duplicates" description:"Also list groups of files in the second manifest with the same contents"`
+		ScoreWarn       float64  `long:"score-warn" description:"Grade the verification score WARN below this percentage of files or bytes matched" default:"99.9"`
+		ScoreCrit       float64  `long:"score-crit" description:"Grade the verification score CRIT below this percentage of files or bytes matched" default:"99"`
+		GradeExit       bool     `long:"grade-exit" description:"Exit with the score's grade: 0 for OK, 1 for WARN and 2 for CRIT, instead of 1 for any mismatch"`
+		CSVPath         string   `long:"csv" description:"Write per-file results as CSV to this path"`
+		JSONPath        string   `long:"json" description:"Write results as JSON to this path"`
+		HTMLPath        string   `long:"html" description:"Write results as a standalone HTML report to this path"`
+	}
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "compare [OPTIONS] FIRST-MANIFEST SECOND-MANIFEST"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "compare needs exactly two manifest files")
+		return 1
+	}
+
+	rules, err := newPathRules(opts.Client, opts.Presets, opts.Synology, opts.PathRules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	grade, err := newGradeThresholds(opts.ScoreWarn, opts.ScoreCrit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	first, err := loadManifestForCompare(args[0], rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer first.Manifest.Close()
+	second, err := loadManifestForCompare(args[1], rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer second.Manifest.Close()
+	if first.Header.HashAlgo != second.Header.HashAlgo {
+		fmt.Fprintf(os.Stderr, "Manifests were hashed with different algorithms (%s and %s)\n", first.Header.HashAlgo, second.Header.HashAlgo)
+		return 1
+	}
+
+	first.Print("remote")
+	second.Print("local")
+	fmt.Println("")
+
+	comparison := compareManifests(first.Manifest, second.Manifest, append(first.Errored, second.Errored...), ComparisonOptions{
+		PathRules:       rules,
+		RecordMatches:   opts.CSVPath != "" || opts.JSONPath != "" || opts.HTMLPath != "",
+		FileTypes:       opts.FileTypes,
+		Grade:           grade,
+		LocalDuplicates: opts.LocalDuplicates,
+	})
+	if err := first.Manifest.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if err := second.Manifest.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if comparison.IsSuspect() && !opts.Verbose {
+		comparison.PrintSuspectWarning()
+		comparison.PrintSummary()
+	} else {
+		comparison.PrintResults(opts.Verbose)
+		if comparison.IsSuspect() {
+			comparison.PrintSuspectWarning()
+		}
+	}
+	if opts.TopProblems > 0 {
+		fmt.Println("")
+		comparison.PrintTopProblems(opts.TopProblems)
+	}
+	if opts.FileTypes {
+		fmt.Println("")
+		comparison.PrintFileTypes()
+	}
+
+	if opts.CSVPath != "" {
+		if err := comparison.WriteCSVFile(opts.CSVPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write CSV results: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\nWrote per-file results to %s\n", opts.CSVPath)
+	}
+	if opts.JSONPath != "" {
+		stats := &RunStats{RemoteFiles: comparison.RemoteCount, LocalFiles: comparison.LocalCount}
+		if err := comparison.WriteJSONFile(opts.JSONPath, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write JSON results: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\nWrote JSON results to %s\n", opts.JSONPath)
+	}
+	if opts.HTMLPath != "" {
+		if err := comparison.WriteHTMLFile(opts.HTMLPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write HTML report: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\nWrote HTML report to %s\n", opts.HTMLPath)
+	}
+
+	if comparison.IsSuspect() {
+		return exitConfigSuspect
+	}
+	if opts.GradeExit {
+		return comparison.gradeExitCode()
+	}
+	if !comparison.IsSuccessful() {
+		return exitSyncFailure
+	}
+	return 0
+}
+
+// savedManifest is a manifest file loaded for comparison
+type savedManifest struct {
+	Path     string
+	Header   *manifestHeader
+	Manifest *sortedManifest
+	Errored  []*FileError
+}
+
+// loadManifestForCompare loads either kind of manifest. Remote manifests are
+// saved unfiltered, so they get the same filtering as a live listing.
+func loadManifestForCompare(path string, rules *pathRules) (*savedManifest, error) {
+	manifest := newSortedManifest(spillConfig{Threshold: 100000})
+	header, errored, err := readManifestFile(path, func(header *manifestHeader, file *File) error {
+		if header.Kind == manifestKindRemote && !prepareRemoteFile(file, rules) {
+			return nil
+		}
+		return manifest.Add(file)
+	})
+	if err != nil {
+		manifest.Close()
+		return nil, err
+	}
+	return &savedManifest{Path: path, Header: header, Manifest: manifest, Errored: errored}, nil
+}
+
+func (m *savedManifest) Print(side string) {
+	fmt.Printf("Comparing as %s: %s (%s manifest of %s, %d files, saved %s)\n", side, m.Path, m.Header.Kind, m.Header.Root, m.Manifest.Len(), m.Header.Created.Format("2006-01-02 15:04"))
+}
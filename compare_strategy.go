@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// compareStrategy is how thoroughly files are compared
+type compareStrategy string
+
+const (
+	// strategyHash compares content hashes (the default)
+	strategyHash compareStrategy = "hash"
+	// strategySize compares sizes, without reading local files
+	strategySize compareStrategy = "size-only"
+	// strategyPresence only checks that files exist on both sides
+	strategyPresence compareStrategy = "presence-only"
+	// strategySkip leaves files out of the comparison entirely
+	strategySkip compareStrategy = "skip"
+)
+
+// strategyRule applies Strategy to everything under the folder Prefix,
+// relative to the roots
+type strategyRule struct {
+	Prefix   string          `json:"prefix"`
+	Strategy compareStrategy `json:"strategy"`
+}
+
+// compareStrategies picks the strategy for each path from the rule with the
+// longest matching prefix. A nil *compareStrategies hashes everything.
+type compareStrategies struct {
+	rules []*strategyRule
+}
+
+// loadCompareStrategies reads a JSON array of strategyRule
+func loadCompareStrategies(path string) (*compareStrategies, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*strategyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("Unable to parse comparison strategies %s: %v", path, err)
+	}
+	for _, rule := range rules {
+		switch rule.Strategy {
+		case strategyHash, strategySize, strategyPresence, strategySkip:
+		default:
+			return nil, fmt.Errorf("Unknown comparison strategy %q for %q (expected hash, size-only, presence-only or skip)", rule.Strategy, rule.Prefix)
+		}
+		// manifest paths are lowercased and normalized
+		rule.Prefix = normalizeUnicodeCharacters(strings.ToLower(strings.Trim(rule.Prefix, "/")))
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].Prefix) > len(rules[j].Prefix) })
+	return &compareStrategies{rules: rules}, nil
+}
+
+func (s *compareStrategies) forPath(path string) compareStrategy {
+	if s == nil {
+		return strategyHash
+	}
+	for _, rule := range s.rules {
+		if rule.Prefix == "" || path == rule.Prefix || strings.HasPrefix(path, rule.Prefix+"/") {
+			return rule.Strategy
+		}
+	}
+	return strategyHash
+}
+
+// hashes reports whether local files at path need hashing
+func (s *compareStrategies) hashes(path string) bool {
+	return s.forPath(path) == strategyHash
+}
+
+func (s *compareStrategies) skips(path string) bool {
+	return s.forPath(path) == strategySkip
+}
+
+// matches compares files sharing a path by the path's strategy
+func (s *compareStrategies) matches(remote, local *File) bool {
+	switch s.forPath(local.Path) {
+	case strategySize:
+		return remote.Size == local.Size
+	case strategyPresence:
+		return true
+	default:
+		return compareFileContents(remote, local)
+	}
+}
+
+// String summarizes the rules for output
+func (s *compareStrategies) String() string {
+	var parts []string
+	for _, rule := range s.rules {
+		prefix := rule.Prefix
+		if prefix == "" {
+			prefix = "(everything else)"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", prefix, rule.Strategy))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// findComputerFolder returns the ID of a folder backed up from a computer by
+// Drive for Desktop. Computers appear in Drive as parentless folders owned by
+// the user, with each backed-up folder directly inside.
+func (g *DriveListing) findComputerFolder(computerName string, folderName string) (string, error) {
+	query := fmt.Sprintf("name = '%s' and mimeType = '%s' and 'me' in owners and trashed != true", escapeQueryString(computerName), folderMimeType)
+	folders, err := g.listQuery(query)
+	if err != nil {
+		return "", err
+	}
+	var computerIds []string
+	for _, folder := range folders {
+		if len(folder.Parents) == 0 {
+			computerIds = append(computerIds, folder.Id)
+		}
+	}
+	if len(computerIds) == 0 {
+		return "", fmt.Errorf("No computer named %q found in Google Drive", computerName)
+	} else if len(computerIds) > 1 {
+		return "", fmt.Errorf("%d computers named %q found in Google Drive", len(computerIds), computerName)
+	}
+
+	query = fmt.Sprintf("'%s' in parents and mimeType = '%s' and trashed != true", computerIds[0], folderMimeType)
+	folders, err = g.listQuery(query)
+	if err != nil {
+		return "", err
+	}
+	var folderIds, available []string
+	for _, folder := range folders {
+		available = append(available, folder.Name)
+		if strings.EqualFold(folder.Name, folderName) {
+			folderIds = append(folderIds, folder.Id)
+		}
+	}
+	if len(folderIds) == 0 {
+		return "", fmt.Errorf("Computer %q has no backed-up folder named %q (found: %s)", computerName, folderName, strings.Join(available, ", "))
+	} else if len(folderIds) > 1 {
+		return "", fmt.Errorf("Computer %q has %d backed-up folders named %q; use --remote-id to pick one", computerName, len(folderIds), folderName)
+	}
+	return folderIds[0], nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+)
+
+var csvHeader = []string{"path", "status", "local_hash", "remote_hash", "size", "remote_id", "error", "remote_link", "local_root", "error_category"}
+
+// WriteCSVFile writes per-file verification results to the given path
+func (mc *ManifestComparison) WriteCSVFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := mc.WriteCSV(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteCSV writes one row per file with its verification status
+func (mc *ManifestComparison) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, result := range mc.Results() {
+		if err := writer.Write(csvRow(result)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvRow(result *FileResult) []string {
+	localHash, remoteHash, remoteId, remoteLink, errMessage, localRoot, errCategory := "", "", "", "", "", "", ""
+	size := ""
+	if result.Remote != nil {
+		remoteHash = result.Remote.ContentHash
+		remoteId = result.Remote.Id
+		remoteLink = result.Remote.RemoteLink()
+		size = strconv.FormatInt(result.Remote.Size, 10)
+	}
+	// prefer local size since that's what was actually hashed
+	if result.Local != nil {
+		localHash = result.Local.ContentHash
+		localRoot = result.Local.LocalRoot
+		size = strconv.FormatInt(result.Local.Size, 10)
+	}
+	if result.Error != nil {
+		errMessage = result.Error.Error()
+		errCategory = string(errorCategory(result.Error))
+	}
+	return []string{result.Path, string(result.Status), localHash, remoteHash, size, remoteId, errMessage, remoteLink, localRoot, errCategory}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DeletedFile records a local-only file whose contents were found in Drive's
+// trash or as an orphaned file (owned but no longer in any folder)
+type DeletedFile struct {
+	Local    *File
+	Remote   *File
+	Orphaned bool
+}
+
+// findDeletedRemote looks for a trashed or orphaned Drive file with the same
+// name and contents as a local file
+func (g *DriveListing) findDeletedRemote(local *File) (*DeletedFile, error) {
+	name := path.Base(local.Path)
+	query := fmt.Sprintf("name contains '%s' and mimeType != '%s'", escapeQueryString(name), folderMimeType)
+	nextPageToken := ""
+	for {
+		result, err := g.list(query, nextPageToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range result.Files {
+			orphaned := !file.Trashed && file.OwnedByMe && len(file.Parents) == 0
+			if !file.Trashed && !orphaned {
+				continue
+			}
+			if g.HashAlgo.checksum(file) != local.ContentHash {
+				continue
+			}
+			if strings.ToLower(normalizeUnicodeCharacters(filterFileName(file.Name))) != name {
+				continue
+			}
+			return &DeletedFile{Local: local, Remote: g.newRemoteFile(local.Path, file), Orphaned: orphaned}, nil
+		}
+		nextPageToken = result.NextPageToken
+		if nextPageToken == "" {
+			return nil, nil
+		}
+	}
+}
+
+func escapeQueryString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `'`, `\'`)
+}
+
+// FindDeletedRemotely looks up local-only files in Drive's trash and among
+// orphaned files, moving any found into DeletedRemotely. This distinguishes
+// files that were never uploaded from files deleted on the Drive side. Each
+// file takes at least one API call, so nothing is looked up if more than
+// maxFiles files are local-only. Deleted files still count as misses.
+func (mc *ManifestComparison) FindDeletedRemotely(listing *DriveListing, maxFiles int) error {
+	if len(mc.OnlyLocal) == 0 || len(mc.OnlyLocal) > maxFiles {
+		return nil
+	}
+	var onlyLocal []*File
+	for _, file := range mc.OnlyLocal {
+		if file.ContentHash == "" {
+			onlyLocal = append(onlyLocal, file)
+			continue
+		}
+		deleted, err := listing.findDeletedRemote(file)
+		if err != nil {
+			return err
+		}
+		if deleted != nil {
+			mc.DeletedRemotely = append(mc.DeletedRemotely, deleted)
+		} else {
+			onlyLocal = append(onlyLocal, file)
+		}
+	}
+	mc.OnlyLocal = onlyLocal
+	return nil
+}
+
+func printDeletedList(files []*DeletedFile, description string) {
+	fmt.Printf("%s: %d\n\n", description, len(files))
+	for _, file := range files {
+		if file.Orphaned {
+			fmt.Printf("%s (orphaned)\n", file.Local.Path)
+		} else {
+			fmt.Println(file.Local.Path)
+		}
+	}
+	if len(files) > 0 {
+		fmt.Print("\n\n")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// remoteChange is a Drive change translated into manifest terms. File is nil
+// if the file was removed, trashed, moved out of scope, or has no content.
+type remoteChange struct {
+	Id            string
+	File          *File
+	FolderChanged bool
+}
+
+// StartPageToken returns a token for listing changes made from now on
+func (g *DriveListing) StartPageToken() (string, error) {
+	var token *drive.StartPageToken
+	var err error
+	err = g.call(func(ctx context.Context) error {
+		token, err = g.service.Changes.GetStartPageToken().Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.StartPageToken, nil
+}
+
+// Changes lists all changes since pageToken, returning the token to use for
+// the next call. Files must have been called first so the folder tree is known.
+func (g *DriveListing) Changes(pageToken string) (changes []*remoteChange, nextToken string, err error) {
+	for {
+		var result *drive.ChangeList
+		err = g.call(func(ctx context.Context) error {
+			result, err = g.service.Changes.List(pageToken).
+				PageSize(1000).
+				Spaces(g.spaces()).
+				Fields(googleapi.Field(fmt.Sprintf("nextPageToken, newStartPageToken, changes(fileId, removed, file(id, name, parents, trashed, %s, mimeType, size, modifiedTime, webViewLink))", g.HashAlgo.driveField()))).
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		for _, change := range result.Changes {
+			changes = append(changes, g.resolveChange(change))
+		}
+		if result.NewStartPageToken != "" {
+			return changes, result.NewStartPageToken, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
+
+func (g *DriveListing) resolveChange(change *drive.Change) *remoteChange {
+	result := &remoteChange{Id: change.FileId}
+	file := change.File
+	if change.Removed || file == nil || file.Trashed {
+		return result
+	}
+
+	if file.MimeType == folderMimeType {
+		if len(file.Parents) > 0 && file.Id != g.rootId {
+			g.driveFolders[file.Id] = &googleDriveFolder{ParentId: file.Parents[0], Name: file.Name}
+			// cached paths of descendants may now be stale
+			for id, folder := range g.driveFolders {
+				if id != g.rootId {
+					folder.path = ""
+				}
+			}
+		}
+		result.FolderChanged = true
+		return result
+	}
+	if isGoogleNative(file) || len(file.Parents) == 0 {
+		return result
+	}
+
+	parentPath, err := g.buildPath(file.Parents[0])
+	if err != nil {
+		return result
+	}
+	relPath, err := remoteRel(g.RootPath, path.Join(parentPath, filterFileName(file.Name)))
+	if err != nil || !g.includePath(relPath) {
+		return result
+	}
+	result.File = g.newRemoteFile(relPath, file)
+	return result
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// remoteExclusions are Google Drive folders that aren't synced on purpose,
+// left out of the listing along with everything below them: by path
+// relative to the remote root, as a pattern like Archive/** or
+// **/node_modules, or by folder ID. Walking the tree never lists them, and
+// when listing everything, files directly in folders given by ID are left
+// out by the query and the rest are dropped afterwards.
+type remoteExclusions struct {
+	// patterns are lowercased and normalized, without any trailing /**
+	patterns []string
+	ids      []string
+}
+
+// newRemoteExclusions checks the --exclude-remote patterns and
+// --exclude-remote-id folder IDs (nil if there are none)
+func newRemoteExclusions(patterns []string, ids []string) (*remoteExclusions, error) {
+	if len(patterns) == 0 && len(ids) == 0 {
+		return nil, nil
+	}
+	e := &remoteExclusions{ids: ids}
+	for _, pattern := range patterns {
+		// a folder's contents are excluded with it
+		normalized := strings.TrimSuffix(strings.Trim(pattern, "/"), "/**")
+		normalized = normalizeUnicodeCharacters(strings.ToLower(normalized))
+		if normalized == "" || normalized == "**" {
+			return nil, fmt.Errorf("--exclude-remote %q would exclude everything", pattern)
+		}
+		if _, err := path.Match(strings.TrimPrefix(normalized, "**/"), ""); err != nil {
+			return nil, fmt.Errorf("Invalid --exclude-remote pattern %q: %v", pattern, err)
+		}
+		e.patterns = append(e.patterns, normalized)
+	}
+	return e, nil
+}
+
+// matches reports whether a folder, by normalized path relative to the
+// remote root, is excluded itself (not counting its parents)
+func (e *remoteExclusions) matches(relPath string) bool {
+	if e == nil {
+		return false
+	}
+	for _, pattern := range e.patterns {
+		if anywhere, ok := strings.CutPrefix(pattern, "**/"); ok {
+			// at any depth: try every trailing part of the path with as
+			// many components as the pattern
+			parts := strings.Split(relPath, "/")
+			depth := strings.Count(anywhere, "/") + 1
+			if depth <= len(parts) {
+				if matched, _ := path.Match(anywhere, strings.Join(parts[len(parts)-depth:], "/")); matched {
+					return true
+				}
+			}
+		} else if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// folderExcluded reports whether the folder id, or one it's in, is
+// excluded, whether as Google Photos or with Exclude. Folders must be
+// checked after their parents have been listed.
+func (g *DriveListing) folderExcluded(id string) bool {
+	if g.excludedFolders[id] {
+		return true
+	}
+	if g.Exclude == nil || id == g.rootId {
+		return false
+	}
+	if excluded, ok := g.excludedChecked[id]; ok {
+		return excluded
+	}
+	excluded := false
+	if folder, ok := g.driveFolders[id]; ok {
+		if fullPath, err := g.buildPath(id); err == nil {
+			rel, err := remoteRel(g.RootPath, fullPath)
+			if err == nil && rel != "." && !strings.HasPrefix(rel, "../") && g.Exclude.matches(strings.ToLower(normalizeUnicodeCharacters(rel))) {
+				excluded = true
+				logger.Debug("excluding remote folder", "path", rel, "id", id)
+			}
+		}
+		excluded = excluded || g.folderExcluded(folder.ParentId)
+	}
+	g.excludedChecked[id] = excluded
+	return excluded
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -9,17 +9,79 @@ import (
 	"time"
 
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
 
-	"github.com/rafaeljesus/retry-go"
+const (
+	folderMimeType   = "application/vnd.google-apps.folder"
+	shortcutMimeType = "application/vnd.google-apps.shortcut"
 )
 
 type DriveListing struct {
-	service        *drive.Service
-	RootPath       string
+	service  *drive.Service
+	RootPath string
+	// RootId, if set, is the folder treated as "/" in place of My Drive's root
+	RootId         string
 	Subdirectories []string
-	rootId         string
-	driveFiles     []*drive.File
-	driveFolders   map[string]*googleDriveFolder
+	// ResolveShortcuts includes shortcut targets at the shortcut's path, the way
+	// Drive for Desktop materializes them
+	ResolveShortcuts bool
+	// Query is an extra Drive API query clause that listed files (but not
+	// folders) must match
+	Query string
+	// HashAlgo selects which Drive checksum is used as ContentHash
+	HashAlgo hashAlgorithm
+	// SkipGooglePhotos leaves out the legacy Google Photos folder
+	SkipGooglePhotos bool
+	// Exclude leaves out folders that aren't synced on purpose (nil to
+	// exclude nothing)
+	Exclude *remoteExclusions
+	// OwnedOnly leaves out files owned by someone else, and IncludeShared
+	// lists files shared with the user that aren't in My Drive (see
+	// drive_sharing.go)
+	OwnedOnly     bool
+	IncludeShared bool
+	// SkippedShared counts files shared with the user that weren't listed
+	// because they aren't in My Drive
+	SkippedShared int
+	// UnresolvedShortcuts counts shortcuts to folders that weren't followed
+	// because ResolveShortcuts is off
+	UnresolvedShortcuts int
+	// DanglingShortcuts counts resolved shortcuts whose target couldn't be
+	// found or accessed
+	DanglingShortcuts int
+	// GoogleNative counts the Google Docs editors files under the root,
+	// which aren't compared; ListGoogleNative records each of them too
+	GoogleNative     *googleNativeFiles
+	ListGoogleNative bool
+	// CountsNative decides which Google-native files are counted, so the
+	// count follows the sample and filters of the files compared (nil counts
+	// them all)
+	CountsNative func(file *File) bool
+	// Spaces is a comma-separated list of Drive spaces to list; "" means
+	// just drive. Files in appDataFolder are listed under /appDataFolder.
+	Spaces string
+	// Context stops the listing early when cancelled (nil to never stop),
+	// setting Incomplete, and bounds every API request
+	Context    context.Context
+	Incomplete bool
+	// RequestTimeout abandons (and retries) a single API request that takes
+	// longer than this (0 for no limit)
+	RequestTimeout time.Duration
+	// APICalls counts requests made to the Drive API, including retries
+	APICalls     int
+	rootId       string
+	driveFolders map[string]*googleDriveFolder
+	// folderShortcuts and fileShortcuts are the listed shortcuts by target
+	// ID, and resolvedTargets the file targets that were in the listing
+	folderShortcuts map[string][]*drive.File
+	fileShortcuts   map[string][]*drive.File
+	resolvedTargets map[string]bool
+	// excludedFolders aren't listed (with SkipGooglePhotos, or given by ID
+	// in Exclude), and excludedChecked records which folders are excluded
+	// by Exclude, themselves or by a parent
+	excludedFolders map[string]bool
+	excludedChecked map[string]bool
 }
 
 type googleDriveFolder struct {
@@ -42,71 +104,273 @@ func NewDriveListing(service *drive.Service, root string, subdirs []string) *Dri
 	return inst
 }
 
+// Files lists every file under the root
 func (g *DriveListing) Files(updateChan chan<- int) (files []*File, err error) {
-	scannedFiles := 0
-	nextPageToken := ""
-	g.driveFiles = []*drive.File{}
+	err = g.Each(updateChan, func(file *File) error {
+		files = append(files, file)
+		return nil
+	})
+	return
+}
+
+// Each lists the files under the root, calling add with each one as its page
+// is listed rather than holding the listing in memory. Folders and shortcuts
+// are listed first, so every file's path can be built as soon as it's listed.
+// updateChan, if not nil, receives the number of files listed so far.
+func (g *DriveListing) Each(updateChan chan<- int, add func(*File) error) (err error) {
+	g.folderShortcuts = make(map[string][]*drive.File)
+	g.fileShortcuts = make(map[string][]*drive.File)
+	g.resolvedTargets = make(map[string]bool)
+	g.GoogleNative = &googleNativeFiles{}
+	g.Incomplete = false
 	g.driveFolders = make(map[string]*googleDriveFolder)
+	g.excludedFolders = make(map[string]bool)
+	g.excludedChecked = make(map[string]bool)
 	g.rootId, err = g.getRootId()
 	if err != nil {
 		return
 	}
-	g.driveFolders[g.rootId] = &googleDriveFolder{path: "/"}
+	if g.Exclude != nil {
+		for _, id := range g.Exclude.ids {
+			g.excludedFolders[id] = true
+		}
+	}
+	if g.SkipGooglePhotos {
+		if err = g.excludeGooglePhotos("root"); err != nil {
+			return
+		}
+	}
+	if err = g.addAppDataFolder(); err != nil {
+		return
+	}
+	if err = g.addSharedWithMeFolder(); err != nil {
+		return
+	}
 
-	for {
-		result, err := g.listAll(nextPageToken)
-		if err != nil {
-			return nil, err
+	if g.isScoped() {
+		rootPath := "/"
+		if g.RootId == "" {
+			rootPath = g.RootPath
+			g.rootId, err = g.resolveRootPath(g.rootId)
+			if err != nil {
+				return
+			}
+		}
+		g.driveFolders[g.rootId] = &googleDriveFolder{path: rootPath}
+		if err = g.listScoped(updateChan, add); err != nil {
+			return
+		}
+	} else {
+		g.driveFolders[g.rootId] = &googleDriveFolder{path: "/"}
+		if err = g.listEverything(updateChan, add); err != nil {
+			return
 		}
+	}
 
-		nextPageToken = result.NextPageToken
-		scannedFiles += g.handleDriveFiles(result.Files)
-		updateChan <- scannedFiles
+	if g.ResolveShortcuts && !g.Incomplete {
+		return g.resolveFileShortcuts(add)
+	}
+	return nil
+}
 
-		if nextPageToken == "" {
-			break
+// listedPath returns the path of a listed file relative to RootPath, and
+// whether it's under the root and wasn't left out
+func (g *DriveListing) listedPath(file *drive.File) (relPath string, ok bool, err error) {
+	parentId := g.rootId
+	if len(file.Parents) > 0 {
+		parentId = file.Parents[0]
+	}
+	if g.OwnedOnly && !file.OwnedByMe {
+		logger.Debug("skipped remote file", "name", file.Name, "id", file.Id, "reason", "not owned by me", "sharedBy", sharingUser(file))
+		return "", false, nil
+	}
+	if g.folderExcluded(parentId) {
+		logger.Debug("skipped remote file", "name", file.Name, "id", file.Id, "reason", "in an excluded folder or Google Photos")
+		return "", false, nil
+	}
+	parentPath, err := g.buildPath(parentId)
+	if err != nil {
+		switch err := err.(type) {
+		case folderNotFoundError:
+			// skip file - this indicates it's in a shared folder owned by someone else, which doesn't sync locally
+			g.SkippedShared++
+			logger.Debug("skipped remote file", "name", file.Name, "id", file.Id, "reason", "not in a synced folder", "sharedBy", sharingUser(file))
+			return "", false, nil
+		default:
+			return "", false, err
 		}
 	}
+	relPath, err = remoteRel(g.RootPath, path.Join(parentPath, filterFileName(file.Name)))
+	if err != nil {
+		return "", false, err
+	}
+	if explainer.wants(relPath) {
+		explainer.note("remote: listed %q (id %s, %s %s)", relPath, file.Id, g.HashAlgo, g.HashAlgo.checksum(file))
+	}
+	if !g.includePath(relPath) {
+		logger.Debug("skipped remote file", "path", relPath, "reason", "outside selected folders")
+		if explainer.wants(relPath) {
+			explainer.note("remote: skipped, outside the selected folders")
+		}
+		return "", false, nil
+	}
+	return relPath, true, nil
+}
 
-	for _, file := range g.driveFiles {
-		parentId := g.rootId
-		if len(file.Parents) > 0 {
-			parentId = file.Parents[0]
+// listEverything lists every file in the account, which is faster than
+// walking folders when verifying all of My Drive
+func (g *DriveListing) listEverything(updateChan chan<- int, add func(*File) error) error {
+	query := "trashed != true" + g.excludedFoldersQuery()
+	err := g.eachPage(g.foldersQuery(query), func(files []*drive.File) bool {
+		g.handleFolders(files)
+		return !g.cancelled()
+	})
+	if err == nil && !g.cancelled() {
+		scannedFiles := 0
+		var addErr error
+		err = g.eachPage(g.contentsQuery(query), func(files []*drive.File) bool {
+			var handled int
+			handled, addErr = g.handleFiles(files, add)
+			scannedFiles += handled
+			sendUpdate(updateChan, scannedFiles)
+			return addErr == nil && !g.cancelled()
+		})
+		if addErr != nil {
+			return addErr
 		}
-		parentPath, err := g.buildPath(parentId)
-		if err != nil {
-			switch err := err.(type) {
-			case folderNotFoundError:
-				// skip file - this indicates it's in a shared folder owned by someone else, which doesn't sync locally
+	}
+	if err != nil && g.cancelled() {
+		return nil
+	}
+	return err
+}
+
+// sendUpdate reports the number of files listed so far, if anyone's listening
+func sendUpdate(updateChan chan<- int, count int) {
+	if updateChan != nil {
+		updateChan <- count
+	}
+}
+
+// RemoteLink returns the address of a remote file in Google Drive's web
+// interface, or "" for local files
+func (f *File) RemoteLink() string {
+	if f.WebLink != "" {
+		return f.WebLink
+	}
+	if f.Id != "" {
+		return "https://drive.google.com/file/d/" + f.Id + "/view"
+	}
+	return ""
+}
+
+func (g *DriveListing) context() context.Context {
+	if g.Context == nil {
+		return context.Background()
+	}
+	return g.Context
+}
+
+// cancelled reports whether the listing should stop early, marking it
+// incomplete if so
+func (g *DriveListing) cancelled() bool {
+	if g.Context != nil && g.Context.Err() != nil {
+		g.Incomplete = true
+	}
+	return g.Incomplete
+}
+
+func (g *DriveListing) newRemoteFile(relPath string, file *drive.File) *File {
+	// a missing or malformed time is left as the zero value
+	modifiedTime, _ := time.Parse(time.RFC3339, file.ModifiedTime)
+	return &File{
+		Path:         strings.ToLower(normalizeUnicodeCharacters(relPath)),
+		ContentHash:  g.HashAlgo.checksum(file),
+		Size:         file.Size,
+		ModifiedTime: modifiedTime,
+		Id:           file.Id,
+		WebLink:      file.WebViewLink,
+		RawPath:      relPath,
+	}
+}
+
+// ListFolder re-lists the direct children of a single folder, identified by
+// its path relative to RootPath in the same normalized form as File paths.
+// Files or Each must have been called first so the folder tree is known.
+func (g *DriveListing) ListFolder(relDir string, rules *pathRules) (files []*File, err error) {
+	folderId, folderPath, err := g.findFolder(relDir, rules)
+	if err != nil {
+		return nil, err
+	}
+	query := g.filesQuery(fmt.Sprintf("'%s' in parents and trashed != true", folderId))
+	var pathErr error
+	err = g.eachPage(query, func(page []*drive.File) bool {
+		for _, file := range page {
+			if isGoogleNative(file) {
 				continue
-			default:
-				return nil, err
 			}
+			var relPath string
+			relPath, pathErr = remoteRel(g.RootPath, path.Join(folderPath, filterFileName(file.Name)))
+			if pathErr != nil {
+				return false
+			}
+			files = append(files, g.newRemoteFile(relPath, file))
 		}
-		relPath, err := filepath.Rel(g.RootPath, path.Join(parentPath, filterFileName(file.Name)))
+		return true
+	})
+	if err == nil {
+		err = pathErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// findFolder looks up a previously listed folder by normalized relative path
+func (g *DriveListing) findFolder(relDir string, rules *pathRules) (id string, folderPath string, err error) {
+	if relDir == "." {
+		relDir = ""
+	}
+	for folderId := range g.driveFolders {
+		fullPath, err := g.buildPath(folderId)
 		if err != nil {
-			return nil, err
+			continue
 		}
-		if g.includePath(relPath) {
-			normalizedPath := strings.ToLower(normalizeUnicodeCharacters(relPath))
-			files = append(files, &File{Path: normalizedPath, ContentHash: file.Md5Checksum})
+		rel, err := remoteRel(g.RootPath, fullPath)
+		if err != nil || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if rel == "." {
+			rel = ""
+		}
+		normalized := strings.ToLower(normalizeUnicodeCharacters(rel))
+		normalized = strings.TrimSuffix(rules.remote(normalized+"/"), "/")
+		if normalized == relDir {
+			return folderId, fullPath, nil
 		}
 	}
-	return
+	return "", "", withCategory(CategoryNotFound, fmt.Errorf("Folder %q not found in remote listing", relDir))
 }
 
 func (g *DriveListing) includePath(path string) bool {
+	return inSubdirectories(path, g.Subdirectories)
+}
+
+// inSubdirectories reports whether a path relative to the remote root is
+// under the root and inside one of subdirs, if any are given
+func inSubdirectories(path string, subdirs []string) bool {
 	// filter files outside of the specified root
 	if strings.HasPrefix(path, "../") {
 		return false
 	}
 	// filter files outside of the specified subdirectories
 	// default case - no subdirectories provided, don't need to check
-	if len(g.Subdirectories) == 0 {
+	if len(subdirs) == 0 {
 		return true
 	} else {
-		for _, subdir := range g.Subdirectories {
-			rel, err := filepath.Rel(subdir, path)
+		for _, subdir := range subdirs {
+			rel, err := remoteRel(subdir, path)
 			if err != nil {
 				return false
 			}
@@ -119,61 +383,163 @@ func (g *DriveListing) includePath(path string) bool {
 	}
 }
 
-const apiRetries int = 10
+// filesQuery adds the user's Query to a listing query. Folders are exempt so
+// that paths can still be built for the files that do match.
+func (g *DriveListing) filesQuery(query string) string {
+	if g.Query == "" {
+		return query
+	}
+	return fmt.Sprintf("%s and (mimeType = '%s' or (%s))", query, folderMimeType, g.Query)
+}
+
+// foldersQuery limits a listing query to folders, and to shortcuts matching
+// the user's Query, which are listed before the files in them
+func (g *DriveListing) foldersQuery(query string) string {
+	shortcuts := fmt.Sprintf("mimeType = '%s'", shortcutMimeType)
+	if g.Query != "" {
+		shortcuts = fmt.Sprintf("(%s and (%s))", shortcuts, g.Query)
+	}
+	return fmt.Sprintf("%s and (mimeType = '%s' or %s)", query, folderMimeType, shortcuts)
+}
+
+// contentsQuery limits a listing query to files matching the user's Query,
+// leaving out the folders and shortcuts listed by foldersQuery
+func (g *DriveListing) contentsQuery(query string) string {
+	query = fmt.Sprintf("%s and mimeType != '%s' and mimeType != '%s'", query, folderMimeType, shortcutMimeType)
+	if g.Query == "" {
+		return query
+	}
+	return fmt.Sprintf("%s and (%s)", query, g.Query)
+}
 
-func (g *DriveListing) listAll(nextPageToken string) (result *drive.FileList, err error) {
-	err = retry.Do(func() error {
+func (g *DriveListing) list(query string, nextPageToken string) (result *drive.FileList, err error) {
+	err = g.call(func(ctx context.Context) error {
 		result, err = g.service.Files.List().
 			PageToken(nextPageToken).
 			PageSize(1000).
-			Fields("nextPageToken, files(id, name, parents, ownedByMe, trashed, md5Checksum, mimeType)").
-			Q("trashed != true").
+			Spaces(g.spaces()).
+			Fields(googleapi.Field(fmt.Sprintf("nextPageToken, files(id, name, parents, ownedByMe, sharingUser(emailAddress), trashed, %s, mimeType, size, quotaBytesUsed, modifiedTime, webViewLink, shortcutDetails(targetId, targetMimeType))", g.HashAlgo.driveField()))).
+			Q(query).
+			Context(ctx).
 			Do()
 		return err
-	}, apiRetries, time.Second*1)
+	})
 	return
 }
 
+// listQuery returns every page of results for a query
+func (g *DriveListing) listQuery(query string) (files []*drive.File, err error) {
+	err = g.eachPage(query, func(page []*drive.File) bool {
+		files = append(files, page...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 func (g *DriveListing) getRootId() (string, error) {
+	rootId := "root"
+	if g.RootId != "" {
+		rootId = g.RootId
+	}
 	var file *drive.File
 	var err error
-	err = retry.Do(func() error {
-		file, err = g.service.Files.Get("root").Fields("id").Do()
+	err = g.call(func(ctx context.Context) error {
+		file, err = g.service.Files.Get(rootId).Fields("id, mimeType").Context(ctx).Do()
 		return err
-	}, apiRetries, time.Second*1)
+	})
 	if err != nil {
-		return "", errors.New(fmt.Sprintf("Unable to retrieve root: %v", err))
+		return "", fmt.Errorf("Unable to retrieve root: %w", err)
+	} else if g.RootId != "" && file.MimeType != folderMimeType {
+		return "", fmt.Errorf("Remote root %s is not a folder", g.RootId)
 	} else {
 		return file.Id, nil
 	}
 }
 
-func (g *DriveListing) handleDriveFiles(files []*drive.File) int {
-	handledFiles := 0
+// parentOf returns the folder a listed file is in, or false to skip it
+func (g *DriveListing) parentOf(file *drive.File) (string, bool) {
+	if len(file.Parents) > 0 {
+		// TODO consider handling multiple parents - expand to multiple paths?
+		// if len(file.Parents) > 1 {
+		// 	fmt.Printf("Multiple parents for %s\n", file.Name)
+		// }
+		return file.Parents[0], true
+	}
+	// shared with us but not in My Drive
+	parentId := g.sharedParent(file)
+	if parentId == "" {
+		return "", false
+	}
+	// so paths are built as for any other file
+	file.Parents = []string{parentId}
+	return parentId, true
+}
+
+// handleFolders records a page of listed folders and shortcuts
+func (g *DriveListing) handleFolders(files []*drive.File) {
 	for _, file := range files {
-		var parentId string
-		if len(file.Parents) == 0 {
-			// parentId = g.rootId
-			// ignore files without parent
+		parentId, ok := g.parentOf(file)
+		if !ok {
 			continue
-		} else {
-			parentId = file.Parents[0]
-			// TODO consider handling multiple parents - expand to multiple paths?
-			// if len(file.Parents) > 1 {
-			// 	fmt.Printf("Multiple parents for %s\n", file.Name)
-			// }
-		}
-		if file.MimeType == "application/vnd.google-apps.folder" {
+		}
+		if file.MimeType == folderMimeType {
+			if file.Id == g.rootId {
+				// a folder given by RootId is listed too; keep it as "/"
+				continue
+			}
 			g.driveFolders[file.Id] = &googleDriveFolder{
 				ParentId: parentId,
 				Name:     file.Name,
 			}
-		} else if file.Md5Checksum != "" {
-			g.driveFiles = append(g.driveFiles, file)
-			handledFiles++
+		} else if file.MimeType == shortcutMimeType && file.ShortcutDetails != nil {
+			details := file.ShortcutDetails
+			if !g.ResolveShortcuts {
+				if details.TargetMimeType == folderMimeType {
+					g.UnresolvedShortcuts++
+				}
+			} else if details.TargetMimeType == folderMimeType {
+				g.folderShortcuts[details.TargetId] = append(g.folderShortcuts[details.TargetId], file)
+			} else {
+				g.fileShortcuts[details.TargetId] = append(g.fileShortcuts[details.TargetId], file)
+			}
 		}
 	}
-	return handledFiles
+}
+
+// handleFiles adds a page of listed files, along with their copies at the
+// paths of any shortcuts to them, and counts Google-native files. It returns
+// how many files were handled.
+func (g *DriveListing) handleFiles(files []*drive.File, add func(*File) error) (handledFiles int, err error) {
+	for _, file := range files {
+		if _, ok := g.parentOf(file); !ok {
+			continue
+		}
+		relPath, ok, err := g.listedPath(file)
+		if err != nil {
+			return handledFiles, err
+		}
+		if isGoogleNative(file) {
+			if ok && (g.CountsNative == nil || g.CountsNative(g.newRemoteFile(relPath, file))) {
+				g.GoogleNative.add(relPath, file, g.ListGoogleNative)
+			}
+			continue
+		}
+		handledFiles++
+		if ok {
+			if err := add(g.newRemoteFile(relPath, file)); err != nil {
+				return handledFiles, err
+			}
+		}
+		if g.ResolveShortcuts {
+			if err := g.addShortcutCopies(file, add); err != nil {
+				return handledFiles, err
+			}
+		}
+	}
+	return handledFiles, nil
 }
 
 func (g *DriveListing) buildPath(folderId string) (string, error) {
@@ -191,6 +557,13 @@ func (g *DriveListing) buildPath(folderId string) (string, error) {
 	}
 }
 
+// remoteRel is filepath.Rel for Drive paths, which use forward slashes on
+// every platform
+func remoteRel(basepath, targpath string) (string, error) {
+	rel, err := filepath.Rel(basepath, targpath)
+	return filepath.ToSlash(rel), err
+}
+
 func filterFileName(name string) string {
 	// TOOD ideally original file name would be preserved somewhere for reference
 	// TODO add filtering for trailing space (linux)
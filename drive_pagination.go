@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Long listings can outlive both the access token and Drive's page tokens.
+// Each request already retries transient failures from the same page; on top
+// of that, an access token rejected mid-scan is refreshed and the request
+// sent again, and a page token Drive no longer accepts restarts the query
+// from its first page, skipping files already listed. Anything else stops
+// the listing with an error, since comparing against part of the remote
+// files would report the rest as missing.
+
+// How many times a query is restarted after its page token expires
+const maxListingRestarts = 2
+
+// incompleteListingError is a listing that failed part way through
+type incompleteListingError struct {
+	pages int
+	files int
+	err   error
+}
+
+func (e *incompleteListingError) Error() string {
+	return fmt.Sprintf("stopped after %d pages (%d files); not comparing against an incomplete listing: %v", e.pages, e.files, e.err)
+}
+
+func (e *incompleteListingError) Unwrap() error {
+	return e.err
+}
+
+// eachPage calls handle with every page of results for a query, until it
+// returns false. Listing resumes from the last good page token after a
+// failed request, and restarts if that token has expired.
+func (g *DriveListing) eachPage(query string, handle func(files []*drive.File) bool) error {
+	// the IDs already handled, so a restarted query doesn't repeat them
+	seen := make(map[string]struct{})
+	pages, restarts := 0, 0
+	nextPageToken := ""
+	for {
+		result, err := g.list(query, nextPageToken)
+		if err != nil && nextPageToken != "" && isPageTokenError(err) && restarts < maxListingRestarts && g.context().Err() == nil {
+			restarts++
+			logger.Warn("Drive page token expired; restarting listing query", "pages", pages, "files", len(seen), "restart", restarts)
+			nextPageToken = ""
+			continue
+		} else if err != nil {
+			if g.context().Err() != nil || pages == 0 {
+				return err
+			}
+			return &incompleteListingError{pages: pages, files: len(seen), err: err}
+		}
+		pages++
+
+		files := result.Files
+		if restarts > 0 {
+			files = files[:0:0]
+			for _, file := range result.Files {
+				if _, ok := seen[file.Id]; !ok {
+					files = append(files, file)
+				}
+			}
+		}
+		for _, file := range files {
+			seen[file.Id] = struct{}{}
+		}
+		if !handle(files) {
+			return nil
+		}
+		nextPageToken = result.NextPageToken
+		if nextPageToken == "" {
+			return nil
+		}
+	}
+}
+
+// isPageTokenError reports whether Drive rejected a request's page token,
+// which happens once it's too old to resume from
+func isPageTokenError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return false
+	}
+	// the parsed error leaves out which parameter was invalid
+	return strings.Contains(apiErr.Body, `"pageToken"`) || strings.Contains(apiErr.Message, "pageToken")
+}
+
+// refreshingTokenSource hands out access tokens like the oauth2 package's
+// own token source, refreshing them once they expire, but can also be told
+// to refresh one the API rejected early (e.g. because of clock skew)
+type refreshingTokenSource struct {
+	lock   sync.Mutex
+	config *oauth2.Config
+	token  *oauth2.Token
+	// stale is set once the current access token has been rejected
+	stale bool
+}
+
+func (s *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token.Valid() && !s.stale {
+		return s.token, nil
+	}
+	// without an access token, the token source has to refresh
+	token, err := s.config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: s.token.RefreshToken}).Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token, s.stale = token, false
+	return token, nil
+}
+
+// invalidate forces the next Token to refresh, if the rejected access token
+// hasn't already been replaced
+func (s *refreshingTokenSource) invalidate(rejected string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token.AccessToken == rejected {
+		s.stale = true
+	}
+}
+
+// reauthTransport sends a request again with a fresh access token if the
+// API answers 401 Unauthorized
+type reauthTransport struct {
+	source *refreshingTokenSource
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, token)
+	// only requests without a body (or one that can be replayed) are resent
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	resp.Body.Close()
+	t.source.invalidate(token.AccessToken)
+	if token, err = t.source.Token(); err != nil {
+		return nil, err
+	}
+	logger.Warn("access token rejected; retrying with a refreshed token")
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.send(retry, token)
+}
+
+func (t *reauthTransport) send(req *http.Request, token *oauth2.Token) (*http.Response, error) {
+	// RoundTrippers mustn't modify the request they're given
+	authorized := req.Clone(req.Context())
+	token.SetAuthHeader(authorized)
+	return http.DefaultTransport.RoundTrip(authorized)
+}
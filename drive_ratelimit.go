@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	apiRetries int = 10
+	// Delay before the first retry, doubled on each further attempt
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// driveLimiter paces every Drive API request made by the process, since
+// quotas apply per user rather than per listing
+var driveLimiter = &rateLimiter{}
+
+// rateLimiter spaces out requests to at most a fixed rate, and pauses all
+// requests after the API reports a rate limit so that concurrent callers back
+// off together instead of each burning quota on retries
+type rateLimiter struct {
+	lock sync.Mutex
+	// interval between requests (0 for no limit)
+	interval time.Duration
+	next     time.Time
+}
+
+// SetRate limits requests to maxQPS per second (0 for no limit)
+func (l *rateLimiter) SetRate(maxQPS float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if maxQPS <= 0 {
+		l.interval = 0
+	} else {
+		l.interval = time.Duration(float64(time.Second) / maxQPS)
+	}
+}
+
+// Wait blocks until the next request may be made
+func (l *rateLimiter) Wait() {
+	l.lock.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.lock.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// Pause holds back all requests for at least d
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// call makes a Drive API request, retrying rate limits, server errors and
+// network failures with exponential backoff and jitter. Errors in a
+// permanent category (e.g. not found or permission denied) are returned
+// immediately, as is the listing's context error once it's cancelled.
+// Returned errors are tagged with their category. request is given a context
+// that also enforces RequestTimeout.
+func (g *DriveListing) call(request func(ctx context.Context) error) error {
+	ctx := g.context()
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		driveLimiter.Wait()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		g.APICalls++
+		requestCtx, cancel := ctx, context.CancelFunc(func() {})
+		if g.RequestTimeout > 0 {
+			requestCtx, cancel = context.WithTimeout(ctx, g.RequestTimeout)
+		}
+		err := categorized(request(requestCtx))
+		cancel()
+		if err == nil || ctx.Err() != nil || attempt >= apiRetries || errorCategory(err).permanent() {
+			return err
+		}
+		// jitter keeps concurrent retries from synchronizing
+		delay := time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		if isRateLimitError(err) {
+			driveLimiter.Pause(delay)
+		}
+		logger.Warn("retrying Drive API request", "attempt", attempt, "delay", delay, "category", errorCategory(err), "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func isRateLimitError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == 429 {
+		return true
+	}
+	if apiErr.Code == 403 {
+		for _, item := range apiErr.Errors {
+			if item.Reason == "userRateLimitExceeded" || item.Reason == "rateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
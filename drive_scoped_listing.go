@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Number of folders whose children are fetched in a single query
+const scopedListBatchSize = 40
+
+// isScoped reports whether the listing only needs part of My Drive, in which
+// case it's faster to walk down from the root folder than to list everything
+func (g *DriveListing) isScoped() bool {
+	return g.RootId != "" || (g.RootPath != "" && g.RootPath != "/")
+}
+
+// resolveRootPath finds the ID of the folder at RootPath by walking down from
+// My Drive's root one path component at a time
+func (g *DriveListing) resolveRootPath(myDriveRootId string) (string, error) {
+	folderId := myDriveRootId
+	for _, name := range strings.Split(strings.Trim(g.RootPath, "/"), "/") {
+		query := fmt.Sprintf("'%s' in parents and mimeType = '%s' and trashed != true", folderId, folderMimeType)
+		var matches []string
+		nextPageToken := ""
+		for {
+			result, err := g.list(query, nextPageToken)
+			if err != nil {
+				return "", err
+			}
+			for _, folder := range result.Files {
+				if filterFileName(folder.Name) == name {
+					matches = append(matches, folder.Id)
+				}
+			}
+			nextPageToken = result.NextPageToken
+			if nextPageToken == "" {
+				break
+			}
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("Remote root %s not found: no folder named %q", g.RootPath, name)
+		} else if len(matches) > 1 {
+			return "", fmt.Errorf("Remote root %s is ambiguous: %d folders named %q; use --remote-id to pick one", g.RootPath, len(matches), name)
+		}
+		folderId = matches[0]
+	}
+	return folderId, nil
+}
+
+// listScoped lists everything under the root folder breadth first, batching
+// the children of several folders into each query: first the folders and
+// shortcuts, then the files in every folder found. Shortcut targets are
+// walked too when resolving shortcuts, since they may live outside the root.
+func (g *DriveListing) listScoped(updateChan chan<- int, add func(*File) error) error {
+	folders := []string{g.rootId}
+	queued := map[string]bool{g.rootId: true}
+	enqueue := func(id string) {
+		if !queued[id] && !g.folderExcluded(id) {
+			queued[id] = true
+			folders = append(folders, id)
+		}
+	}
+
+	for next := 0; next < len(folders); {
+		batch := g.nextBatch(folders, next)
+		next += len(batch)
+		err := g.eachPage(g.foldersQuery(parentsQuery(batch)), func(files []*drive.File) bool {
+			g.handleFolders(files)
+			for _, file := range files {
+				if file.MimeType == folderMimeType {
+					enqueue(file.Id)
+				} else if g.ResolveShortcuts && file.ShortcutDetails != nil && file.ShortcutDetails.TargetMimeType == folderMimeType {
+					enqueue(file.ShortcutDetails.TargetId)
+				}
+			}
+			return !g.cancelled()
+		})
+		if err != nil && g.cancelled() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if g.cancelled() {
+			return nil
+		}
+	}
+
+	scannedFiles := 0
+	for next := 0; next < len(folders); {
+		batch := g.nextBatch(folders, next)
+		next += len(batch)
+		var addErr error
+		err := g.eachPage(g.contentsQuery(parentsQuery(batch)), func(files []*drive.File) bool {
+			var handled int
+			handled, addErr = g.handleFiles(files, add)
+			scannedFiles += handled
+			sendUpdate(updateChan, scannedFiles)
+			return addErr == nil && !g.cancelled()
+		})
+		if addErr != nil {
+			return addErr
+		} else if err != nil && g.cancelled() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if g.cancelled() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// nextBatch returns up to scopedListBatchSize folders starting at next
+func (g *DriveListing) nextBatch(folders []string, next int) []string {
+	end := next + scopedListBatchSize
+	if end > len(folders) {
+		end = len(folders)
+	}
+	return folders[next:end]
+}
+
+// parentsQuery matches the untrashed children of any of folders
+func parentsQuery(folders []string) string {
+	var clauses []string
+	for _, id := range folders {
+		clauses = append(clauses, fmt.Sprintf("'%s' in parents", id))
+	}
+	return fmt.Sprintf("(%s) and trashed != true", strings.Join(clauses, " or "))
+}
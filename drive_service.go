@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -16,78 +17,179 @@ import (
 
 // Google Drive API authorization helpers
 
-// Create service client from file configuration
-func NewDriveService(credentialPath string, tokenPath string) (*drive.Service, error) {
-	b, err := ioutil.ReadFile(credentialPath)
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// driveScopes are the scopes --scope can select. Both are read-only, so the
+// verifier can never change anything in Google Drive.
+var driveScopes = map[string]string{
+	"readonly": drive.DriveReadonlyScope,
+	"metadata": drive.DriveMetadataReadonlyScope,
+}
+
+// resolveDriveScope returns the scope to authorize with: name if given,
+// otherwise readonly when file contents are downloaded (spot checks), or
+// metadata, which checksums need and tokens from earlier versions have
+func resolveDriveScope(name string, downloads bool) string {
+	if name != "" {
+		return name
+	}
+	if downloads {
+		return "readonly"
+	}
+	return "metadata"
+}
+
+// Create service client from file configuration, authorized with the named
+// scope from driveScopes
+func NewDriveService(credentialPath string, tokenPath string, scope string) (*drive.Service, error) {
+	config, err := loadOAuthConfig(credentialPath, scope)
+	if err != nil {
+		return nil, err
+	}
+	source, err := getTokenSource(config, tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// a saved token keeps the scopes it was granted, whatever was asked for
+	granted, err := grantedScopes(source)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		return nil, fmt.Errorf("Unable to check the token's scopes: %w", err)
+	}
+	if err := checkGrantedScopes(granted, scope); err != nil {
+		return nil, err
+	}
+
+	srv, err := drive.New(&http.Client{Transport: &reauthTransport{source: source}})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Drive client: %w", err)
+	}
+	return srv, nil
+}
+
+// loadOAuthConfig reads the OAuth client from credentials.json, or
+// GDSV_CREDENTIALS_JSON if set
+func loadOAuthConfig(credentialPath string, scope string) (*oauth2.Config, error) {
+	b, ok := envCredentials()
+	if !ok {
+		var err error
+		if b, err = ioutil.ReadFile(credentialPath); err != nil {
+			return nil, fmt.Errorf("Unable to read client secret file: %v", err)
+		}
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, drive.DriveMetadataReadonlyScope)
+	scopeURL, ok := driveScopes[scope]
+	if !ok {
+		return nil, fmt.Errorf("Unknown scope %q (expected readonly or metadata)", scope)
+	}
+	config, err := google.ConfigFromJSON(b, scopeURL)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
 	}
-	client := getClient(config, tokenPath)
+	return config, nil
+}
+
+type tokenInfo struct {
+	Scope string `json:"scope"`
+}
 
-	srv, err := drive.New(client)
+// grantedScopes asks Google which scopes a token was granted
+func grantedScopes(source oauth2.TokenSource) ([]string, error) {
+	tok, err := source.Token()
 	if err != nil {
-		log.Fatalf("Unable to retrieve Drive client: %v", err)
+		return nil, err
+	}
+	resp, err := http.Get(tokenInfoURL + "?access_token=" + url.QueryEscape(tok.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	info := &tokenInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, err
 	}
+	return strings.Fields(info.Scope), nil
+}
 
-	return srv, err
+// checkGrantedScopes makes sure a token allows what scope needs and can't
+// modify Drive: any Drive scope that isn't read-only is refused, e.g. from a
+// token.json saved by another tool
+func checkGrantedScopes(granted []string, scope string) error {
+	satisfied := false
+	for _, s := range granted {
+		// drive.appdata only reaches this OAuth client's own hidden folder
+		if strings.HasPrefix(s, "https://www.googleapis.com/auth/drive") && !strings.HasSuffix(s, ".readonly") && s != drive.DriveAppdataScope {
+			return withCategory(CategoryAuth, fmt.Errorf("The saved token has write access to Google Drive (%s); run auth login --force to authorize read-only access", s))
+		}
+		// readonly includes everything metadata allows
+		if s == driveScopes[scope] || (scope == "metadata" && s == drive.DriveReadonlyScope) {
+			satisfied = true
+		}
+	}
+	if !satisfied {
+		return withCategory(CategoryAuth, fmt.Errorf("The saved token wasn't granted the %s scope (it has %s); run auth login --force --scope %s", scope, strings.Join(granted, " "), scope))
+	}
+	return nil
 }
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config, tokFile string) *http.Client {
+// Retrieve a token, saves the token, then returns a source of access tokens
+// refreshed from it.
+func getTokenSource(config *oauth2.Config, tokFile string) (*refreshingTokenSource, error) {
+	// GDSV_TOKEN_JSON is used as it is; refreshed access tokens aren't saved
+	if tok, ok, err := envToken(tokFile); ok {
+		if err != nil {
+			return nil, err
+		}
+		return &refreshingTokenSource{config: config, token: tok}, nil
+	}
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+	tok, err := tokens.Load(tokFile)
+	if err != nil && !os.IsNotExist(err) {
+		// a token that can't be read is left alone rather than replaced
+		return nil, fmt.Errorf("Unable to load the saved token from %s: %w", tokens.Describe(tokFile), err)
+	} else if err != nil {
+		if nonInteractive {
+			return nil, withCategory(CategoryAuth, fmt.Errorf("Not authorized: no token saved at %s; run auth login, or set %s", tokens.Describe(tokFile), envTokenJSON))
+		}
+		if tok, err = getTokenFromWeb(config); err != nil {
+			return nil, err
+		}
+		if err = saveToken(tokFile, tok); err != nil {
+			return nil, err
+		}
 	}
-	return config.Client(context.Background(), tok)
+	return &refreshingTokenSource{config: config, token: tok}, nil
 }
 
 // Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
+		return nil, fmt.Errorf("Unable to read authorization code: %w", err)
 	}
 
 	tok, err := config.Exchange(context.TODO(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
+		return nil, withCategory(CategoryAuth, fmt.Errorf("Unable to retrieve token from web: %w", err))
 	}
-	return tok
+	return tok, nil
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+// Saves a token for the token file path, in the token store.
+func saveToken(path string, token *oauth2.Token) error {
+	fmt.Printf("Saving credential file to: %s\n", tokens.Describe(path))
+	if err := tokens.Save(path, token); err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %w", err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return nil
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Files shared with the user that aren't in My Drive have no visible parent
+// (or a parent outside the listing). Drive for Desktop doesn't sync them
+// unless they're reached through a shortcut, so they're skipped by default
+// and counted in SkippedShared. With IncludeShared they're listed under a
+// "Shared with me" folder, as some other sync clients do.
+const (
+	sharedWithMeFolderId   = "sharedWithMe"
+	sharedWithMeFolderName = "Shared with me"
+)
+
+// addSharedWithMeFolder places the "Shared with me" folder under the root
+// when including shared files. Shared files live outside My Drive, so
+// they're only reachable when listing everything.
+func (g *DriveListing) addSharedWithMeFolder() error {
+	if !g.IncludeShared {
+		return nil
+	}
+	if g.isScoped() {
+		return fmt.Errorf("Files shared with you can only be included with the remote root at /")
+	}
+	g.driveFolders[sharedWithMeFolderId] = &googleDriveFolder{ParentId: g.rootId, Name: sharedWithMeFolderName}
+	return nil
+}
+
+// sharedParent returns the folder to list a file without a visible parent
+// in, or "" to skip it
+func (g *DriveListing) sharedParent(file *drive.File) string {
+	if file.OwnedByMe {
+		return ""
+	}
+	if g.IncludeShared {
+		return sharedWithMeFolderId
+	}
+	if file.MimeType != folderMimeType {
+		g.SkippedShared++
+		logger.Debug("skipped remote file", "name", file.Name, "id", file.Id, "reason", "shared with me, not in My Drive", "sharedBy", sharingUser(file))
+	}
+	return ""
+}
+
+// printSharingNotes explains which shared files weren't verified
+func printSharingNotes(listing *DriveListing) {
+	if listing.SkippedShared > 0 {
+		fmt.Printf("%d files shared with you aren't in My Drive and weren't verified (see --include-shared).\n\n", listing.SkippedShared)
+	}
+	if listing.UnresolvedShortcuts > 0 {
+		fmt.Printf("%d shortcuts to folders weren't followed. Drive for Desktop syncs their contents; use --resolve-shortcuts to verify them.\n\n", listing.UnresolvedShortcuts)
+	}
+	if listing.DanglingShortcuts > 0 {
+		fmt.Printf("%d shortcuts point to files that were deleted or aren't shared with you any more, and weren't verified.\n\n", listing.DanglingShortcuts)
+	}
+}
+
+func sharingUser(file *drive.File) string {
+	if file.SharingUser == nil {
+		return ""
+	}
+	return file.SharingUser.EmailAddress
+}
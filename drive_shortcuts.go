@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// addShortcutCopies adds a listed file again wherever a shortcut makes it
+// appear locally: at the path of a shortcut to it, and inside the path of a
+// shortcut to any folder it's in, the way Drive for Desktop materializes them
+func (g *DriveListing) addShortcutCopies(file *drive.File, add func(*File) error) error {
+	if shortcuts, ok := g.fileShortcuts[file.Id]; ok {
+		g.resolvedTargets[file.Id] = true
+		for _, shortcut := range shortcuts {
+			if err := g.addShortcutFile(shortcut, "", file, add); err != nil {
+				return err
+			}
+		}
+	}
+	if len(g.folderShortcuts) == 0 {
+		return nil
+	}
+	// walk up from the file, since every folder shortcut was listed first
+	relPath := filterFileName(file.Name)
+	folderId := file.Parents[0]
+	for depth := 0; depth <= len(g.driveFolders); depth++ {
+		for _, shortcut := range g.folderShortcuts[folderId] {
+			if err := g.addShortcutFile(shortcut, relPath, file, add); err != nil {
+				return err
+			}
+		}
+		folder, ok := g.driveFolders[folderId]
+		if !ok || folder.ParentId == "" {
+			break
+		}
+		relPath = path.Join(filterFileName(folder.Name), relPath)
+		folderId = folder.ParentId
+	}
+	return nil
+}
+
+// resolveFileShortcuts adds the targets of shortcuts to files that weren't in
+// the listing (e.g. shared with us), fetching each one directly
+func (g *DriveListing) resolveFileShortcuts(add func(*File) error) error {
+	for targetId, shortcuts := range g.fileShortcuts {
+		if g.resolvedTargets[targetId] {
+			continue
+		}
+		target, err := g.getFile(targetId)
+		if category := errorCategory(err); category == CategoryNotFound || category == CategoryPermission {
+			// trashed, deleted or no longer shared with us
+			g.DanglingShortcuts += len(shortcuts)
+			for _, shortcut := range shortcuts {
+				logger.Debug("skipped shortcut", "name", shortcut.Name, "id", shortcut.Id, "target", targetId, "reason", category)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if isGoogleNative(target) {
+			// Google-native target, nothing to verify
+			continue
+		}
+		for _, shortcut := range shortcuts {
+			if err := g.addShortcutFile(shortcut, "", target, add); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addShortcutFile adds target at relPath under a shortcut's path, unless the
+// shortcut is somewhere that isn't listed
+func (g *DriveListing) addShortcutFile(shortcut *drive.File, relPath string, target *drive.File, add func(*File) error) error {
+	parentId := g.rootId
+	if len(shortcut.Parents) > 0 {
+		parentId = shortcut.Parents[0]
+	}
+	parentPath, err := g.buildPath(parentId)
+	if _, ok := err.(folderNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	file, err := g.shortcutFile(path.Join(parentPath, filterFileName(shortcut.Name), relPath), target)
+	if err != nil || file == nil {
+		return err
+	}
+	return add(file)
+}
+
+func (g *DriveListing) shortcutFile(fullPath string, target *drive.File) (*File, error) {
+	relPath, err := remoteRel(g.RootPath, fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if !g.includePath(relPath) {
+		return nil, nil
+	}
+	return g.newRemoteFile(relPath, target), nil
+}
+
+func (g *DriveListing) getFile(id string) (*drive.File, error) {
+	var file *drive.File
+	var err error
+	err = g.call(func(ctx context.Context) error {
+		file, err = g.service.Files.Get(id).Fields(googleapi.Field("id, name, mimeType, size, modifiedTime, webViewLink, " + g.HashAlgo.driveField())).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve shortcut target %s: %w", id, err)
+	}
+	return file, nil
+}
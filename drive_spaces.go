@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Drive spaces that can be listed. App data is private to the apps that
+// create it and never synced, so it's only listed when asked for.
+const (
+	spaceDrive   = "drive"
+	spaceAppData = "appDataFolder"
+)
+
+// appDataFolderName is the folder under the remote root that app data is
+// listed in
+const appDataFolderName = "appDataFolder"
+
+// parseSpaces validates a comma-separated --spaces value
+func parseSpaces(value string) (string, error) {
+	var spaces []string
+	for _, space := range strings.Split(value, ",") {
+		space = strings.TrimSpace(space)
+		switch space {
+		case spaceDrive, spaceAppData:
+			spaces = append(spaces, space)
+		case "photos":
+			return "", fmt.Errorf("The photos space is no longer supported by Google Drive")
+		default:
+			return "", fmt.Errorf("Unknown Drive space %q (expected drive or appDataFolder)", space)
+		}
+	}
+	return strings.Join(spaces, ","), nil
+}
+
+func (g *DriveListing) spaces() string {
+	if g.Spaces == "" {
+		return spaceDrive
+	}
+	return g.Spaces
+}
+
+func (g *DriveListing) includesSpace(space string) bool {
+	for _, s := range strings.Split(g.spaces(), ",") {
+		if s == space {
+			return true
+		}
+	}
+	return false
+}
+
+// addAppDataFolder places the app data folder under the root, so its files
+// get paths, when the appDataFolder space is listed. App data lives outside
+// My Drive, so it's only reachable when listing everything.
+func (g *DriveListing) addAppDataFolder() error {
+	if !g.includesSpace(spaceAppData) {
+		return nil
+	}
+	if g.isScoped() {
+		return fmt.Errorf("The appDataFolder space can only be listed with the remote root at /")
+	}
+	var id string
+	err := g.call(func(ctx context.Context) error {
+		file, err := g.service.Files.Get(spaceAppData).Fields("id").Context(ctx).Do()
+		if err == nil {
+			id = file.Id
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to find the app data folder (listing it needs the drive.appdata scope): %w", err)
+	}
+	g.driveFolders[id] = &googleDriveFolder{ParentId: g.rootId, Name: appDataFolderName}
+	return nil
+}
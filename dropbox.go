@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const dropboxAPI = "https://api.dropboxapi.com/2"
+
+// dropboxProvider lists a Dropbox folder with the HTTP API, comparing by
+// Dropbox's content hash
+type dropboxProvider struct {
+	token  string
+	client *http.Client
+}
+
+func newDropboxProvider(token string) *dropboxProvider {
+	return &dropboxProvider{token: token, client: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+func (p *dropboxProvider) Name() string {
+	return "Dropbox"
+}
+
+func (p *dropboxProvider) HashAlgo() hashAlgorithm {
+	return hashDropbox
+}
+
+type dropboxEntry struct {
+	Tag            string `json:".tag"`
+	PathDisplay    string `json:"path_display"`
+	Size           int64  `json:"size"`
+	ServerModified string `json:"server_modified"`
+	ContentHash    string `json:"content_hash"`
+}
+
+type dropboxListResult struct {
+	Entries []*dropboxEntry `json:"entries"`
+	Cursor  string          `json:"cursor"`
+	HasMore bool            `json:"has_more"`
+}
+
+// List lists every file under root recursively
+func (p *dropboxProvider) List(ctx context.Context, root string, add func(*File) error) error {
+	// the API names the root folder "", and everything else from a leading /
+	root = strings.TrimSuffix(root, "/")
+	result := &dropboxListResult{}
+	err := p.call(ctx, "files/list_folder", map[string]interface{}{"path": root, "recursive": true, "limit": 2000}, result)
+	for {
+		if err != nil {
+			return fmt.Errorf("Unable to list Dropbox folder %q: %v", root, err)
+		}
+		for _, entry := range result.Entries {
+			if entry.Tag != "file" {
+				continue
+			}
+			if err := add(newDropboxFile(root, entry)); err != nil {
+				return err
+			}
+		}
+		if !result.HasMore {
+			return nil
+		}
+		cursor := result.Cursor
+		result = &dropboxListResult{}
+		err = p.call(ctx, "files/list_folder/continue", map[string]string{"cursor": cursor}, result)
+	}
+}
+
+func newDropboxFile(root string, entry *dropboxEntry) *File {
+	// path_display only has the right case for the last component, but that's
+	// all that's compared
+	relPath := strings.TrimPrefix(entry.PathDisplay[len(root):], "/")
+	modifiedTime, _ := time.Parse(time.RFC3339, entry.ServerModified)
+	return &File{
+		Path:         strings.ToLower(normalizeUnicodeCharacters(relPath)),
+		RawPath:      relPath,
+		ContentHash:  entry.ContentHash,
+		Size:         entry.Size,
+		ModifiedTime: modifiedTime,
+		WebLink:      "https://www.dropbox.com/home" + (&url.URL{Path: path.Dir(entry.PathDisplay)}).EscapedPath() + "?preview=" + url.QueryEscape(path.Base(entry.PathDisplay)),
+	}
+}
+
+// call makes a Dropbox RPC request, retrying rate limits, server errors and
+// network failures
+func (p *dropboxProvider) call(ctx context.Context, endpoint string, args interface{}, result interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	_, data, err := providerRequest(ctx, p.client, "Dropbox API", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", dropboxAPI+"/"+endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Dropbox content hashes are the SHA-256 of the concatenated SHA-256 hashes
+// of each 4 MiB block
+const dropboxBlockSize = 4 * 1024 * 1024
+
+type dropboxContentHash struct {
+	block       hash.Hash
+	blockLen    int
+	blockHashes []byte
+}
+
+func newDropboxContentHash() hash.Hash {
+	return &dropboxContentHash{block: sha256.New()}
+}
+
+func (d *dropboxContentHash) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := dropboxBlockSize - d.blockLen
+		if n > len(p) {
+			n = len(p)
+		}
+		d.block.Write(p[:n])
+		d.blockLen += n
+		p = p[n:]
+		if d.blockLen == dropboxBlockSize {
+			d.blockHashes = d.block.Sum(d.blockHashes)
+			d.block.Reset()
+			d.blockLen = 0
+		}
+	}
+	return written, nil
+}
+
+func (d *dropboxContentHash) Sum(b []byte) []byte {
+	overall := sha256.New()
+	overall.Write(d.blockHashes)
+	if d.blockLen > 0 {
+		overall.Write(d.block.Sum(nil))
+	}
+	return overall.Sum(b)
+}
+
+func (d *dropboxContentHash) Reset() {
+	d.block.Reset()
+	d.blockLen = 0
+	d.blockHashes = nil
+}
+
+func (d *dropboxContentHash) Size() int {
+	return sha256.Size
+}
+
+func (d *dropboxContentHash) BlockSize() int {
+	return sha256.BlockSize
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/oauth2"
+)
+
+// Containers and NAS task schedulers are easier to configure with
+// environment variables than with command lines and files in a home
+// directory, so every verify option can also be set as GDSV_ and its long
+// name, e.g. GDSV_REMOTE for --remote, and the OAuth client and token can be
+// given as JSON instead of being read from the config directory.
+
+const (
+	// envPrefix starts the environment variable for each verify option
+	envPrefix = "GDSV_"
+	// envConfigDir replaces ~/.googledrive-sync-verifier
+	envConfigDir = "GDSV_CONFIG_DIR"
+	// envCredentialsJSON holds the contents of credentials.json
+	envCredentialsJSON = "GDSV_CREDENTIALS_JSON"
+	// envTokenJSON holds the contents of token.json
+	envTokenJSON = "GDSV_TOKEN_JSON"
+)
+
+// nonInteractive is set by --non-interactive, so nothing waits for input
+var nonInteractive bool
+
+// envListDelims separate the values of options that can be given more than
+// once; anything else is split on commas
+var envListDelims = map[string]string{
+	"local": string(os.PathListSeparator),
+}
+
+// setOptionEnv lets each of the parser's options be set from the
+// environment, except those that already read their own variable (e.g.
+// AWS_ACCESS_KEY_ID)
+func setOptionEnv(parser *flags.Parser) {
+	for _, group := range parser.Groups() {
+		for _, option := range group.Options() {
+			if option.EnvDefaultKey != "" || option.LongName == "" {
+				continue
+			}
+			option.EnvDefaultKey = envPrefix + strings.ToUpper(strings.ReplaceAll(option.LongName, "-", "_"))
+			if option.Field().Type.Kind() == reflect.Slice {
+				option.EnvDefaultDelim = ","
+				if delim, ok := envListDelims[option.LongName]; ok {
+					option.EnvDefaultDelim = delim
+				}
+			}
+		}
+	}
+}
+
+// envConfigDirPath returns GDSV_CONFIG_DIR, if it's set
+func envConfigDirPath() (string, bool) {
+	dir := os.Getenv(envConfigDir)
+	return dir, dir != ""
+}
+
+// envCredentials returns the OAuth client from GDSV_CREDENTIALS_JSON, if
+// it's set
+func envCredentials() ([]byte, bool) {
+	credentials := os.Getenv(envCredentialsJSON)
+	return []byte(credentials), credentials != ""
+}
+
+// envToken returns the token from GDSV_TOKEN_JSON, if it's set and tokFile
+// is the default account's token; named accounts keep their own tokens
+func envToken(tokFile string) (*oauth2.Token, bool, error) {
+	contents := os.Getenv(envTokenJSON)
+	if contents == "" || filepath.Base(tokFile) != "token.json" {
+		return nil, false, nil
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(contents), tok); err != nil {
+		return nil, true, fmt.Errorf("Unable to parse %s: %v", envTokenJSON, err)
+	}
+	return tok, true, nil
+}
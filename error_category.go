@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrorCategory groups errors by cause, for reports and for deciding what's
+// worth retrying
+type ErrorCategory string
+
+const (
+	// the token is missing, revoked or lacks a scope
+	CategoryAuth ErrorCategory = "auth"
+	// a Drive rate limit or quota was exceeded
+	CategoryQuota ErrorCategory = "quota"
+	// the Google account's storage is full
+	CategoryStorage ErrorCategory = "storage-quota"
+	// a connection failed or was dropped
+	CategoryNetwork ErrorCategory = "network"
+	// the server failed (a 5xx response)
+	CategoryServer ErrorCategory = "server"
+	// a request or read made no progress in time
+	CategoryTimeout ErrorCategory = "timeout"
+	// the file or folder isn't there (any more)
+	CategoryNotFound ErrorCategory = "not-found"
+	// a local file can't be read, or a Drive file can't be accessed
+	CategoryPermission ErrorCategory = "permission-denied"
+	// Drive rejected the request itself
+	CategoryInvalid ErrorCategory = "invalid-request"
+	// reading a local file failed otherwise
+	CategoryIO        ErrorCategory = "io"
+	CategoryCancelled ErrorCategory = "cancelled"
+	CategoryOther     ErrorCategory = "other"
+)
+
+// permanent reports whether errors in the category won't go away by trying
+// again; the rest are retried
+func (c ErrorCategory) permanent() bool {
+	switch c {
+	case CategoryAuth, CategoryStorage, CategoryNotFound, CategoryPermission, CategoryInvalid, CategoryCancelled:
+		return true
+	}
+	return false
+}
+
+// categorizedError is an error tagged with its category
+type categorizedError struct {
+	Category ErrorCategory
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// withCategory tags err with category
+func withCategory(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{Category: category, err: err}
+}
+
+// categorized tags err with the category it falls in, unless it's already
+// tagged
+func categorized(err error) error {
+	var tagged *categorizedError
+	if err == nil || errors.As(err, &tagged) {
+		return err
+	}
+	return withCategory(inferErrorCategory(err), err)
+}
+
+// errorCategory returns the category err was tagged with, or the one it
+// falls in ("" for nil)
+func errorCategory(err error) ErrorCategory {
+	var tagged *categorizedError
+	if err == nil {
+		return ""
+	} else if errors.As(err, &tagged) {
+		return tagged.Category
+	}
+	return inferErrorCategory(err)
+}
+
+func inferErrorCategory(err error) ErrorCategory {
+	var apiErr *googleapi.Error
+	var retrieveErr *oauth2.RetrieveError
+	var netErr net.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CategoryCancelled
+	case errors.As(err, new(readTimeoutError)), errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout
+	case errors.As(err, &retrieveErr):
+		// refreshing the token failed
+		if retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= 500 {
+			return CategoryServer
+		}
+		return CategoryAuth
+	case errors.As(err, &apiErr):
+		return apiErrorCategory(apiErr)
+	case errors.Is(err, fs.ErrPermission):
+		return CategoryPermission
+	case errors.Is(err, fs.ErrNotExist):
+		return CategoryNotFound
+	case errors.As(err, &pathErr):
+		// checked before net.Error, which every syscall.Errno satisfies
+		return CategoryIO
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+		return CategoryNetwork
+	}
+	return CategoryOther
+}
+
+func apiErrorCategory(apiErr *googleapi.Error) ErrorCategory {
+	switch {
+	case apiErr.Code == 401:
+		return CategoryAuth
+	case isRateLimitError(apiErr):
+		return CategoryQuota
+	case apiErr.Code == 403:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "quotaExceeded" || item.Reason == "dailyLimitExceeded" {
+				return CategoryQuota
+			} else if item.Reason == "storageQuotaExceeded" {
+				// only freeing up space helps
+				return CategoryStorage
+			}
+		}
+		// a token without the drive.readonly scope can't download
+		if strings.Contains(apiErr.Message, "scope") {
+			return CategoryAuth
+		}
+		return CategoryPermission
+	case apiErr.Code == 404:
+		return CategoryNotFound
+	case apiErr.Code >= 500:
+		return CategoryServer
+	}
+	return CategoryInvalid
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// localEstimate is the size of the local tree found by a quick pre-scan
+type localEstimate struct {
+	Files int
+	Bytes int64
+}
+
+// estimateLocalTree counts the files and bytes that a full scan would hash,
+// walking the tree the same way without reading any file contents
+func estimateLocalTree(localRoot string, localDirs []string, opts localScanOptions) *localEstimate {
+	localRootLowercase := strings.ToLower(localRoot)
+	processChan := make(chan *localEntry)
+	errorChan := make(chan *FileError)
+	walker := &localWalker{
+		root:           localRoot,
+		followSymlinks: opts.FollowSymlinks,
+		rules:          opts.PathRules,
+		processChan:    processChan,
+		errorChan:      errorChan,
+		concurrency:    opts.WalkConcurrency,
+	}
+	go func() {
+		if len(localDirs) > 0 {
+			for _, dir := range localDirs {
+				walker.walk(filepath.Join(localRoot, dir))
+			}
+		} else {
+			walker.walk(localRoot)
+		}
+		close(processChan)
+	}()
+
+	estimate := &localEstimate{}
+	for {
+		select {
+		case entry, ok := <-processChan:
+			if !ok {
+				return estimate
+			}
+			_, filteredPath, err := localManifestPath(localRootLowercase, entry.Path, opts.PathRules)
+			if err != nil || !opts.Shard.includes(filteredPath) || !opts.Sample.includes(filteredPath) {
+				continue
+			}
+			estimate.Files++
+			estimate.Bytes += entry.Info.Size()
+		case <-errorChan:
+			// reported by the full scan
+		}
+	}
+}
+
+func (e *localEstimate) String() string {
+	return fmt.Sprintf("%s files / %s", humanize.Comma(int64(e.Files)), humanize.Bytes(uint64(e.Bytes)))
+}
+
+// progress describes how far a scan has got through the estimated tree
+func (e *localEstimate) progress(files int, bytes int64) string {
+	// bytes stay at zero when content hashing is skipped
+	percent := 100.0
+	if bytes > 0 && e.Bytes > 0 {
+		percent = float64(bytes) / float64(e.Bytes) * 100
+	} else if e.Files > 0 {
+		percent = float64(files) / float64(e.Files) * 100
+	}
+	return fmt.Sprintf("%s/%s files, %s/%s (%.0f%%)", humanize.Comma(int64(files)), humanize.Comma(int64(e.Files)), humanize.Bytes(uint64(bytes)), humanize.Bytes(uint64(e.Bytes)), percent)
+}
+
+// looksEmpty reports whether the local tree is much smaller than the remote
+// one, suggesting an unmounted drive or wrong local root
+func (e *localEstimate) looksEmpty(remoteFiles int) bool {
+	return remoteFiles >= suspectManifestSize && e.Files < remoteFiles/10
+}
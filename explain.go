@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// explainer traces one path through listing, filtering and comparison for
+// --explain; it's nil (and every method a no-op) otherwise
+var explainer *pathExplainer
+
+type pathExplainer struct {
+	path string
+	key  string
+	lock sync.Mutex
+	// steps are recorded concurrently by the remote and local scans
+	steps []string
+}
+
+func newPathExplainer(path string) *pathExplainer {
+	return &pathExplainer{path: path, key: explainKey(path)}
+}
+
+// explainKey reduces a path to the form manifests are compared by, before
+// filtering, so the path can be given as it appears on either side
+func explainKey(path string) string {
+	return strings.Trim(strings.ToLower(normalizeUnicodeCharacters(filepath.ToSlash(path))), "/")
+}
+
+// wants reports whether any of paths is the one being explained
+func (e *pathExplainer) wants(paths ...string) bool {
+	if e == nil {
+		return false
+	}
+	for _, path := range paths {
+		if path != "" && explainKey(path) == e.key {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *pathExplainer) note(format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.steps = append(e.steps, fmt.Sprintf(format, args...))
+}
+
+// noteCandidates records the files compared for the explained path
+func (e *pathExplainer) noteCandidates(remotes, locals []*File) {
+	e.note("comparing %d remote and %d local file(s) with this path", len(remotes), len(locals))
+	for _, remote := range remotes {
+		e.note("  remote candidate %q: hash %s, %d bytes", remote.RawPath, remote.ContentHash, remote.Size)
+	}
+	for _, local := range locals {
+		e.note("  local candidate: hash %s, %d bytes", local.ContentHash, local.Size)
+	}
+}
+
+// noteResults records how the explained path was finally classified
+func (e *pathExplainer) noteResults(comparison *ManifestComparison) {
+	if e == nil {
+		return
+	}
+	found := false
+	for _, result := range comparison.Results() {
+		var remotePath, localPath string
+		if result.Remote != nil {
+			remotePath = result.Remote.Path
+		}
+		if result.Local != nil {
+			localPath = result.Local.Path
+		}
+		if e.wants(result.Path, remotePath, localPath) {
+			e.note("classified as %s", result.Status)
+			found = true
+		}
+	}
+	if !found {
+		e.note("not in the results: it wasn't in either manifest")
+	}
+}
+
+func (e *pathExplainer) Print() {
+	if e == nil {
+		return
+	}
+	fmt.Printf("\nEXPLAIN %s:\n", e.path)
+	if len(e.steps) == 0 {
+		fmt.Println("No file with this path was found remotely or locally.")
+		return
+	}
+	for i, step := range e.steps {
+		fmt.Printf("%d. %s\n", i+1, step)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// manifestReader yields Files in path order. Len is the number of Files not
+// yet popped.
+type manifestReader interface {
+	Len() int
+	PopOrNil() *File
+}
+
+// spillConfig controls when manifests are spilled to disk
+type spillConfig struct {
+	// Threshold is the number of Files held in memory before a sorted run is
+	// written to disk (0 to keep everything in memory)
+	Threshold int
+	// Dir holds the temporary run files (the system temp dir if empty)
+	Dir string
+}
+
+// sortedManifest collects Files in any order and yields them sorted by path,
+// using an external merge sort so memory use is bounded by the spill
+// threshold rather than the number of files. Add must not be called once
+// PopOrNil has been.
+type sortedManifest struct {
+	config  spillConfig
+	buffer  []*File
+	runs    []*os.File
+	count   int
+	popped  int
+	merging bool
+	heads   manifestRunHeap
+	err     error
+}
+
+func newSortedManifest(config spillConfig) *sortedManifest {
+	return &sortedManifest{config: config}
+}
+
+// Add a File to the manifest, spilling buffered Files to disk if needed
+func (m *sortedManifest) Add(file *File) error {
+	m.buffer = append(m.buffer, file)
+	m.count++
+	if m.config.Threshold > 0 && len(m.buffer) >= m.config.Threshold {
+		return m.spill()
+	}
+	return nil
+}
+
+func (m *sortedManifest) spill() error {
+	m.sortBuffer()
+	f, err := ioutil.TempFile(m.config.Dir, "gdsv-manifest-")
+	if err != nil {
+		return err
+	}
+	m.runs = append(m.runs, f)
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, file := range m.buffer {
+		if err := enc.Encode(file); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	m.buffer = nil
+	return nil
+}
+
+func (m *sortedManifest) sortBuffer() {
+	sort.Slice(m.buffer, func(i, j int) bool { return m.buffer[i].Path < m.buffer[j].Path })
+}
+
+func (m *sortedManifest) Len() int {
+	return m.count - m.popped
+}
+
+// Spilled reports how many sorted runs were written to disk
+func (m *sortedManifest) Spilled() int {
+	return len(m.runs)
+}
+
+// PopOrNil returns the next File in path order, or nil once all have been
+// returned or a spilled run can't be read (see Err)
+func (m *sortedManifest) PopOrNil() *File {
+	if !m.merging {
+		m.startMerge()
+	}
+	if m.err != nil || m.heads.Len() == 0 {
+		return nil
+	}
+	run := m.heads[0]
+	file := run.head
+	if err := run.advance(); err != nil {
+		m.err = err
+		return nil
+	}
+	if run.head == nil {
+		heap.Pop(&m.heads)
+	} else {
+		heap.Fix(&m.heads, 0)
+	}
+	m.popped++
+	return file
+}
+
+func (m *sortedManifest) startMerge() {
+	m.merging = true
+	m.sortBuffer()
+	runs := []*manifestRun{{files: m.buffer}}
+	m.buffer = nil
+	for _, f := range m.runs {
+		runs = append(runs, &manifestRun{decoder: gob.NewDecoder(bufio.NewReader(f))})
+	}
+	for _, run := range runs {
+		if err := run.advance(); err != nil {
+			m.err = err
+			return
+		}
+		if run.head != nil {
+			m.heads = append(m.heads, run)
+		}
+	}
+	heap.Init(&m.heads)
+}
+
+// Err returns the first error encountered reading spilled runs
+func (m *sortedManifest) Err() error {
+	return m.err
+}
+
+// Close removes any spilled run files
+func (m *sortedManifest) Close() error {
+	var firstErr error
+	for _, f := range m.runs {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	m.runs = nil
+	return firstErr
+}
+
+// manifestRun is one sorted sequence of Files, either in memory or decoded
+// from a spilled run file
+type manifestRun struct {
+	head    *File
+	files   []*File
+	decoder *gob.Decoder
+}
+
+func (r *manifestRun) advance() error {
+	r.head = nil
+	if r.decoder == nil {
+		if len(r.files) > 0 {
+			r.head = r.files[0]
+			r.files[0] = nil
+			r.files = r.files[1:]
+		}
+		return nil
+	}
+	file := &File{}
+	err := r.decoder.Decode(file)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	r.head = file
+	return nil
+}
+
+// manifestRunHeap orders runs by the path of their next File
+type manifestRunHeap []*manifestRun
+
+func (h manifestRunHeap) Len() int           { return len(h) }
+func (h manifestRunHeap) Less(i, j int) bool { return h[i].head.Path < h[j].head.Path }
+func (h manifestRunHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *manifestRunHeap) Push(x interface{}) {
+	*h = append(*h, x.(*manifestRun))
+}
+
+func (h *manifestRunHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
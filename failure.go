@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// printFailure reports an error that stopped a run, with a hint at how to fix
+// it. Panics are left for bugs.
+func printFailure(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	if hint := failureHint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+	}
+}
+
+// failureHint suggests what to do about err, based on its category ("" if
+// there's nothing useful to add)
+func failureHint(err error) string {
+	if strings.Contains(err.Error(), "auth login") {
+		// the error already says what to do
+		return ""
+	}
+	var retrieveErr *oauth2.RetrieveError
+	var pathErr *fs.PathError
+	local := errors.As(err, &pathErr)
+	switch errorCategory(err) {
+	case CategoryAuth:
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			return "The saved token has expired or been revoked (tokens for OAuth clients in testing expire after 7 days); run auth login --force to authorize again."
+		}
+		return "Run auth login --force to authorize again."
+	case CategoryNotFound:
+		if local {
+			return fmt.Sprintf("Check that %s exists, and if it's on an external drive or network share, that it's mounted.", pathErr.Path)
+		}
+		return "Check the remote root path or --remote-id, and that the signed-in account can see it."
+	case CategoryPermission:
+		if local {
+			return fmt.Sprintf("Check that you can read %s (on macOS, the terminal may need Full Disk Access).", pathErr.Path)
+		}
+		return "Check that the signed-in account can access the remote root; auth status shows the scopes the token has."
+	case CategoryStorage:
+		return "The Google account's storage is full; free up space in Google Drive and try again."
+	case CategoryQuota:
+		return "Google Drive's API quota was used up; try again later, or slow requests down with --max-qps."
+	case CategoryNetwork, CategoryServer, CategoryTimeout:
+		return "Check the network connection and try again; --remote-timeout sets how long a single Drive request may take."
+	}
+	return ""
+}
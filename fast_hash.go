@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The hash cache (--hash-cache) saves each local file's hash with an xxHash
+// of its contents. On the next run a file the same size as before is read
+// with only xxHash, several times faster than MD5 or SHA; if that still
+// matches, the saved hash is reused, and otherwise the file is hashed again.
+// Unlike --resume, a changed file is caught even if its modification time
+// wasn't.
+
+// hashXXH64 is xxHash64, used only for the hash cache since remote providers
+// have no such checksum
+const hashXXH64 hashAlgorithm = "xxh64"
+
+// withFastHash is algo computed alongside xxHash64 in one pass, as the two
+// hex sums concatenated
+func (a hashAlgorithm) withFastHash() hashAlgorithm {
+	return hashAlgorithm(a.String() + "+" + string(hashXXH64))
+}
+
+// splitFastHash splits a sum from withFastHash into its two parts
+func splitFastHash(sum string) (hash string, fastHash string) {
+	split := len(sum) - 2*8
+	return sum[:split], sum[split:]
+}
+
+// hashCacheFile saves the local scan for the next run's hash cache. It's
+// written next to the cache and renamed over it once complete, so a
+// cancelled run leaves the previous cache in place. Files from the previous
+// cache that this run didn't hash (another --rotate shard, a folder left out
+// of selective sync, or filtered out by size or age) are carried forward if
+// they still exist.
+type hashCacheFile struct {
+	*manifestWriter
+	path     string
+	tmp      string
+	root     string
+	previous map[string]*File
+	written  map[string]bool
+}
+
+func createHashCache(config *verifyConfig, previous map[string]*File) (*hashCacheFile, error) {
+	// keep the extension, which says whether the file is gzipped
+	tmp := filepath.Join(filepath.Dir(config.HashCache), "saving-"+filepath.Base(config.HashCache))
+	w, err := createManifestFile(tmp, &manifestHeader{
+		Kind:     manifestKindLocal,
+		Root:     config.LocalRoot,
+		HashAlgo: config.Local.HashAlgo.String(),
+		Created:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hashCacheFile{manifestWriter: w, path: config.HashCache, tmp: tmp, root: config.LocalRoot, previous: previous, written: make(map[string]bool)}, nil
+}
+
+func (c *hashCacheFile) Write(file *File) error {
+	c.written[hashCacheKey(file)] = true
+	return c.manifestWriter.Write(file)
+}
+
+// Commit adds the previous cache's files this run didn't reach, closes the
+// new cache and replaces the previous one with it
+func (c *hashCacheFile) Commit() error {
+	for relPath, file := range c.previous {
+		if c.written[relPath] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(c.root, filepath.FromSlash(relPath))); os.IsNotExist(err) {
+			continue
+		}
+		if err := c.manifestWriter.Write(file); err != nil {
+			c.manifestWriter.Close()
+			return err
+		}
+	}
+	if err := c.manifestWriter.Close(); err != nil {
+		return err
+	}
+	return os.Rename(c.tmp, c.path)
+}
+
+// Discard closes and removes the new cache, keeping the previous one
+func (c *hashCacheFile) Discard() {
+	c.manifestWriter.Close()
+	os.Remove(c.tmp)
+}
+
+// loadHashCache reads the files saved by the previous run with the same local
+// root and hash algorithm, keyed by their normalized path relative to the
+// root
+func loadHashCache(config *verifyConfig) (map[string]*File, error) {
+	files := make(map[string]*File)
+	header, _, err := readManifestFile(config.HashCache, func(_ *manifestHeader, file *File) error {
+		if file.ContentHash == "" || file.FastHash == "" {
+			return nil
+		}
+		files[hashCacheKey(file)] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkManifestHeader(config.HashCache, header, manifestKindLocal, config.Local.HashAlgo); err != nil {
+		return nil, err
+	}
+	if header.Root != config.LocalRoot {
+		return nil, fmt.Errorf("%s is a hash cache for %s, not %s", config.HashCache, header.Root, config.LocalRoot)
+	}
+	return files, nil
+}
+
+// hashCacheKey is the path a file is kept under in the hash cache
+func hashCacheKey(file *File) string {
+	if file.OriginalPath != "" {
+		return file.OriginalPath
+	}
+	return file.Path
+}
+
+// hashLocalFileCached hashes a local file for the hash cache, reusing the
+// previous run's hash if the file is the same size and its xxHash is
+// unchanged. reused is set if the hash came from the cache.
+func hashLocalFileCached(ctx context.Context, path string, size int64, previous *File, opts localScanOptions) (hash string, fastHash string, reused bool, err error) {
+	if previous != nil && previous.Size == size {
+		fastHash, err = hashLocalFileWithTimeout(ctx, path, opts.HashTimeout, hashXXH64, opts.ReadLimiter)
+		if err != nil {
+			return "", "", false, err
+		}
+		if fastHash == previous.FastHash {
+			return previous.ContentHash, fastHash, true, nil
+		}
+	}
+	sum, err := hashLocalFileWithTimeout(ctx, path, opts.HashTimeout, opts.HashAlgo.withFastHash(), opts.ReadLimiter)
+	if err != nil {
+		return "", "", false, err
+	}
+	hash, fastHash = splitFastHash(sum)
+	return hash, fastHash, false, nil
+}
+
+// pairedHash writes to two hashes at once, summing to both sums concatenated
+type pairedHash struct {
+	first, second hash.Hash
+}
+
+func (h *pairedHash) Write(b []byte) (int, error) {
+	h.first.Write(b)
+	return h.second.Write(b)
+}
+
+func (h *pairedHash) Sum(b []byte) []byte {
+	return h.second.Sum(h.first.Sum(b))
+}
+
+func (h *pairedHash) Reset() {
+	h.first.Reset()
+	h.second.Reset()
+}
+
+func (h *pairedHash) Size() int {
+	return h.first.Size() + h.second.Size()
+}
+
+func (h *pairedHash) BlockSize() int {
+	return h.first.BlockSize()
+}
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is xxHash64 with a seed of 0, summing to the canonical big-endian
+// form that xxhsum prints
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+func newXXH64() *xxh64 {
+	h := &xxh64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	// the seed (0) plus and minus primes, wrapping around
+	h.v1 = xxhPrime1
+	h.v1 += xxhPrime2
+	h.v2 = xxhPrime2
+	h.v3 = 0
+	h.v4 = 0
+	h.v4 -= xxhPrime1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) Size() int {
+	return 8
+}
+
+func (h *xxh64) BlockSize() int {
+	return 32
+}
+
+func (h *xxh64) Write(b []byte) (int, error) {
+	written := len(b)
+	h.total += uint64(written)
+	if h.n+len(b) < 32 {
+		h.n += copy(h.mem[h.n:], b)
+		return written, nil
+	}
+	if h.n > 0 {
+		b = b[copy(h.mem[h.n:], b):]
+		h.stripe(h.mem[:])
+		h.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		h.stripe(b)
+	}
+	h.n = copy(h.mem[:], b)
+	return written, nil
+}
+
+func (h *xxh64) stripe(b []byte) {
+	h.v1 = xxhRound(h.v1, binary.LittleEndian.Uint64(b[0:]))
+	h.v2 = xxhRound(h.v2, binary.LittleEndian.Uint64(b[8:]))
+	h.v3 = xxhRound(h.v3, binary.LittleEndian.Uint64(b[16:]))
+	h.v4 = xxhRound(h.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) + bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxhMergeRound(acc, h.v1)
+		acc = xxhMergeRound(acc, h.v2)
+		acc = xxhMergeRound(acc, h.v3)
+		acc = xxhMergeRound(acc, h.v4)
+	} else {
+		acc = xxhPrime5
+	}
+	acc += h.total
+
+	b := h.mem[:h.n]
+	for ; len(b) >= 8; b = b[8:] {
+		acc ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+		acc = bits.RotateLeft64(acc, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(b) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(b)) * xxhPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxhPrime2 + xxhPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		acc ^= uint64(c) * xxhPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxhPrime1
+	}
+
+	acc ^= acc >> 33
+	acc *= xxhPrime2
+	acc ^= acc >> 29
+	acc *= xxhPrime3
+	acc ^= acc >> 32
+	return acc
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	return bits.RotateLeft64(acc, 31) * xxhPrime1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// fileFilter limits verification to files within a size and modification
+// time range, applied to both manifests. Zero values mean no limit.
+type fileFilter struct {
+	MinSize        int64
+	MaxSize        int64
+	ModifiedSince  time.Time
+	ModifiedBefore time.Time
+}
+
+// newFileFilter parses the --min-size, --max-size, --modified-since and
+// --modified-before values, returning nil if none are set
+func newFileFilter(minSize, maxSize, since, before string) (*fileFilter, error) {
+	if minSize == "" && maxSize == "" && since == "" && before == "" {
+		return nil, nil
+	}
+	f := &fileFilter{}
+	var err error
+	if f.MinSize, err = parseFilterSize(minSize); err != nil {
+		return nil, err
+	}
+	if f.MaxSize, err = parseFilterSize(maxSize); err != nil {
+		return nil, err
+	}
+	if f.ModifiedSince, err = parseFilterTime(since); err != nil {
+		return nil, err
+	}
+	if f.ModifiedBefore, err = parseFilterTime(before); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseFilterSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q: %v", s, err)
+	}
+	return int64(size), nil
+}
+
+// parseFilterTime accepts a date (2006-01-02), an RFC 3339 time, or an age
+// such as 72h or 30d, meaning that long ago
+func parseFilterTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if age, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q (expected a date like 2006-01-02, an RFC 3339 time, or an age like 72h or 30d)", s)
+}
+
+// includes reports whether a file of this size and modification time is
+// verified
+func (f *fileFilter) includes(size int64, modified time.Time) bool {
+	if f == nil {
+		return true
+	}
+	if size < f.MinSize || (f.MaxSize > 0 && size > f.MaxSize) {
+		return false
+	}
+	if !f.ModifiedSince.IsZero() && modified.Before(f.ModifiedSince) {
+		return false
+	}
+	if !f.ModifiedBefore.IsZero() && !modified.Before(f.ModifiedBefore) {
+		return false
+	}
+	return true
+}
+
+func (f *fileFilter) String() string {
+	var parts []string
+	if f.MinSize > 0 {
+		parts = append(parts, "at least "+humanize.Bytes(uint64(f.MinSize)))
+	}
+	if f.MaxSize > 0 {
+		parts = append(parts, "at most "+humanize.Bytes(uint64(f.MaxSize)))
+	}
+	if !f.ModifiedSince.IsZero() {
+		parts = append(parts, "modified since "+f.ModifiedSince.Format("2006-01-02 15:04"))
+	}
+	if !f.ModifiedBefore.IsZero() {
+		parts = append(parts, "modified before "+f.ModifiedBefore.Format("2006-01-02 15:04"))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// How many extensions PrintFileTypes lists
+const fileTypeRows = 20
+
+// sizeBuckets are the upper bounds of the size ranges results are grouped
+// into; the last range has no upper bound
+var sizeBuckets = []int64{1 << 20, 100 << 20, 1 << 30}
+
+// fileTypeCounts tallies the results for one extension or size range
+type fileTypeCounts struct {
+	Matched    int
+	Mismatched int
+	OnlyRemote int
+	OnlyLocal  int
+}
+
+func (c *fileTypeCounts) problems() int {
+	return c.Mismatched + c.OnlyRemote + c.OnlyLocal
+}
+
+func (c *fileTypeCounts) total() int {
+	return c.Matched + c.problems()
+}
+
+// fileTypeStats breaks results down by extension and size, to show patterns
+// like every .heic file failing that point at a sync client bug. Matches
+// are counted as they're found, so they needn't be kept in memory; problems
+// are counted from the results when printed. A nil *fileTypeStats counts
+// nothing.
+type fileTypeStats struct {
+	matchedByExt  map[string]int
+	matchedBySize []int
+}
+
+func newFileTypeStats() *fileTypeStats {
+	return &fileTypeStats{matchedByExt: make(map[string]int), matchedBySize: make([]int, len(sizeBuckets)+1)}
+}
+
+func (s *fileTypeStats) match(file *File) {
+	if s == nil {
+		return
+	}
+	s.matchedByExt[fileExtension(file.Path)]++
+	s.matchedBySize[sizeBucket(file.Size)]++
+}
+
+// unmatch takes back a match counted before its contents were compared
+func (s *fileTypeStats) unmatch(file *File) {
+	if s == nil {
+		return
+	}
+	s.matchedByExt[fileExtension(file.Path)]--
+	s.matchedBySize[sizeBucket(file.Size)]--
+}
+
+// fileExtension returns the lowercased extension of a manifest path, or
+// "(none)"; anything after the last dot that has spaces isn't an extension
+func fileExtension(filePath string) string {
+	ext := path.Ext(filePath)
+	if len(ext) < 2 || strings.Contains(ext, " ") {
+		return "(none)"
+	}
+	return ext
+}
+
+func sizeBucket(size int64) int {
+	for i, limit := range sizeBuckets {
+		if size < limit {
+			return i
+		}
+	}
+	return len(sizeBuckets)
+}
+
+func sizeBucketName(i int) string {
+	switch {
+	case i == 0:
+		return "under " + humanize.IBytes(uint64(sizeBuckets[0]))
+	case i == len(sizeBuckets):
+		return humanize.IBytes(uint64(sizeBuckets[i-1])) + " and over"
+	default:
+		return humanize.IBytes(uint64(sizeBuckets[i-1])) + " to " + humanize.IBytes(uint64(sizeBuckets[i]))
+	}
+}
+
+// PrintFileTypes prints the results by extension, most problems first, and
+// by size. It needs ComparisonOptions.FileTypes.
+func (mc *ManifestComparison) PrintFileTypes() {
+	if mc.fileTypes == nil {
+		return
+	}
+	byExt := make(map[string]*fileTypeCounts)
+	bySize := make([]*fileTypeCounts, len(sizeBuckets)+1)
+	for i := range bySize {
+		bySize[i] = &fileTypeCounts{Matched: mc.fileTypes.matchedBySize[i]}
+	}
+	extCounts := func(file *File) *fileTypeCounts {
+		ext := fileExtension(file.Path)
+		if byExt[ext] == nil {
+			byExt[ext] = &fileTypeCounts{}
+		}
+		return byExt[ext]
+	}
+	for ext, matched := range mc.fileTypes.matchedByExt {
+		byExt[ext] = &fileTypeCounts{Matched: matched}
+	}
+	for _, file := range mc.OnlyRemote {
+		extCounts(file).OnlyRemote++
+		bySize[sizeBucket(file.Size)].OnlyRemote++
+	}
+	for _, file := range mc.OnlyLocal {
+		extCounts(file).OnlyLocal++
+		bySize[sizeBucket(file.Size)].OnlyLocal++
+	}
+	for _, pairs := range [][]*FilePair{mc.ContentMismatch, mc.EmptyLocal} {
+		for _, pair := range pairs {
+			extCounts(pair.Remote).Mismatched++
+			bySize[sizeBucket(pair.Remote.Size)].Mismatched++
+		}
+	}
+	// spot check failures were counted as matches when found
+	for _, pair := range mc.SpotCheckFailed {
+		counts := extCounts(pair.Remote)
+		counts.Matched--
+		counts.Mismatched++
+		bySize[sizeBucket(pair.Remote.Size)].Matched--
+		bySize[sizeBucket(pair.Remote.Size)].Mismatched++
+	}
+
+	var exts []string
+	for ext := range byExt {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		a, b := byExt[exts[i]], byExt[exts[j]]
+		if a.problems() != b.problems() {
+			return a.problems() > b.problems()
+		}
+		if a.total() != b.total() {
+			return a.total() > b.total()
+		}
+		return exts[i] < exts[j]
+	})
+
+	fmt.Println("FILE TYPES:")
+	fmt.Println("")
+	printFileTypeHeader("Extension")
+	for i, ext := range exts {
+		if i == fileTypeRows {
+			fmt.Printf("(%d more extensions)\n", len(exts)-fileTypeRows)
+			break
+		}
+		printFileTypeRow(ext, byExt[ext])
+	}
+	fmt.Println("")
+	printFileTypeHeader("Size")
+	for i, counts := range bySize {
+		printFileTypeRow(sizeBucketName(i), counts)
+	}
+	fmt.Println("")
+}
+
+func printFileTypeHeader(label string) {
+	fmt.Printf("%-20s %9s %9s %9s %12s %12s\n", label, "Files", "Matched", "Mismatch", "Only remote", "Only local")
+}
+
+func printFileTypeRow(label string, counts *fileTypeCounts) {
+	problems := ""
+	if counts.problems() > 0 {
+		problems = fmt.Sprintf("  %.0f%% problems", 100*float64(counts.problems())/float64(counts.total()))
+	}
+	fmt.Printf("%-20s %9d %9d %9d %12d %12d%s\n", label, counts.total(), counts.Matched, counts.Mismatched, counts.OnlyRemote, counts.OnlyLocal, problems)
+}
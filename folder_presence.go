@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// With --check-folders, the folder trees are compared as well as the files,
+// so a missing folder with nothing in it (or only other empty folders) is
+// reported. A missing folder with files in it is already reported through
+// its files, so only empty ones are listed, and only the topmost of a
+// missing branch.
+
+// Folders returns the path of every listed folder under the root, not
+// including the root, normalized like file paths with rules applied. Files
+// must have been called first.
+func (g *DriveListing) Folders(rules *pathRules) []string {
+	var folders []string
+	for id := range g.driveFolders {
+		if id == g.rootId || g.folderExcluded(id) {
+			continue
+		}
+		fullPath, err := g.buildPath(id)
+		if err != nil {
+			continue
+		}
+		rel, err := remoteRel(g.RootPath, fullPath)
+		if err != nil || rel == "." || !g.includePath(rel) {
+			continue
+		}
+		folders = append(folders, folderManifestPath(strings.ToLower(normalizeUnicodeCharacters(rel)), rules.remote))
+	}
+	return folders
+}
+
+// folderManifestPath applies path rules to a normalized folder path, as the
+// folder part of a file path
+func folderManifestPath(relPath string, transform func(string) string) string {
+	return strings.TrimSuffix(transform(relPath+"/"), "/")
+}
+
+// localFolderPaths normalizes the directories found by the local walk
+func localFolderPaths(localRootLowercase string, dirs []string, rules *pathRules) []string {
+	var folders []string
+	for _, dir := range dirs {
+		relPath, err := relativePath(localRootLowercase, strings.ToLower(dir))
+		if err != nil {
+			continue
+		}
+		folders = append(folders, folderManifestPath(normalizeUnicodeCharacters(relPath), rules.local))
+	}
+	return folders
+}
+
+// CompareFolders records the empty folders only on one side in
+// OnlyRemoteFolders and OnlyLocalFolders; they count towards Misses
+func (mc *ManifestComparison) CompareFolders(remote, local []string) {
+	remoteFiles := append([]*File{}, mc.OnlyRemote...)
+	remoteFiles = append(remoteFiles, mc.KnownSyncIssues...)
+	remoteFiles = append(remoteFiles, mc.NormalizationCollisions...)
+	localFiles := append([]*File{}, mc.OnlyLocal...)
+	for _, match := range mc.PossibleMatches {
+		remoteFiles = append(remoteFiles, match.Remote)
+		localFiles = append(localFiles, match.Local)
+	}
+	mc.OnlyRemoteFolders = missingFolders(remote, local, remoteFiles)
+	mc.OnlyLocalFolders = missingFolders(local, remote, localFiles)
+	mc.Misses += len(mc.OnlyRemoteFolders) + len(mc.OnlyLocalFolders)
+}
+
+// missingFolders returns the folders in have that aren't in other, leaving
+// out those whose parent is missing too and those with unmatched files in
+// them, which are reported already
+func missingFolders(have, other []string, unmatched []*File) []*File {
+	present := make(map[string]bool, len(other))
+	for _, dir := range other {
+		present[dir] = true
+	}
+	withFiles := make(map[string]bool)
+	for _, file := range unmatched {
+		for dir := path.Dir(file.Path); dir != "." && !withFiles[dir]; dir = path.Dir(dir) {
+			withFiles[dir] = true
+		}
+	}
+	sort.Strings(have)
+	var missing []*File
+	for i, dir := range have {
+		if present[dir] || withFiles[dir] || (i > 0 && have[i-1] == dir) {
+			continue
+		}
+		if parent := path.Dir(dir); parent != "." && !present[parent] {
+			continue
+		}
+		missing = append(missing, &File{Path: dir})
+	}
+	return missing
+}
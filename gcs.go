@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsProvider lists a bucket or a prefix of it on Google Cloud Storage,
+// comparing by the MD5 GCS stores for every object except composite ones
+type gcsProvider struct {
+	// credentialsPath is a service account key, or "" for Application
+	// Default Credentials
+	credentialsPath string
+	client          *http.Client
+}
+
+func newGCSProvider(opts providerOptions) *gcsProvider {
+	return &gcsProvider{credentialsPath: opts.GCSCredentials}
+}
+
+func (p *gcsProvider) Name() string {
+	return "Google Cloud Storage"
+}
+
+func (p *gcsProvider) HashAlgo() hashAlgorithm {
+	return hashMD5
+}
+
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size,string"`
+	Updated time.Time `json:"updated"`
+	MD5Hash string    `json:"md5Hash"`
+}
+
+type gcsListResult struct {
+	Items         []*gcsObject `json:"items"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// List lists every object under root, given as /bucket/prefix
+func (p *gcsProvider) List(ctx context.Context, root string, add func(*File) error) error {
+	bucket, prefix, _ := strings.Cut(strings.Trim(root, "/"), "/")
+	if bucket == "" {
+		return fmt.Errorf("Google Cloud Storage needs a bucket as the remote root, e.g. --remote mybucket/takeout")
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	if err := p.authorize(ctx); err != nil {
+		return err
+	}
+	var withoutMD5 int
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,size,updated,md5Hash),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		result := &gcsListResult{}
+		if err := p.call(ctx, "https://storage.googleapis.com/storage/v1/b/"+url.PathEscape(bucket)+"/o?"+query.Encode(), result); err != nil {
+			return fmt.Errorf("Unable to list Google Cloud Storage bucket %s: %v", bucket, err)
+		}
+		for _, object := range result.Items {
+			// zero-length names ending in / are folder placeholders
+			if strings.HasSuffix(object.Name, "/") {
+				continue
+			}
+			file := newGCSFile(bucket, prefix, object)
+			if file.ContentHash == "" {
+				withoutMD5++
+			}
+			if err := add(file); err != nil {
+				return err
+			}
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		token = result.NextPageToken
+	}
+	if withoutMD5 > 0 {
+		logger.Warn("composite Google Cloud Storage objects have no MD5, so only their presence will be checked", "count", withoutMD5)
+	}
+	return nil
+}
+
+func newGCSFile(bucket, prefix string, object *gcsObject) *File {
+	relPath := strings.TrimPrefix(object.Name, prefix)
+	file := &File{
+		Path:         strings.ToLower(normalizeUnicodeCharacters(relPath)),
+		RawPath:      relPath,
+		Size:         object.Size,
+		ModifiedTime: object.Updated,
+		WebLink:      "https://console.cloud.google.com/storage/browser/_details/" + url.PathEscape(bucket) + "/" + (&url.URL{Path: object.Name}).EscapedPath(),
+	}
+	// md5Hash is base64, where local hashes are hex
+	if sum, err := base64.StdEncoding.DecodeString(object.MD5Hash); err == nil && len(sum) == 16 {
+		file.ContentHash = hex.EncodeToString(sum)
+	}
+	return file
+}
+
+// authorize sets up an HTTP client from the service account key, or from
+// Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS or
+// gcloud auth application-default login)
+func (p *gcsProvider) authorize(ctx context.Context) error {
+	if p.credentialsPath == "" {
+		client, err := google.DefaultClient(ctx, gcsReadOnlyScope)
+		if err != nil {
+			return fmt.Errorf("Unable to find Google Cloud credentials (set --gcs-credentials or GOOGLE_APPLICATION_CREDENTIALS): %v", err)
+		}
+		p.client = client
+		return nil
+	}
+	data, err := ioutil.ReadFile(p.credentialsPath)
+	if err != nil {
+		return fmt.Errorf("Unable to read Google Cloud credentials: %v", err)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, gcsReadOnlyScope)
+	if err != nil {
+		return fmt.Errorf("Unable to parse Google Cloud credentials %s: %v", p.credentialsPath, err)
+	}
+	p.client = oauth2.NewClient(ctx, creds.TokenSource)
+	return nil
+}
+
+// call makes a JSON API request, retrying rate limits, server errors and
+// network failures
+func (p *gcsProvider) call(ctx context.Context, endpoint string, result interface{}) error {
+	_, data, err := providerRequest(ctx, p.client, "Google Cloud Storage", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
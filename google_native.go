@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/drive/v3"
+)
+
+// googleNativeFiles counts the Google Docs, Sheets, Slides, Forms and other
+// Google-native files under the remote root. They have no binary contents to
+// download or compare (sync clients only create .gdoc-style link files for
+// them, which are ignored locally), so they aren't verified; counting them
+// keeps the summary honest about what was. Bytes is the Drive storage they
+// use, which is 0 for older ones.
+type googleNativeFiles struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Entries lists them, with --list-google-native
+	Entries []*GoogleNativeFile `json:"entries,omitempty"`
+}
+
+// GoogleNativeFile is a Google-native file that wasn't verified
+type GoogleNativeFile struct {
+	// Path is relative to the remote root, as named in Google Drive
+	Path string `json:"path"`
+	// Kind is the Google Docs editors type, e.g. document or spreadsheet
+	Kind    string `json:"kind"`
+	Size    int64  `json:"size"`
+	WebLink string `json:"webLink,omitempty"`
+}
+
+func (n *googleNativeFiles) add(relPath string, file *drive.File, record bool) {
+	n.Files++
+	n.Bytes += file.QuotaBytesUsed
+	if record {
+		n.Entries = append(n.Entries, &GoogleNativeFile{
+			Path:    relPath,
+			Kind:    strings.TrimPrefix(file.MimeType, "application/vnd.google-apps."),
+			Size:    file.QuotaBytesUsed,
+			WebLink: file.WebViewLink,
+		})
+	}
+}
+
+// Count is the number of Google-native files
+func (n *googleNativeFiles) Count() int {
+	if n == nil {
+		return 0
+	}
+	return n.Files
+}
+
+// Print prints the count, and the files if they were listed
+func (n *googleNativeFiles) Print() {
+	fmt.Printf("%d Google-native files not verified (no binary content), using %s in Drive\n\n", n.Files, humanize.Bytes(uint64(n.Bytes)))
+	for _, file := range n.Entries {
+		fmt.Printf("%s (%s, %s)\n", file.Path, file.Kind, humanize.Bytes(uint64(file.Size)))
+	}
+	if len(n.Entries) > 0 {
+		fmt.Print("\n\n")
+	} else {
+		fmt.Print("List them with --list-google-native.\n\n")
+	}
+}
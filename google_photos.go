@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Before 2019, Google Photos could be shown in My Drive as a "Google Photos"
+// folder in the root. Its contents still appear in Drive listings but are
+// rarely synced, so they show up as thousands of files only in remote.
+const googlePhotosFolderName = "Google Photos"
+
+// Maximum number of Google Photos folders excluded in the listing query
+// itself; beyond this their files are listed and dropped afterwards
+const maxQueryExcludedFolders = 50
+
+// findGooglePhotosFolders returns the IDs of the legacy Google Photos folder
+// in My Drive's root and every folder below it
+func (g *DriveListing) findGooglePhotosFolders(myDriveRootId string) ([]string, error) {
+	query := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType = '%s' and trashed != true", myDriveRootId, googlePhotosFolderName, folderMimeType)
+	roots, err := g.listQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	queue := []string{}
+	for _, folder := range roots {
+		queue = append(queue, folder.Id)
+	}
+	for len(queue) > 0 {
+		batchSize := scopedListBatchSize
+		if len(queue) < batchSize {
+			batchSize = len(queue)
+		}
+		batch := queue[:batchSize]
+		queue = queue[batchSize:]
+		ids = append(ids, batch...)
+
+		var clauses []string
+		for _, id := range batch {
+			clauses = append(clauses, fmt.Sprintf("'%s' in parents", id))
+		}
+		subfolders, err := g.listQuery(fmt.Sprintf("(%s) and mimeType = '%s' and trashed != true", strings.Join(clauses, " or "), folderMimeType))
+		if err != nil {
+			return nil, err
+		}
+		for _, folder := range subfolders {
+			queue = append(queue, folder.Id)
+		}
+	}
+	return ids, nil
+}
+
+// excludeGooglePhotos finds the Google Photos folders so they're left out of
+// the listing: skipped when walking folders, and excluded by the query when
+// listing everything if there aren't too many
+func (g *DriveListing) excludeGooglePhotos(myDriveRootId string) error {
+	ids, err := g.findGooglePhotosFolders(myDriveRootId)
+	if err != nil {
+		return fmt.Errorf("Unable to find the Google Photos folder: %v", err)
+	}
+	for _, id := range ids {
+		g.excludedFolders[id] = true
+	}
+	if len(ids) > 0 {
+		logger.Info("excluding Google Photos folders", "folders", len(ids))
+	}
+	return nil
+}
+
+// excludedFoldersQuery returns query clauses leaving out files directly in
+// excluded folders, or "" if there are none or too many
+func (g *DriveListing) excludedFoldersQuery() string {
+	if len(g.excludedFolders) == 0 || len(g.excludedFolders) > maxQueryExcludedFolders {
+		return ""
+	}
+	var clauses []string
+	for id := range g.excludedFolders {
+		clauses = append(clauses, fmt.Sprintf(" and not '%s' in parents", id))
+	}
+	return strings.Join(clauses, "")
+}
+
+// googlePhotosOnlyRemote counts files only in remote under a Google Photos
+// folder at the top of the remote root, to suggest --skip-google-photos
+func (mc *ManifestComparison) googlePhotosOnlyRemote() int {
+	prefix := strings.ToLower(googlePhotosFolderName) + "/"
+	count := 0
+	for _, file := range mc.OnlyRemote {
+		if strings.HasPrefix(file.Path, prefix) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,10 @@
+package main
+
+import "os"
+
+// hardLinkID finds nothing: a directory listing on Windows doesn't include
+// link counts, and opening every file to get them would cost more than the
+// rare hard link saves
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
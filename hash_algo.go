@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// hashAlgorithm selects the checksum compared between local and remote files.
+// The zero value means MD5, the only checksum older Drive files are
+// guaranteed to have.
+type hashAlgorithm string
+
+const (
+	hashMD5    hashAlgorithm = "md5"
+	hashSHA1   hashAlgorithm = "sha1"
+	hashSHA256 hashAlgorithm = "sha256"
+	// hashDropbox is Dropbox's content hash, used with --provider dropbox
+	hashDropbox hashAlgorithm = "dropbox"
+)
+
+func parseHashAlgorithm(name string) (hashAlgorithm, error) {
+	switch algo := hashAlgorithm(name); algo {
+	case "", hashMD5:
+		return hashMD5, nil
+	case hashSHA1, hashSHA256:
+		return algo, nil
+	default:
+		return "", fmt.Errorf("Unknown hash algorithm %q (expected md5, sha1 or sha256)", name)
+	}
+}
+
+func (a hashAlgorithm) newHash() hash.Hash {
+	if algo, ok := strings.CutSuffix(string(a), "+"+string(hashXXH64)); ok {
+		return &pairedHash{first: hashAlgorithm(algo).newHash(), second: newXXH64()}
+	}
+	switch a {
+	case hashSHA1:
+		return sha1.New()
+	case hashSHA256:
+		return sha256.New()
+	case hashDropbox:
+		return newDropboxContentHash()
+	case hashXXH64:
+		return newXXH64()
+	default:
+		return md5.New()
+	}
+}
+
+// driveField is the Drive API field holding this checksum
+func (a hashAlgorithm) driveField() string {
+	switch a {
+	case hashSHA1:
+		return "sha1Checksum"
+	case hashSHA256:
+		return "sha256Checksum"
+	default:
+		return "md5Checksum"
+	}
+}
+
+// checksum returns the file's Drive checksum, which is empty for
+// Google-native documents and other files without binary content
+func (a hashAlgorithm) checksum(file *drive.File) string {
+	switch a {
+	case hashSHA1:
+		return file.Sha1Checksum
+	case hashSHA256:
+		return file.Sha256Checksum
+	default:
+		return file.Md5Checksum
+	}
+}
+
+func (a hashAlgorithm) String() string {
+	if a == "" {
+		return string(hashMD5)
+	}
+	return string(a)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// defaultHashBufferSize is much larger than io.Copy's 32KB, so hashing a
+// large file takes fewer read calls; on NAS devices each one is slow. Files
+// aren't memory-mapped instead, since a mapped file that's truncated or on a
+// mount that goes away crashes the whole run rather than failing one file.
+const defaultHashBufferSize = 1024 * 1024
+
+// hashBuffers holds the buffers files are read into for hashing, shared by
+// every worker so each buffer is allocated once
+var hashBuffers = newHashBufferPool(defaultHashBufferSize)
+
+type hashBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newHashBufferPool(size int) *hashBufferPool {
+	p := &hashBufferPool{size: size}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *hashBufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *hashBufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
+
+// parseHashBufferSize parses sizes like "4MB" for --hash-buffer-size
+func parseHashBufferSize(s string) (int, error) {
+	size, err := humanize.ParseBytes(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid hash buffer size %q: %v", s, err)
+	}
+	if size < 4*1024 || size > 256*1024*1024 {
+		return 0, fmt.Errorf("Hash buffer size %s must be between 4KB and 256MB", humanize.Bytes(size))
+	}
+	return int(size), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Google Drive has no checksum for Google Docs, Sheets and other native
+// files, which Drive for Desktop doesn't download anyway, but now and then
+// an ordinary file is missing its checksum too (some uploads, some empty
+// files). Those are listed, and a local file at the same path is only checked
+// for being there.
+
+// isGoogleNative reports whether a Drive file is a Google Docs editors file
+// (or any other application/vnd.google-apps type), with no contents to sync
+func isGoogleNative(file *drive.File) bool {
+	return strings.HasPrefix(file.MimeType, "application/vnd.google-apps.")
+}
+
+// separateHashUnavailable moves content mismatches whose remote file has no
+// checksum to HashUnavailable; they don't count towards Misses. Run it after
+// separateEmptyLocal, so an empty local file is still reported.
+func (mc *ManifestComparison) separateHashUnavailable() {
+	var mismatched []*FilePair
+	for _, pair := range mc.ContentMismatch {
+		if isHashUnavailable(pair.Remote) {
+			mc.HashUnavailable = append(mc.HashUnavailable, pair)
+			mc.Misses--
+		} else {
+			mismatched = append(mismatched, pair)
+		}
+	}
+	mc.ContentMismatch = mismatched
+}
+
+func isHashUnavailable(remote *File) bool {
+	return remote.ContentHash == ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// readTimeoutError indicates a local file stopped responding while hashing,
+// e.g. a dead network mount
+type readTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e readTimeoutError) Error() string {
+	return fmt.Sprintf("read timeout: no progress for %v", e.timeout)
+}
+
+// hashLocalFileWithTimeout hashes a file, giving up if opening it or any read
+// makes no progress within the timeout. A timed out read can't be
+// interrupted, so it's abandoned in the background and the caller is freed up
+// to move on to other files.
+func hashLocalFileWithTimeout(ctx context.Context, path string, timeout time.Duration, algo hashAlgorithm, limiter *byteRateLimiter) (string, error) {
+	if timeout <= 0 {
+		return hashLocalFile(ctx, path, algo, limiter)
+	}
+
+	type hashResult struct {
+		hash string
+		err  error
+	}
+	// buffered so an abandoned hash doesn't block forever once it finishes
+	done := make(chan hashResult, 1)
+	var lastProgress int64
+	atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+
+	go func() {
+		hash, err := hashWithProgress(ctx, path, &lastProgress, algo, limiter)
+		done <- hashResult{hash, err}
+	}()
+
+	ticker := time.NewTicker(watchdogInterval(timeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-done:
+			return result.hash, result.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&lastProgress))
+			if time.Since(last) > timeout {
+				return "", readTimeoutError{timeout: timeout}
+			}
+		}
+	}
+}
+
+func watchdogInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	return interval
+}
+
+func hashWithProgress(ctx context.Context, path string, lastProgress *int64, algo hashAlgorithm, limiter *byteRateLimiter) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	atomic.StoreInt64(lastProgress, time.Now().UnixNano())
+
+	return hashContents(&progressReader{r: &contextReader{ctx: ctx, r: throttle(f, limiter)}, lastProgress: lastProgress}, algo)
+}
+
+// contextReader stops reading once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// progressReader records the time of each successful read
+type progressReader struct {
+	r            io.Reader
+	lastProgress *int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		atomic.StoreInt64(p.lastProgress, time.Now().UnixNano())
+	}
+	return n, err
+}
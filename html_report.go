@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+)
+
+// htmlSections lists the report's categories in display order
+var htmlSections = []struct {
+	Status FileStatus
+	Title  string
+}{
+	{StatusOnlyRemote, "Files only in remote"},
+	{StatusOnlyLocal, "Files only in local"},
+	{StatusOnlyRemoteFolder, "Empty folders only in remote"},
+	{StatusOnlyLocalFolder, "Empty folders only in local"},
+	{StatusMismatch, "Files whose contents don't match"},
+	{StatusEmptyLocal, "Empty local files (need re-downloading)"},
+	{StatusSpotCheck, "Checksums match but downloaded contents differ"},
+	{StatusDeleted, "Deleted remotely (in trash)"},
+	{StatusError, "Errored"},
+	{StatusPossibleMatch, "Possible matches"},
+	{StatusKnownIssue, "Known sync issues"},
+	{StatusCollision, "Unicode normalization collisions"},
+	{StatusTrashed, "In Google Drive's trash"},
+	{StatusPlaceholder, "Cloud-only placeholders (not hashed)"},
+	{StatusHashUnavailable, "Hash unavailable remotely (only presence checked)"},
+	{StatusAcknowledged, "Acknowledged (in baseline)"},
+	{StatusXattrs, "Extended attributes not kept by Google Drive"},
+	{StatusLocalDuplicate, "Local files with duplicate contents"},
+	{StatusMatch, "Matched files"},
+}
+
+type htmlReport struct {
+	Generated string
+	Success   bool
+	Suspect   bool
+	// RemoteIncomplete is set if the remote listing didn't finish
+	RemoteIncomplete bool
+	Matches          int
+	Misses           int
+	Sections         []*htmlSection
+}
+
+type htmlSection struct {
+	Title string
+	// Open sections are expanded when the page loads
+	Open  bool
+	Files []*htmlFile
+}
+
+type htmlFile struct {
+	Path   string
+	Local  string
+	Remote string
+	// RemoteLink opens the remote file in Google Drive
+	RemoteLink string
+	Error      string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Google Drive sync verification</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+.status { font-size: 1.4em; font-weight: bold; }
+.success { color: #2a7d2a; }
+.failure { color: #b8322a; }
+.suspect { color: #a66f00; }
+#filter { width: 100%; max-width: 40em; padding: 0.4em; margin: 1em 0; font-size: 1em; }
+summary { cursor: pointer; font-size: 1.1em; margin: 0.6em 0; }
+ul { list-style: none; padding-left: 1em; }
+li { margin: 0.3em 0; }
+.path { font-family: Menlo, Consolas, monospace; }
+.detail { color: #666; font-size: 0.85em; margin-left: 1em; }
+</style>
+</head>
+<body>
+<h1>Google Drive sync verification</h1>
+<p>Generated {{.Generated}}</p>
+{{if .Suspect}}<p class="status suspect">Configuration suspect: no files matched</p>
+{{else if .Success}}<p class="status success">Success: verified local sync</p>
+{{else}}<p class="status failure">Failure: {{.Misses}} sync mismatches detected</p>
+{{end}}{{if .RemoteIncomplete}}<p class="status suspect">Remote listing incomplete: local-only files weren't checked</p>
+{{end}}<p>Files matched: {{.Matches}}/{{.Total}}</p>
+<input id="filter" type="search" placeholder="Filter by path">
+{{range .Sections}}<details{{if .Open}} open{{end}}>
+<summary>{{.Title}}: {{len .Files}}</summary>
+<ul>
+{{range .Files}}<li><span class="path">{{.Path}}</span>
+{{if .Local}}<div class="detail">local: {{.Local}}</div>{{end}}
+{{if .Remote}}<div class="detail">remote: {{.Remote}}{{if .RemoteLink}} <a href="{{.RemoteLink}}" target="_blank">open in Drive</a>{{end}}</div>{{end}}
+{{if .Error}}<div class="detail">error: {{.Error}}</div>{{end}}
+</li>
+{{end}}</ul>
+</details>
+{{end}}<script>
+document.getElementById("filter").addEventListener("input", function (e) {
+  var needle = e.target.value.toLowerCase();
+  document.querySelectorAll("li").forEach(function (li) {
+    li.style.display = li.textContent.toLowerCase().indexOf(needle) >= 0 ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// Total is the number of files counted towards the result
+func (r *htmlReport) Total() int {
+	return r.Matches + r.Misses
+}
+
+// WriteHTMLFile writes the comparison results as a standalone HTML page
+func (mc *ManifestComparison) WriteHTMLFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := htmlReportTemplate.Execute(f, mc.htmlReport(time.Now())); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (mc *ManifestComparison) htmlReport(generated time.Time) *htmlReport {
+	byStatus := make(map[FileStatus][]*htmlFile)
+	for _, result := range mc.Results() {
+		file := &htmlFile{Path: result.Path}
+		if result.Local != nil {
+			file.Local = fileDetail(result.Local)
+		}
+		if result.Remote != nil {
+			file.Remote = fileDetail(result.Remote)
+			if result.Remote.Path != result.Path {
+				file.Remote = result.Remote.Path + "  " + file.Remote
+			}
+			file.RemoteLink = result.Remote.RemoteLink()
+		}
+		if result.Error != nil {
+			file.Error = fmt.Sprintf("[%s] %s", errorCategory(result.Error), result.Error)
+		}
+		byStatus[result.Status] = append(byStatus[result.Status], file)
+	}
+
+	report := &htmlReport{
+		Generated:        generated.Format("2006-01-02 15:04:05"),
+		Success:          mc.IsSuccessful(),
+		Suspect:          mc.IsSuspect(),
+		RemoteIncomplete: mc.RemoteIncomplete,
+		Matches:          mc.Matches,
+		Misses:           mc.Misses,
+	}
+	for _, section := range htmlSections {
+		files := byStatus[section.Status]
+		if len(files) == 0 {
+			continue
+		}
+		open := section.Status != StatusMatch && section.Status != StatusPossibleMatch && section.Status != StatusKnownIssue && section.Status != StatusCollision && section.Status != StatusTrashed && section.Status != StatusPlaceholder && section.Status != StatusHashUnavailable && section.Status != StatusAcknowledged && section.Status != StatusXattrs && section.Status != StatusLocalDuplicate
+		report.Sections = append(report.Sections, &htmlSection{Title: section.Title, Open: open, Files: files})
+	}
+	return report
+}
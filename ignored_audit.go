@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ignoredLocal counts the local files and folders left out of a scan by
+// ignore rules: the sync clients' own files, and the file extensions and
+// folder names the --client rules ignore. It's informational, so you can
+// check the rules aren't hiding real data. Ignored folders aren't scanned, so
+// what's in them isn't counted.
+type ignoredLocal struct {
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Folders int   `json:"folders"`
+	// Entries lists them, with --list-ignored
+	Entries []*IgnoredEntry `json:"entries,omitempty"`
+}
+
+// IgnoredEntry is a local file or folder skipped by an ignore rule
+type IgnoredEntry struct {
+	// Path is relative to the local root, with forward slashes
+	Path   string `json:"path"`
+	Folder bool   `json:"folder,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Count is the number of files and folders ignored
+func (i *ignoredLocal) Count() int {
+	if i == nil {
+		return 0
+	}
+	return i.Files + i.Folders
+}
+
+// add merges other into i, with its paths under prefix
+func (i *ignoredLocal) add(other *ignoredLocal, spec localRootSpec) {
+	if other == nil {
+		return
+	}
+	i.Files += other.Files
+	i.Bytes += other.Bytes
+	i.Folders += other.Folders
+	for _, entry := range other.Entries {
+		entry.Path = spec.prefixed(entry.Path)
+		i.Entries = append(i.Entries, entry)
+	}
+}
+
+// ignore records a file or folder the walker skipped
+func (w *localWalker) ignore(entryPath string, size int64, folder bool, reason string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if folder {
+		w.ignored.Folders++
+	} else {
+		w.ignored.Files++
+		w.ignored.Bytes += size
+	}
+	if !w.listIgnored {
+		return
+	}
+	relPath, err := filepath.Rel(w.root, entryPath)
+	if err != nil {
+		relPath = entryPath
+	}
+	w.ignored.Entries = append(w.ignored.Entries, &IgnoredEntry{Path: filepath.ToSlash(relPath), Folder: folder, Size: size, Reason: reason})
+}
+
+// ignoredFileReason tells which rule skipLocalFile ignored a file by
+func ignoredFileReason(path string) string {
+	if slices.Contains(ignoredFiles[:], filepath.Base(path)) {
+		return "ignored file name"
+	}
+	return "ignored extension " + filepath.Ext(path)
+}
+
+func (i *ignoredLocal) sort() {
+	sort.Slice(i.Entries, func(a, b int) bool { return i.Entries[a].Path < i.Entries[b].Path })
+}
+
+// Print prints the counts, and the files and folders if they were listed
+func (i *ignoredLocal) Print() {
+	fmt.Printf("Ignored locally by ignore rules (not counted): %d files (%s), %d folders not scanned\n\n", i.Files, humanize.Bytes(uint64(i.Bytes)), i.Folders)
+	for _, entry := range i.Entries {
+		if entry.Folder {
+			fmt.Printf("%s/ (%s)\n", entry.Path, entry.Reason)
+		} else {
+			fmt.Printf("%s (%s, %s)\n", entry.Path, humanize.Bytes(uint64(entry.Size)), entry.Reason)
+		}
+	}
+	if len(i.Entries) > 0 {
+		fmt.Print("\n\n")
+	} else {
+		fmt.Print("List them with --list-ignored.\n\n")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+type jsonReport struct {
+	Success bool `json:"success"`
+	Suspect bool `json:"suspect"`
+	// Partial is set if verification was cancelled before the scans finished
+	Partial bool `json:"partial,omitempty"`
+	// RemoteIncomplete is set if the remote listing didn't finish
+	RemoteIncomplete bool               `json:"remoteIncomplete,omitempty"`
+	Matches          int                `json:"matches"`
+	Misses           int                `json:"misses"`
+	Score            *verificationScore `json:"score"`
+	Files            []*jsonFileResult  `json:"files"`
+	// Recovered lists local files that were read on retry
+	Recovered []*jsonRecoveredFile `json:"recovered,omitempty"`
+	// Ignored counts local files and folders skipped by ignore rules
+	Ignored *ignoredLocal `json:"ignored,omitempty"`
+	// GoogleNative counts remote Google-native files, which weren't verified
+	GoogleNative *googleNativeFiles `json:"googleNative,omitempty"`
+	// Sample is set when only a sample of files was verified
+	Sample *sampleEstimate `json:"sample,omitempty"`
+	Stats  *jsonStats      `json:"stats,omitempty"`
+}
+
+type jsonFileResult struct {
+	Path      string     `json:"path"`
+	Status    FileStatus `json:"status"`
+	LocalPath string     `json:"localPath,omitempty"`
+	// LocalRoot is the root the local file was found under, when verifying
+	// several
+	LocalRoot  string `json:"localRoot,omitempty"`
+	RemotePath string `json:"remotePath,omitempty"`
+	LocalHash  string `json:"localHash,omitempty"`
+	RemoteHash string `json:"remoteHash,omitempty"`
+	Size       int64  `json:"size"`
+	RemoteId   string `json:"remoteId,omitempty"`
+	RemoteLink string `json:"remoteLink,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// ErrorCategory is the kind of error, e.g. permission-denied or network
+	ErrorCategory ErrorCategory `json:"errorCategory,omitempty"`
+	// OriginalError is the first error, for files read more than once
+	OriginalError string    `json:"originalError,omitempty"`
+	Attempts      int       `json:"attempts,omitempty"`
+	Stale         bool      `json:"stale,omitempty"`
+	Newer         NewerSide `json:"newer,omitempty"`
+}
+
+type jsonRecoveredFile struct {
+	Path          string `json:"path"`
+	OriginalError string `json:"originalError"`
+	Attempts      int    `json:"attempts"`
+}
+
+type jsonStats struct {
+	RemoteListed     int     `json:"remoteListed"`
+	RemoteFiles      int     `json:"remoteFiles"`
+	RemoteAPICalls   int     `json:"remoteApiCalls"`
+	RemoteSeconds    float64 `json:"remoteSeconds"`
+	LocalFiles       int     `json:"localFiles"`
+	LocalErrored     int     `json:"localErrored"`
+	LocalBytesHashed int64   `json:"localBytesHashed"`
+	LocalHardLinks   int     `json:"localHardLinks"`
+	LocalClones      int     `json:"localClones"`
+	LocalNotHashed   int     `json:"localNotHashed"`
+	LocalSeconds     float64 `json:"localSeconds"`
+	CompareSeconds   float64 `json:"compareSeconds"`
+	TotalSeconds     float64 `json:"totalSeconds"`
+}
+
+// WriteJSONFile writes the comparison results and run statistics as JSON
+func (mc *ManifestComparison) WriteJSONFile(path string, stats *RunStats) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(mc.JSONReport(stats)); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// JSONReport builds the JSON representation of the comparison; stats may be nil
+func (mc *ManifestComparison) JSONReport(stats *RunStats) *jsonReport {
+	report := &jsonReport{
+		Success:          mc.IsSuccessful(),
+		Suspect:          mc.IsSuspect(),
+		Partial:          mc.Partial,
+		RemoteIncomplete: mc.RemoteIncomplete,
+		Matches:          mc.Matches,
+		Misses:           mc.Misses,
+		Score:            mc.Score(),
+		Files:            []*jsonFileResult{},
+		Sample:           mc.Sample,
+	}
+	if mc.IgnoredLocal.Count() > 0 {
+		report.Ignored = mc.IgnoredLocal
+	}
+	if mc.GoogleNative.Count() > 0 {
+		report.GoogleNative = mc.GoogleNative
+	}
+	for _, result := range mc.Results() {
+		report.Files = append(report.Files, newJSONFileResult(result))
+	}
+	for _, rec := range mc.Recovered {
+		report.Recovered = append(report.Recovered, &jsonRecoveredFile{
+			Path:          rec.Path,
+			OriginalError: rec.OriginalError.Error(),
+			Attempts:      rec.Attempts,
+		})
+	}
+	if stats != nil {
+		report.Stats = &jsonStats{
+			RemoteListed:     stats.RemoteListed,
+			RemoteFiles:      stats.RemoteFiles,
+			RemoteAPICalls:   stats.RemoteAPICalls,
+			RemoteSeconds:    stats.RemoteDuration.Seconds(),
+			LocalFiles:       stats.LocalFiles,
+			LocalErrored:     stats.LocalErrored,
+			LocalBytesHashed: stats.LocalBytesHashed,
+			LocalHardLinks:   stats.LocalHardLinks,
+			LocalClones:      stats.LocalClones,
+			LocalNotHashed:   stats.LocalNotHashed,
+			LocalSeconds:     stats.LocalDuration.Seconds(),
+			CompareSeconds:   stats.CompareDuration.Seconds(),
+			TotalSeconds:     stats.TotalDuration.Seconds(),
+		}
+	}
+	return report
+}
+
+func newJSONFileResult(result *FileResult) *jsonFileResult {
+	rec := &jsonFileResult{Path: result.Path, Status: result.Status, Stale: result.Stale, Newer: result.Newer}
+	if result.Remote != nil {
+		rec.RemotePath = result.Remote.Path
+		rec.RemoteHash = result.Remote.ContentHash
+		rec.RemoteId = result.Remote.Id
+		rec.RemoteLink = result.Remote.RemoteLink()
+		rec.Size = result.Remote.Size
+	}
+	if result.Local != nil {
+		rec.LocalPath = result.Local.Path
+		rec.LocalRoot = result.Local.LocalRoot
+		rec.LocalHash = result.Local.ContentHash
+		rec.Size = result.Local.Size
+	}
+	if result.Error != nil {
+		rec.Error = result.Error.Error()
+		rec.ErrorCategory = errorCategory(result.Error)
+	}
+	if result.OriginalError != nil {
+		rec.OriginalError = result.OriginalError.Error()
+		rec.Attempts = result.Attempts
+	}
+	return rec
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// duplicateGroup is a set of local files with the same contents
+type duplicateGroup struct {
+	Hash  string
+	Size  int64
+	Files []*File
+}
+
+// Extra returns the space taken by all but one copy; hard links to and
+// clones of a file already scanned take none
+func (g *duplicateGroup) Extra() int64 {
+	copies := 0
+	for _, file := range g.Files {
+		if !file.HardLink && !file.Clone {
+			copies++
+		}
+	}
+	if copies < 2 {
+		return 0
+	}
+	return int64(copies-1) * g.Size
+}
+
+// duplicateFinder records local files by contents as the comparison reads
+// them. It holds every hashed local file in memory until the comparison is
+// done.
+type duplicateFinder struct {
+	manifestReader
+	byContent map[string][]*File
+}
+
+func newDuplicateFinder(manifest manifestReader) *duplicateFinder {
+	return &duplicateFinder{manifestReader: manifest, byContent: make(map[string][]*File)}
+}
+
+func (d *duplicateFinder) PopOrNil() *File {
+	file := d.manifestReader.PopOrNil()
+	// every empty file has the same contents
+	if file != nil && file.ContentHash != "" && file.Size > 0 {
+		key := fmt.Sprintf("%s:%d", file.ContentHash, file.Size)
+		d.byContent[key] = append(d.byContent[key], file)
+	}
+	return file
+}
+
+// groups returns the files with more than one copy taking up space, those
+// taking the most first
+func (d *duplicateFinder) groups() []*duplicateGroup {
+	var groups []*duplicateGroup
+	for _, files := range d.byContent {
+		group := &duplicateGroup{Hash: files[0].ContentHash, Size: files[0].Size, Files: files}
+		if group.Extra() > 0 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Extra() != groups[j].Extra() {
+			return groups[i].Extra() > groups[j].Extra()
+		}
+		return groups[i].Files[0].Path < groups[j].Files[0].Path
+	})
+	return groups
+}
+
+func printDuplicateList(groups []*duplicateGroup) {
+	var extra int64
+	for _, group := range groups {
+		extra += group.Extra()
+	}
+	fmt.Printf("Local files with duplicate contents (not counted): %d groups, %s in extra copies\n\n", len(groups), humanize.Bytes(uint64(extra)))
+	for _, group := range groups {
+		fmt.Printf("%d copies of %s:\n", len(group.Files), humanize.Bytes(uint64(group.Size)))
+		for _, file := range group.Files {
+			if file.HardLink {
+				fmt.Printf("  %s (hard link)\n", file.Path)
+			} else if file.Clone {
+				fmt.Printf("  %s (clone)\n", file.Path)
+			} else {
+				fmt.Printf("  %s\n", file.Path)
+			}
+		}
+	}
+	if len(groups) > 0 {
+		fmt.Print("\n\n")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// retryLocalErrors re-reads files that failed to hash, which is often
+// transient (antivirus locks, network filesystem hiccups). It makes up to
+// opts.ReadRetries passes, waiting opts.ReadRetryDelay before each, and
+// returns the files that were read successfully along with their original
+// errors. Errors from walking the tree aren't retried, nor are files deleted
+// mid-scan. Permission denied is, since antivirus locks on Windows show up
+// as ERROR_ACCESS_DENIED.
+func retryLocalErrors(ctx context.Context, localRootLowercase string, opts localScanOptions, errored []*FileError) (files []*File, recovered []*FileError, remaining []*FileError) {
+	pending := errored
+	for attempt := 1; attempt <= opts.ReadRetries && len(pending) > 0; attempt++ {
+		select {
+		case <-time.After(opts.ReadRetryDelay):
+		case <-ctx.Done():
+			return files, recovered, pending
+		}
+		var failed []*FileError
+		for _, e := range pending {
+			if e.entry == nil || errorCategory(e.Error) == CategoryNotFound {
+				failed = append(failed, e)
+				continue
+			}
+			file, retryErr := processLocalFile(ctx, localRootLowercase, opts, e.entry)
+			if e.OriginalError == nil {
+				e.OriginalError = e.Error
+			}
+			e.Attempts++
+			if retryErr != nil {
+				logger.Debug("retry failed", "path", e.Path, "attempt", attempt, "error", retryErr.Error)
+				e.Error = retryErr.Error
+				failed = append(failed, e)
+				continue
+			}
+			logger.Info("read local file on retry", "path", e.Path, "attempts", e.Attempts, "originalError", e.OriginalError)
+			recovered = append(recovered, e)
+			if file != nil {
+				files = append(files, file)
+			}
+		}
+		pending = failed
+	}
+	return files, recovered, pending
+}
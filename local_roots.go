@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// localRootSpec is one of several local roots verified together against a
+// single remote root. Its files are compared with the remote files under
+// Prefix, relative to the remote root ("" for the remote root itself).
+type localRootSpec struct {
+	Root   string
+	Prefix string
+}
+
+// parseLocalRoots parses --local arguments of the form path or
+// path=remote/prefix into absolute roots
+func parseLocalRoots(args []string) ([]localRootSpec, error) {
+	var roots []localRootSpec
+	for _, arg := range args {
+		root, prefix, _ := strings.Cut(arg, "=")
+		root, err := homedir.Expand(root)
+		if err != nil {
+			return nil, err
+		}
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		// manifest paths are lowercased and normalized
+		prefix = normalizeUnicodeCharacters(strings.ToLower(strings.Trim(filepath.ToSlash(prefix), "/")))
+		roots = append(roots, localRootSpec{Root: root, Prefix: prefix})
+	}
+	return roots, nil
+}
+
+func (s localRootSpec) String() string {
+	if s.Prefix == "" {
+		return s.Root
+	}
+	return fmt.Sprintf("%s (as %s/)", s.Root, s.Prefix)
+}
+
+func (s localRootSpec) prefixed(path string) string {
+	if s.Prefix == "" || path == "" {
+		return path
+	}
+	return s.Prefix + "/" + path
+}
+
+// scanLocalRoots scans each of config.LocalRoots in turn and merges their
+// files into a single manifest, under their remote prefixes. Each file
+// records the root it came from in LocalRoot.
+func scanLocalRoots(ctx context.Context, progress *scanProgress, config *verifyConfig) (*localScanResult, error) {
+	merged := newSortedManifest(config.Local.Spill)
+	result := &localScanResult{Manifest: merged, Ignored: &ignoredLocal{}, Complete: true}
+	for _, spec := range config.LocalRoots {
+		scan, err := getLocalManifest(ctx, progress, spec.Root, nil, config.Local)
+		if err != nil {
+			merged.Close()
+			return nil, fmt.Errorf("Unable to scan %s: %v", spec.Root, err)
+		}
+		for file := scan.Manifest.PopOrNil(); file != nil; file = scan.Manifest.PopOrNil() {
+			file.Path = spec.prefixed(file.Path)
+			file.OriginalPath = spec.prefixed(file.OriginalPath)
+			file.LocalRoot = spec.Root
+			if err = merged.Add(file); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = scan.Manifest.Err()
+		}
+		scan.Manifest.Close()
+		if err != nil {
+			merged.Close()
+			return nil, err
+		}
+		for _, e := range scan.Errored {
+			if !filepath.IsAbs(e.Path) {
+				e.Path = spec.prefixed(e.Path)
+			}
+		}
+		result.Errored = append(result.Errored, scan.Errored...)
+		result.SkippedSymlinks = append(result.SkippedSymlinks, scan.SkippedSymlinks...)
+		result.Recovered = append(result.Recovered, scan.Recovered...)
+		result.Xattrs = append(result.Xattrs, scan.Xattrs...)
+		result.Ignored.add(scan.Ignored, spec)
+		result.BytesHashed += scan.BytesHashed
+		result.HardLinks += scan.HardLinks
+		result.Clones += scan.Clones
+		result.Complete = result.Complete && scan.Complete
+	}
+	return result, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SkippedSymlink records a local symlink that wasn't verified
+type SkippedSymlink struct {
+	Path   string
+	Target string
+	Reason string
+}
+
+// localWalker walks the local directory, queueing regular files for
+// processing and either following or recording symlinks. Up to concurrency
+// directories are listed at once, which matters most on network file
+// systems, where each listing waits on a round trip; entries are found in no
+// particular order, but the manifests they end up in are sorted anyway.
+type localWalker struct {
+	root           string
+	followSymlinks bool
+	// rules decide which local files the sync client created and are ignored
+	rules       *pathRules
+	processChan chan<- *localEntry
+	errorChan   chan<- *FileError
+	// concurrency is how many directories are listed at once (0 or 1 for
+	// one at a time)
+	concurrency int
+	// ctx stops the walk early when cancelled (nil to never stop), setting
+	// cancelled
+	ctx context.Context
+	// recordDirs collects the directories under root in dirs
+	recordDirs bool
+	// listIgnored records the paths of ignored files and folders, not just
+	// their number
+	listIgnored bool
+
+	// slots limits the extra goroutines listing directories
+	slots chan struct{}
+	wg    sync.WaitGroup
+	// lock guards the fields below, which are safe to read once walk returns
+	lock            sync.Mutex
+	skippedSymlinks []*SkippedSymlink
+	cancelled       bool
+	dirs            []string
+	ignored         ignoredLocal
+}
+
+func (w *localWalker) walk(path string) {
+	if w.concurrency > 1 && w.slots == nil {
+		w.slots = make(chan struct{}, w.concurrency-1)
+	}
+	w.walkAs(path, path, nil)
+	w.wg.Wait()
+	sort.Slice(w.skippedSymlinks, func(i, j int) bool { return w.skippedSymlinks[i].Path < w.skippedSymlinks[j].Path })
+	sort.Strings(w.dirs)
+	w.ignored.sort()
+}
+
+// walkAs walks realPath but reports entries as if they were under reportPath,
+// so that the contents of symlinked directories keep the symlink's path.
+// ancestors are the real paths of the symlinked directories being walked
+// above it, to detect cycles.
+func (w *localWalker) walkAs(realPath string, reportPath string, ancestors []string) {
+	if resolved, err := filepath.EvalSymlinks(realPath); err == nil {
+		// copied, since sibling directories may be walked concurrently
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], resolved)
+	}
+	info, err := os.Lstat(realPath)
+	if w.stopped() {
+		return
+	}
+	if err != nil {
+		w.errorChan <- &FileError{Path: reportPath, Error: categorized(err)}
+		return
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		w.handleSymlink(reportPath, ancestors)
+	case info.IsDir():
+		w.walkDir(realPath, reportPath, ancestors)
+	case info.Mode().IsRegular():
+		w.process(reportPath, info)
+	}
+}
+
+// walkDir lists a directory, handling its files and walking its
+// subdirectories, concurrently if a slot is free
+func (w *localWalker) walkDir(realPath string, reportPath string, ancestors []string) {
+	if skipLocalDir(reportPath, w.rules) {
+		logger.Debug("skipped local directory", "path", reportPath, "reason", "ignored directory name")
+		w.ignore(reportPath, 0, true, "ignored directory name")
+		return
+	}
+	if w.recordDirs && reportPath != w.root {
+		w.lock.Lock()
+		w.dirs = append(w.dirs, reportPath)
+		w.lock.Unlock()
+	}
+
+	entries, err := os.ReadDir(realPath)
+	if err != nil {
+		w.errorChan <- &FileError{Path: reportPath, Error: categorized(err)}
+		return
+	}
+	for _, entry := range entries {
+		if w.stopped() {
+			return
+		}
+		entryReal := filepath.Join(realPath, entry.Name())
+		entryPath := filepath.Join(reportPath, entry.Name())
+		if entry.IsDir() {
+			select {
+			case w.slots <- struct{}{}:
+				w.wg.Add(1)
+				go func() {
+					defer w.wg.Done()
+					w.walkDir(entryReal, entryPath, ancestors)
+					<-w.slots
+				}()
+			default:
+				// a nil slots (no concurrency) always ends up here
+				w.walkDir(entryReal, entryPath, ancestors)
+			}
+			continue
+		}
+		// listing only gives the type; files need their size and time
+		info, err := entry.Info()
+		if err != nil {
+			w.errorChan <- &FileError{Path: entryPath, Error: categorized(err)}
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			w.handleSymlink(entryPath, ancestors)
+		} else if info.Mode().IsRegular() {
+			w.process(entryPath, info)
+		}
+	}
+}
+
+// stopped reports whether ctx has been cancelled, noting that the walk was
+// cut short
+func (w *localWalker) stopped() bool {
+	if w.ctx == nil || w.ctx.Err() == nil {
+		return false
+	}
+	w.lock.Lock()
+	w.cancelled = true
+	w.lock.Unlock()
+	return true
+}
+
+func (w *localWalker) handleSymlink(entryPath string, ancestors []string) {
+	target, _ := os.Readlink(entryPath)
+	if !w.followSymlinks {
+		w.skip(entryPath, target, "not followed")
+		return
+	}
+
+	resolved, err := filepath.EvalSymlinks(entryPath)
+	if err != nil {
+		w.skip(entryPath, target, "broken link")
+		return
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		w.errorChan <- &FileError{Path: entryPath, Error: categorized(err)}
+		return
+	}
+
+	switch {
+	case info.IsDir():
+		if skipLocalDir(entryPath, w.rules) {
+			logger.Debug("skipped local directory", "path", entryPath, "reason", "ignored directory name")
+			w.ignore(entryPath, 0, true, "ignored directory name")
+			return
+		}
+		if slices.Contains(ancestors, resolved) || isAncestorDir(resolved, filepath.Dir(entryPath)) {
+			w.skip(entryPath, target, "cycle")
+			return
+		}
+		w.walkAs(resolved, entryPath, ancestors)
+	case info.Mode().IsRegular():
+		w.process(entryPath, info)
+	default:
+		w.skip(entryPath, target, "not a regular file")
+	}
+}
+
+// process queues a regular file for hashing unless it's ignored
+func (w *localWalker) process(entryPath string, info os.FileInfo) {
+	if skipLocalFile(entryPath, w.rules) {
+		logger.Debug("skipped local file", "path", entryPath, "reason", "ignored file name")
+		w.ignore(entryPath, info.Size(), false, ignoredFileReason(entryPath))
+		if explainer != nil {
+			if relPath, err := filepath.Rel(w.root, entryPath); err == nil && explainer.wants(relPath) {
+				explainer.note("local: skipped, ignored file name")
+			}
+		}
+		return
+	}
+	w.processChan <- &localEntry{Path: entryPath, Info: info}
+}
+
+func (w *localWalker) skip(entryPath, target, reason string) {
+	logger.Debug("skipped local symlink", "path", entryPath, "target", target, "reason", reason)
+	relPath, err := filepath.Rel(w.root, entryPath)
+	if err != nil {
+		relPath = entryPath
+	}
+	relPath = filepath.ToSlash(relPath)
+	w.lock.Lock()
+	w.skippedSymlinks = append(w.skippedSymlinks, &SkippedSymlink{Path: relPath, Target: target, Reason: reason})
+	w.lock.Unlock()
+}
+
+// isAncestorDir reports whether dir is the same as or contains the real
+// location of path
+func isAncestorDir(dir string, path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	return realPath == dir || strings.HasPrefix(realPath, dir+string(filepath.Separator))
+}
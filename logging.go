@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger records diagnostics that aren't part of the results: API retries,
+// unreadable files, and why files were left out of a manifest (at debug
+// level, so a run can be audited with --log-level debug). Until
+// setupLogging is called it writes warnings and errors to stderr.
+var logger = newStderrLogger(slog.LevelWarn)
+
+func newStderrLogger(level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: level,
+		// timestamps are just noise next to the progress output
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+}
+
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("Unknown log level %q (expected debug, info, warn or error)", name)
+}
+
+// setupLogging points logger at path (stderr if empty) in the given format
+// ("text" or "json"). The returned closer closes the log file, if any.
+func setupLogging(path string, format string, levelName string) (io.Closer, error) {
+	level, err := parseLogLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" && format == "text" {
+		logger = newStderrLogger(level)
+		return nopWriteCloser{}, nil
+	}
+
+	var out io.WriteCloser = nopWriteCloser{os.Stderr}
+	if path != "" {
+		out, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(out, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(out, opts))
+	default:
+		out.Close()
+		return nil, fmt.Errorf("Unknown log format %q (expected text or json)", format)
+	}
+	return out, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
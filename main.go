@@ -1,19 +1,20 @@
 package main
 
 import (
-	"container/heap"
-	"crypto/md5"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -26,8 +27,6 @@ import (
 
 // TODO
 /*
-- Try to identify multiple files with same name (on Google Drive side) and flag
-	separately or use different strategy to validate
 - REFACTOR! especially main
 */
 
@@ -40,25 +39,52 @@ type File struct {
 	Path         string
 	OriginalPath string
 	ContentHash  string
+	Size         int64
+	ModifiedTime time.Time
+	// Id is the Google Drive file ID and WebLink its webViewLink (remote
+	// files only)
+	Id      string
+	WebLink string
+	// RawPath is the path as named in Google Drive, before Unicode
+	// normalization and lowercasing (remote files only)
+	RawPath string
+	// Placeholder marks a cloud-only local file whose contents aren't on disk
+	Placeholder bool
+	// FullPath is the local file's path on disk, only recorded when needed
+	// (local files only)
+	FullPath string
+	// Xattrs are extended attributes Google Drive won't keep (local files
+	// only, with --check-xattrs)
+	Xattrs []fileXattr
+	// LocalRoot is the local root the file was found under, when verifying
+	// several (local files only)
+	LocalRoot string
+	// FastHash is an xxHash of the contents, for the hash cache (local files
+	// only, with --hash-cache)
+	FastHash string
+	// HardLink marks a local file that's a hard link to one already scanned,
+	// and Clone one that's a copy-on-write clone of one (local files only)
+	HardLink bool
+	Clone    bool
 }
 
 // FileError records a local file that could not be read due to an error
 type FileError struct {
 	Path  string
 	Error error
+	// OriginalError is the first error, if the file was read again
+	OriginalError error
+	// Attempts counts reads of the file, including retries (0 for errors
+	// walking the tree)
+	Attempts int
+	// entry is the file to read again, for errors that may be transient
+	entry *localEntry
 }
 
-type progressType int
-
-const (
-	remoteProgress progressType = iota
-	localProgress
-	errorProgress
-)
-
-type scanProgressUpdate struct {
-	Type  progressType
-	Count int
+// localEntry is a local file queued for processing by a worker
+type localEntry struct {
+	Path string
+	Info os.FileInfo
 }
 
 type googleDriveDirectory struct {
@@ -66,25 +92,82 @@ type googleDriveDirectory struct {
 	Id   string
 }
 
-var ignoredExtensions = [...]string{".gdoc", ".gsheet", ".gmap", ".gslides", ".gdraw", ".gform", ".gshortcut"}
-var ignoredFiles = [...]string{"Icon\r", ".DS_Store"}
-var ignoredDirectories = [...]string{"@eaDir", ".tmp.drivedownload"}
+var ignoredFiles = [...]string{"Icon\r", ".DS_Store", "desktop.ini", "Thumbs.db"}
+var ignoredDirectories = [...]string{"@eaDir", ".tmp.drivedownload", ".tmp.driveupload"}
 
 // lowercased by the time we filter
 var ignoredRemoteFiles = [...]string{".ds_store"}
 
-var localConflictMarkerRegexp = regexp.MustCompile(`\(slash conflict\)(/|$)`)
-var trailingSpaceRegexp = regexp.MustCompile(` /`)
+// Exit codes other than generic errors (1)
+const (
+	// exitSyncFailure indicates the comparison found mismatches
+	exitSyncFailure = 1
+	// exitGradeCrit indicates, with --grade-exit, that the verification score
+	// was graded CRIT (WARN exits with exitSyncFailure)
+	exitGradeCrit = 2
+	// exitConfigSuspect indicates the comparison looks misconfigured (e.g. the
+	// wrong root) rather than a genuine sync failure
+	exitConfigSuspect = 3
+	// exitSanityCheck indicates one side had far fewer files than expected
+	// (--min-expected-files, --max-missing-pct), or the local root wasn't
+	// mounted (--require-mounted)
+	exitSanityCheck = 4
+	// exitLocked indicates another verification was already running (see
+	// --wait and --force)
+	exitLocked = 5
+	// exitCancelled indicates verification was interrupted and only partial
+	// results were reported
+	exitCancelled = 130
+	// exitTimedOut indicates verification hit --timeout; results, if any,
+	// are partial
+	exitTimedOut = 124
+)
+
+// How long to wait for scans to stop after --timeout before giving up on them
+const timeoutGrace = time.Minute
+
+// subcommands other than verify, which is the default
+var subcommands = map[string]func(args []string) int{
+	"auth":             runAuth,
+	"scan":             runScan,
+	"compare":          runCompare,
+	"repair":           runRepair,
+	"tree":             runTree,
+	"install-schedule": runInstallSchedule,
+}
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := subcommands[args[0]]; ok {
+			os.Exit(command(args[1:]))
+		}
+		if args[0] == "verify" {
+			args = args[1:]
+		}
+	}
+	// anything else is verify's flags, as before there were subcommands
+	runVerify(args)
+}
+
+// getConfigDir returns the directory holding credentials, tokens and saved
+// state (GDSV_CONFIG_DIR if set), exiting if there's no home directory
+func getConfigDir() string {
+	if dir, ok := envConfigDirPath(); ok {
+		return dir
+	}
 	homeDir, err := homedir.Dir()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Please set $HOME to a readable path!")
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	configDir := filepath.Join(homeDir, ".googledrive-sync-verifier")
-	srv, err := NewDriveService(filepath.Join(configDir, "credentials.json"), filepath.Join(configDir, "token.json"))
+	return filepath.Join(homeDir, ".googledrive-sync-verifier")
+}
+
+// runVerify verifies a local directory against Google Drive
+func runVerify(args []string) {
+	configDir := getConfigDir()
 
 	// Uncomment the following to allow profiling via http
 	// go func() {
@@ -92,17 +175,134 @@ func main() {
 	// }()
 
 	var opts struct {
-		Verbose            bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
-		RemoteRoot         string `short:"r" long:"remote" description:"Directory in Google Drive to verify" default:""`
-		LocalRoot          string `short:"l" long:"local" description:"Local directory to compare to Google Drive contents" default:"."`
-		SelectiveSync      bool   `long:"selective" description:"Assume local is selectively synced - only check contents of top-level folders in local directory"`
-		SkipContentHash    bool   `long:"skip-hash" description:"Skip checking content hash of local files"`
-		WorkerCount        int    `short:"w" long:"workers" description:"Number of worker threads to use (defaults to 8) - set to 0 to use all CPU cores" default:"8"`
-		FreeMemoryInterval int    `long:"free-memory-interval" description:"Interval (in seconds) to manually release unused memory back to the OS on low-memory systems" default:"0"`
-		Synology           bool   `long:"synology" description:"Skip files known to have sync issues under Synology's Cloud Sync client"`
+		Verbose             bool          `short:"v" long:"verbose" description:"Show verbose debug information"`
+		Quiet               bool          `short:"q" long:"quiet" description:"Only print the status line and summary, e.g. for cron"`
+		Stream              string        `long:"stream" choice:"ndjson" description:"Write a JSON object per file to standard output as results are found (other output goes to standard error)"`
+		TopProblems         int           `long:"top-problems" description:"Also list the largest N files in each problem category, by size"`
+		FileTypes           bool          `long:"file-types" description:"Also break down results by file extension and size, to spot file types a sync client mishandles"`
+		ScoreWarn           float64       `long:"score-warn" description:"Grade the verification score WARN below this percentage of files or bytes matched" default:"99.9"`
+		ScoreCrit           float64       `long:"score-crit" description:"Grade the verification score CRIT below this percentage of files or bytes matched" default:"99"`
+		GradeExit           bool          `long:"grade-exit" description:"Exit with the score's grade: 0 for OK, 1 for WARN and 2 for CRIT, instead of 1 for any mismatch"`
+		LogFile             string        `long:"log-file" description:"Append log messages (API retries, unreadable and skipped files) to this file instead of stderr"`
+		LogFormat           string        `long:"log-format" description:"Log format: text or json" default:"text"`
+		LogLevel            string        `long:"log-level" description:"Minimum level to log: debug (includes why each skipped file was skipped), info, warn or error" default:"warn"`
+		Explain             string        `long:"explain" description:"Print a step-by-step trace of how this path (relative to the roots) was listed, normalized, filtered, compared and classified"`
+		Paths               []string      `long:"path" description:"Only verify this file (relative to the roots, or a full local path), looking it up directly instead of listing and scanning everything; can be given more than once"`
+		PathsFrom           string        `long:"paths-from" description:"Only verify the files listed in this file, one path per line, as with --path"`
+		RPC                 bool          `long:"rpc" description:"Serve JSON-RPC verification requests over stdin/stdout instead of running a single verification"`
+		NonInteractive      bool          `long:"non-interactive" description:"Never prompt or wait for input, failing instead (e.g. if Google Drive hasn't been authorized yet), for containers and task schedulers"`
+		RemoteRoot          string        `short:"r" long:"remote" description:"Directory in Google Drive to verify" default:""`
+		RemoteId            string        `long:"remote-id" description:"ID of the Google Drive folder to verify, instead of --remote (e.g. for shared folders or ambiguous names)"`
+		Computers           string        `long:"computers" description:"Verify a folder backed up from this computer (the \"Computers\" section of Google Drive): the backed-up folder with the same name as the local root"`
+		RemoteQuery         string        `long:"remote-query" description:"Only verify remote files matching this Drive API query clause, e.g. \"ownedByMe = true\" or \"modifiedTime > '2023-01-01'\"; local-only files are then not reported"`
+		MinSize             string        `long:"min-size" description:"Only verify files of at least this size (e.g. 10MB)"`
+		MaxSize             string        `long:"max-size" description:"Only verify files of at most this size"`
+		ModifiedSince       string        `long:"modified-since" description:"Only verify files modified since this date (2006-01-02), time (RFC 3339) or age (e.g. 30d or 72h)"`
+		ModifiedBefore      string        `long:"modified-before" description:"Only verify files modified before this date, time or age"`
+		SaveRemoteManifest  string        `long:"save-remote-manifest" description:"Save the Google Drive listing to this file (gzipped if it ends in .gz) for reuse with --load-remote-manifest"`
+		LoadRemoteManifest  string        `long:"load-remote-manifest" description:"Compare against a listing saved with --save-remote-manifest instead of listing Google Drive"`
+		RemoteCacheTTL      time.Duration `long:"remote-cache-ttl" description:"Reuse the Google Drive listing from an earlier run with the same remote settings if it's younger than this (e.g. 1h), and cache this run's listing for later ones"`
+		RefreshRemote       bool          `long:"refresh-remote" description:"List Google Drive even if the cached listing is still fresh, replacing it"`
+		SaveLocalManifest   string        `long:"save-local-manifest" description:"Save the hashed local scan to this file (gzipped if it ends in .gz) for reuse with --load-local-manifest"`
+		LoadLocalManifest   string        `long:"load-local-manifest" description:"Compare against a local scan saved with --save-local-manifest instead of scanning the local directory"`
+		LocalRoots          []string      `short:"l" long:"local" description:"Local directory to compare to Google Drive contents; give more than once to verify several disks against one remote root, each optionally as path=remote/folder to compare it with a remote subfolder" default:"."`
+		Accounts            []string      `long:"account" description:"Verify this account from accounts.json in the config directory against its own local root; repeat for several accounts"`
+		ParallelAccounts    bool          `long:"parallel-accounts" description:"Verify accounts given with --account in parallel rather than one after another"`
+		SelectiveSync       int           `long:"selective" description:"Assume local is selectively synced - only check contents of local folders at the given depth (top-level folders if no depth is given)" optional:"yes" optional-value:"1" default:"0"`
+		SelectiveList       string        `long:"selective-list" description:"Assume local is selectively synced - only check the folders listed in this text file, one per line, relative to the local root or as full paths"`
+		SkipContentHash     bool          `long:"skip-hash" description:"Skip checking content hash of local files"`
+		Scope               string        `long:"scope" description:"Read-only Google Drive access to use: metadata (the default) or readonly (the default with --spot-check); see auth login" choice:"readonly" choice:"metadata"`
+		TokenStore          string        `long:"token-store" description:"Where the Google Drive token is kept: file (token.json in the config directory) or keychain (the OS credential store)" choice:"file" choice:"keychain" default:"file"`
+		TokenPassphraseFile string        `long:"token-passphrase-file" description:"Encrypt the token file with the passphrase (or key) in this file, for systems without a keychain"`
+		SkipPlaceholders    bool          `long:"skip-placeholders" description:"Don't hash cloud-only placeholder files (which would download them); they're reported separately if their size matches"`
+		CheckFolders        bool          `long:"check-folders" description:"Also check that the folder tree matches, reporting empty folders missing on either side"`
+		CheckXattrs         bool          `long:"check-xattrs" description:"List local files with extended attributes, resource forks or Finder info, which Google Drive doesn't keep (macOS and Linux)"`
+		ListIgnored         bool          `long:"list-ignored" description:"List the local files and folders skipped by ignore rules (the sync client's own files, and the extensions and folder names --client ignores), not just how many there were"`
+		LocalDuplicates     bool          `long:"local-duplicates" description:"Also list groups of local files with the same contents (hard links to the same file aren't counted as copies); holds every local hash in memory"`
+		DetectClones        bool          `long:"detect-clones" description:"Hash copy-on-write clones of large files (APFS clones, btrfs and XFS reflinks) once, trusting the file system that they still share their contents"`
+		AdaptiveHash        bool          `long:"adaptive-hash" description:"List Google Drive before hashing, and don't read local files whose remote copy has no checksum (Google Docs and the like), which are only checked for being there"`
+		TwoPhase            bool          `long:"two-phase" description:"Compare paths and sizes first, printing what's missing or a different size straight away, then hash the local files whose size matched, printing content mismatches as they're found"`
+		Estimate            bool          `long:"estimate" description:"Count local files and bytes before scanning, to show percentage progress and warn early if the local tree looks empty"`
+		HashAlgo            string        `long:"hash-algo" description:"Checksum to compare: md5, sha1 or sha256 (uses Drive's matching checksum field)" default:"md5"`
+		Provider            string        `long:"provider" description:"Cloud storage to verify against: google-drive, dropbox, s3 or gcs (other than Google Drive, only plain comparison is supported)" default:"google-drive"`
+		DropboxToken        string        `long:"dropbox-token" env:"DROPBOX_TOKEN" description:"Dropbox access token for --provider dropbox (or set DROPBOX_TOKEN)"`
+		S3Endpoint          string        `long:"s3-endpoint" description:"S3-compatible endpoint URL for --provider s3, e.g. a MinIO server (default: AWS S3 in --s3-region)"`
+		S3Region            string        `long:"s3-region" env:"AWS_REGION" description:"S3 region for --provider s3" default:"us-east-1"`
+		S3AccessKey         string        `long:"s3-access-key" env:"AWS_ACCESS_KEY_ID" description:"S3 access key ID for --provider s3 (or set AWS_ACCESS_KEY_ID; omit for a public bucket)"`
+		S3SecretKey         string        `long:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY" description:"S3 secret access key for --provider s3 (or set AWS_SECRET_ACCESS_KEY)"`
+		GCSCredentials      string        `long:"gcs-credentials" description:"Service account key file for --provider gcs (default: Application Default Credentials)"`
+		WorkerCount         int           `short:"w" long:"workers" description:"Number of worker threads to use (defaults to 8) - set to 0 to use all CPU cores" default:"8"`
+		IOConcurrency       int           `long:"io-concurrency" description:"Maximum number of files read at once, independent of --workers (e.g. 1-2 for spinning disks, higher for SSDs; 0 for no separate limit)" default:"0"`
+		WalkConcurrency     int           `long:"walk-concurrency" description:"Number of local directories listed at once; more helps on network file systems (SMB, NFS) with many folders (1 to list one at a time)" default:"8"`
+		MaxReadRate         string        `long:"max-read-rate" description:"Limit the total rate of local reads for hashing, e.g. 50MB/s, so background verification doesn't starve other clients of the disk"`
+		HashBufferSize      string        `long:"hash-buffer-size" description:"Read local files in chunks of this size for hashing; larger chunks mean fewer reads, which helps on NAS devices" default:"1MiB"`
+		MaxQPS              float64       `long:"max-qps" description:"Maximum Google Drive API requests per second (0 for no limit); rate limit errors are retried with backoff either way" default:"0"`
+		FollowSymlinks      bool          `long:"follow-symlinks" description:"Follow symlinks in the local directory instead of skipping them"`
+		HashTimeout         time.Duration `long:"hash-timeout" description:"Give up on a local file if opening or reading it stalls for this long, e.g. 2m (0 to disable)" default:"0"`
+		ReadRetries         int           `long:"read-retries" description:"Number of times to retry local files that couldn't be read, at the end of the scan" default:"2"`
+		ReadRetryDelay      time.Duration `long:"read-retry-delay" description:"How long to wait before each retry of unreadable local files" default:"5s"`
+		FreeMemoryInterval  int           `long:"free-memory-interval" description:"Interval (in seconds) to manually release unused memory back to the OS on low-memory systems" default:"0"`
+		SpillThreshold      int           `long:"spill-threshold" description:"Number of files per manifest to hold in memory before spilling sorted runs to disk, bounding memory use on large trees (0 to keep everything in memory)" default:"100000"`
+		SpillDir            string        `long:"spill-dir" description:"Directory for manifests spilled to disk (defaults to the system temp directory)"`
+		Synology            bool          `long:"synology" description:"Shorthand for --client synology"`
+		Client              string        `long:"client" description:"Sync client whose renaming, possible matches and ignored files to expect: drive-desktop, synology, insync, rclone or none" default:"drive-desktop"`
+		Presets             []string      `long:"preset" description:"Apply a further preset's path transformations on top of --client; can be given more than once"`
+		PathRules           string        `long:"path-rules" description:"JSON file of extra path transformation rules"`
+		Strategies          string        `long:"strategies" description:"JSON file of comparison strategies by folder: hash, size-only, presence-only or skip"`
+		CSVPath             string        `long:"csv" description:"Write per-file verification results to a CSV file at this path"`
+		JSONPath            string        `long:"json" description:"Write verification results and performance statistics to a JSON file at this path"`
+		HTMLPath            string        `long:"html" description:"Write a standalone HTML report with collapsible sections and a search box to this path"`
+		EmptyLocalList      string        `long:"empty-local-list" description:"Write the Google Drive paths of files that are empty locally but not remotely to this file, one per line, for re-downloading"`
+		TUI                 bool          `long:"tui" description:"Show progress and then browse the results interactively, marking expected differences and exporting the rest"`
+		TUIExport           string        `long:"tui-export" description:"File the remaining results are exported to from --tui (as JSON)" default:"remaining.json"`
+		MetricsFile         string        `long:"metrics-file" description:"Write Prometheus metrics to this file after each run, for node_exporter's textfile collector"`
+		MetricsListen       string        `long:"metrics-listen" description:"Serve Prometheus metrics on this address (e.g. :9090) while running, useful with --watch"`
+		BadgePath           string        `long:"badge" description:"Write an SVG status badge (passing/failing with counts and date) to this path"`
+		ResolveShortcuts    bool          `long:"resolve-shortcuts" description:"Verify Drive shortcuts as copies of their targets at the shortcut's path, as Drive for Desktop syncs them"`
+		SkipGooglePhotos    bool          `long:"skip-google-photos" description:"Leave the legacy Google Photos folder (from before 2019) out of the remote listing"`
+		ExcludeRemote       []string      `long:"exclude-remote" description:"Leave this Google Drive folder out of the listing, by path relative to the remote root; wildcards and a leading **/ for any depth work, e.g. Archive/** or **/node_modules; can be given more than once"`
+		ExcludeRemoteIds    []string      `long:"exclude-remote-id" description:"Leave the Google Drive folder with this ID out of the listing; can be given more than once"`
+		ListGoogleNative    bool          `long:"list-google-native" description:"List the Google Docs, Sheets and other Google-native files that weren't verified, not just how many there were"`
+		Spaces              string        `long:"spaces" description:"Comma-separated Drive spaces to list: drive, appDataFolder (app-private data, listed under appDataFolder/; needs the drive.appdata scope)" default:"drive"`
+		OwnedOnly           bool          `long:"owned-only" description:"Only verify files you own, leaving out files others own in folders shared with you"`
+		IncludeShared       bool          `long:"include-shared" description:"Also verify files shared with you that aren't in My Drive, under a \"Shared with me\" folder (remote root / only)"`
+		RecheckFolders      int           `long:"recheck-folders" description:"Maximum number of remote folders with discrepancies to re-list after comparison, to catch changes made during the scan (0 to disable)" default:"100"`
+		CheckTrash          int           `long:"check-trash" optional:"yes" optional-value:"100" default:"0" description:"Look up local-only files in Drive's trash and orphaned files to tell deletions apart from files never uploaded. Optionally specify the maximum number of files to look up (default 100)"`
+		IncludeTrashed      bool          `long:"include-trashed" description:"Also list trashed files from Google Drive, reported in their own section, and report local-only files trashed at the same path as deleted remotely"`
+		CheckRevisions      int           `long:"check-revisions" optional:"yes" optional-value:"100" default:"0" description:"Look up earlier Google Drive revisions of files whose contents don't match, to find local copies that are just out of date. Optionally specify the maximum number of files to look up (default 100)"`
+		SpotCheck           string        `long:"spot-check" description:"Download this percentage of matched files (e.g. 1%) from Google Drive and compare them byte for byte with the local copies"`
+		Sample              string        `long:"sample" description:"Only verify a random sample of this percentage of files (e.g. 5%), fully hashed, and estimate how many files across the whole tree aren't matched"`
+		SampleSeed          uint64        `long:"sample-seed" description:"Pick the --sample with this seed, to verify the same files as an earlier run (by default a new seed is picked and printed)"`
+		Baseline            string        `long:"baseline" description:"JSON file of known, accepted differences (paths, optionally with a status or hash); they're reported as acknowledged and don't count as failures"`
+		DiffPrevious        bool          `long:"diff-previous" description:"Only list problems that are new since the last run with this option, and those resolved since then"`
+		Resume              bool          `long:"resume" description:"Reuse local hashes saved when an earlier run was cancelled (Ctrl+C), for files that haven't changed since"`
+		HashCache           string        `long:"hash-cache" description:"Save local hashes to this file with a fast xxHash, and on later runs only read unchanged files with xxHash to reuse their hashes"`
+		Timeout             time.Duration `long:"timeout" description:"Stop scanning after this long (e.g. 2h) and report partial results"`
+		Wait                bool          `long:"wait" description:"If another verification is running, wait for it to finish instead of exiting"`
+		Force               bool          `long:"force" description:"Run even if another verification is running"`
+		RemoteTimeout       time.Duration `long:"remote-timeout" description:"Abandon and retry a Google Drive API request that takes longer than this" default:"5m"`
+		MinExpectedFiles    int           `long:"min-expected-files" description:"Fail with exit status 4 if either side has fewer files than this, e.g. because the local folder isn't mounted"`
+		MaxMissingPct       float64       `long:"max-missing-pct" description:"Fail with exit status 4 if more than this percentage of either side's files are missing from the other"`
+		RequireMounted      bool          `long:"require-mounted" description:"Before scanning, check that the local root is a non-empty directory on a mounted drive or share, not the system disk"`
+		NotifyWebhook       string        `long:"notify-webhook" description:"POST a notification to this URL when verification fails (see --notify-on)"`
+		NotifyFormat        string        `long:"notify-format" description:"Webhook payload format: json, slack or discord" default:"json"`
+		NotifyOn            string        `long:"notify-on" description:"When to notify: failure, or new-problems (only problems new since the last run; needs --diff-previous)" default:"failure"`
+		NotifyEmail         []string      `long:"notify-email" description:"Email a notification to this address when verification fails; can be given more than once"`
+		NotifyFrom          string        `long:"notify-from" description:"Sender address for email notifications"`
+		SMTPServer          string        `long:"smtp-server" description:"SMTP server for email notifications, as host:port"`
+		SMTPUser            string        `long:"smtp-user" description:"SMTP username, if the server needs authentication"`
+		SMTPPassword        string        `long:"smtp-password" env:"GDSV_SMTP_PASSWORD" description:"SMTP password (or set GDSV_SMTP_PASSWORD)"`
+		Rotate              int           `long:"rotate" description:"Split the tree into this many shards and verify the next shard each run, for full coverage over N runs" default:"0"`
+		Watch               bool          `long:"watch" description:"Keep running after verification, re-checking files as they change locally or in Google Drive and reporting drift"`
+		WatchInterval       time.Duration `long:"watch-interval" description:"How often to re-check changed files in --watch mode" default:"30s"`
+		CASStore            string        `long:"cas-store" description:"Audit Google Drive against a content-addressable store in this directory (files stored by MD5) instead of a synced folder"`
+		CASIndex            string        `long:"cas-index" description:"Path index for --cas-store, in md5sum format (\"<md5>  <path>\" per line)"`
 	}
 
-	args, err := flags.Parse(&opts)
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "[verify] [OPTIONS]"
+	setOptionEnv(parser)
+	args, err := parser.ParseArgs(args)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
@@ -112,113 +312,833 @@ func main() {
 		os.Exit(1)
 	}
 
-	localRoot, _ := filepath.Abs(opts.LocalRoot)
-	var localDirs []string
-	if opts.SelectiveSync {
-		localDirs, err = listFolders(opts.LocalRoot)
+	nonInteractive = opts.NonInteractive
+
+	logCloser, err := setupLogging(opts.LogFile, opts.LogFormat, opts.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up logging: %v\n", err)
+		os.Exit(1)
+	}
+	// os.Exit skips deferred calls, but the log file is unbuffered
+	defer logCloser.Close()
+
+	driveLimiter.SetRate(opts.MaxQPS)
+
+	workerCount := resolveWorkerCount(opts.WorkerCount)
+	hashAlgo, err := parseHashAlgorithm(opts.HashAlgo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	readLimiter, err := parseReadRate(opts.MaxReadRate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	hashBufferSize, err := parseHashBufferSize(opts.HashBufferSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	hashBuffers = newHashBufferPool(hashBufferSize)
+	if tokens, err = newTokenStore(opts.TokenStore, opts.TokenPassphraseFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	filter, err := newFileFilter(opts.MinSize, opts.MaxSize, opts.ModifiedSince, opts.ModifiedBefore)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if opts.CheckXattrs && !xattrsSupported {
+		fmt.Fprintln(os.Stderr, "--check-xattrs isn't supported on this platform")
+		os.Exit(1)
+	}
+	if opts.OwnedOnly && opts.IncludeShared {
+		fmt.Fprintln(os.Stderr, "--owned-only can't be combined with --include-shared")
+		os.Exit(1)
+	}
+	provider, err := newRemoteProvider(opts.Provider, providerOptions{
+		DropboxToken:   opts.DropboxToken,
+		S3Endpoint:     opts.S3Endpoint,
+		S3Region:       opts.S3Region,
+		S3AccessKey:    opts.S3AccessKey,
+		S3SecretKey:    opts.S3SecretKey,
+		S3SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		GCSCredentials: opts.GCSCredentials,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if provider != nil {
+		if opts.RemoteId != "" || opts.Computers != "" || opts.Watch || len(opts.Accounts) > 0 || opts.RPC || opts.CASStore != "" || opts.SpotCheck != "" || opts.IncludeTrashed || opts.CheckTrash > 0 || opts.CheckRevisions > 0 {
+			fmt.Fprintf(os.Stderr, "--provider %s only supports plain comparison, without Google Drive options such as --remote-id, --computers, --watch, --account, --rpc, --spot-check or the trash and revision checks\n", opts.Provider)
+			os.Exit(1)
+		}
+		if opts.RemoteQuery != "" || opts.OwnedOnly || opts.IncludeShared || opts.SkipGooglePhotos || opts.Spaces != "drive" || opts.ResolveShortcuts || len(opts.ExcludeRemote) > 0 || len(opts.ExcludeRemoteIds) > 0 || opts.ListGoogleNative {
+			fmt.Fprintf(os.Stderr, "--provider %s can't be combined with the Google Drive listing options --remote-query, --owned-only, --include-shared, --skip-google-photos, --spaces, --resolve-shortcuts, --exclude-remote, --exclude-remote-id or --list-google-native\n", opts.Provider)
+			os.Exit(1)
+		}
+		hashAlgo = provider.HashAlgo()
+	}
+	rules, err := newPathRules(opts.Client, opts.Presets, opts.Synology, opts.PathRules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	grade, err := newGradeThresholds(opts.ScoreWarn, opts.ScoreCrit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	var strategies *compareStrategies
+	if opts.Strategies != "" {
+		strategies, err = loadCompareStrategies(opts.Strategies)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+	spotCheck, err := parseSpotCheck(opts.SpotCheck)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	excludeRemote, err := newRemoteExclusions(opts.ExcludeRemote, opts.ExcludeRemoteIds)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	samplePct, err := parseSample(opts.Sample)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if samplePct > 0 && (opts.DiffPrevious || opts.Watch || opts.MinExpectedFiles > 0 || len(opts.Paths) > 0 || opts.PathsFrom != "") {
+		fmt.Fprintln(os.Stderr, "--sample can't be combined with --diff-previous, --watch, --min-expected-files, --path or --paths-from")
+		os.Exit(1)
+	}
+	if opts.SampleSeed != 0 && samplePct == 0 {
+		fmt.Fprintln(os.Stderr, "--sample-seed needs --sample")
+		os.Exit(1)
+	}
+	sample := newSampleFilter(samplePct, opts.SampleSeed)
+	if spotCheck > 0 && (opts.LoadLocalManifest != "" || opts.SkipContentHash || opts.Scope == "metadata") {
+		fmt.Fprintln(os.Stderr, "--spot-check can't be combined with --load-local-manifest, --skip-hash or --scope metadata")
+		os.Exit(1)
+	}
+	spaces, err := parseSpaces(opts.Spaces)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	notify := &notifyConfig{
+		Webhook:      opts.NotifyWebhook,
+		Format:       opts.NotifyFormat,
+		On:           opts.NotifyOn,
+		SMTPServer:   opts.SMTPServer,
+		SMTPUser:     opts.SMTPUser,
+		SMTPPassword: opts.SMTPPassword,
+		From:         opts.NotifyFrom,
+		To:           opts.NotifyEmail,
+	}
+	if err := notify.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if notify.On == "new-problems" && !opts.DiffPrevious {
+		fmt.Fprintln(os.Stderr, "--notify-on new-problems needs --diff-previous")
+		os.Exit(1)
+	}
+	var accepted *baseline
+	if opts.Baseline != "" {
+		accepted, err = loadBaseline(opts.Baseline)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Acknowledging %d known differences from %s.\n", accepted.Len(), opts.Baseline)
+	}
+	// settings shared by every verification; roots are filled in below
+	template := verifyConfig{
+		DriveScope:         resolveDriveScope(opts.Scope, spotCheck > 0),
+		RemoteQuery:        opts.RemoteQuery,
+		ResolveShortcuts:   opts.ResolveShortcuts,
+		SkipGooglePhotos:   opts.SkipGooglePhotos,
+		ExcludeRemote:      excludeRemote,
+		ListGoogleNative:   opts.ListGoogleNative,
+		Spaces:             spaces,
+		OwnedOnly:          opts.OwnedOnly,
+		IncludeShared:      opts.IncludeShared,
+		RecheckFolders:     opts.RecheckFolders,
+		CheckTrash:         opts.CheckTrash,
+		IncludeTrashed:     opts.IncludeTrashed,
+		CheckRevisions:     opts.CheckRevisions,
+		Baseline:           accepted,
+		SpotCheck:          spotCheck,
+		SaveRemoteManifest: opts.SaveRemoteManifest,
+		LoadRemoteManifest: opts.LoadRemoteManifest,
+		SaveLocalManifest:  opts.SaveLocalManifest,
+		LoadLocalManifest:  opts.LoadLocalManifest,
+		RecordMatches:      opts.CSVPath != "" || opts.JSONPath != "" || opts.HTMLPath != "" || opts.Watch || opts.Explain != "" || spotCheck > 0 || opts.TwoPhase,
+		FileTypes:          opts.FileTypes,
+		LocalDuplicates:    opts.LocalDuplicates,
+		AdaptiveHash:       opts.AdaptiveHash,
+		TwoPhase:           opts.TwoPhase,
+		Quiet:              opts.Quiet,
+		Grade:              grade,
+		RemoteTimeout:      opts.RemoteTimeout,
+		Provider:           provider,
+		Local: localScanOptions{
+			SkipContentHash:  opts.SkipContentHash,
+			SkipPlaceholders: opts.SkipPlaceholders,
+			RecordFolders:    opts.CheckFolders,
+			CheckXattrs:      opts.CheckXattrs,
+			ListIgnored:      opts.ListIgnored,
+			RecordFullPaths:  spotCheck > 0 || opts.TwoPhase,
+			WorkerCount:      workerCount,
+			HashTimeout:      opts.HashTimeout,
+			ReadRetries:      opts.ReadRetries,
+			ReadRetryDelay:   opts.ReadRetryDelay,
+			FollowSymlinks:   opts.FollowSymlinks,
+			HashAlgo:         hashAlgo,
+			Spill:            spillConfig{Threshold: opts.SpillThreshold, Dir: opts.SpillDir},
+			IOConcurrency:    opts.IOConcurrency,
+			DetectClones:     opts.DetectClones,
+			WalkConcurrency:  opts.WalkConcurrency,
+			ReadLimiter:      readLimiter,
+			Filter:           filter,
+			Sample:           sample,
+			PathRules:        rules,
+			Strategies:       strategies,
+		},
+	}
+	selection := selectiveSync{Depth: opts.SelectiveSync, ListFile: opts.SelectiveList}
+
+	if opts.LoadRemoteManifest != "" && (opts.SaveRemoteManifest != "" || opts.Watch || len(opts.Accounts) > 0) {
+		fmt.Fprintln(os.Stderr, "--load-remote-manifest can't be combined with --save-remote-manifest, --watch or --account")
+		os.Exit(1)
+	}
+	if opts.LocalDuplicates && (opts.SkipContentHash || opts.Watch) {
+		fmt.Fprintln(os.Stderr, "--local-duplicates can't be combined with --skip-hash or --watch")
+		os.Exit(1)
+	}
+	if opts.AdaptiveHash && (opts.SkipContentHash || opts.LoadLocalManifest != "" || opts.SaveLocalManifest != "" || opts.Watch) {
+		fmt.Fprintln(os.Stderr, "--adaptive-hash can't be combined with --skip-hash, --load-local-manifest, --save-local-manifest or --watch")
+		os.Exit(1)
+	}
+	if (len(opts.Paths) > 0 || opts.PathsFrom != "") && (opts.LoadRemoteManifest != "" || opts.SaveRemoteManifest != "" || opts.LoadLocalManifest != "" || opts.SaveLocalManifest != "" || opts.RemoteCacheTTL > 0 || provider != nil || opts.Watch || len(opts.Accounts) > 0 || opts.CheckFolders || opts.Rotate > 1 || opts.Resume || opts.HashCache != "" || opts.TwoPhase || len(opts.LocalRoots) > 1 || selection.enabled()) {
+		fmt.Fprintln(os.Stderr, "--path and --paths-from can't be combined with saving or loading manifests, --remote-cache-ttl, --provider, --watch, --account, --check-folders, --rotate, --resume, --hash-cache, --two-phase, several --local roots or selective sync")
+		os.Exit(1)
+	}
+	if opts.TwoPhase && (opts.SkipContentHash || opts.AdaptiveHash || opts.LoadLocalManifest != "" || opts.SaveLocalManifest != "" || opts.HashCache != "" || opts.LocalDuplicates || opts.Watch || opts.TUI) {
+		fmt.Fprintln(os.Stderr, "--two-phase can't be combined with --skip-hash, --adaptive-hash, --load-local-manifest, --save-local-manifest, --hash-cache, --local-duplicates, --watch or --tui")
+		os.Exit(1)
+	}
+	if opts.RefreshRemote && opts.RemoteCacheTTL <= 0 {
+		fmt.Fprintln(os.Stderr, "--refresh-remote needs --remote-cache-ttl")
+		os.Exit(1)
+	}
+	if opts.RemoteCacheTTL > 0 {
+		if opts.LoadRemoteManifest != "" || opts.SaveRemoteManifest != "" || provider != nil || opts.Watch || len(opts.Accounts) > 0 || opts.CheckFolders {
+			fmt.Fprintln(os.Stderr, "--remote-cache-ttl can't be combined with --load-remote-manifest, --save-remote-manifest, --provider, --watch, --account or --check-folders")
+			os.Exit(1)
+		}
+		template.RemoteCache = &remoteCache{Dir: filepath.Join(configDir, "remote-cache"), TTL: opts.RemoteCacheTTL, Refresh: opts.RefreshRemote}
+	}
+	if opts.MaxMissingPct < 0 || opts.MaxMissingPct > 100 {
+		fmt.Fprintln(os.Stderr, "--max-missing-pct must be between 0 and 100")
+		os.Exit(1)
+	}
+	sanity := sanityCheck{MinFiles: opts.MinExpectedFiles, MaxMissingPct: opts.MaxMissingPct}
+	if len(opts.Accounts) > 0 && (opts.CSVPath != "" || opts.HTMLPath != "" || opts.BadgePath != "" || notify.enabled()) {
+		fmt.Fprintln(os.Stderr, "--account can't be combined with --csv, --html, --badge, --notify-webhook or --notify-email; --json writes every account's results")
+		os.Exit(1)
+	}
+	if opts.RPC && (opts.CSVPath != "" || opts.HTMLPath != "" || opts.BadgePath != "" || notify.enabled() || opts.MinExpectedFiles > 0 || opts.MaxMissingPct > 0 || opts.RequireMounted) {
+		fmt.Fprintln(os.Stderr, "--rpc can't be combined with --csv, --html, --badge, --notify-webhook, --notify-email, --min-expected-files, --max-missing-pct or --require-mounted, since each request gives its own settings")
+		os.Exit(1)
+	}
+	if opts.Timeout > 0 && (opts.Watch || len(opts.Accounts) > 0 || opts.RPC) {
+		fmt.Fprintln(os.Stderr, "--timeout can't be combined with --watch, --account or --rpc")
+		os.Exit(1)
+	}
+	if opts.TUI && opts.NonInteractive {
+		fmt.Fprintln(os.Stderr, "--tui can't be combined with --non-interactive")
+		os.Exit(1)
+	}
+	if opts.TUI && (opts.Watch || len(opts.Accounts) > 0 || opts.RPC) {
+		fmt.Fprintln(os.Stderr, "--tui can't be combined with --watch, --account or --rpc")
+		os.Exit(1)
+	}
+	if opts.Stream != "" && (opts.Watch || len(opts.Accounts) > 0 || opts.RPC || opts.TUI) {
+		fmt.Fprintln(os.Stderr, "--stream can't be combined with --watch, --account, --rpc or --tui")
+		os.Exit(1)
+	}
+	var stream *resultStream
+	if opts.Stream != "" {
+		stream = newResultStream(os.Stdout)
+		// keep standard output for the stream alone
+		os.Stdout = os.Stderr
+	}
+	if opts.Quiet && (opts.Verbose || opts.TUI) {
+		fmt.Fprintln(os.Stderr, "--quiet can't be combined with --verbose or --tui")
+		os.Exit(1)
+	}
+	if opts.LoadLocalManifest != "" && (opts.SaveLocalManifest != "" || opts.Watch || len(opts.Accounts) > 0) {
+		fmt.Fprintln(os.Stderr, "--load-local-manifest can't be combined with --save-local-manifest, --watch or --account")
+		os.Exit(1)
+	}
+	if opts.CheckFolders && (opts.LoadLocalManifest != "" || opts.LoadRemoteManifest != "" || provider != nil || opts.RemoteQuery != "" || opts.Rotate > 1) {
+		fmt.Fprintln(os.Stderr, "--check-folders needs a Google Drive listing and a local scan, so it can't be combined with --load-local-manifest, --load-remote-manifest, --provider, --remote-query or --rotate")
+		os.Exit(1)
+	}
+	if opts.HashCache != "" && (opts.LoadLocalManifest != "" || len(opts.Accounts) > 0) {
+		fmt.Fprintln(os.Stderr, "--hash-cache can't be combined with --load-local-manifest or --account")
+		os.Exit(1)
+	}
+	if opts.ListGoogleNative && (opts.LoadRemoteManifest != "" || opts.RemoteCacheTTL > 0 || provider != nil || len(opts.Paths) > 0 || opts.PathsFrom != "") {
+		fmt.Fprintln(os.Stderr, "--list-google-native needs a Google Drive listing, so it can't be combined with --load-remote-manifest, --remote-cache-ttl, --provider, --path or --paths-from")
+		os.Exit(1)
+	}
+	if opts.ListIgnored && (opts.LoadLocalManifest != "" || len(opts.Paths) > 0 || opts.PathsFrom != "") {
+		fmt.Fprintln(os.Stderr, "--list-ignored needs a local scan, so it can't be combined with --load-local-manifest, --path or --paths-from")
+		os.Exit(1)
+	}
+
+	localRoots, err := parseLocalRoots(opts.LocalRoots)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	multipleRoots := len(localRoots) > 1 || localRoots[0].Prefix != ""
+	if multipleRoots && (selection.enabled() || opts.Rotate > 1 || opts.Watch || opts.SaveLocalManifest != "" || opts.LoadLocalManifest != "" || opts.HashCache != "" || opts.CheckFolders || len(opts.Accounts) > 0) {
+		fmt.Fprintln(os.Stderr, "Several local roots, or a remote folder for a local root, can't be combined with --selective, --rotate, --watch, --save-local-manifest, --load-local-manifest, --hash-cache, --check-folders or --account")
+		os.Exit(1)
+	}
+
+	if opts.Force {
+		logger.Warn("not checking for other verifications running", "reason", "--force")
+	} else if err := acquireRunLock(filepath.Join(configDir, "verify.lock"), opts.Wait); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		if _, ok := err.(*lockedError); ok {
+			os.Exit(exitLocked)
+		}
+		os.Exit(1)
+	}
+
+	if len(opts.Accounts) > 0 {
+		os.Exit(runAccounts(configDir, opts.Accounts, opts.ParallelAccounts, template, selection, sanity, opts.RequireMounted, opts.JSONPath))
+	}
+
+	var srv *drive.Service
+	if provider == nil {
+		srv, err = NewDriveService(filepath.Join(configDir, "credentials.json"), filepath.Join(configDir, "token.json"), template.DriveScope)
 		if err != nil {
+			printFailure(err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.RPC {
+		if err := serveRPC(srv, os.Stdin, os.Stdout); err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
+		return
 	}
 
-	remoteRoot := opts.RemoteRoot
-	if remoteRoot == "" {
-		remoteRoot = defaultRemoteRoot(localRoot)
+	if opts.CASStore != "" {
+		os.Exit(runCASAudit(srv, opts.RemoteRoot, opts.CASStore, opts.CASIndex, !opts.SkipContentHash, rules))
 	}
-	if remoteRoot[0] != '/' {
-		remoteRoot = "/" + remoteRoot
+
+	remoteArg := opts.RemoteRoot
+	if provider != nil && remoteArg == "" {
+		remoteArg = "/"
+	}
+	remoteId := opts.RemoteId
+	if remoteId != "" || opts.Computers != "" {
+		if opts.RemoteRoot != "" || (remoteId != "" && opts.Computers != "") {
+			fmt.Fprintln(os.Stderr, "Only one of --remote, --remote-id and --computers can be given")
+			os.Exit(1)
+		}
+		remoteArg = "/"
+	}
+	localRoot, remoteRoot, localDirs, err := resolveRoots(localRoots[0].Root, remoteArg, selection)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if opts.Explain != "" {
+		explainPath := opts.Explain
+		if filepath.IsAbs(explainPath) {
+			// a full local path; anything else is taken as relative to the roots
+			if rel, err := filepath.Rel(localRoot, explainPath); err == nil && !strings.HasPrefix(rel, "..") {
+				explainPath = rel
+			}
+		}
+		explainer = newPathExplainer(explainPath)
+	}
+	checked, err := checkedPaths(localRoot, opts.Paths, opts.PathsFrom)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
 	}
 
-	if opts.SelectiveSync {
-		fmt.Printf("Comparing subfolders of Google Drive directory \"%v\" to local directory \"%v\"\n", remoteRoot, localRoot)
-	} else {
-		fmt.Printf("Comparing Google Drive directory \"%v\" to local directory \"%v\"\n", remoteRoot, localRoot)
+	// remoteLabel identifies the remote root in output and saved state
+	remoteLabel := remoteRoot
+	remoteName := "Google Drive"
+	if provider != nil {
+		remoteName = provider.Name()
+		remoteLabel = opts.Provider + ":" + remoteRoot
 	}
-	// TODO add caveat about using non-default remote root - may be slow with
-	// many files in account since it's filtering post API calls
-	if !opts.SkipContentHash {
-		fmt.Println("Checking content hashes.")
+	if opts.Computers != "" {
+		folderName := filepath.Base(localRoot)
+		remoteId, err = NewDriveListing(srv, "/", nil).findComputerFolder(opts.Computers, folderName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		remoteLabel = fmt.Sprintf("Computers/%s/%s", opts.Computers, folderName)
+	} else if remoteId != "" {
+		remoteLabel = "id:" + remoteId
 	}
-	workerCount := opts.WorkerCount
-	if workerCount <= 0 {
-		workerCount = int(math.Max(1, float64(runtime.NumCPU())))
+	if !opts.Quiet {
+		if selection.enabled() {
+			fmt.Printf("Comparing subfolders of %s directory \"%v\" to local directory \"%v\"\n", remoteName, remoteLabel, localRoot)
+		} else if multipleRoots {
+			fmt.Printf("Comparing %s directory \"%v\" to local directories:\n", remoteName, remoteLabel)
+			for _, spec := range localRoots {
+				fmt.Printf("  %s\n", spec)
+			}
+		} else {
+			fmt.Printf("Comparing %s directory \"%v\" to local directory \"%v\"\n", remoteName, remoteLabel, localRoot)
+		}
+		if opts.RemoteQuery != "" {
+			fmt.Printf("Only verifying remote files matching: %s\n", opts.RemoteQuery)
+		}
+		if len(checked) > 0 {
+			fmt.Printf("Only verifying %d files given by path.\n", len(checked))
+		}
+		if sample != nil {
+			fmt.Printf("Only verifying a %s; --sample-seed %d checks the same files again.\n", sample, sample.Seed)
+		}
+		if filter != nil {
+			fmt.Printf("Only verifying files %s.\n", filter)
+		}
+		if strategies != nil {
+			fmt.Printf("Comparing by folder: %s.\n", strategies)
+		}
+		if !opts.SkipContentHash {
+			fmt.Println("Checking content hashes.")
+		}
+		fmt.Printf("Using %d local worker threads.\n", workerCount)
+		if opts.IOConcurrency > 0 {
+			fmt.Printf("Reading at most %d files at once.\n", opts.IOConcurrency)
+		}
+		if readLimiter != nil {
+			fmt.Printf("Reading at most %s.\n", readLimiter)
+		}
+		fmt.Println("")
 	}
-	fmt.Printf("Using %d local worker threads.\n", workerCount)
-	fmt.Println("")
 
 	// set up manual garbage collection routine
 	if opts.FreeMemoryInterval > 0 {
 		go timedManualGC(opts.FreeMemoryInterval, opts.Verbose)
 	}
 
-	progressChan := make(chan *scanProgressUpdate)
-	var wg sync.WaitGroup
-	wg.Add(2)
+	config := &template
+	config.RemoteRoot = remoteRoot
+	config.RemoteRootId = remoteId
+	config.LocalRoot = localRoot
+	config.LocalDirs = localDirs
+	config.Stream = stream
+	config.Checkpoint = filepath.Join(configDir, "checkpoint.json.gz")
+	config.Resume = opts.Resume
+	config.HashCache = opts.HashCache
+	config.Paths = checked
+	if len(checked) > 0 {
+		// a checkpoint of a few files would replace one worth resuming
+		config.Checkpoint = ""
+	}
+	if multipleRoots {
+		config.LocalRoots = localRoots
+		// checkpoints hold a single root
+		config.Checkpoint = ""
+	}
 
-	var driveManifest *FileHeap
-	var driveError error
-	go func() {
-		driveManifest, driveError = getGoogleDriveManifest(progressChan, srv, remoteRoot, localDirs, opts.Synology)
-		wg.Done()
-	}()
+	var rotation *rotationState
+	rotationId := rotationKey(remoteLabel, localRoot, opts.Rotate)
+	if opts.Rotate > 1 {
+		rotation, err = loadRotationState(filepath.Join(configDir, "rotation.json"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		config.Local.Shard = rotation.shard(rotationId, opts.Rotate)
+		fmt.Printf("Verifying shard %s.\n\n", config.Local.Shard)
+	}
 
-	var localManifest *FileHeap
-	var errored []*FileError
-	var localErr error
-	go func() {
-		localManifest, errored, localErr = getLocalManifest(progressChan, localRoot, localDirs, opts.SkipContentHash, workerCount)
-		wg.Done()
-	}()
+	var metrics *metricsServer
+	if opts.MetricsListen != "" {
+		metrics, err = startMetricsServer(opts.MetricsListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to serve metrics: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	go func() {
-		remoteCount := 0
-		localCount := 0
-		errorCount := 0
-		for update := range progressChan {
-			switch update.Type {
-			case remoteProgress:
-				remoteCount = update.Count
-			case localProgress:
-				localCount = update.Count
-			case errorProgress:
-				errorCount = update.Count
+	if opts.RequireMounted && opts.LoadLocalManifest == "" {
+		for _, spec := range localRoots {
+			if err := checkMounted(spec.Root); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(exitSanityCheck)
 			}
+		}
+	}
+
+	var estimate *localEstimate
+	if opts.Estimate && opts.LoadLocalManifest == "" {
+		fmt.Println("Estimating local tree size...")
+		estimate = estimateLocalTree(localRoot, localDirs, config.Local)
+		fmt.Printf("Found %s to verify.\n\n", estimate)
+		if estimate.Files == 0 {
+			fmt.Fprintln(os.Stderr, "⚠️  WARNING: no local files found. Is the local folder mounted and synced?")
+		}
+	}
 
-			if opts.Verbose {
-				fmt.Fprintf(os.Stderr, "Scanning: %d (remote) %d (local) %d (errored)\r", remoteCount, localCount, errorCount)
+	progress := newScanProgress()
+	progressDone := make(chan bool)
+	if opts.TUI {
+		// the TUI shows progress itself
+		close(progressDone)
+	} else {
+		go func() {
+			printProgress := func() {
+				totals := progress.Snapshot()
+				if estimate != nil {
+					fmt.Fprintf(os.Stderr, "Scanning: %d (remote) %s (local) %d (errored)\r", totals.RemoteListed, estimate.progress(totals.LocalFiles, totals.LocalBytesHashed), totals.LocalErrored)
+				} else if opts.Verbose {
+					fmt.Fprintf(os.Stderr, "Scanning: %d (remote) %d (local) %d (errored)\r", totals.RemoteListed, totals.LocalFiles, totals.LocalErrored)
+				}
 			}
+			ticker := time.NewTicker(progressInterval)
+			defer ticker.Stop()
+			remoteDone := progress.RemoteDone()
+			for {
+				select {
+				case <-ticker.C:
+					printProgress()
+				case <-remoteDone:
+					remoteDone = nil
+					remoteFiles := progress.Snapshot().RemoteFiles
+					if estimate != nil && estimate.looksEmpty(remoteFiles) {
+						fmt.Fprintf(os.Stderr, "\n⚠️  WARNING: Google Drive has %d files but only %d were found locally. Is the local folder mounted and synced?\n", remoteFiles, estimate.Files)
+					}
+				case <-progress.Done():
+					printProgress()
+					fmt.Fprintf(os.Stderr, "\n")
+					close(progressDone)
+					return
+				}
+			}
+		}()
+	}
+
+	// get a changes token before scanning so nothing is missed during the scan
+	var changesToken string
+	if opts.Watch {
+		changesToken, err = NewDriveListing(srv, remoteRoot, localDirs).StartPageToken()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to start watching remote changes: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "\n")
-	}()
+	}
 
-	// wait until remote and local scans are complete, then close progress reporting channel
-	wg.Wait()
-	close(progressChan)
-	fmt.Printf("\nGenerated manifests for %d remote files, %d local files, with %d local errors\n\n", driveManifest.Len(), localManifest.Len(), len(errored))
+	// on SIGINT or SIGTERM, or after --timeout, stop scanning and report
+	// what was found so far; a second signal quits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if opts.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, opts.Timeout)
+		defer cancelTimeout()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	verified := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\nCancelling: comparing what was scanned so far. Interrupt again to quit immediately.")
+			cancel()
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "\nTimed out after %v: comparing what was scanned so far.\n", opts.Timeout)
+			// reads from a dead mount can't be interrupted, so don't wait
+			// on them forever
+			select {
+			case <-verified:
+			case <-time.After(timeoutGrace):
+				fmt.Fprintln(os.Stderr, "Scans didn't stop after timing out; giving up.")
+				os.Exit(exitTimedOut)
+			}
+		case <-verified:
+		}
+	}()
 
+	var result *verifyResult
+	if opts.TUI {
+		result, err = runTUI(progress, func() (*verifyResult, error) {
+			return runVerification(ctx, srv, config, progress)
+		}, opts.TUIExport)
+		if err != nil && result == nil {
+			printFailure(err)
+			os.Exit(1)
+		}
+	} else {
+		result, err = runVerification(ctx, srv, config, progress)
+	}
+	close(verified)
+	signal.Stop(signals)
+	progress.finish()
+	<-progressDone
 	// check for fatal errors
-	if driveError != nil {
-		panic(driveError)
+	if err != nil && ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Stopped before anything could be compared: %v\n", err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			os.Exit(exitTimedOut)
+		}
+		os.Exit(exitCancelled)
+	}
+	if err != nil {
+		printFailure(err)
+		os.Exit(1)
+	}
+	manifestComparison := result.Comparison
+	stats := result.Stats
+	if stream != nil {
+		if err := stream.finish(manifestComparison); err != nil {
+			logger.Error("unable to stream results", "error", err)
+		}
+	}
+	if rotation != nil && !result.Partial {
+		if err := rotation.advance(rotationId, config.Local.Shard); err != nil {
+			logger.Error("unable to save rotation state", "error", err)
+		}
 	}
-	if localErr != nil {
-		panic(localErr)
+	if !opts.Quiet {
+		fmt.Printf("\nGenerated manifests for %d remote files, %d local files, with %d local errors\n\n", stats.RemoteFiles, stats.LocalFiles, len(manifestComparison.Errored))
+		fmt.Println("")
 	}
 
-	fmt.Println("")
+	if !opts.SkipGooglePhotos && remoteRoot == "/" && remoteId == "" && !opts.Quiet {
+		if count := manifestComparison.googlePhotosOnlyRemote(); count > 0 {
+			fmt.Printf("%d files in the Google Photos folder are only in remote. If that's the legacy Google Photos folder and it isn't synced, use --skip-google-photos.\n\n", count)
+		}
+	}
 
-	manifestComparison := compareManifests(driveManifest, localManifest, errored, opts.Synology)
-	manifestComparison.PrintResults()
+	if result.Listing != nil && !opts.Quiet {
+		printSharingNotes(result.Listing)
+	}
 
-	if opts.SelectiveSync {
+	if result.RecheckErr != nil {
+		logger.Warn("unable to re-check remote folders", "error", result.RecheckErr)
+	} else if result.Recheck != nil && result.Recheck.Folders > 0 && !opts.Quiet {
+		fmt.Printf("Re-checked %d remote folders with discrepancies, %d resolved\n\n", result.Recheck.Folders, result.Recheck.Resolved)
+	}
+	if result.TrashErr != nil {
+		logger.Warn("unable to look up files in trash", "error", result.TrashErr)
+	}
+	if result.SpotCheckErr != nil {
+		logger.Warn("unable to spot check files", "error", result.SpotCheckErr)
+	}
+	if result.SpotCheck != nil && !opts.Quiet {
+		fmt.Printf("Spot checked %d matched files by downloading them: %d differ, %d couldn't be checked\n\n", result.SpotCheck.Checked, len(manifestComparison.SpotCheckFailed), result.SpotCheck.Errors)
+	}
+	if result.RevisionsErr != nil {
+		logger.Warn("unable to look up remote revisions", "error", result.RevisionsErr)
+	}
+
+	var previous *previousRuns
+	previousKey := fmt.Sprintf("%s|%s", remoteLabel, localRoot)
+	if config.Local.Shard != nil {
+		previousKey += "|" + config.Local.Shard.String()
+	}
+	var diff *runDiff
+	if opts.DiffPrevious {
+		previous, err = loadPreviousRuns(filepath.Join(configDir, "previous.json"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if problems, ok := previous.Problems[previousKey]; ok {
+			diff = diffPrevious(problems, manifestComparison)
+		} else if !opts.Quiet {
+			fmt.Println("No previous run recorded; listing all problems.")
+		}
+	}
+
+	// partial scans are expected to be short
+	var sanityProblems []string
+	if !result.Partial {
+		sanityProblems = sanity.problems(manifestComparison)
+	}
+
+	if opts.TUI {
+		// already reviewed interactively
+		manifestComparison.PrintSummary()
+	} else if opts.Quiet {
+		manifestComparison.PrintStatus()
+		if len(sanityProblems) > 0 {
+			printSanityProblems(sanityProblems)
+		}
+		manifestComparison.PrintSummary()
+	} else if len(sanityProblems) > 0 && !opts.Verbose {
+		// skip the full listings, which would mostly be the missing side
+		printSanityProblems(sanityProblems)
+		manifestComparison.PrintSummary()
+	} else if diff != nil {
+		diff.Print()
+		manifestComparison.PrintSummary()
+	} else if manifestComparison.IsSuspect() && !opts.Verbose {
+		// skip the full listings, which are almost certainly noise
+		manifestComparison.PrintSuspectWarning()
+		manifestComparison.PrintSummary()
+	} else {
+		manifestComparison.PrintResults(opts.Verbose)
+		if manifestComparison.IsSuspect() {
+			manifestComparison.PrintSuspectWarning()
+		}
+	}
+	if len(sanityProblems) > 0 && (opts.TUI || opts.Verbose) {
+		printSanityProblems(sanityProblems)
+	}
+	if opts.TopProblems > 0 {
+		fmt.Println("")
+		manifestComparison.PrintTopProblems(opts.TopProblems)
+	}
+	if opts.FileTypes {
+		fmt.Println("")
+		manifestComparison.PrintFileTypes()
+	}
+
+	explainer.Print()
+
+	// a suspect run would record nearly everything as a problem
+	if previous != nil && !manifestComparison.IsSuspect() && !result.Partial && len(sanityProblems) == 0 {
+		if err := previous.record(previousKey, manifestComparison); err != nil {
+			logger.Error("unable to save problems for --diff-previous", "error", err)
+		}
+	}
+
+	if !opts.Quiet {
+		fmt.Println("")
+		stats.Print()
+	}
+
+	if opts.CSVPath != "" {
+		if err := manifestComparison.WriteCSVFile(opts.CSVPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write CSV results: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote per-file results to %s\n", opts.CSVPath)
+	}
+
+	if opts.JSONPath != "" {
+		if err := manifestComparison.WriteJSONFile(opts.JSONPath, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write JSON results: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote JSON results to %s\n", opts.JSONPath)
+	}
+
+	if opts.HTMLPath != "" {
+		if err := manifestComparison.WriteHTMLFile(opts.HTMLPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write HTML report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote HTML report to %s\n", opts.HTMLPath)
+	}
+
+	if opts.EmptyLocalList != "" {
+		if err := manifestComparison.WriteEmptyLocalList(opts.EmptyLocalList); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write empty file list: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote %d empty local files to re-download to %s\n", len(manifestComparison.EmptyLocal), opts.EmptyLocalList)
+	}
+
+	if selection.enabled() && !opts.Quiet {
 		fmt.Println("Subfolders verified:")
 		for _, f := range localDirs {
 			fmt.Println(f)
 		}
+		fmt.Printf("\nRemote folders not selected (not verified): %d\n", len(result.NotSelected))
+		for _, f := range result.NotSelected {
+			fmt.Println(f)
+		}
+	}
+
+	if opts.BadgePath != "" {
+		if err := manifestComparison.WriteBadgeFile(opts.BadgePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write badge: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.MetricsFile != "" || metrics != nil {
+		snapshot := newMetricsSnapshot(manifestComparison, stats, readLastSuccess(opts.MetricsFile))
+		if metrics != nil {
+			metrics.update(snapshot)
+		}
+		if opts.MetricsFile != "" {
+			if err := writeMetricsFile(opts.MetricsFile, snapshot); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to write metrics: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if notify.enabled() && notify.shouldNotify(manifestComparison, diff) {
+		if err := notify.send(newNotification(manifestComparison, diff, remoteLabel, localRoot)); err != nil {
+			logger.Error("unable to send notification", "error", err)
+		}
+	}
+
+	if result.Partial {
+		if result.SavedCheckpoint {
+			fmt.Printf("\nSaved the local scan so far to %s. Run again with --resume to skip hashing files that haven't changed.\n", config.Checkpoint)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			os.Exit(exitTimedOut)
+		}
+		os.Exit(exitCancelled)
+	}
+
+	if len(sanityProblems) > 0 {
+		os.Exit(exitSanityCheck)
+	}
+
+	if opts.Watch {
+		if err := runWatch(config, result.Listing, changesToken, manifestComparison, opts.WatchInterval); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
 	}
 
+	if manifestComparison.IsSuspect() {
+		os.Exit(exitConfigSuspect)
+	}
+	if opts.GradeExit {
+		os.Exit(manifestComparison.gradeExitCode())
+	}
 	if !manifestComparison.IsSuccessful() {
-		os.Exit(1)
+		os.Exit(exitSyncFailure)
 	}
 }
 
@@ -248,23 +1168,27 @@ func timedManualGC(freeMemoryInterval int, verbose bool) {
 	}
 }
 
+// defaultRemoteRoot guesses the remote root from the local root's position
+// under a Google Drive folder, e.g. ~/Google Drive (Backup and Sync) or
+// G:\My Drive (Drive for Desktop)
 func defaultRemoteRoot(localRoot string) string {
 	relPath := ""
 	for {
 		base := filepath.Base(localRoot)
 		dir := filepath.Dir(localRoot)
-		if base == "Google Drive" || base == "GoogleDrive" {
+		if base == "Google Drive" || base == "GoogleDrive" || base == "My Drive" {
 			return "/" + relPath
-		} else if dir == "/" {
+		} else if dir == localRoot || dir == "/" {
 			return "/"
 		} else {
-			relPath = filepath.Join(base, relPath)
+			relPath = path.Join(base, relPath)
 			localRoot = dir
 		}
 	}
 }
 
-func listFolders(localRoot string) (folders []string, err error) {
+// listFolders returns the folders exactly depth levels below localRoot
+func listFolders(localRoot string, depth int) (folders []string, err error) {
 	root, err := filepath.Abs(localRoot)
 	if err != nil {
 		return
@@ -274,28 +1198,149 @@ func listFolders(localRoot string) (folders []string, err error) {
 		return
 	}
 	for _, f := range files {
-		if f.IsDir() && !skipLocalDir(f.Name()) {
+		if !f.IsDir() || skipLocalDir(f.Name(), nil) {
+			continue
+		}
+		if depth <= 1 {
 			folders = append(folders, f.Name())
+			continue
+		}
+		subfolders, err := listFolders(filepath.Join(root, f.Name()), depth-1)
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range subfolders {
+			folders = append(folders, path.Join(f.Name(), sub))
 		}
 	}
 
 	return
 }
 
-func getLocalManifest(progressChan chan<- *scanProgressUpdate, localRoot string, localDirs []string, skipContentHash bool, workerCount int) (manifest *FileHeap, errored []*FileError, err error) {
-	contentHash := !skipContentHash
+// localScanOptions controls how the local directory is scanned and hashed
+type localScanOptions struct {
+	SkipContentHash bool
+	// SkipPlaceholders avoids hashing (and so downloading) cloud-only
+	// placeholder files
+	SkipPlaceholders bool
+	// RecordFolders collects the local directories, for --check-folders
+	RecordFolders bool
+	// CheckXattrs records extended attributes that Google Drive won't keep
+	CheckXattrs bool
+	// ListIgnored records the paths of files and folders skipped by ignore
+	// rules, not just their number
+	ListIgnored bool
+	// RecordFullPaths keeps each file's path on disk in File.FullPath
+	RecordFullPaths bool
+	WorkerCount     int
+	// HashTimeout abandons hashing a file that makes no progress for this long
+	HashTimeout    time.Duration
+	FollowSymlinks bool
+	// HashAlgo is also used to pick the remote checksum to compare against
+	HashAlgo hashAlgorithm
+	Spill    spillConfig
+	// IOConcurrency limits how many workers read files at once (0 for no
+	// limit beyond WorkerCount)
+	IOConcurrency int
+	// ioSlots is a semaphore enforcing IOConcurrency during a scan
+	ioSlots chan struct{}
+	// ReadLimiter caps the total rate of reads for hashing (nil for no limit)
+	ReadLimiter *byteRateLimiter
+	// Shard limits the scan to one partition of the tree (nil for everything)
+	Shard *shardFilter
+	// Sample limits both scans to a random sample of files (nil for
+	// everything)
+	Sample *sampleFilter
+	// Filter limits both scans by size and modification time (nil for
+	// everything)
+	Filter *fileFilter
+	// PathRules rewrite paths on both sides before comparing
+	PathRules *pathRules
+	// Strategies decide how files are compared, by path (nil to hash
+	// everything)
+	Strategies *compareStrategies
+	// ReadRetries is how many times files that couldn't be read are tried
+	// again at the end of the scan, ReadRetryDelay apart
+	ReadRetries    int
+	ReadRetryDelay time.Duration
+	// save records each scanned file as it's found (nil to not save)
+	save *manifestWriter
+	// resume holds files hashed by a cancelled run, by relative path; their
+	// hashes are reused if the size and modification time are unchanged
+	resume map[string]*File
+	// cache saves each scanned file with its xxHash for the next run, whose
+	// hashes are reused from cached if the xxHash is unchanged (nil for no
+	// hash cache)
+	cache  *hashCacheFile
+	cached map[string]*File
+	// WalkConcurrency is how many directories are listed at once
+	WalkConcurrency int
+	// DetectClones reuses hashes between copy-on-write clones
+	DetectClones bool
+	// shared shares hashes between hard links to the same file (and clones)
+	// during a scan
+	shared *sharedContents
+	// remoteHashes skips hashing files whose remote copy has no checksum
+	// (nil to hash regardless)
+	remoteHashes *remoteHashes
+}
+
+// localScanResult holds everything found while scanning the local directory
+type localScanResult struct {
+	Manifest        *sortedManifest
+	Errored         []*FileError
+	SkippedSymlinks []*SkippedSymlink
+	// Recovered holds files that were read on retry, with their original
+	// errors
+	Recovered []*FileError
+	// Xattrs holds files with extended attributes, with --check-xattrs
+	Xattrs []*File
+	// Ignored counts the files and folders skipped by ignore rules
+	Ignored     *ignoredLocal
+	BytesHashed int64
+	// HardLinks and Clones count files that were hard links to or clones of
+	// ones already scanned
+	HardLinks int
+	Clones    int
+	// Complete is false if the scan was cancelled before the walk finished
+	Complete bool
+	// Folders holds the normalized paths of the local directories, with
+	// localScanOptions.RecordFolders
+	Folders []string
+}
+
+func getLocalManifest(ctx context.Context, progress *scanProgress, localRoot string, localDirs []string, opts localScanOptions) (scan *localScanResult, err error) {
 	localRootLowercase := strings.ToLower(localRoot)
-	manifest = &FileHeap{}
-	heap.Init(manifest)
-	processChan := make(chan string)
+	manifest := newSortedManifest(opts.Spill)
+	if opts.IOConcurrency > 0 {
+		opts.ioSlots = make(chan struct{}, opts.IOConcurrency)
+	}
+	opts.shared = newSharedContents(opts.DetectClones)
+	var spillErr, saveErr error
+	hardLinked, clones := 0, 0
+	var errored []*FileError
+	var bytesHashed int64
+	processChan := make(chan *localEntry)
 	resultChan := make(chan *File)
 	errorChan := make(chan *FileError)
 	var wg sync.WaitGroup
 
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < opts.WorkerCount; i++ {
 		// spin up workers
 		wg.Add(1)
-		go handleLocalFile(localRootLowercase, contentHash, processChan, resultChan, errorChan, &wg)
+		go handleLocalFile(ctx, localRootLowercase, opts, processChan, resultChan, errorChan, &wg)
+	}
+
+	walker := &localWalker{
+		root:           localRoot,
+		followSymlinks: opts.FollowSymlinks,
+		rules:          opts.PathRules,
+		processChan:    processChan,
+		errorChan:      errorChan,
+		concurrency:    opts.WalkConcurrency,
+		ctx:            ctx,
+		recordDirs:     opts.RecordFolders,
+		listIgnored:    opts.ListIgnored,
 	}
 
 	// walk in separate goroutine so that sends to errorChan don't block
@@ -309,22 +1354,7 @@ func getLocalManifest(progressChan chan<- *scanProgressUpdate, localRoot string,
 			pathsToWalk = append(pathsToWalk, localRoot)
 		}
 		for _, path := range pathsToWalk {
-			filepath.Walk(path, func(entryPath string, info os.FileInfo, err error) error {
-				if err != nil {
-					errorChan <- &FileError{Path: entryPath, Error: err}
-					return nil
-				}
-
-				if info.Mode().IsDir() && skipLocalDir(entryPath) {
-					return filepath.SkipDir
-				}
-
-				if info.Mode().IsRegular() && !skipLocalFile(entryPath) {
-					processChan <- entryPath
-				}
-
-				return nil
-			})
+			walker.walk(path)
 		}
 
 		close(processChan)
@@ -337,12 +1367,41 @@ func getLocalManifest(progressChan chan<- *scanProgressUpdate, localRoot string,
 		close(errorChan)
 	}()
 
+	var xattrs []*File
+	addResult := func(result *File) {
+		if len(result.Xattrs) > 0 {
+			xattrs = append(xattrs, result)
+		}
+		if err := manifest.Add(result); err != nil && spillErr == nil {
+			spillErr = err
+		}
+		if opts.save != nil {
+			if err := opts.save.Write(result); err != nil && saveErr == nil {
+				saveErr = err
+			}
+		}
+		if opts.cache != nil {
+			if err := opts.cache.Write(result); err != nil && saveErr == nil {
+				saveErr = err
+			}
+		}
+		var hashed int64
+		if result.HardLink {
+			hardLinked++
+		} else if result.Clone {
+			clones++
+		} else if result.ContentHash != "" {
+			hashed = result.Size
+		}
+		bytesHashed += hashed
+		progress.addLocal(1, hashed)
+	}
+
 	for {
 		select {
 		case result, ok := <-resultChan:
 			if ok {
-				heap.Push(manifest, result)
-				progressChan <- &scanProgressUpdate{Type: localProgress, Count: manifest.Len()}
+				addResult(result)
 			} else {
 				resultChan = nil
 			}
@@ -350,7 +1409,7 @@ func getLocalManifest(progressChan chan<- *scanProgressUpdate, localRoot string,
 		case e, ok := <-errorChan:
 			if ok {
 				errored = append(errored, e)
-				progressChan <- &scanProgressUpdate{Type: errorProgress, Count: len(errored)}
+				progress.addLocalErrors(1)
 			} else {
 				errorChan = nil
 			}
@@ -361,52 +1420,236 @@ func getLocalManifest(progressChan chan<- *scanProgressUpdate, localRoot string,
 		}
 	}
 
-	return
+	var retried []*File
+	var recovered []*FileError
+	if ctx.Err() == nil {
+		retried, recovered, errored = retryLocalErrors(ctx, localRootLowercase, opts, errored)
+	}
+	for _, result := range retried {
+		addResult(result)
+	}
+	progress.addLocalErrors(-len(recovered))
+	for _, e := range errored {
+		logger.Warn("unable to read local file", "path", e.Path, "error", e.Error, "attempts", e.Attempts)
+		if opts.save != nil {
+			if err := opts.save.WriteError(e); err != nil && saveErr == nil {
+				saveErr = err
+			}
+		}
+	}
+
+	if spillErr != nil {
+		manifest.Close()
+		return nil, spillErr
+	}
+	if saveErr != nil {
+		manifest.Close()
+		return nil, fmt.Errorf("Unable to save local manifest: %v", saveErr)
+	}
+
+	// the walker is finished once all results are in, so this is safe to read
+	return &localScanResult{
+		Manifest:        manifest,
+		Errored:         errored,
+		SkippedSymlinks: walker.skippedSymlinks,
+		Recovered:       recovered,
+		Xattrs:          xattrs,
+		Ignored:         &walker.ignored,
+		BytesHashed:     bytesHashed,
+		HardLinks:       hardLinked,
+		Clones:          clones,
+		Complete:        !walker.cancelled,
+		Folders:         localFolderPaths(localRootLowercase, walker.dirs, opts.PathRules),
+	}, nil
 }
 
 // fill in args etc
-func handleLocalFile(localRootLowercase string, contentHash bool, processChan <-chan string, resultChan chan<- *File, errorChan chan<- *FileError, wg *sync.WaitGroup) {
-	for entryPath := range processChan {
-		relPath, err := relativePath(localRootLowercase, strings.ToLower(entryPath))
-		if err != nil {
-			errorChan <- &FileError{Path: entryPath, Error: err}
-			continue
+func handleLocalFile(ctx context.Context, localRootLowercase string, opts localScanOptions, processChan <-chan *localEntry, resultChan chan<- *File, errorChan chan<- *FileError, wg *sync.WaitGroup) {
+	for entry := range processChan {
+		file, fileErr := processLocalFile(ctx, localRootLowercase, opts, entry)
+		if fileErr != nil {
+			errorChan <- fileErr
+		} else if file != nil {
+			resultChan <- file
 		}
-		relPath = normalizeUnicodeCharacters(relPath)
-		filteredPath := filterLocalPath(relPath)
-		originalPath := ""
-		if relPath != filteredPath {
-			originalPath = relPath
+	}
+	wg.Done()
+}
+
+// processLocalFile builds the manifest entry for a single local file, or
+// returns nil if the file is outside the shard being verified or ctx was
+// cancelled while hashing it
+func processLocalFile(ctx context.Context, localRootLowercase string, opts localScanOptions, entry *localEntry) (*File, *FileError) {
+	entryPath := entry.Path
+	relPath, filteredPath, err := localManifestPath(localRootLowercase, entryPath, opts.PathRules)
+	if err != nil {
+		return nil, &FileError{Path: entryPath, Error: categorized(err)}
+	}
+	explain := explainer.wants(relPath, filteredPath)
+	if explain {
+		explainer.note("local: %q normalized to %q, filtered to %q", entryPath, relPath, filteredPath)
+	}
+	if !opts.Shard.includes(filteredPath) {
+		logger.Debug("skipped local file", "path", entryPath, "reason", "outside shard")
+		if explain {
+			explainer.note("local: skipped, outside the shard being verified")
+		}
+		return nil, nil
+	}
+	if !opts.Sample.includes(filteredPath) {
+		logger.Debug("skipped local file", "path", entryPath, "reason", "not sampled")
+		if explain {
+			explainer.note("local: skipped, not in the sample being verified")
+		}
+		return nil, nil
+	}
+	if !opts.Filter.includes(entry.Info.Size(), entry.Info.ModTime()) {
+		logger.Debug("skipped local file", "path", entryPath, "reason", "outside size or modification time filter")
+		if explain {
+			explainer.note("local: skipped, outside the size or modification time filter")
+		}
+		return nil, nil
+	}
+	if opts.Strategies.skips(filteredPath) {
+		logger.Debug("skipped local file", "path", entryPath, "reason", "comparison strategy is skip")
+		if explain {
+			explainer.note("local: skipped, comparison strategy is skip")
+		}
+		return nil, nil
+	}
+	originalPath := ""
+	if relPath != filteredPath {
+		originalPath = relPath
+	}
+
+	placeholder := isCloudPlaceholder(entry.Info)
+	if placeholder {
+		logger.Debug("found cloud-only placeholder", "path", entryPath)
+		if explain {
+			explainer.note("local: cloud-only placeholder")
 		}
+	}
 
-		hash := ""
-		if contentHash {
-			hash, err = hashLocalFile(entryPath)
-			if err != nil {
+	hash := ""
+	fastHash := ""
+	hashing := !opts.SkipContentHash && !(placeholder && opts.SkipPlaceholders) && opts.Strategies.hashes(filteredPath)
+	if hashing && !opts.remoteHashes.needed(ctx, filteredPath) {
+		hashing = false
+		logger.Debug("not hashing local file", "path", entryPath, "reason", "remote file has no checksum")
+		if explain {
+			explainer.note("local: not hashed, the remote file has no checksum")
+		}
+	}
+	var shared *sharedFile
+	first := false
+	if opts.shared != nil {
+		if shared, first = opts.shared.claim(entryPath, entry.Info, hashing); first {
+			// other paths waiting for this one's hash hash themselves if
+			// it's left empty
+			defer func() { shared.finish(hash, fastHash) }()
+		}
+	}
+	if previous, ok := opts.resume[relPath]; ok && !opts.SkipContentHash && previous.ContentHash != "" && previous.Size == entry.Info.Size() && previous.ModifiedTime.Equal(entry.Info.ModTime()) {
+		hash = previous.ContentHash
+		fastHash = previous.FastHash
+		if explain {
+			explainer.note("local: reused %s from checkpoint", opts.HashAlgo)
+		}
+	} else if hashing {
+		if shared != nil && !first {
+			hash, fastHash = shared.wait(ctx)
+		}
+		if hash != "" {
+			if explain && shared.clone {
+				explainer.note("local: reused %s from a clone sharing the same storage", opts.HashAlgo)
+			} else if explain {
+				explainer.note("local: reused %s from another hard link to the same file", opts.HashAlgo)
+			}
+		} else {
+			if opts.ioSlots != nil {
+				opts.ioSlots <- struct{}{}
+			}
+			if opts.cache != nil {
+				var reused bool
+				hash, fastHash, reused, err = hashLocalFileCached(ctx, entryPath, entry.Info.Size(), opts.cached[relPath], opts)
+				if reused && explain {
+					explainer.note("local: reused %s from hash cache, xxHash unchanged", opts.HashAlgo)
+				}
+			} else {
+				hash, err = hashLocalFileWithTimeout(ctx, entryPath, opts.HashTimeout, opts.HashAlgo, opts.ReadLimiter)
+			}
+			if opts.ioSlots != nil {
+				// a timed out read may still be running, but it's given up on
+				<-opts.ioSlots
+			}
+			if err != nil && ctx.Err() != nil {
+				logger.Debug("skipped local file", "path", entryPath, "reason", "cancelled while hashing")
+				return nil, nil
+			} else if err != nil {
 				// use relPath here because the error relates to the local file
-				errorChan <- &FileError{Path: relPath, Error: err}
-				continue
+				if explain {
+					explainer.note("local: unable to hash: %v", err)
+				}
+				return nil, &FileError{Path: relPath, Error: categorized(err), Attempts: 1, entry: entry}
 			}
 		}
-
-		resultChan <- &File{
-			Path:         filteredPath,
-			OriginalPath: originalPath,
-			ContentHash:  hash,
+	}
+	if explain {
+		explainer.note("local: %s %s, %d bytes", opts.HashAlgo, hash, entry.Info.Size())
+	}
+	fullPath := ""
+	if opts.RecordFullPaths {
+		fullPath = entryPath
+	}
+	var xattrs []fileXattr
+	if opts.CheckXattrs {
+		if xattrs, err = listXattrs(entryPath); err != nil {
+			// the contents were read, so don't report the file as errored
+			logger.Warn("unable to read extended attributes", "path", entryPath, "error", err)
 		}
 	}
-	wg.Done()
+
+	return &File{
+		Path:         filteredPath,
+		OriginalPath: originalPath,
+		ContentHash:  hash,
+		FastHash:     fastHash,
+		Size:         entry.Info.Size(),
+		ModifiedTime: entry.Info.ModTime(),
+		Placeholder:  placeholder,
+		Xattrs:       xattrs,
+		FullPath:     fullPath,
+		HardLink:     shared != nil && !first && !shared.clone,
+		Clone:        shared != nil && !first && shared.clone,
+	}, nil
+}
+
+// localManifestPath returns the normalized relative path of a local file, and
+// the filtered path it's compared by
+func localManifestPath(localRootLowercase string, entryPath string, rules *pathRules) (relPath string, filteredPath string, err error) {
+	relPath, err = relativePath(localRootLowercase, strings.ToLower(entryPath))
+	if err != nil {
+		return "", "", err
+	}
+	relPath = normalizeUnicodeCharacters(relPath)
+	return relPath, rules.local(relPath), nil
 }
 
-func hashLocalFile(path string) (string, error) {
+func hashLocalFile(ctx context.Context, path string, algo hashAlgorithm, limiter *byteRateLimiter) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
+	return hashContents(&contextReader{ctx: ctx, r: throttle(f, limiter)}, algo)
+}
+
+func hashContents(r io.Reader, algo hashAlgorithm) (string, error) {
+	h := algo.newHash()
+	buf := hashBuffers.get()
+	defer hashBuffers.put(buf)
+	if _, err := io.CopyBuffer(h, r, *buf); err != nil {
 		return "", err
 	}
 
@@ -418,7 +1661,7 @@ func relativePath(root string, entryPath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if len(relPath) >= 3 && relPath[0:3] == "../" {
+	if strings.HasPrefix(filepath.ToSlash(relPath), "../") {
 		// try lowercase root instead
 		relPath, err = filepath.Rel(strings.ToLower(root), entryPath)
 		if err != nil {
@@ -426,7 +1669,8 @@ func relativePath(root string, entryPath string) (string, error) {
 		}
 	}
 
-	return relPath, nil
+	// manifest paths always use forward slashes, like Drive paths
+	return filepath.ToSlash(relPath), nil
 }
 
 // Normalize Unicode combining characters
@@ -434,20 +1678,7 @@ func normalizeUnicodeCharacters(entryPath string) string {
 	return norm.NFC.String(entryPath)
 }
 
-func filterLocalPath(entryPath string) string {
-	filtered := entryPath
-	filtered = localConflictMarkerRegexp.ReplaceAllString(filtered, "$1")
-	return filtered
-}
-
-func filterRemotePath(entryPath string, synologyMode bool) string {
-	if synologyMode {
-		return trailingSpaceRegexp.ReplaceAllString(entryPath, "/")
-	}
-	return entryPath
-}
-
-func skipLocalFile(path string) bool {
+func skipLocalFile(path string, rules *pathRules) bool {
 	base := filepath.Base(path)
 	for _, ignoredFile := range ignoredFiles {
 		if base == ignoredFile {
@@ -455,24 +1686,17 @@ func skipLocalFile(path string) bool {
 		}
 	}
 
-	ext := filepath.Ext(path)
-	for _, ignoredExt := range ignoredExtensions {
-		if ext == ignoredExt {
-			return true
-		}
-	}
-
-	return false
+	return rules.ignoresExtension(filepath.Ext(path))
 }
 
-func skipLocalDir(path string) bool {
+func skipLocalDir(path string, rules *pathRules) bool {
 	base := filepath.Base(path)
 	for _, ignore := range ignoredDirectories {
 		if base == ignore {
 			return true
 		}
 	}
-	return false
+	return rules.ignoresDirectory(base)
 }
 
 func skipRemoteFile(path string) bool {
@@ -486,32 +1710,114 @@ func skipRemoteFile(path string) bool {
 	return false
 }
 
-func getGoogleDriveManifest(progressChan chan<- *scanProgressUpdate, srv *drive.Service, rootPath string, subdirectories []string, synologyMode bool) (manifest *FileHeap, err error) {
-	manifest = &FileHeap{}
-	heap.Init(manifest)
-
-	listing := NewDriveListing(srv, rootPath, subdirectories)
-	updateChan := make(chan int)
-	go func() {
-		for updateCount := range updateChan {
-			progressChan <- &scanProgressUpdate{Type: remoteProgress, Count: updateCount}
+// getRemoteManifest lists the remote root with provider, saving the listing
+// if configured, and returns the files to compare. Each file is added to the
+// manifest as it's listed, so the listing is never held in memory beyond the
+// manifest's spill threshold.
+func getRemoteManifest(ctx context.Context, progress *scanProgress, provider RemoteProvider, config *verifyConfig) (manifest *sortedManifest, err error) {
+	// the listing is saved as it's listed, before the path rules, sample and
+	// filters, so it can be reused with different settings
+	var saved, cached *remoteManifestFile
+	if config.SaveRemoteManifest != "" {
+		if saved, err = createRemoteManifestFile(config.SaveRemoteManifest, config); err != nil {
+			return nil, fmt.Errorf("Unable to save remote manifest: %v", err)
 		}
-	}()
-	files, err := listing.Files(updateChan)
-	if err != nil {
-		return
 	}
-	for _, file := range files {
-		if skipRemoteFile(file.Path) {
-			continue
+	// only Google Drive listings are cached
+	if _, ok := provider.(*driveProvider); ok && config.RemoteCache != nil {
+		if cached, err = config.RemoteCache.create(config); err != nil {
+			logger.Warn("unable to save remote cache", "error", err)
+			cached, err = nil, nil
+		}
+	}
+	defer func() {
+		// a later --load-remote-manifest would take a partial listing as complete
+		if saved != nil && (err != nil || ctx.Err() != nil) {
+			saved.Discard()
+			if err == nil {
+				logger.Warn("not saving the remote manifest of a cancelled listing", "path", config.SaveRemoteManifest)
+			}
+		} else if saved != nil {
+			if err = saved.Commit(); err != nil {
+				manifest.Close()
+				manifest, err = nil, fmt.Errorf("Unable to save remote manifest: %v", err)
+			}
+		}
+		if cached != nil && (err != nil || ctx.Err() != nil) {
+			cached.Discard()
+		} else if cached != nil {
+			if err := cached.Commit(); err != nil {
+				logger.Warn("unable to save remote cache", "error", err)
+			}
+		}
+	}()
+
+	manifest = newSortedManifest(config.Local.Spill)
+	listed := 0
+	var addErr error
+	err = provider.List(ctx, config.RemoteRoot, func(file *File) error {
+		listed++
+		progress.setRemoteListed(listed)
+		if saved != nil {
+			if err := saved.Write(file); err != nil {
+				addErr = fmt.Errorf("Unable to save remote manifest: %v", err)
+				return addErr
+			}
 		}
-		originalPath := file.Path
-		file.Path = filterRemotePath(file.Path, synologyMode)
-		if file.Path != originalPath {
-			file.OriginalPath = originalPath
+		if cached != nil {
+			if err := cached.Write(file); err != nil {
+				logger.Warn("unable to save remote cache", "error", err)
+				cached.Discard()
+				cached = nil
+			}
 		}
-		heap.Push(manifest, file)
+		addErr = addRemoteFile(manifest, file, config)
+		return addErr
+	})
+	if addErr != nil {
+		err = addErr
+	} else if err != nil {
+		err = fmt.Errorf("Unable to list %s: %w", provider.Name(), err)
+	}
+	if err != nil {
+		manifest.Close()
+		return nil, err
 	}
+	progress.finishRemote(manifest.Len())
 
 	return manifest, nil
 }
+
+// addRemoteFile adds a listed file to manifest if it's to be compared
+func addRemoteFile(manifest *sortedManifest, file *File, config *verifyConfig) error {
+	if !config.includesRemote(file) {
+		return nil
+	}
+	if err := manifest.Add(file); err != nil {
+		return err
+	}
+	config.Local.remoteHashes.add(file)
+	return nil
+}
+
+// prepareRemoteFile applies remote path filtering to a listed file, returning
+// false if the file should be skipped entirely
+func prepareRemoteFile(file *File, rules *pathRules) bool {
+	explain := explainer.wants(file.RawPath, file.Path)
+	if skipRemoteFile(file.Path) {
+		logger.Debug("skipped remote file", "path", file.Path, "reason", "ignored file name")
+		if explain {
+			explainer.note("remote: skipped, ignored file name")
+		}
+		return false
+	}
+	originalPath := file.Path
+	file.Path = rules.remote(file.Path)
+	if file.Path != originalPath {
+		file.OriginalPath = originalPath
+	}
+	if explain {
+		explainer.note("remote: %q normalized to %q, filtered to %q", file.RawPath, originalPath, file.Path)
+	}
+	return true
+}
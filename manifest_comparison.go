@@ -2,39 +2,258 @@ package main
 
 import (
 	"fmt"
-	"path/filepath"
-	"regexp"
+	"os"
 	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // ManifestComparison records the relative paths that differ between remote and
 // local versions of a directory
 type ManifestComparison struct {
-	OnlyRemote      []*File
-	OnlyLocal       []*File
-	ContentMismatch []string
+	OnlyRemote []*File
+	OnlyLocal  []*File
+	// OnlyRemoteFolders and OnlyLocalFolders hold empty folders only on one
+	// side, with --check-folders; they count towards Misses
+	OnlyRemoteFolders []*File
+	OnlyLocalFolders  []*File
+	ContentMismatch   []*FilePair
+	// EmptyLocal holds mismatches where the local file is empty but the
+	// remote one isn't, usually a placeholder left by a failed download
+	EmptyLocal      []*FilePair
 	PossibleMatches []*PossibleMatch
-	KnownSyncIssues []string
-	Errored         []*FileError
-	Matches         int
-	Misses          int
+	KnownSyncIssues []*File
+	// NormalizationCollisions holds remote files left over from a set whose
+	// names differ only by Unicode normalization (or case), which collapse to a
+	// single local path; they don't count towards Misses
+	NormalizationCollisions []*File
+	// DeletedRemotely holds local-only files found in Drive's trash or
+	// orphaned; they still count towards Misses
+	DeletedRemotely []*DeletedFile
+	// Trashed lists files in Drive's trash from under the root; it's
+	// informational and doesn't count towards Misses
+	Trashed []*File
+	// Placeholders holds cloud-only local files that weren't hashed but match
+	// the remote size; they don't count towards Misses
+	Placeholders []*FilePair
+	// HashUnavailable holds files present on both sides whose remote file
+	// has no checksum to compare; they don't count towards Misses
+	HashUnavailable []*FilePair
+	// SpotCheckFailed holds files whose checksums matched but whose
+	// downloaded contents didn't; they count towards Misses
+	SpotCheckFailed []*FilePair
+	// Xattrs holds local files with extended attributes Google Drive won't
+	// keep; it's informational and doesn't count towards Misses
+	Xattrs []*File
+	// LocalDuplicates holds groups of local files with the same contents,
+	// with ComparisonOptions.LocalDuplicates; it's informational and doesn't
+	// count towards Misses
+	LocalDuplicates []*duplicateGroup
+	// Acknowledged holds differences covered by a baseline, with their
+	// original status; they don't count towards Misses
+	Acknowledged []*FileResult
+	Errored      []*FileError
+	// Recovered holds local files that were read on retry; it's
+	// informational and doesn't count towards Misses
+	Recovered []*FileError
+	// SkippedSymlinks is informational and doesn't count towards Misses
+	SkippedSymlinks []*SkippedSymlink
+	// IgnoredLocal counts local files and folders skipped by ignore rules;
+	// it's informational and doesn't count towards Misses
+	IgnoredLocal *ignoredLocal
+	// GoogleNative counts remote Google-native files, which have no contents
+	// to compare; it's informational and doesn't count towards Misses
+	GoogleNative *googleNativeFiles
+	// Matched is only populated when ComparisonOptions.RecordMatches is set,
+	// to avoid holding every file in memory on large runs
+	Matched []*FilePair
+	Matches int
+	Misses  int
+	// MatchedBytes is the total size of matched files
+	MatchedBytes int64
+	// IgnoredOnlyLocal counts local-only files dropped by IgnoreOnlyLocal
+	IgnoredOnlyLocal int
+	// Partial is set if the scans were cancelled; Unverified counts files
+	// found on one side that the other side's scan may not have reached
+	Partial    bool
+	Unverified int
+	// RemoteIncomplete is set if the remote listing didn't finish, so files
+	// only found locally weren't necessarily missing remotely
+	RemoteIncomplete bool
+	// Stale counts content mismatches found to match an earlier revision
+	Stale int
+	// Sample extrapolates the results to every file, when only a sample was
+	// verified
+	Sample *sampleEstimate
+	// Number of entries in each manifest before comparison
+	RemoteCount int
+	LocalCount  int
+
+	recordMatches bool
+	onMatch       func(remote, local *File)
+	onMismatch    func(result *FileResult)
+	strategies    *compareStrategies
+	fileTypes     *fileTypeStats
+	grade         *gradeThresholds
+}
+
+// ComparisonOptions controls optional behavior of compareManifests
+type ComparisonOptions struct {
+	// PathRules decide which files are known sync issues and possible
+	// matches
+	PathRules     *pathRules
+	RecordMatches bool
+	// OnMatch, if set, is called with each match as it's found
+	OnMatch func(remote, local *File)
+	// OnMismatch, if set, is called with the result for each pair of files
+	// that don't match as it's found, with the status compareManifests gives
+	// it in the end
+	OnMismatch func(result *FileResult)
+	// Strategies decide how files sharing a path are compared (nil to
+	// compare content hashes)
+	Strategies *compareStrategies
+	// FileTypes counts results by extension and size for PrintFileTypes
+	FileTypes bool
+	// Grade sets the score thresholds for WARN and CRIT (nil for the
+	// defaults)
+	Grade *gradeThresholds
+	// LocalDuplicates finds local files with the same contents
+	LocalDuplicates bool
+	// DeferPossibleMatches leaves FindPossibleMatches to the caller, for
+	// --two-phase, where only-local files aren't hashed yet
+	DeferPossibleMatches bool
+}
+
+// FilePair records the remote and local versions of the same path
+type FilePair struct {
+	Remote *File
+	Local  *File
+	// Stale is set for a content mismatch whose local file matches an earlier
+	// remote revision, last modified at StaleRevision
+	Stale         bool
+	StaleRevision time.Time
+}
+
+// NewerSide tells which copy of a mismatched file was modified more recently
+type NewerSide string
+
+const (
+	// the local change hasn't been uploaded yet
+	LocalNewer NewerSide = "local-newer"
+	// the remote change hasn't been downloaded yet
+	RemoteNewer NewerSide = "remote-newer"
+	// modification times are too close to tell, or unknown
+	NewerAmbiguous NewerSide = "ambiguous"
+)
+
+// Modification times closer than this are considered equal, allowing for
+// filesystems with coarse timestamps (FAT has 2 second resolution)
+const modifiedTimeTolerance = 2 * time.Second
+
+// Newer compares the modification times of the pair
+func (p *FilePair) Newer() NewerSide {
+	local, remote := p.Local.ModifiedTime, p.Remote.ModifiedTime
+	if local.IsZero() || remote.IsZero() {
+		return NewerAmbiguous
+	}
+	diff := local.Sub(remote)
+	switch {
+	case diff > modifiedTimeTolerance:
+		return LocalNewer
+	case diff < -modifiedTimeTolerance:
+		return RemoteNewer
+	}
+	return NewerAmbiguous
+}
+
+func (n NewerSide) describe() string {
+	switch n {
+	case LocalNewer:
+		return "local (pending upload?)"
+	case RemoteNewer:
+		return "remote (pending download?)"
+	}
+	return "can't tell"
 }
 
+// PossibleMatch records a remote and local file that have identical contents
+// but paths that only match after applying naming transformations
 type PossibleMatch struct {
-	LocalPath  string
-	RemotePath string
+	Remote *File
+	Local  *File
 }
 
-var possibleDuplicateRegexp = regexp.MustCompile(` \(1\)(/|$)`)
+// FileStatus is the verification outcome category for a single path
+type FileStatus string
+
+const (
+	StatusMatch            FileStatus = "match"
+	StatusPossibleMatch    FileStatus = "possible-match"
+	StatusOnlyLocal        FileStatus = "only-local"
+	StatusOnlyRemote       FileStatus = "only-remote"
+	StatusOnlyRemoteFolder FileStatus = "only-remote-folder"
+	StatusOnlyLocalFolder  FileStatus = "only-local-folder"
+	StatusMismatch         FileStatus = "mismatch"
+	StatusEmptyLocal       FileStatus = "empty-local"
+	StatusPlaceholder      FileStatus = "placeholder"
+	StatusHashUnavailable  FileStatus = "hash-unavailable"
+	StatusAcknowledged     FileStatus = "acknowledged"
+	StatusXattrs           FileStatus = "xattrs"
+	StatusLocalDuplicate   FileStatus = "local-duplicate"
+	StatusSpotCheck        FileStatus = "spot-check-failed"
+	StatusKnownIssue       FileStatus = "known-issue"
+	StatusDeleted          FileStatus = "deleted-remotely"
+	StatusCollision        FileStatus = "normalization-collision"
+	StatusTrashed          FileStatus = "trashed"
+	StatusError            FileStatus = "error"
+)
+
+// FileResult is the verification outcome for a single path. Remote and Local
+// are nil when the file doesn't exist on that side.
+type FileResult struct {
+	Path   string
+	Status FileStatus
+	Remote *File
+	Local  *File
+	Error  error
+	// Stale marks a mismatch whose local file matches an earlier revision
+	Stale bool
+	// Newer is set for mismatches
+	Newer NewerSide
+	// OriginalError and Attempts are set for errors on files read more than
+	// once
+	OriginalError error
+	Attempts      int
+}
 
-func compareManifests(remoteManifest, localManifest *FileHeap, errored []*FileError, synologyMode bool) *ManifestComparison {
+func compareManifests(remoteManifest, localManifest manifestReader, errored []*FileError, opts ComparisonOptions) *ManifestComparison {
 	// 1. Pop a path off both remote and local manifests.
 	// 2. While remote & local are both not nil:
 	//    Compare remote & local:
 	//    a. If local is nil or local > remote, this file is only in remote. Record and pop remote again.
 	//    b. If remote is nil or local < remote, this file is only in local. Record and pop local again.
-	//    c. If local == remote, check for content mismatch. Record if necessary and pop both again.
-	comparison := &ManifestComparison{Errored: errored}
+	//    c. If local == remote, pop any further entries with the same path from both sides (e.g.
+	//       duplicate names in Google Drive), match them up by content, and record the rest.
+	comparison := &ManifestComparison{
+		Errored:     errored,
+		RemoteCount: remoteManifest.Len(),
+		LocalCount:  localManifest.Len(),
+
+		recordMatches: opts.RecordMatches,
+		onMatch:       opts.OnMatch,
+		onMismatch:    opts.OnMismatch,
+		strategies:    opts.Strategies,
+		grade:         opts.Grade,
+	}
+	if opts.FileTypes {
+		comparison.fileTypes = newFileTypeStats()
+	}
+	var duplicates *duplicateFinder
+	if opts.LocalDuplicates {
+		duplicates = newDuplicateFinder(localManifest)
+		localManifest = duplicates
+	}
 	local := localManifest.PopOrNil()
 	remote := remoteManifest.PopOrNil()
 	for local != nil || remote != nil {
@@ -56,23 +275,151 @@ func compareManifests(remoteManifest, localManifest *FileHeap, errored []*FileEr
 			local = localManifest.PopOrNil()
 		} else {
 			// this must mean that remote.Path == local.Path
-			if compareFileContents(remote, local) {
-				comparison.Matches++
-			} else {
-				comparison.ContentMismatch = append(comparison.ContentMismatch, local.Path)
-				comparison.Misses++
-			}
-			local = localManifest.PopOrNil()
-			remote = remoteManifest.PopOrNil()
+			var remotes, locals []*File
+			remotes, remote = popSamePath(remoteManifest, remote)
+			locals, local = popSamePath(localManifest, local)
+			comparison.compareSamePath(remotes, locals)
 		}
 	}
-	if synologyMode {
+	if opts.PathRules.knownSyncIssues() {
 		comparison.FindKnownSyncIssues()
 	}
-	comparison.FindPossibleMatches()
+	if !opts.DeferPossibleMatches {
+		comparison.FindPossibleMatches(opts.PathRules)
+	}
+	comparison.separateEmptyLocal()
+	comparison.separateHashUnavailable()
+	comparison.separatePlaceholders()
+	if duplicates != nil {
+		comparison.LocalDuplicates = duplicates.groups()
+	}
 	return comparison
 }
 
+// separateEmptyLocal moves content mismatches with an empty local file to
+// EmptyLocal; they still count towards Misses
+func (mc *ManifestComparison) separateEmptyLocal() {
+	var mismatched []*FilePair
+	for _, pair := range mc.ContentMismatch {
+		if isEmptyLocal(pair.Remote, pair.Local) {
+			mc.EmptyLocal = append(mc.EmptyLocal, pair)
+		} else {
+			mismatched = append(mismatched, pair)
+		}
+	}
+	mc.ContentMismatch = mismatched
+}
+
+// mismatchStatus is the status a content mismatch ends up with once
+// separateEmptyLocal, separateHashUnavailable and separatePlaceholders have
+// sorted it
+func mismatchStatus(pair *FilePair) FileStatus {
+	switch {
+	case isEmptyLocal(pair.Remote, pair.Local):
+		return StatusEmptyLocal
+	case isHashUnavailable(pair.Remote):
+		return StatusHashUnavailable
+	case isUnhashedPlaceholder(pair.Remote, pair.Local):
+		return StatusPlaceholder
+	}
+	return StatusMismatch
+}
+
+func isEmptyLocal(remote, local *File) bool {
+	return local.Size == 0 && remote.Size > 0
+}
+
+// popSamePath collects first and any following entries with the same path,
+// returning them along with the next entry with a different path
+func popSamePath(manifest manifestReader, first *File) (group []*File, next *File) {
+	group = []*File{first}
+	for {
+		next = manifest.PopOrNil()
+		if next == nil || next.Path != first.Path {
+			return
+		}
+		group = append(group, next)
+	}
+}
+
+// compareSamePath matches sets of remote and local files sharing a path.
+// Files are paired by content first, so a local file matching any one of
+// several same-named remote files counts as a match; leftovers are paired as
+// content mismatches, and anything still unpaired is only on one side.
+func (mc *ManifestComparison) compareSamePath(remotes, locals []*File) {
+	if explainer.wants(remotes[0].Path) {
+		explainer.noteCandidates(remotes, locals)
+	}
+	unmatchedRemotes := append([]*File{}, remotes...)
+	var unmatchedLocals []*File
+	for _, local := range locals {
+		matched := false
+		for i, remote := range unmatchedRemotes {
+			if mc.strategies.matches(remote, local) {
+				mc.Matches++
+				mc.MatchedBytes += remote.Size
+				if mc.recordMatches {
+					mc.Matched = append(mc.Matched, &FilePair{Remote: remote, Local: local})
+				}
+				if mc.onMatch != nil {
+					mc.onMatch(remote, local)
+				}
+				mc.fileTypes.match(remote)
+				unmatchedRemotes = deleteFromSlice(unmatchedRemotes, i)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatchedLocals = append(unmatchedLocals, local)
+		}
+	}
+
+	for len(unmatchedRemotes) > 0 && len(unmatchedLocals) > 0 {
+		pair := &FilePair{Remote: unmatchedRemotes[0], Local: unmatchedLocals[0]}
+		mc.ContentMismatch = append(mc.ContentMismatch, pair)
+		mc.Misses++
+		if mc.onMismatch != nil {
+			result := &FileResult{Path: pair.Local.Path, Status: mismatchStatus(pair), Remote: pair.Remote, Local: pair.Local}
+			if result.Status == StatusMismatch {
+				result.Newer = pair.Newer()
+			}
+			mc.onMismatch(result)
+		}
+		unmatchedRemotes = unmatchedRemotes[1:]
+		unmatchedLocals = unmatchedLocals[1:]
+	}
+	collision := hasNormalizationCollision(remotes)
+	for _, remote := range unmatchedRemotes {
+		if collision {
+			mc.NormalizationCollisions = append(mc.NormalizationCollisions, remote)
+			continue
+		}
+		mc.OnlyRemote = append(mc.OnlyRemote, remote)
+		mc.Misses++
+	}
+	for _, local := range unmatchedLocals {
+		mc.OnlyLocal = append(mc.OnlyLocal, local)
+		mc.Misses++
+	}
+}
+
+// hasNormalizationCollision reports whether files sharing a normalized path
+// have names that differ by more than case, i.e. by Unicode normalization
+// (e.g. an NFD name created on macOS next to an NFC one)
+func hasNormalizationCollision(files []*File) bool {
+	if len(files) < 2 {
+		return false
+	}
+	first := strings.ToLower(files[0].RawPath)
+	for _, file := range files[1:] {
+		if strings.ToLower(file.RawPath) != first {
+			return true
+		}
+	}
+	return false
+}
+
 func compareFileContents(remote, local *File) bool {
 	// if remote.ContentHash == "" || local.ContentHash == "" {
 	// 	// Missing content hash for one of the files, possibly intentionally,
@@ -80,19 +427,21 @@ func compareFileContents(remote, local *File) bool {
 	// 	// validate.
 	// 	return true
 	// }
-	return remote.ContentHash == local.ContentHash
+	// a remote file with no checksum is reported as such, even if the
+	// local file wasn't hashed either
+	return !isHashUnavailable(remote) && remote.ContentHash == local.ContentHash
 }
 
-func (mc *ManifestComparison) FindPossibleMatches() {
+func (mc *ManifestComparison) FindPossibleMatches(rules *pathRules) {
 	remoteMatchIndices := []int{}
 	for i, remoteFile := range mc.OnlyRemote {
 		for j, localFile := range mc.OnlyLocal {
-			if isPossibleMatch(remoteFile, localFile) {
+			if isPossibleMatch(remoteFile, localFile, rules) {
 				mc.PossibleMatches = append(
 					mc.PossibleMatches,
 					&PossibleMatch{
-						LocalPath:  localFile.Path,
-						RemotePath: remoteFile.Path,
+						Remote: remoteFile,
+						Local:  localFile,
 					},
 				)
 				// prepend index so highest gets deleted first
@@ -101,6 +450,7 @@ func (mc *ManifestComparison) FindPossibleMatches() {
 				// misses decreases by 2 because it counts entries in both OnlyLocal and OnlyRemote
 				mc.Misses -= 2
 				mc.Matches++
+				mc.MatchedBytes += remoteFile.Size
 				break
 			}
 		}
@@ -116,42 +466,25 @@ func deleteFromSlice(coll []*File, index int) []*File {
 	return coll[:len(coll)-1]
 }
 
-func isPossibleMatch(remoteFile, localFile *File) bool {
-	// Content hash must match
-	if remoteFile.ContentHash != localFile.ContentHash {
+func isPossibleMatch(remoteFile, localFile *File, rules *pathRules) bool {
+	// Content hash must match, and be known on both sides
+	if localFile.ContentHash == "" || isHashUnavailable(remoteFile) || remoteFile.ContentHash != localFile.ContentHash {
 		return false
 	}
-	// Try file path transformations to make paths match
-	localPath := localFile.Path
-	remotePath := remoteFile.Path
-
-	// 1. Strip file extensions
-	localPath = strings.TrimSuffix(localPath, filepath.Ext(localPath))
-	remotePath = strings.TrimSuffix(remotePath, filepath.Ext(remotePath))
-
-	// 2. Remote duplication markers
-	localPath = possibleDuplicateRegexp.ReplaceAllString(localPath, "$1")
-	remotePath = possibleDuplicateRegexp.ReplaceAllString(remotePath, "$1")
-
-	// 3. Special characters represented as underscores vs. spaces in files
-	// downloaded by new (2021) version of Google Drive app
-	localPath = strings.ReplaceAll(localPath, "_", " ")
-	remotePath = strings.ReplaceAll(remotePath, "_", " ")
-
-	// Test match
-	if localPath == remotePath {
-		return true
+	// Try the sync client's file path transformations to make paths match
+	if rules == nil || len(rules.PossibleMatches) == 0 {
+		return false
 	}
-	return false
+	return rules.possibleMatch(localFile.Path) == rules.possibleMatch(remoteFile.Path)
 }
 
-// Filter known sync issues on Synology
+// Filter known sync issues on Synology (paths with ":")
 func (mc *ManifestComparison) FindKnownSyncIssues() {
 	// iterate in reverse so we can delete safely
 	for i := len(mc.OnlyRemote) - 1; i >= 0; i-- {
 		file := mc.OnlyRemote[i]
 		if hasKnownSyncIssue(file.Path) {
-			mc.KnownSyncIssues = append([]string{file.Path}, mc.KnownSyncIssues...)
+			mc.KnownSyncIssues = append([]*File{file}, mc.KnownSyncIssues...)
 			mc.OnlyRemote = deleteFromSlice(mc.OnlyRemote, i)
 		}
 	}
@@ -161,26 +494,218 @@ func hasKnownSyncIssue(path string) bool {
 	return strings.Contains(path, ":")
 }
 
+// Results flattens the comparison into one FileResult per path. Matched files
+// are only included if they were recorded during comparison.
+func (mc *ManifestComparison) Results() []*FileResult {
+	var results []*FileResult
+	for _, pair := range mc.Matched {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusMatch, Remote: pair.Remote, Local: pair.Local})
+	}
+	for _, match := range mc.PossibleMatches {
+		results = append(results, &FileResult{Path: match.Local.Path, Status: StatusPossibleMatch, Remote: match.Remote, Local: match.Local})
+	}
+	for _, file := range mc.OnlyLocal {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusOnlyLocal, Local: file})
+	}
+	for _, file := range mc.OnlyRemote {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusOnlyRemote, Remote: file})
+	}
+	for _, folder := range mc.OnlyRemoteFolders {
+		results = append(results, &FileResult{Path: folder.Path, Status: StatusOnlyRemoteFolder})
+	}
+	for _, folder := range mc.OnlyLocalFolders {
+		results = append(results, &FileResult{Path: folder.Path, Status: StatusOnlyLocalFolder})
+	}
+	for _, pair := range mc.ContentMismatch {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusMismatch, Remote: pair.Remote, Local: pair.Local, Stale: pair.Stale, Newer: pair.Newer()})
+	}
+	for _, pair := range mc.EmptyLocal {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusEmptyLocal, Remote: pair.Remote, Local: pair.Local})
+	}
+	for _, pair := range mc.SpotCheckFailed {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusSpotCheck, Remote: pair.Remote, Local: pair.Local})
+	}
+	for _, pair := range mc.Placeholders {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusPlaceholder, Remote: pair.Remote, Local: pair.Local})
+	}
+	for _, pair := range mc.HashUnavailable {
+		results = append(results, &FileResult{Path: pair.Local.Path, Status: StatusHashUnavailable, Remote: pair.Remote, Local: pair.Local})
+	}
+	for _, file := range mc.KnownSyncIssues {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusKnownIssue, Remote: file})
+	}
+	for _, file := range mc.NormalizationCollisions {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusCollision, Remote: file})
+	}
+	for _, file := range mc.Trashed {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusTrashed, Remote: file})
+	}
+	for _, file := range mc.DeletedRemotely {
+		results = append(results, &FileResult{Path: file.Local.Path, Status: StatusDeleted, Remote: file.Remote, Local: file.Local})
+	}
+	for _, file := range mc.Xattrs {
+		results = append(results, &FileResult{Path: file.Path, Status: StatusXattrs, Local: file})
+	}
+	for _, group := range mc.LocalDuplicates {
+		for _, file := range group.Files {
+			results = append(results, &FileResult{Path: file.Path, Status: StatusLocalDuplicate, Local: file})
+		}
+	}
+	for _, ack := range mc.Acknowledged {
+		result := *ack
+		result.Status = StatusAcknowledged
+		results = append(results, &result)
+	}
+	for _, rec := range mc.Errored {
+		results = append(results, &FileResult{Path: rec.Path, Status: StatusError, Error: rec.Error, OriginalError: rec.OriginalError, Attempts: rec.Attempts})
+	}
+	return results
+}
+
+// IgnoreOnlyLocal drops files only found locally, for when the remote listing
+// was deliberately limited to a subset of files
+func (mc *ManifestComparison) IgnoreOnlyLocal() {
+	mc.IgnoredOnlyLocal += len(mc.OnlyLocal)
+	mc.Misses -= len(mc.OnlyLocal)
+	mc.OnlyLocal = nil
+}
+
 func (mc *ManifestComparison) IsSuccessful() bool {
-	return mc.Misses <= 0
+	return !mc.Partial && mc.Misses <= 0
+}
+
+// Minimum number of files on each side before zero matches is considered a
+// sign of misconfiguration rather than a real sync failure
+const suspectManifestSize = 1000
+
+// IsSuspect reports whether nothing matched despite both sides having plenty
+// of files, which usually means the roots or path normalization don't line up
+func (mc *ManifestComparison) IsSuspect() bool {
+	// partial scans needn't overlap at all
+	return !mc.Partial && mc.Matches == 0 && mc.RemoteCount >= suspectManifestSize && mc.LocalCount >= suspectManifestSize
+}
+
+func (mc *ManifestComparison) PrintSuspectWarning() {
+	fmt.Println("⚠️  WARNING: configuration suspect!")
+	fmt.Printf("No files matched, but there are %d remote files and %d local files.\n", mc.RemoteCount, mc.LocalCount)
+	fmt.Println("This usually isn't a real sync failure. Check that:")
+	fmt.Println("- the remote root (--remote) corresponds to the local root (--local)")
+	fmt.Println("- --selective is set if only some folders are synced locally")
+	fmt.Println("- file names aren't differing only by Unicode normalization or case")
+	fmt.Println("Run with --verbose to see the full file listings.")
+	fmt.Println("")
 }
 
-func (mc *ManifestComparison) PrintResults() {
+// PrintResults prints the status line, the file listings from most to least
+// severe, and the summary. Empty listings are left out unless verbose.
+func (mc *ManifestComparison) PrintResults(verbose bool) {
 	mc.PrintStatus()
-	printFileList(mc.OnlyRemote, "Files only in remote")
-	printFileList(mc.OnlyLocal, "Files only in local")
-	printStringList(mc.ContentMismatch, "Files whose contents don't match")
-	printPossibleMatchList(mc.PossibleMatches, "Possible matches")
-	printKnownSyncList(mc.KnownSyncIssues, "Known sync issues")
-	mc.PrintErrored()
+	show := func(count int) bool {
+		return verbose || count > 0
+	}
+
+	problems := len(mc.OnlyRemote) + len(mc.OnlyLocal) + len(mc.OnlyRemoteFolders) + len(mc.OnlyLocalFolders) + len(mc.ContentMismatch) + len(mc.EmptyLocal) + len(mc.SpotCheckFailed) + len(mc.DeletedRemotely) + len(mc.Errored) + len(mc.Recovered)
+	if show(problems) {
+		fmt.Print("PROBLEMS\n\n")
+	}
+	if show(len(mc.OnlyRemote)) {
+		printFileList(mc.OnlyRemote, "Files only in remote")
+	}
+	if show(len(mc.OnlyLocal)) {
+		printFileList(mc.OnlyLocal, "Files only in local")
+	}
+	if len(mc.OnlyRemoteFolders) > 0 {
+		printFileList(mc.OnlyRemoteFolders, "Empty folders only in remote")
+	}
+	if len(mc.OnlyLocalFolders) > 0 {
+		printFileList(mc.OnlyLocalFolders, "Empty folders only in local")
+	}
+	if show(len(mc.ContentMismatch)) {
+		printMismatchList(mc.ContentMismatch, "Files whose contents don't match")
+	}
+	if len(mc.EmptyLocal) > 0 {
+		printMismatchList(mc.EmptyLocal, "Empty local files (need re-downloading)")
+	}
+	if len(mc.SpotCheckFailed) > 0 {
+		printMismatchList(mc.SpotCheckFailed, "Checksums match but downloaded contents differ")
+	}
+	if len(mc.DeletedRemotely) > 0 {
+		printDeletedList(mc.DeletedRemotely, "Deleted remotely (in trash)")
+	}
+	if show(len(mc.Errored) + len(mc.Recovered)) {
+		mc.PrintErrored()
+	}
+
+	expected := len(mc.PossibleMatches) + len(mc.KnownSyncIssues) + len(mc.NormalizationCollisions) + len(mc.SkippedSymlinks)
+	if show(expected) {
+		fmt.Print("POSSIBLY EXPECTED\n\n")
+	}
+	if show(len(mc.PossibleMatches)) {
+		printPossibleMatchList(mc.PossibleMatches, "Possible matches")
+	}
+	if show(len(mc.KnownSyncIssues)) {
+		printFileList(mc.KnownSyncIssues, "Known sync issues")
+	}
+	if len(mc.NormalizationCollisions) > 0 {
+		printCollisionList(mc.NormalizationCollisions, "Unicode normalization collisions (not counted)")
+	}
+	if show(len(mc.SkippedSymlinks)) {
+		mc.PrintSkippedSymlinks()
+	}
+
+	notCounted := len(mc.Trashed) + len(mc.Placeholders) + len(mc.HashUnavailable) + len(mc.Acknowledged) + len(mc.Xattrs) + len(mc.LocalDuplicates) + mc.IgnoredLocal.Count() + mc.GoogleNative.Count()
+	if notCounted > 0 {
+		fmt.Print("NOT COUNTED\n\n")
+	}
+	if len(mc.Trashed) > 0 {
+		printFileList(mc.Trashed, "In Google Drive's trash (not counted)")
+	}
+	if len(mc.Placeholders) > 0 {
+		placeholders := make([]*File, len(mc.Placeholders))
+		for i, pair := range mc.Placeholders {
+			placeholders[i] = pair.Local
+		}
+		printFileList(placeholders, "Cloud-only placeholders (not hashed, not counted)")
+	}
+	if len(mc.HashUnavailable) > 0 {
+		unavailable := make([]*File, len(mc.HashUnavailable))
+		for i, pair := range mc.HashUnavailable {
+			unavailable[i] = pair.Local
+		}
+		printFileList(unavailable, "Hash unavailable remotely (only presence checked, not counted)")
+	}
+	if len(mc.Acknowledged) > 0 {
+		fmt.Printf("Acknowledged (in baseline, not counted): %d\n\n", len(mc.Acknowledged))
+		for _, ack := range mc.Acknowledged {
+			fmt.Printf("%s (%s)\n", ack.Path, ack.Status)
+		}
+		fmt.Print("\n\n")
+	}
+	if len(mc.Xattrs) > 0 {
+		printXattrList(mc.Xattrs)
+	}
+	if len(mc.LocalDuplicates) > 0 {
+		printDuplicateList(mc.LocalDuplicates)
+	}
+	if mc.IgnoredLocal.Count() > 0 {
+		mc.IgnoredLocal.Print()
+	}
+	if mc.GoogleNative.Count() > 0 {
+		mc.GoogleNative.Print()
+	}
 	mc.PrintSummary()
 }
 
 func (mc *ManifestComparison) PrintStatus() {
+	if mc.Partial {
+		mc.PrintPartialWarning()
+	}
 	if mc.IsSuccessful() {
-		fmt.Printf("✅ SUCCESS: verified local sync.\n")
+		fmt.Printf("✅ SUCCESS: verified local sync (%d files matched).\n", mc.Matches)
+	} else if mc.Partial {
+		fmt.Printf("⚠️  INCOMPLETE: %d sync mismatches detected before cancelling (%d files matched).\n", mc.Misses, mc.Matches)
 	} else {
-		fmt.Printf("❌ FAILURE: %d sync mismatches detected.\n", mc.Misses)
+		fmt.Printf("❌ FAILURE: %d sync mismatches detected (%d files matched).\n", mc.Misses, mc.Matches)
 	}
 	fmt.Println("")
 }
@@ -188,39 +713,96 @@ func (mc *ManifestComparison) PrintStatus() {
 func printFileList(files []*File, description string) {
 	fmt.Printf("%s: %d\n\n", description, len(files))
 	for _, file := range files {
-		fmt.Println(file.Path)
+		if file.LocalRoot != "" {
+			fmt.Printf("%s (in %s)\n", file.Path, file.LocalRoot)
+		} else {
+			fmt.Println(file.Path)
+		}
 	}
 	if len(files) > 0 {
 		fmt.Print("\n\n")
 	}
 }
 
-func printStringList(files []string, description string) {
+func printCollisionList(files []*File, description string) {
 	fmt.Printf("%s: %d\n\n", description, len(files))
-	for _, path := range files {
-		fmt.Println(path)
+	for _, file := range files {
+		form := "NFC"
+		if !norm.NFC.IsNormalString(file.RawPath) {
+			form = "non-NFC"
+		}
+		fmt.Printf("%s (%s name in Drive: %q)\n", file.Path, form, file.RawPath)
 	}
 	if len(files) > 0 {
 		fmt.Print("\n\n")
 	}
 }
 
-func printPossibleMatchList(matches []*PossibleMatch, description string) {
-	fmt.Printf("%s: %d\n\n", description, len(matches))
-	for _, match := range matches {
-		fmt.Printf("\"%s\" -> \"%s\"\n", match.RemotePath, match.LocalPath)
+func printStringList(items []string, description string) {
+	fmt.Printf("%s: %d\n\n", description, len(items))
+	for _, item := range items {
+		fmt.Println(item)
 	}
-	if len(matches) > 0 {
+	if len(items) > 0 {
+		fmt.Print("\n\n")
+	}
+}
+
+func printMismatchList(pairs []*FilePair, description string) {
+	fmt.Printf("%s: %d\n\n", description, len(pairs))
+	for _, pair := range pairs {
+		fmt.Println(pair.Local.Path)
+		fmt.Printf("  local:  %s\n", fileDetail(pair.Local))
+		fmt.Printf("  remote: %s\n", fileDetail(pair.Remote))
+		fmt.Printf("  newer:  %s\n", pair.Newer().describe())
+		if pair.Stale {
+			fmt.Printf("  stale: local matches the remote revision from %s\n", pair.StaleRevision.Local().Format("2006-01-02 15:04"))
+		}
+	}
+	if len(pairs) > 0 {
 		fmt.Print("\n\n")
 	}
 }
 
-func printKnownSyncList(issues []string, description string) {
-	fmt.Printf("%s: %d\n\n", description, len(issues))
-	for _, path := range issues {
-		fmt.Println(path)
+// WriteEmptyLocalList writes the remote path of each empty local file, one per
+// line, as a list of files to re-download
+func (mc *ManifestComparison) WriteEmptyLocalList(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, pair := range mc.EmptyLocal {
+		remotePath := pair.Remote.RawPath
+		if remotePath == "" {
+			remotePath = pair.Remote.Path
+		}
+		if _, err := fmt.Fprintln(f, remotePath); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func fileDetail(file *File) string {
+	modified := "unknown"
+	if !file.ModifiedTime.IsZero() {
+		modified = file.ModifiedTime.Local().Format("2006-01-02 15:04:05")
+	}
+	hash := file.ContentHash
+	if hash == "" {
+		hash = "(not hashed)"
+	}
+	return fmt.Sprintf("hash %s  size %d  modified %s", hash, file.Size, modified)
+}
+
+func printPossibleMatchList(matches []*PossibleMatch, description string) {
+	fmt.Printf("%s: %d\n\n", description, len(matches))
+	for _, match := range matches {
+		fmt.Printf("\"%s\" -> \"%s\"\n", match.Remote.Path, match.Local.Path)
 	}
-	if len(issues) > 0 {
+	if len(matches) > 0 {
 		fmt.Print("\n\n")
 	}
 }
@@ -229,17 +811,74 @@ func (mc *ManifestComparison) PrintErrored() {
 	fmt.Printf("Errored: %d\n\n", len(mc.Errored))
 	if len(mc.Errored) > 0 {
 		for _, rec := range mc.Errored {
-			fmt.Printf("%s: %s\n", rec.Path, rec.Error)
+			fmt.Printf("%s: [%s] %s\n", rec.Path, errorCategory(rec.Error), rec.Error)
+			if rec.Attempts > 1 {
+				fmt.Printf("  after %d attempts; first error: %s\n", rec.Attempts, rec.OriginalError)
+			}
 		}
 		if len(mc.Errored) > 0 {
 			fmt.Print("\n\n")
 		}
 	}
+	if len(mc.Recovered) > 0 {
+		fmt.Printf("Read on retry: %d\n\n", len(mc.Recovered))
+		for _, rec := range mc.Recovered {
+			fmt.Printf("%s: read after %d attempts; first error: %s\n", rec.Path, rec.Attempts, rec.OriginalError)
+		}
+		fmt.Print("\n\n")
+	}
+}
+
+func (mc *ManifestComparison) PrintSkippedSymlinks() {
+	fmt.Printf("Symlinks skipped: %d\n\n", len(mc.SkippedSymlinks))
+	for _, link := range mc.SkippedSymlinks {
+		fmt.Printf("%s -> %s (%s)\n", link.Path, link.Target, link.Reason)
+	}
+	if len(mc.SkippedSymlinks) > 0 {
+		fmt.Print("\n\n")
+	}
 }
 
 func (mc *ManifestComparison) PrintSummary() {
 	total := mc.Matches + mc.Misses
-	fmt.Println("SUMMARY:")
+	if mc.Partial {
+		fmt.Println("SUMMARY (PARTIAL RESULTS):")
+	} else {
+		fmt.Println("SUMMARY:")
+	}
 	fmt.Printf("Files matched: %d/%d\n", mc.Matches, total)
 	fmt.Printf("Files not matched: %d/%d\n", mc.Misses, total)
+	score := mc.Score()
+	fmt.Printf("Score: %s of files, %s of bytes (%s)\n", formatPercent(score.FilesPct), formatPercent(score.BytesPct), score.Grade)
+	if mc.Partial {
+		fmt.Printf("Files not verified (cancelled): %d\n", mc.Unverified)
+	}
+	if mc.RemoteIncomplete {
+		fmt.Println("Remote listing: INCOMPLETE (local-only files weren't checked)")
+	}
+	if mc.IgnoredOnlyLocal > 0 {
+		fmt.Printf("Local files outside the remote query (not checked): %d\n", mc.IgnoredOnlyLocal)
+	}
+	if len(mc.ContentMismatch) > 0 {
+		counts := make(map[NewerSide]int)
+		for _, pair := range mc.ContentMismatch {
+			counts[pair.Newer()]++
+		}
+		fmt.Printf("Mismatched files newer locally: %d, newer remotely: %d, can't tell: %d\n", counts[LocalNewer], counts[RemoteNewer], counts[NewerAmbiguous])
+	}
+	if mc.Stale > 0 {
+		fmt.Printf("Mismatched files matching an earlier remote revision (stale): %d\n", mc.Stale)
+	}
+	if len(mc.Placeholders) > 0 {
+		fmt.Printf("Cloud-only placeholders (not checked): %d\n", len(mc.Placeholders))
+	}
+	if len(mc.HashUnavailable) > 0 {
+		fmt.Printf("Hash unavailable remotely (only presence checked): %d\n", len(mc.HashUnavailable))
+	}
+	if len(mc.Acknowledged) > 0 {
+		fmt.Printf("Acknowledged differences (not counted): %d\n", len(mc.Acknowledged))
+	}
+	if mc.Sample != nil {
+		mc.Sample.Print()
+	}
 }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Saved manifests are JSON lines: a manifestHeader followed by one
+// manifestEntry per file, gzipped if the file name ends in .gz. Entries are
+// written as files are listed or scanned, so saving never holds the whole
+// manifest in memory. Local manifests also record files that couldn't be read, so they
+// aren't reported as missing when the manifest is loaded.
+const manifestFileVersion = 1
+
+const (
+	manifestKindRemote = "remote"
+	manifestKindLocal  = "local"
+)
+
+type manifestHeader struct {
+	Version  int       `json:"version"`
+	Kind     string    `json:"kind"`
+	Root     string    `json:"root"`
+	HashAlgo string    `json:"hashAlgo"`
+	Created  time.Time `json:"created"`
+	// Files is how many entries follow, so a truncated manifest isn't
+	// mistaken for a complete one. Only manifests saved by earlier versions
+	// record it; remote listings are now saved as they're listed, and
+	// renamed into place once complete.
+	Files int `json:"files,omitempty"`
+}
+
+type manifestEntry struct {
+	Path         string      `json:"path"`
+	OriginalPath string      `json:"originalPath,omitempty"`
+	RawPath      string      `json:"rawPath,omitempty"`
+	Hash         string      `json:"hash,omitempty"`
+	FastHash     string      `json:"fastHash,omitempty"`
+	Size         int64       `json:"size"`
+	Modified     time.Time   `json:"modified"`
+	Id           string      `json:"id,omitempty"`
+	WebLink      string      `json:"webViewLink,omitempty"`
+	Placeholder  bool        `json:"placeholder,omitempty"`
+	Xattrs       []fileXattr `json:"xattrs,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	// ErrorCategory is missing from manifests saved by earlier versions
+	ErrorCategory ErrorCategory `json:"errorCategory,omitempty"`
+}
+
+// manifestWriter saves a manifest file entry by entry
+type manifestWriter struct {
+	f   *os.File
+	gz  *gzip.Writer
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func createManifestFile(path string, header *manifestHeader) (*manifestWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &manifestWriter{f: f}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.buf = bufio.NewWriter(out)
+	w.enc = json.NewEncoder(w.buf)
+	header.Version = manifestFileVersion
+	if err := w.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *manifestWriter) Write(file *File) error {
+	return w.enc.Encode(&manifestEntry{
+		Path:         file.Path,
+		OriginalPath: file.OriginalPath,
+		RawPath:      file.RawPath,
+		Hash:         file.ContentHash,
+		FastHash:     file.FastHash,
+		Size:         file.Size,
+		Modified:     file.ModifiedTime,
+		Id:           file.Id,
+		WebLink:      file.WebLink,
+		Placeholder:  file.Placeholder,
+		Xattrs:       file.Xattrs,
+	})
+}
+
+// WriteError records a file that couldn't be read
+func (w *manifestWriter) WriteError(fileErr *FileError) error {
+	return w.enc.Encode(&manifestEntry{Path: fileErr.Path, Error: fileErr.Error.Error(), ErrorCategory: errorCategory(fileErr.Error)})
+}
+
+func (w *manifestWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
+
+// openManifestFile reads a saved manifest's header, returning a decoder for
+// the entries that follow and a function to close the file
+func openManifestFile(path string) (*manifestHeader, *json.Decoder, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	closeFile := func() { f.Close() }
+	var in io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+		closeFile = func() {
+			gz.Close()
+			f.Close()
+		}
+		in = gz
+	}
+
+	dec := json.NewDecoder(in)
+	header := &manifestHeader{}
+	if err := dec.Decode(header); err != nil {
+		closeFile()
+		return nil, nil, nil, fmt.Errorf("%s: invalid manifest header: %v", path, err)
+	}
+	if header.Version != manifestFileVersion {
+		closeFile()
+		return nil, nil, nil, fmt.Errorf("%s: unsupported manifest version %d", path, header.Version)
+	}
+	return header, dec, closeFile, nil
+}
+
+// readManifestFile calls fn with the header and each file in a saved manifest,
+// returning the header and the files recorded as errors
+func readManifestFile(path string, fn func(*manifestHeader, *File) error) (*manifestHeader, []*FileError, error) {
+	header, dec, closeFile, err := openManifestFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeFile()
+	var errored []*FileError
+	read := 0
+	for {
+		entry := &manifestEntry{}
+		err := dec.Decode(entry)
+		if err == io.EOF {
+			if header.Files > 0 && read != header.Files {
+				return nil, nil, fmt.Errorf("%s is incomplete: its header lists %d files, but %d were read", path, header.Files, read)
+			}
+			return header, errored, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		read++
+		if entry.Error != "" {
+			err := errors.New(entry.Error)
+			if entry.ErrorCategory != "" {
+				err = withCategory(entry.ErrorCategory, err)
+			}
+			errored = append(errored, &FileError{Path: entry.Path, Error: err})
+			continue
+		}
+		err = fn(header, &File{
+			Path:         entry.Path,
+			OriginalPath: entry.OriginalPath,
+			RawPath:      entry.RawPath,
+			ContentHash:  entry.Hash,
+			FastHash:     entry.FastHash,
+			Size:         entry.Size,
+			ModifiedTime: entry.Modified,
+			Id:           entry.Id,
+			WebLink:      entry.WebLink,
+			Placeholder:  entry.Placeholder,
+			Xattrs:       entry.Xattrs,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// remoteManifestFile saves the remote listing as it's listed. It's written
+// next to path and renamed over it by Commit, so a listing that fails or is
+// cancelled never leaves a manifest that looks complete.
+type remoteManifestFile struct {
+	*manifestWriter
+	path, tmp string
+}
+
+func createRemoteManifestFile(path string, config *verifyConfig) (*remoteManifestFile, error) {
+	// keep the extension, which says whether the file is gzipped
+	tmp := filepath.Join(filepath.Dir(path), "saving-"+filepath.Base(path))
+	w, err := createManifestFile(tmp, &manifestHeader{
+		Kind:     manifestKindRemote,
+		Root:     remoteManifestRoot(config),
+		HashAlgo: config.Local.HashAlgo.String(),
+		Created:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &remoteManifestFile{manifestWriter: w, path: path, tmp: tmp}, nil
+}
+
+// Commit closes the manifest and moves it into place
+func (m *remoteManifestFile) Commit() error {
+	if err := m.manifestWriter.Close(); err != nil {
+		os.Remove(m.tmp)
+		return err
+	}
+	return os.Rename(m.tmp, m.path)
+}
+
+// Discard closes and removes the manifest, leaving any previous one in place
+func (m *remoteManifestFile) Discard() {
+	m.manifestWriter.Close()
+	os.Remove(m.tmp)
+}
+
+// remoteManifestRoot is the remote root a saved listing records
+func remoteManifestRoot(config *verifyConfig) string {
+	if config.RemoteRootId != "" {
+		return "id:" + config.RemoteRootId
+	}
+	return path.Clean(config.RemoteRoot)
+}
+
+// sameRemoteRoot reports whether a saved listing's root is config's
+func sameRemoteRoot(root string, config *verifyConfig) bool {
+	return path.Clean(root) == remoteManifestRoot(config)
+}
+
+// loadRemoteManifest reads a saved remote listing in place of listing Google
+// Drive, applying the same filtering as getRemoteManifest and the selective
+// sync folders, which the listing may have been saved without
+func loadRemoteManifest(progress *scanProgress, path string, config *verifyConfig) (*sortedManifest, error) {
+	manifest := newSortedManifest(config.Local.Spill)
+	listed := 0
+	header, _, err := readManifestFile(path, func(_ *manifestHeader, file *File) error {
+		listed++
+		rawPath := file.RawPath
+		if rawPath == "" {
+			rawPath = file.Path
+		}
+		if !inSubdirectories(rawPath, config.LocalDirs) {
+			return nil
+		}
+		if config.includesRemote(file) {
+			config.Local.remoteHashes.add(file)
+			return manifest.Add(file)
+		}
+		return nil
+	})
+	if err == nil {
+		err = checkManifestHeader(path, header, manifestKindRemote, config.Local.HashAlgo)
+	}
+	if err == nil && !sameRemoteRoot(header.Root, config) {
+		err = fmt.Errorf("%s is a listing of %s, not %s", path, header.Root, remoteManifestRoot(config))
+	}
+	if err != nil {
+		manifest.Close()
+		return nil, err
+	}
+	progress.setRemoteListed(listed)
+	progress.finishRemote(manifest.Len())
+	return manifest, nil
+}
+
+// createLocalManifestFile starts saving the local scan for config
+func createLocalManifestFile(path string, config *verifyConfig) (*manifestWriter, error) {
+	return createManifestFile(path, &manifestHeader{
+		Kind:     manifestKindLocal,
+		Root:     config.LocalRoot,
+		HashAlgo: config.Local.HashAlgo.String(),
+		Created:  time.Now(),
+	})
+}
+
+// loadLocalManifest reads a saved local scan in place of scanning and hashing
+// the local directory
+func loadLocalManifest(progress *scanProgress, path string, config *verifyConfig) (*localScanResult, error) {
+	manifest := newSortedManifest(config.Local.Spill)
+	var xattrs []*File
+	header, errored, err := readManifestFile(path, func(_ *manifestHeader, file *File) error {
+		if config.Local.Shard.includes(file.Path) && config.Local.Sample.includes(file.Path) && config.Local.Filter.includes(file.Size, file.ModifiedTime) {
+			if len(file.Xattrs) > 0 {
+				xattrs = append(xattrs, file)
+			}
+			return manifest.Add(file)
+		}
+		return nil
+	})
+	if err == nil {
+		err = checkManifestHeader(path, header, manifestKindLocal, config.Local.HashAlgo)
+	}
+	if err != nil {
+		manifest.Close()
+		return nil, err
+	}
+	progress.addLocal(manifest.Len(), 0)
+	progress.addLocalErrors(len(errored))
+	return &localScanResult{Manifest: manifest, Errored: errored, Xattrs: xattrs, Complete: true}, nil
+}
+
+func checkManifestHeader(path string, header *manifestHeader, kind string, algo hashAlgorithm) error {
+	if header.Kind != kind {
+		return fmt.Errorf("%s is a %s manifest, expected %s", path, header.Kind, kind)
+	}
+	if header.HashAlgo != algo.String() {
+		return fmt.Errorf("%s was hashed with %s, but %s is selected", path, header.HashAlgo, algo)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics in the text exposition format, either served over HTTP
+// or written for node_exporter's textfile collector.
+
+const lastSuccessMetric = "gdsv_last_success_timestamp_seconds"
+
+// metricsSnapshot holds the values exported for the most recent run
+type metricsSnapshot struct {
+	Matched        int
+	Mismatched     int
+	EmptyLocal     int
+	OnlyLocal      int
+	OnlyRemote     int
+	Deleted        int
+	Errored        int
+	Misses         int
+	Success        bool
+	ScanDuration   time.Duration
+	LastRun        time.Time
+	LastSuccess    time.Time
+	BytesHashed    int64
+	RemoteAPICalls int
+}
+
+func newMetricsSnapshot(mc *ManifestComparison, stats *RunStats, previousSuccess time.Time) *metricsSnapshot {
+	now := time.Now()
+	snapshot := &metricsSnapshot{
+		Matched:        mc.Matches,
+		Mismatched:     len(mc.ContentMismatch),
+		EmptyLocal:     len(mc.EmptyLocal),
+		OnlyLocal:      len(mc.OnlyLocal),
+		OnlyRemote:     len(mc.OnlyRemote),
+		Deleted:        len(mc.DeletedRemotely),
+		Errored:        len(mc.Errored),
+		Misses:         mc.Misses,
+		Success:        mc.IsSuccessful(),
+		ScanDuration:   stats.TotalDuration,
+		LastRun:        now,
+		LastSuccess:    previousSuccess,
+		BytesHashed:    stats.LocalBytesHashed,
+		RemoteAPICalls: stats.RemoteAPICalls,
+	}
+	if snapshot.Success {
+		snapshot.LastSuccess = now
+	}
+	return snapshot
+}
+
+func (m *metricsSnapshot) write(w io.Writer) {
+	success := 0
+	if m.Success {
+		success = 1
+	}
+	writeMetric(w, "gdsv_files_matched", "gauge", "Files whose local copy matches Google Drive", float64(m.Matched))
+	writeMetric(w, "gdsv_files_mismatched", "gauge", "Files whose contents differ between local and Google Drive", float64(m.Mismatched))
+	writeMetric(w, "gdsv_files_empty_local", "gauge", "Files that are empty locally but not in Google Drive", float64(m.EmptyLocal))
+	writeMetric(w, "gdsv_files_only_local", "gauge", "Files only present locally", float64(m.OnlyLocal))
+	writeMetric(w, "gdsv_files_only_remote", "gauge", "Files only present in Google Drive", float64(m.OnlyRemote))
+	writeMetric(w, "gdsv_files_deleted_remotely", "gauge", "Local-only files found in Google Drive's trash or orphaned", float64(m.Deleted))
+	writeMetric(w, "gdsv_files_errored", "gauge", "Local files that could not be read", float64(m.Errored))
+	writeMetric(w, "gdsv_sync_misses", "gauge", "Total sync mismatches detected", float64(m.Misses))
+	writeMetric(w, "gdsv_success", "gauge", "Whether the last verification succeeded", float64(success))
+	writeMetric(w, "gdsv_scan_duration_seconds", "gauge", "Duration of the last verification", m.ScanDuration.Seconds())
+	writeMetric(w, "gdsv_bytes_hashed", "gauge", "Bytes hashed locally during the last verification", float64(m.BytesHashed))
+	writeMetric(w, "gdsv_remote_api_calls", "gauge", "Google Drive API calls made during the last verification", float64(m.RemoteAPICalls))
+	writeMetric(w, "gdsv_last_run_timestamp_seconds", "gauge", "Unix time of the last verification", float64(m.LastRun.Unix()))
+	if !m.LastSuccess.IsZero() {
+		writeMetric(w, lastSuccessMetric, "gauge", "Unix time of the last successful verification", float64(m.LastSuccess.Unix()))
+	}
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// writeMetricsFile atomically replaces a textfile collector file, so the
+// collector never reads a partial file
+func writeMetricsFile(metricsPath string, snapshot *metricsSnapshot) error {
+	var buf bytes.Buffer
+	snapshot.write(&buf)
+	tmp, err := ioutil.TempFile(filepath.Dir(metricsPath), ".gdsv-metrics-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), metricsPath)
+}
+
+// readLastSuccess returns the last success timestamp from a previously
+// written metrics file, so it survives failed runs
+func readLastSuccess(metricsPath string) time.Time {
+	f, err := os.Open(metricsPath)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == lastSuccessMetric {
+			if seconds, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				return time.Unix(int64(seconds), 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// metricsServer serves the latest snapshot on /metrics
+type metricsServer struct {
+	lock     sync.Mutex
+	snapshot *metricsSnapshot
+}
+
+func startMetricsServer(addr string) (*metricsServer, error) {
+	server := &metricsServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", server.handle)
+	listener := &http.Server{Addr: addr, Handler: mux}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- listener.ListenAndServe()
+	}()
+	// surface immediate failures such as the port being in use
+	select {
+	case err := <-errChan:
+		return nil, err
+	case <-time.After(100 * time.Millisecond):
+	}
+	return server, nil
+}
+
+func (s *metricsServer) update(snapshot *metricsSnapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snapshot = snapshot
+}
+
+func (s *metricsServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	snapshot := s.snapshot
+	s.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if snapshot == nil {
+		// still running the first verification
+		return
+	}
+	snapshot.write(w)
+}
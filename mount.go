@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkMounted guards against verifying an unmounted share or external
+// drive: the local root must be a non-empty directory, and on a different
+// filesystem from the system root where the platform can tell
+func checkMounted(localRoot string) error {
+	info, err := os.Stat(localRoot)
+	if err != nil {
+		return fmt.Errorf("Local root isn't available: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Local root %s isn't a directory", localRoot)
+	}
+	dir, err := os.Open(localRoot)
+	if err != nil {
+		return fmt.Errorf("Local root isn't readable: %v", err)
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err == io.EOF {
+		return fmt.Errorf("Local root %s is empty; is it mounted?", localRoot)
+	} else if err != nil {
+		return fmt.Errorf("Local root isn't readable: %v", err)
+	}
+	mounted, err := onSeparateFilesystem(localRoot)
+	if err != nil {
+		return fmt.Errorf("Unable to tell whether %s is mounted: %v", localRoot, err)
+	}
+	if !mounted {
+		return fmt.Errorf("Local root %s is on the system disk, not a mounted drive or share; is it mounted?", localRoot)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// onSeparateFilesystem reports whether path is on a different device from
+// /, i.e. under a mount point
+func onSeparateFilesystem(path string) (bool, error) {
+	var pathStat, rootStat syscall.Stat_t
+	if err := syscall.Stat(path, &pathStat); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat("/", &rootStat); err != nil {
+		return false, err
+	}
+	return pathStat.Dev != rootStat.Dev, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// notifyConfig sets up alerts sent after a run by webhook and/or email
+type notifyConfig struct {
+	Webhook string
+	// Format is the webhook payload: json, slack or discord
+	Format string
+	// On is when to notify: failure, or new-problems (with --diff-previous)
+	On           string
+	SMTPServer   string
+	SMTPUser     string
+	SMTPPassword string
+	From         string
+	To           []string
+}
+
+// Maximum number of problem paths included in a notification
+const notifyMaxProblems = 20
+
+func (c *notifyConfig) enabled() bool {
+	return c.Webhook != "" || len(c.To) > 0
+}
+
+func (c *notifyConfig) validate() error {
+	switch c.Format {
+	case "json", "slack", "discord":
+	default:
+		return fmt.Errorf("Unknown notification format %q (expected json, slack or discord)", c.Format)
+	}
+	switch c.On {
+	case "failure", "new-problems":
+	default:
+		return fmt.Errorf("Unknown notification condition %q (expected failure or new-problems)", c.On)
+	}
+	if len(c.To) > 0 && (c.SMTPServer == "" || c.From == "") {
+		return fmt.Errorf("Email notifications need --smtp-server and --notify-from")
+	}
+	return nil
+}
+
+// shouldNotify decides whether this run warrants an alert; diff is nil if
+// there's no previous run to compare with, in which case any failure counts
+func (c *notifyConfig) shouldNotify(mc *ManifestComparison, diff *runDiff) bool {
+	if c.On == "new-problems" && diff != nil {
+		return len(diff.New) > 0
+	}
+	return !mc.IsSuccessful() || mc.IsSuspect()
+}
+
+// notification summarizes a run for alerts; it's also the generic JSON
+// webhook payload
+type notification struct {
+	Success     bool                   `json:"success"`
+	Suspect     bool                   `json:"suspect"`
+	Remote      string                 `json:"remote"`
+	Local       string                 `json:"local"`
+	Matches     int                    `json:"matches"`
+	Misses      int                    `json:"misses"`
+	NewProblems *int                   `json:"newProblems,omitempty"`
+	Resolved    *int                   `json:"resolved,omitempty"`
+	Problems    []*notificationProblem `json:"problems"`
+	// Text is a human readable summary
+	Text string `json:"text"`
+}
+
+type notificationProblem struct {
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status"`
+}
+
+// newNotification lists the new problems if diff is given, otherwise all of
+// them, up to notifyMaxProblems
+func newNotification(mc *ManifestComparison, diff *runDiff, remote, local string) *notification {
+	n := &notification{
+		Success:  mc.IsSuccessful(),
+		Suspect:  mc.IsSuspect(),
+		Remote:   remote,
+		Local:    local,
+		Matches:  mc.Matches,
+		Misses:   mc.Misses,
+		Problems: []*notificationProblem{},
+	}
+	var problems []*FileResult
+	if diff != nil {
+		newProblems, resolved := len(diff.New), len(diff.Resolved)
+		n.NewProblems, n.Resolved = &newProblems, &resolved
+		problems = diff.New
+	} else {
+		for _, result := range mc.Results() {
+			if problemStatuses[result.Status] {
+				problems = append(problems, result)
+			}
+		}
+	}
+	for i, result := range problems {
+		if i == notifyMaxProblems {
+			break
+		}
+		n.Problems = append(n.Problems, &notificationProblem{Path: result.Path, Status: result.Status})
+	}
+
+	var b strings.Builder
+	status := "FAILURE"
+	if mc.Partial {
+		status = "PARTIAL"
+	} else if n.Suspect {
+		status = "SUSPECT"
+	} else if n.Success {
+		status = "SUCCESS"
+	}
+	fmt.Fprintf(&b, "%s: Google Drive \"%s\" vs local \"%s\"\n", status, remote, local)
+	fmt.Fprintf(&b, "Files matched: %d/%d\n", mc.Matches, mc.Matches+mc.Misses)
+	if diff != nil {
+		fmt.Fprintf(&b, "New problems since the last run: %d, resolved: %d\n", len(diff.New), len(diff.Resolved))
+	}
+	for _, problem := range n.Problems {
+		fmt.Fprintf(&b, "%s (%s)\n", problem.Path, problem.Status)
+	}
+	if len(problems) > len(n.Problems) {
+		fmt.Fprintf(&b, "...and %d more\n", len(problems)-len(n.Problems))
+	}
+	n.Text = b.String()
+	return n
+}
+
+// send delivers the notification to every configured destination, returning
+// the first error
+func (c *notifyConfig) send(n *notification) error {
+	var firstErr error
+	if c.Webhook != "" {
+		if err := c.postWebhook(n); err != nil {
+			firstErr = fmt.Errorf("Unable to send webhook notification: %v", err)
+		}
+	}
+	if len(c.To) > 0 {
+		if err := c.sendEmail(n); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Unable to send email notification: %v", err)
+		}
+	}
+	return firstErr
+}
+
+func (c *notifyConfig) postWebhook(n *notification) error {
+	var payload interface{} = n
+	switch c.Format {
+	case "slack":
+		payload = map[string]string{"text": n.Text}
+	case "discord":
+		// Discord rejects messages over 2000 characters
+		text := n.Text
+		if len(text) > 2000 {
+			text = text[:1996] + "\n..."
+		}
+		payload = map[string]string{"content": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(c.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", c.Webhook, resp.Status)
+	}
+	return nil
+}
+
+func (c *notifyConfig) sendEmail(n *notification) error {
+	subject := strings.SplitN(n.Text, "\n", 2)[0]
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.To, ", "))
+	fmt.Fprintf(&msg, "Subject: googledrive-sync-verifier %s\r\n", subject)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if c.SMTPUser != "" {
+		host := c.SMTPServer
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPassword, host)
+	}
+	return smtp.SendMail(c.SMTPServer, auth, c.From, c.To, msg.Bytes())
+}
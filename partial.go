@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// markPartial adjusts a comparison of manifests from cancelled scans: files
+// only on one side may simply not have been reached on the other, so they're
+// set aside as unverified instead of counting towards Misses
+func (mc *ManifestComparison) markPartial(remoteComplete, localComplete bool) {
+	mc.Partial = true
+	if !localComplete {
+		mc.Unverified += len(mc.OnlyRemote)
+		mc.Misses -= len(mc.OnlyRemote)
+		mc.OnlyRemote = nil
+	}
+	if !remoteComplete {
+		mc.RemoteIncomplete = true
+		mc.Unverified += len(mc.OnlyLocal)
+		mc.Misses -= len(mc.OnlyLocal)
+		mc.OnlyLocal = nil
+	}
+}
+
+func (mc *ManifestComparison) PrintPartialWarning() {
+	fmt.Println("⚠️  PARTIAL RESULTS: verification was cancelled before the scans finished.")
+	fmt.Printf("Files found on only one side before cancelling (not verified): %d\n", mc.Unverified)
+	if mc.RemoteIncomplete {
+		fmt.Println("The remote listing didn't finish, so files found only locally may still be in the remote.")
+	}
+	fmt.Println("")
+}
+
+// checkpointManifest passes a local manifest through to the comparison,
+// saving each file to a checkpoint as it goes so a later run can --resume
+// without hashing it again
+type checkpointManifest struct {
+	manifestReader
+	w   *manifestWriter
+	err error
+}
+
+func (c *checkpointManifest) PopOrNil() *File {
+	file := c.manifestReader.PopOrNil()
+	if file != nil && c.err == nil {
+		c.err = c.w.Write(file)
+	}
+	return file
+}
+
+func createCheckpoint(config *verifyConfig, manifest manifestReader) (*checkpointManifest, error) {
+	w, err := createManifestFile(config.Checkpoint, &manifestHeader{
+		Kind:     manifestKindLocal,
+		Root:     config.LocalRoot,
+		HashAlgo: config.Local.HashAlgo.String(),
+		Created:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointManifest{manifestReader: manifest, w: w}, nil
+}
+
+func (c *checkpointManifest) Close() error {
+	closeErr := c.w.Close()
+	if c.err != nil {
+		return c.err
+	}
+	return closeErr
+}
+
+// loadCheckpoint reads the files hashed by a cancelled run of the same
+// local root, keyed by their normalized path relative to the root
+func loadCheckpoint(config *verifyConfig) (map[string]*File, error) {
+	files := make(map[string]*File)
+	header, _, err := readManifestFile(config.Checkpoint, func(_ *manifestHeader, file *File) error {
+		if file.ContentHash == "" {
+			return nil
+		}
+		relPath := file.OriginalPath
+		if relPath == "" {
+			relPath = file.Path
+		}
+		files[relPath] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkManifestHeader(config.Checkpoint, header, manifestKindLocal, config.Local.HashAlgo); err != nil {
+		return nil, err
+	}
+	if header.Root != config.LocalRoot {
+		return nil, fmt.Errorf("%s is a checkpoint for %s, not %s", config.Checkpoint, header.Root, config.LocalRoot)
+	}
+	return files, nil
+}
+
+// removeCheckpoint deletes the checkpoint once a run completes, since its
+// hashes are no longer needed
+func removeCheckpoint(config *verifyConfig) {
+	if config.Checkpoint == "" {
+		return
+	}
+	if err := os.Remove(config.Checkpoint); err != nil && !os.IsNotExist(err) {
+		logger.Warn("unable to remove checkpoint", "path", config.Checkpoint, "error", err)
+	}
+}
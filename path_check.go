@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// With --path or --paths-from, only the given files are verified: each is
+// looked up in Google Drive folder by folder from the root and hashed
+// locally, without listing or scanning anything else, to quickly check a few
+// files after a suspected sync glitch.
+
+// drivePathsProvider looks up a few files in Google Drive by path, in place
+// of listing everything under the root
+type drivePathsProvider struct {
+	listing *DriveListing
+	// paths are relative to the root, with forward slashes
+	paths []string
+}
+
+func (p *drivePathsProvider) Name() string {
+	return "Google Drive"
+}
+
+func (p *drivePathsProvider) HashAlgo() hashAlgorithm {
+	return p.listing.HashAlgo
+}
+
+func (p *drivePathsProvider) List(ctx context.Context, root string, add func(*File) error) error {
+	p.listing.Context = ctx
+	p.listing.RootPath = root
+	rootId, err := p.listing.getRootId()
+	if err != nil {
+		return err
+	}
+	for _, filePath := range p.paths {
+		found, err := p.listing.findPath(rootId, filePath)
+		if err != nil {
+			return fmt.Errorf("Unable to look up %s in Google Drive: %w", filePath, err)
+		}
+		if len(found) == 0 {
+			logger.Debug("file not found in Google Drive", "path", filePath)
+		}
+		for _, file := range found {
+			if err := add(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findPath returns the files at relPath, relative to RootPath under the
+// folder rootId, by listing each folder on the way down. Names are compared
+// the way listed paths are, normalized and lowercased; Drive allows several
+// files (and folders) with the same name, so every match is followed.
+func (g *DriveListing) findPath(rootId string, relPath string) (files []*File, err error) {
+	var names []string
+	if root := strings.Trim(g.RootPath, "/"); root != "" {
+		names = strings.Split(root, "/")
+	}
+	names = append(names, strings.Split(relPath, "/")...)
+
+	// the folders matched so far, and their paths as named in Drive
+	folders := map[string]string{rootId: "/"}
+	for i, name := range names {
+		last := i == len(names)-1
+		want := strings.ToLower(normalizeUnicodeCharacters(name))
+		next := make(map[string]string)
+		for folderId, folderPath := range folders {
+			query := fmt.Sprintf("'%s' in parents and trashed != true", folderId)
+			if last {
+				query = g.filesQuery(query)
+			}
+			var pathErr error
+			err = g.eachPage(query, func(page []*drive.File) bool {
+				for _, file := range page {
+					fileName := filterFileName(file.Name)
+					if strings.ToLower(normalizeUnicodeCharacters(fileName)) != want {
+						continue
+					}
+					filePath := path.Join(folderPath, fileName)
+					if !last && file.MimeType == folderMimeType {
+						next[file.Id] = filePath
+					} else if last && file.MimeType != folderMimeType && !isGoogleNative(file) {
+						var rel string
+						if rel, pathErr = remoteRel(g.RootPath, filePath); pathErr != nil {
+							return false
+						}
+						files = append(files, g.newRemoteFile(rel, file))
+					}
+				}
+				return true
+			})
+			if err == nil {
+				err = pathErr
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		folders = next
+	}
+	return files, nil
+}
+
+// checkedPaths collects the --path and --paths-from files relative to
+// localRoot, with forward slashes; a full local path is made relative
+func checkedPaths(localRoot string, paths []string, pathsFrom string) ([]string, error) {
+	if pathsFrom != "" {
+		listed, err := readFolderList(pathsFrom)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read paths from %s: %v", pathsFrom, err)
+		}
+		paths = append(paths, listed...)
+	}
+	var checked []string
+	for _, filePath := range paths {
+		if filepath.IsAbs(filePath) {
+			rel, err := filepath.Rel(localRoot, filePath)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("%s isn't under the local root %s", filePath, localRoot)
+			}
+			filePath = rel
+		}
+		filePath = strings.Trim(filepath.ToSlash(filepath.Clean(filePath)), "/")
+		if filePath == "" || filePath == "." || filePath == ".." || strings.HasPrefix(filePath, "../") {
+			return nil, fmt.Errorf("%q isn't a file under the roots", filePath)
+		}
+		checked = append(checked, filePath)
+	}
+	return checked, nil
+}
+
+// scanLocalPaths hashes just the local files at config.Paths, in place of
+// scanning the local root. A file that doesn't exist locally is left out,
+// to be reported as only in remote.
+func scanLocalPaths(ctx context.Context, progress *scanProgress, config *verifyConfig) (*localScanResult, error) {
+	opts := config.Local
+	localRootLowercase := strings.ToLower(config.LocalRoot)
+	manifest := newSortedManifest(opts.Spill)
+	var errored []*FileError
+	var bytesHashed int64
+	for _, relPath := range config.Paths {
+		if ctx.Err() != nil {
+			break
+		}
+		entryPath := filepath.Join(config.LocalRoot, filepath.FromSlash(relPath))
+		info, err := os.Stat(entryPath)
+		if os.IsNotExist(err) {
+			logger.Debug("file not found locally", "path", entryPath)
+			continue
+		} else if err == nil && info.IsDir() {
+			err = fmt.Errorf("%s is a folder; only files can be verified by path", entryPath)
+		}
+		if err != nil {
+			errored = append(errored, &FileError{Path: relPath, Error: categorized(err)})
+			progress.addLocalErrors(1)
+			continue
+		}
+		file, fileErr := processLocalFile(ctx, localRootLowercase, opts, &localEntry{Path: entryPath, Info: info})
+		if fileErr != nil {
+			errored = append(errored, fileErr)
+			progress.addLocalErrors(1)
+		} else if file != nil {
+			if err := manifest.Add(file); err != nil {
+				manifest.Close()
+				return nil, err
+			}
+			hashed := int64(0)
+			if file.ContentHash != "" {
+				hashed = file.Size
+			}
+			bytesHashed += hashed
+			progress.addLocal(1, hashed)
+		}
+	}
+	return &localScanResult{Manifest: manifest, Errored: errored, BytesHashed: bytesHashed, Complete: ctx.Err() == nil}, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// pathRule rewrites whatever matches Find in a path with Replace ($1 etc.
+// for groups), on the local side, the remote side or both. Paths are
+// relative to the roots, lowercased and Unicode normalized by the time rules
+// are applied.
+type pathRule struct {
+	Side    string `json:"side"`
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+	find    *regexp.Regexp
+}
+
+func (r *pathRule) compile() error {
+	switch r.Side {
+	case "local", "remote", "both":
+	default:
+		return fmt.Errorf("Unknown side %q in path rule %q (expected local, remote or both)", r.Side, r.Find)
+	}
+	find, err := regexp.Compile(r.Find)
+	if err != nil {
+		return fmt.Errorf("Invalid path rule %q: %v", r.Find, err)
+	}
+	r.find = find
+	return nil
+}
+
+// pathPreset is a named set of rules for a sync client's renaming
+type pathPreset struct {
+	Description string
+	Rules       []*pathRule
+	// PossibleMatches are applied to both sides of files left unmatched,
+	// to pair up files with the same contents whose names the client
+	// changed in ways that can't be undone exactly
+	PossibleMatches []*pathRule
+	// IgnoredExtensions and IgnoredDirectories are local files and folders
+	// the client creates that have no remote counterpart
+	IgnoredExtensions  []string
+	IgnoredDirectories []string
+	// KnownSyncIssues sets aside remote files with a ":" in their path,
+	// which the client can't sync
+	KnownSyncIssues bool
+}
+
+// googleNativeExtensions are the link files sync clients create for Google
+// Docs, Sheets etc. and shortcuts, which have no binary contents in Drive
+var googleNativeExtensions = []string{".gdoc", ".gsheet", ".gmap", ".gslides", ".gdraw", ".gform", ".gshortcut"}
+
+var pathPresets = map[string]pathPreset{
+	"drive-desktop": {
+		Description: "Google Drive for Desktop",
+		Rules:       driveDesktopRules(),
+		PossibleMatches: []*pathRule{
+			// extensions are added or changed for some file types
+			{Side: "both", Find: `\.[^./]*$`, Replace: ""},
+			// duplicate names get a " (1)" suffix
+			{Side: "both", Find: ` \(1\)(/|$)`, Replace: "$1"},
+			// special characters are replaced with underscores, or spaces
+			// since the 2021 version
+			{Side: "both", Find: `_`, Replace: " "},
+		},
+		IgnoredExtensions: googleNativeExtensions,
+	},
+	"drive-desktop-2021": {
+		Description: "Google Drive for Desktop's renaming only, without its possible matches or ignored files",
+		Rules:       driveDesktopRules(),
+	},
+	"synology": {
+		Description: "Synology Cloud Sync",
+		Rules: []*pathRule{
+			slashConflictRule,
+			// trailing spaces are dropped from folder names
+			{Side: "remote", Find: ` /`, Replace: "/"},
+		},
+		IgnoredExtensions: googleNativeExtensions,
+		KnownSyncIssues:   true,
+	},
+	"insync": {
+		Description: "Insync",
+		PossibleMatches: []*pathRule{
+			// duplicate names get a " (1)" suffix
+			{Side: "both", Find: ` \(1\)(\.[^./]*)?$`, Replace: "$1"},
+		},
+		IgnoredExtensions: googleNativeExtensions,
+		// deleted files are kept in the sync root until emptied
+		IgnoredDirectories: []string{".insync-trash"},
+	},
+	"rclone": {
+		Description: "rclone sync or bisync",
+		Rules:       rcloneRules(),
+		// in-progress downloads
+		IgnoredExtensions: []string{".partial"},
+	},
+}
+
+// defaultClient is the preset for --client if not given
+const defaultClient = "drive-desktop"
+
+// slashConflictRule strips the marker Synology adds to names that had a / in
+// Drive. Earlier versions always stripped it, so the default preset keeps
+// doing so for local trees synced by Synology before.
+var slashConflictRule = &pathRule{Side: "local", Find: `\(slash conflict\)(/|$)`, Replace: "$1"}
+
+func driveDesktopRules() []*pathRule {
+	rules := []*pathRule{slashConflictRule}
+	if runtime.GOOS != "windows" {
+		return rules
+	}
+	// characters Windows doesn't allow in names are replaced with underscores
+	return append(rules, &pathRule{Side: "remote", Find: `[<>:"\\|?*]`, Replace: "_"})
+}
+
+// rcloneRules undo rclone's encoding of characters the local filesystem
+// doesn't allow in names, as lookalike Unicode characters. Drive names with
+// a / are listed with an underscore instead, as elsewhere.
+func rcloneRules() []*pathRule {
+	rules := []*pathRule{{Side: "local", Find: "／", Replace: "_"}}
+	if runtime.GOOS != "windows" {
+		return rules
+	}
+	for _, encoding := range [][2]string{
+		{"＜", "<"}, {"＞", ">"}, {"：", ":"}, {"＂", `"`}, {"＼", `\`}, {"｜", "|"}, {"？", "?"}, {"＊", "*"},
+	} {
+		rules = append(rules, &pathRule{Side: "local", Find: encoding[0], Replace: encoding[1]})
+	}
+	// trailing spaces and periods are encoded too
+	return append(rules,
+		&pathRule{Side: "local", Find: "␠(/|$)", Replace: " $1"},
+		&pathRule{Side: "local", Find: "．(/|$)", Replace: ".$1"},
+	)
+}
+
+// pathRules are the path transformations applied before comparing, from
+// presets and a rules file. A nil *pathRules changes nothing.
+type pathRules struct {
+	Presets            []string
+	Local              []*pathRule
+	Remote             []*pathRule
+	PossibleMatches    []*pathRule
+	IgnoredExtensions  []string
+	IgnoredDirectories []string
+	KnownSyncIssues    bool
+}
+
+// newPathRules combines the preset for the sync client (none for "none";
+// synology for --synology) and any further presets with the rules in
+// rulesPath, a JSON array of pathRule, if given
+func newPathRules(client string, presets []string, synology bool, rulesPath string) (*pathRules, error) {
+	if synology {
+		client = "synology"
+	}
+	var names []string
+	if client != "none" {
+		names = append(names, client)
+	}
+	for _, name := range presets {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	rules := &pathRules{}
+	var all []*pathRule
+	for _, name := range names {
+		preset, ok := pathPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown preset %q (expected %s)", name, strings.Join(presetNames(), ", "))
+		}
+		rules.Presets = append(rules.Presets, name)
+		all = append(all, preset.Rules...)
+		for _, rule := range preset.PossibleMatches {
+			rule := *rule
+			if err := rule.compile(); err != nil {
+				return nil, err
+			}
+			rules.PossibleMatches = append(rules.PossibleMatches, &rule)
+		}
+		rules.IgnoredExtensions = append(rules.IgnoredExtensions, preset.IgnoredExtensions...)
+		rules.IgnoredDirectories = append(rules.IgnoredDirectories, preset.IgnoredDirectories...)
+		rules.KnownSyncIssues = rules.KnownSyncIssues || preset.KnownSyncIssues
+	}
+	if rulesPath != "" {
+		data, err := ioutil.ReadFile(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		var custom []*pathRule
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("Unable to parse path rules %s: %v", rulesPath, err)
+		}
+		all = append(all, custom...)
+	}
+	for _, rule := range all {
+		// presets are shared, so compile a copy
+		rule := *rule
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		if rule.Side != "remote" {
+			rules.Local = append(rules.Local, &rule)
+		}
+		if rule.Side != "local" {
+			rules.Remote = append(rules.Remote, &rule)
+		}
+	}
+	return rules, nil
+}
+
+func presetNames() []string {
+	var names []string
+	for name := range pathPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *pathRules) local(path string) string {
+	if r == nil {
+		return path
+	}
+	return applyPathRules(r.Local, path)
+}
+
+func (r *pathRules) remote(path string) string {
+	if r == nil {
+		return path
+	}
+	return applyPathRules(r.Remote, path)
+}
+
+func (r *pathRules) knownSyncIssues() bool {
+	return r != nil && r.KnownSyncIssues
+}
+
+// possibleMatch rewrites a path from either side for finding possible
+// matches
+func (r *pathRules) possibleMatch(path string) string {
+	if r == nil {
+		return path
+	}
+	return applyPathRules(r.PossibleMatches, path)
+}
+
+func (r *pathRules) ignoresExtension(ext string) bool {
+	return r != nil && slices.Contains(r.IgnoredExtensions, ext)
+}
+
+func (r *pathRules) ignoresDirectory(name string) bool {
+	return r != nil && slices.Contains(r.IgnoredDirectories, name)
+}
+
+func applyPathRules(rules []*pathRule, path string) string {
+	for _, rule := range rules {
+		path = rule.find.ReplaceAllString(path, rule.Replace)
+	}
+	return path
+}
@@ -0,0 +1,28 @@
+package main
+
+// Cloud sync clients that stream files on demand (Drive for Desktop's
+// streaming mode, iCloud, OneDrive) leave placeholders on disk for files that
+// haven't been downloaded. Placeholders report the file's full size, but
+// reading one downloads it, so with --skip-placeholders they aren't hashed.
+// isCloudPlaceholder is implemented per platform.
+
+// separatePlaceholders moves content mismatches whose local file is an
+// unhashed placeholder of the same size as the remote file to Placeholders;
+// they don't count towards Misses. Placeholders of a different size are left
+// as content mismatches.
+func (mc *ManifestComparison) separatePlaceholders() {
+	var mismatched []*FilePair
+	for _, pair := range mc.ContentMismatch {
+		if isUnhashedPlaceholder(pair.Remote, pair.Local) {
+			mc.Placeholders = append(mc.Placeholders, pair)
+			mc.Misses--
+		} else {
+			mismatched = append(mismatched, pair)
+		}
+	}
+	mc.ContentMismatch = mismatched
+}
+
+func isUnhashedPlaceholder(remote, local *File) bool {
+	return local.Placeholder && local.ContentHash == "" && local.Size == remote.Size
+}
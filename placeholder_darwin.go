@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// SF_DATALESS marks a file whose contents are fetched on first access
+const sfDataless = 0x40000000
+
+func isCloudPlaceholder(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Flags&sfDataless != 0
+}
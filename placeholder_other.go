@@ -0,0 +1,11 @@
+//go:build !darwin && !windows
+
+package main
+
+import "os"
+
+// isCloudPlaceholder always returns false; there's no common placeholder
+// attribute on other platforms
+func isCloudPlaceholder(info os.FileInfo) bool {
+	return false
+}
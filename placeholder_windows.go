@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Attributes set on cloud files (reparse points managed by the Cloud Files
+// API) whose contents aren't on disk
+const (
+	fileAttributeOffline            = 0x1000
+	fileAttributeRecallOnOpen       = 0x40000
+	fileAttributeRecallOnDataAccess = 0x400000
+)
+
+func isCloudPlaceholder(info os.FileInfo) bool {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&(fileAttributeOffline|fileAttributeRecallOnOpen|fileAttributeRecallOnDataAccess) != 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// problemStatuses are the statuses that count towards Misses, plus errors
+var problemStatuses = map[FileStatus]bool{
+	StatusOnlyLocal:        true,
+	StatusOnlyRemote:       true,
+	StatusOnlyRemoteFolder: true,
+	StatusOnlyLocalFolder:  true,
+	StatusMismatch:         true,
+	StatusEmptyLocal:       true,
+	StatusDeleted:          true,
+	StatusSpotCheck:        true,
+	StatusError:            true,
+}
+
+// previousRuns records the problem paths found by the last run of each
+// configuration, persisted between runs for --diff-previous
+type previousRuns struct {
+	path     string
+	Problems map[string]map[string]FileStatus `json:"problems"`
+}
+
+func loadPreviousRuns(statePath string) (*previousRuns, error) {
+	state := &previousRuns{path: statePath, Problems: make(map[string]map[string]FileStatus)}
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Unable to parse previous run state %s: %v", statePath, err)
+	}
+	if state.Problems == nil {
+		state.Problems = make(map[string]map[string]FileStatus)
+	}
+	return state, nil
+}
+
+// record replaces the problems stored for key with those in mc
+func (r *previousRuns) record(key string, mc *ManifestComparison) error {
+	r.Problems[key] = problemPaths(mc)
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0600)
+}
+
+func problemPaths(mc *ManifestComparison) map[string]FileStatus {
+	problems := make(map[string]FileStatus)
+	for _, result := range mc.Results() {
+		if problemStatuses[result.Status] {
+			problems[result.Path] = result.Status
+		}
+	}
+	return problems
+}
+
+// runDiff compares the problems found by this run with the last one
+type runDiff struct {
+	// New holds problems that weren't problems last time, or had a
+	// different status
+	New []*FileResult
+	// Previous holds the earlier status of paths in New, if any
+	Previous map[string]FileStatus
+	// Resolved holds paths that were problems last time but aren't now
+	Resolved map[string]FileStatus
+}
+
+func diffPrevious(previous map[string]FileStatus, mc *ManifestComparison) *runDiff {
+	diff := &runDiff{Previous: make(map[string]FileStatus), Resolved: make(map[string]FileStatus)}
+	current := make(map[string]bool)
+	for _, result := range mc.Results() {
+		if !problemStatuses[result.Status] {
+			continue
+		}
+		current[result.Path] = true
+		status, ok := previous[result.Path]
+		if !ok || status != result.Status {
+			diff.New = append(diff.New, result)
+			if ok {
+				diff.Previous[result.Path] = status
+			}
+		}
+	}
+	for filePath, status := range previous {
+		if !current[filePath] {
+			diff.Resolved[filePath] = status
+		}
+	}
+	sort.Slice(diff.New, func(i, j int) bool { return diff.New[i].Path < diff.New[j].Path })
+	return diff
+}
+
+func (d *runDiff) Print() {
+	fmt.Printf("New problems since the last run: %d\n\n", len(d.New))
+	for _, result := range d.New {
+		if previous, ok := d.Previous[result.Path]; ok {
+			fmt.Printf("%s (%s, was %s)\n", result.Path, result.Status, previous)
+		} else {
+			fmt.Printf("%s (%s)\n", result.Path, result.Status)
+		}
+	}
+	if len(d.New) > 0 {
+		fmt.Print("\n\n")
+	}
+
+	fmt.Printf("Resolved since the last run: %d\n\n", len(d.Resolved))
+	resolved := make([]string, 0, len(d.Resolved))
+	for filePath := range d.Resolved {
+		resolved = append(resolved, filePath)
+	}
+	sort.Strings(resolved)
+	for _, filePath := range resolved {
+		fmt.Printf("%s (was %s)\n", filePath, d.Resolved[filePath])
+	}
+	if len(resolved) > 0 {
+		fmt.Print("\n\n")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often progress is redrawn on the terminal
+const progressInterval = 200 * time.Millisecond
+
+// scanProgress aggregates progress from the concurrent remote and local
+// scans. The scans update atomic counters, so they never wait on whoever is
+// displaying progress; readers poll Snapshot until Done is closed, after
+// which the counts are final.
+type scanProgress struct {
+	remoteListed int64
+	remoteFiles  int64
+	localFiles   int64
+	localErrored int64
+	localBytes   int64
+
+	remoteDone     chan struct{}
+	remoteDoneOnce sync.Once
+	done           chan struct{}
+	doneOnce       sync.Once
+}
+
+// progressTotals is a snapshot of scanProgress
+type progressTotals struct {
+	// RemoteListed counts files listed in Google Drive, before filtering
+	RemoteListed int
+	// RemoteFiles counts remote files kept for comparison, once the remote
+	// scan is done
+	RemoteFiles      int
+	LocalFiles       int
+	LocalErrored     int
+	LocalBytesHashed int64
+}
+
+func newScanProgress() *scanProgress {
+	return &scanProgress{remoteDone: make(chan struct{}), done: make(chan struct{})}
+}
+
+// setRemoteListed records the number of remote files listed so far
+func (p *scanProgress) setRemoteListed(count int) {
+	atomic.StoreInt64(&p.remoteListed, int64(count))
+}
+
+// finishRemote records the number of remote files kept for comparison and
+// closes RemoteDone
+func (p *scanProgress) finishRemote(files int) {
+	atomic.StoreInt64(&p.remoteFiles, int64(files))
+	p.remoteDoneOnce.Do(func() { close(p.remoteDone) })
+}
+
+// addLocal records scanned local files and the bytes hashed for them
+func (p *scanProgress) addLocal(files int, bytesHashed int64) {
+	atomic.AddInt64(&p.localFiles, int64(files))
+	atomic.AddInt64(&p.localBytes, bytesHashed)
+}
+
+// addLocalErrors records local files that couldn't be read
+func (p *scanProgress) addLocalErrors(count int) {
+	atomic.AddInt64(&p.localErrored, int64(count))
+}
+
+// finish marks both scans as complete, closing Done, and returns the final
+// counts
+func (p *scanProgress) finish() progressTotals {
+	p.remoteDoneOnce.Do(func() { close(p.remoteDone) })
+	p.doneOnce.Do(func() { close(p.done) })
+	return p.Snapshot()
+}
+
+func (p *scanProgress) Snapshot() progressTotals {
+	return progressTotals{
+		RemoteListed:     int(atomic.LoadInt64(&p.remoteListed)),
+		RemoteFiles:      int(atomic.LoadInt64(&p.remoteFiles)),
+		LocalFiles:       int(atomic.LoadInt64(&p.localFiles)),
+		LocalErrored:     int(atomic.LoadInt64(&p.localErrored)),
+		LocalBytesHashed: atomic.LoadInt64(&p.localBytes),
+	}
+}
+
+// RemoteDone is closed once the remote scan has finished
+func (p *scanProgress) RemoteDone() <-chan struct{} {
+	return p.remoteDone
+}
+
+// Done is closed once both scans have finished
+func (p *scanProgress) Done() <-chan struct{} {
+	return p.done
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// RemoteProvider lists the files under a folder of a cloud storage service,
+// with paths relative to that folder (lowercased and normalized in Path, as
+// named in RawPath). ContentHash is the checksum the service keeps, which
+// local files are hashed with HashAlgo to compare against.
+type RemoteProvider interface {
+	// Name describes the service in output, e.g. "Google Drive"
+	Name() string
+	HashAlgo() hashAlgorithm
+	// List calls add with each file as it's listed, stopping at the first
+	// error add returns
+	List(ctx context.Context, root string, add func(*File) error) error
+}
+
+// providerOptions holds the credentials and settings for every provider
+// other than Google Drive, which is set up separately
+type providerOptions struct {
+	DropboxToken   string
+	S3Endpoint     string
+	S3Region       string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3SessionToken string
+	GCSCredentials string
+}
+
+// newRemoteProvider returns the provider selected by name, or nil for Google
+// Drive, which verification sets up itself since it does more than list
+func newRemoteProvider(name string, opts providerOptions) (RemoteProvider, error) {
+	switch name {
+	case "", "google-drive":
+		return nil, nil
+	case "dropbox":
+		if opts.DropboxToken == "" {
+			return nil, fmt.Errorf("Dropbox needs an access token (--dropbox-token or DROPBOX_TOKEN)")
+		}
+		return newDropboxProvider(opts.DropboxToken), nil
+	case "s3":
+		return newS3Provider(opts)
+	case "gcs":
+		return newGCSProvider(opts), nil
+	default:
+		return nil, fmt.Errorf("Unknown provider %q (expected google-drive, dropbox, s3 or gcs)", name)
+	}
+}
+
+// driveProvider lists Google Drive with a DriveListing
+type driveProvider struct {
+	listing *DriveListing
+}
+
+func (p *driveProvider) Name() string {
+	return "Google Drive"
+}
+
+func (p *driveProvider) HashAlgo() hashAlgorithm {
+	return p.listing.HashAlgo
+}
+
+func (p *driveProvider) List(ctx context.Context, root string, add func(*File) error) error {
+	p.listing.Context = ctx
+	p.listing.RootPath = root
+	err := p.listing.Each(nil, add)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Unable to list Google Drive folder %s: %w", root, err)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// providerRequest makes a request to another provider's HTTP API, built
+// afresh by newRequest for each attempt, and returns the response and its
+// body once one succeeds. Like DriveListing.call, it retries rate limits,
+// server errors and network failures with exponential backoff and jitter,
+// waiting instead for as long as a Retry-After header asks. Other failed
+// responses are returned as errors immediately, as is ctx's error once it's
+// done. service names the API in warnings.
+func providerRequest(ctx context.Context, client *http.Client, service string, newRequest func() (*http.Request, error)) (*http.Response, []byte, error) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := client.Do(req)
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		var delay time.Duration
+		if err == nil {
+			if resp.StatusCode == http.StatusOK {
+				return resp, data, nil
+			}
+			retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+			err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+			if !retryable {
+				return nil, nil, err
+			}
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+		} else if ctx.Err() != nil || errorCategory(err).permanent() {
+			return nil, nil, err
+		}
+		if attempt >= apiRetries {
+			return nil, nil, err
+		}
+		if delay <= 0 {
+			// jitter keeps concurrent retries from synchronizing
+			delay = time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		}
+		logger.Warn("retrying "+service+" request", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
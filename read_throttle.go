@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Largest single read passed through a throttledReader, so that throttling
+// stays smooth at low rates
+const throttleChunkSize = 64 * 1024
+
+// byteRateLimiter is a token bucket shared by every file being hashed, so the
+// total read rate stays under the limit regardless of worker count
+type byteRateLimiter struct {
+	lock sync.Mutex
+	// bytes per second
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// parseReadRate parses rates like "50MB/s" or "1.5GiB" (per second)
+func parseReadRate(s string) (*byteRateLimiter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	rate, err := humanize.ParseBytes(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid read rate %q: %v", s, err)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	return &byteRateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}, nil
+}
+
+// wait accounts for n bytes read, sleeping as long as needed to keep the
+// average rate under the limit. The bucket holds at most one second of reads.
+func (l *byteRateLimiter) wait(n int) {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+	time.Sleep(delay)
+}
+
+func (l *byteRateLimiter) String() string {
+	return humanize.Bytes(uint64(l.rate)) + "/s"
+}
+
+// throttledReader limits reads through a shared byteRateLimiter
+type throttledReader struct {
+	r       io.Reader
+	limiter *byteRateLimiter
+}
+
+// throttle wraps r if limiter is set
+func throttle(r io.Reader, limiter *byteRateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: limiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := t.r.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
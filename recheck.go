@@ -0,0 +1,135 @@
+package main
+
+import (
+	"path"
+	"sort"
+)
+
+// RecheckResult summarizes a re-listing of remote folders with discrepancies
+type RecheckResult struct {
+	Folders  int
+	Resolved int
+}
+
+// discrepancyFolders returns the relative directories containing files that
+// are only on one side or whose contents don't match
+func (mc *ManifestComparison) discrepancyFolders() []string {
+	dirs := make(map[string]bool)
+	for _, file := range mc.OnlyRemote {
+		dirs[path.Dir(file.Path)] = true
+	}
+	for _, file := range mc.OnlyLocal {
+		dirs[path.Dir(file.Path)] = true
+	}
+	for _, pair := range mc.ContentMismatch {
+		dirs[path.Dir(pair.Local.Path)] = true
+	}
+	var result []string
+	for dir := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// RecheckRemote re-lists the remote folders that contain discrepancies and
+// updates the comparison with their current contents. This catches files that
+// changed on Drive while the full listing was in progress. Nothing is
+// rechecked if more than maxFolders folders are affected. Folders that
+// aren't found (e.g. ones only on the local side) are skipped, leaving their
+// files as they were.
+func (mc *ManifestComparison) RecheckRemote(listing *DriveListing, rules *pathRules, maxFolders int) (*RecheckResult, error) {
+	result := &RecheckResult{}
+	dirs := mc.discrepancyFolders()
+	if len(dirs) == 0 || len(dirs) > maxFolders {
+		return result, nil
+	}
+
+	fresh := make(map[string]*File)
+	skipped := make(map[string]bool)
+	for _, dir := range dirs {
+		files, err := listing.ListFolder(dir, rules)
+		if errorCategory(err) == CategoryNotFound {
+			logger.Debug("skipped recheck of folder", "path", dir, "reason", "not found")
+			skipped[dir] = true
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		for _, file := range files {
+			if prepareRemoteFile(file, rules) {
+				fresh[file.Path] = file
+			}
+		}
+		result.Folders++
+	}
+
+	missesBefore := mc.Misses
+
+	// remote-only files that no longer exist were removed during the scan
+	var onlyRemote []*File
+	for _, file := range mc.OnlyRemote {
+		if skipped[path.Dir(file.Path)] {
+			onlyRemote = append(onlyRemote, file)
+		} else if current, ok := fresh[file.Path]; ok {
+			onlyRemote = append(onlyRemote, current)
+		} else {
+			mc.Misses--
+		}
+	}
+	mc.OnlyRemote = onlyRemote
+
+	// local-only files may have finished uploading during the scan
+	var onlyLocal []*File
+	for _, file := range mc.OnlyLocal {
+		current, ok := fresh[file.Path]
+		if !ok {
+			onlyLocal = append(onlyLocal, file)
+			continue
+		}
+		pair := &FilePair{Remote: current, Local: file}
+		if mc.strategies.matches(current, file) {
+			mc.recordRecheckedMatch(pair)
+			// counted in Misses once as only-local
+			mc.Misses--
+		} else {
+			mc.ContentMismatch = append(mc.ContentMismatch, pair)
+		}
+	}
+	mc.OnlyLocal = onlyLocal
+
+	var mismatched []*FilePair
+	for _, pair := range mc.ContentMismatch {
+		if skipped[path.Dir(pair.Local.Path)] {
+			mismatched = append(mismatched, pair)
+			continue
+		}
+		current, ok := fresh[pair.Remote.Path]
+		if !ok {
+			mc.OnlyLocal = append(mc.OnlyLocal, pair.Local)
+			continue
+		}
+		pair.Remote = current
+		if mc.strategies.matches(current, pair.Local) {
+			mc.recordRecheckedMatch(pair)
+			mc.Misses--
+		} else {
+			mismatched = append(mismatched, pair)
+		}
+	}
+	mc.ContentMismatch = mismatched
+	mc.separateEmptyLocal()
+	mc.separateHashUnavailable()
+	mc.separatePlaceholders()
+
+	result.Resolved = missesBefore - mc.Misses
+	return result, nil
+}
+
+func (mc *ManifestComparison) recordRecheckedMatch(pair *FilePair) {
+	mc.Matches++
+	mc.MatchedBytes += pair.Remote.Size
+	if mc.recordMatches {
+		mc.Matched = append(mc.Matched, pair)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// remoteCache keeps the last Google Drive listing for each combination of
+// listing settings, so verifications within TTL of it (e.g. of several local
+// roots against one Drive folder) don't list Drive again
+type remoteCache struct {
+	Dir string
+	TTL time.Duration
+	// Refresh lists Drive even if the cached listing is fresh, replacing it
+	Refresh bool
+}
+
+// remoteCacheKey is everything that changes what's listed, before the
+// filtering that's also applied to loaded manifests
+type remoteCacheKey struct {
+	Root             string   `json:"root"`
+	RootId           string   `json:"rootId,omitempty"`
+	Query            string   `json:"query,omitempty"`
+	Dirs             []string `json:"dirs,omitempty"`
+	HashAlgo         string   `json:"hashAlgo"`
+	Spaces           string   `json:"spaces"`
+	OwnedOnly        bool     `json:"ownedOnly,omitempty"`
+	IncludeShared    bool     `json:"includeShared,omitempty"`
+	SkipGooglePhotos bool     `json:"skipGooglePhotos,omitempty"`
+	ResolveShortcuts bool     `json:"resolveShortcuts,omitempty"`
+	ExcludeRemote    []string `json:"excludeRemote,omitempty"`
+	ExcludeRemoteIds []string `json:"excludeRemoteIds,omitempty"`
+}
+
+// path returns where the listing for config is cached
+func (c *remoteCache) path(config *verifyConfig) string {
+	var exclude, excludeIds []string
+	if config.ExcludeRemote != nil {
+		exclude, excludeIds = config.ExcludeRemote.patterns, config.ExcludeRemote.ids
+	}
+	key, _ := json.Marshal(&remoteCacheKey{
+		Root:             config.RemoteRoot,
+		RootId:           config.RemoteRootId,
+		Query:            config.RemoteQuery,
+		Dirs:             config.LocalDirs,
+		HashAlgo:         config.Local.HashAlgo.String(),
+		Spaces:           config.Spaces,
+		OwnedOnly:        config.OwnedOnly,
+		IncludeShared:    config.IncludeShared,
+		SkipGooglePhotos: config.SkipGooglePhotos,
+		ResolveShortcuts: config.ResolveShortcuts,
+		ExcludeRemote:    exclude,
+		ExcludeRemoteIds: excludeIds,
+	})
+	sum := sha256.Sum256(key)
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:8])+".jsonl.gz")
+}
+
+// lookup returns the cached listing for config if it's younger than TTL
+func (c *remoteCache) lookup(config *verifyConfig) (path string, ok bool) {
+	if c.Refresh {
+		return "", false
+	}
+	path = c.path(config)
+	header, _, closeFile, err := openManifestFile(path)
+	if os.IsNotExist(err) {
+		return "", false
+	} else if err != nil {
+		logger.Warn("ignoring unreadable remote cache", "path", path, "error", err)
+		return "", false
+	}
+	closeFile()
+	age := time.Since(header.Created)
+	if age < 0 || age >= c.TTL {
+		logger.Info("remote cache expired", "path", path, "age", age.Round(time.Second))
+		return "", false
+	}
+	logger.Info("reusing cached remote listing", "path", path, "age", age.Round(time.Second))
+	return path, true
+}
+
+// create starts replacing the cached listing for config. Until it's
+// committed, the old one stays in place.
+func (c *remoteCache) create(config *verifyConfig) (*remoteManifestFile, error) {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return createRemoteManifestFile(c.path(config), config)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// repairActions describes how to fix each kind of problem. The verifier only
+// has read access to Google Drive, so repair prints a plan rather than
+// changing anything.
+var repairActions = []struct {
+	Status FileStatus
+	Action string
+}{
+	{StatusOnlyRemote, "Download from Google Drive (missing locally)"},
+	{StatusOnlyLocal, "Upload to Google Drive (missing remotely)"},
+	{StatusOnlyRemoteFolder, "Create the empty folder locally"},
+	{StatusOnlyLocalFolder, "Create the empty folder in Google Drive, or delete it locally"},
+	{StatusDeleted, "Delete locally or restore from Drive's trash"},
+	{StatusMismatch, "Re-sync; keep whichever copy is correct"},
+	{StatusEmptyLocal, "Re-download from Google Drive (local copy is empty)"},
+	{StatusSpotCheck, "Compare by hand; checksums match but contents differ"},
+	{StatusError, "Check the local file is readable"},
+}
+
+// runRepair reads the results of a previous verification (written with
+// --json) and lists the actions needed to bring the local copy back in sync.
+// It returns the process exit code.
+func runRepair(args []string) int {
+	var opts struct {
+		Status []string `long:"status" description:"Only list files with this status (e.g. only-remote); can be given more than once"`
+	}
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "repair [OPTIONS] RESULTS-JSON"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "repair needs the JSON results of a verification (see --json)")
+		return 1
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read results: %v\n", err)
+		return 1
+	}
+	report := &jsonReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to parse %s: %v\n", args[0], err)
+		return 1
+	}
+
+	selected := make(map[FileStatus]bool)
+	for _, status := range opts.Status {
+		selected[FileStatus(status)] = true
+	}
+	byStatus := make(map[FileStatus][]*jsonFileResult)
+	for _, file := range report.Files {
+		byStatus[file.Status] = append(byStatus[file.Status], file)
+	}
+
+	planned := 0
+	for _, repair := range repairActions {
+		files := byStatus[repair.Status]
+		if len(files) == 0 || (len(selected) > 0 && !selected[repair.Status]) {
+			continue
+		}
+		fmt.Printf("%s: %d\n", repair.Action, len(files))
+		for _, file := range files {
+			fmt.Printf("  %s\n", repairPath(file))
+			if file.Error != "" {
+				fmt.Printf("    error: %s\n", file.Error)
+			}
+		}
+		fmt.Println("")
+		planned += len(files)
+	}
+	if planned == 0 {
+		fmt.Println("Nothing to repair.")
+	}
+	return 0
+}
+
+// repairPath prefers the path as named on whichever side has the file
+func repairPath(file *jsonFileResult) string {
+	if file.RemotePath != "" {
+		return file.RemotePath
+	}
+	if file.LocalPath != "" {
+		return file.LocalPath
+	}
+	return file.Path
+}
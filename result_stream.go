@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// resultStream writes results as NDJSON, one object per line, so large runs
+// can be piped into jq or a database as they go. Matches are written as soon
+// as they're found, and so are mismatched pairs when nothing later in the
+// run revisits them; everything else is written once verification finishes,
+// since files only on one side may still turn out to be possible matches,
+// known issues and so on. A spot check failure is written again after its
+// match, and the later line wins. The last line is a summary.
+type resultStream struct {
+	enc *json.Encoder
+	err error
+	// mismatches holds the local files of the mismatched pairs already
+	// written, which finish leaves out (nil if they're left to finish)
+	mismatches map[*File]bool
+}
+
+type streamFileRecord struct {
+	Type string `json:"type"`
+	*jsonFileResult
+}
+
+type streamSummaryRecord struct {
+	Type             string             `json:"type"`
+	Success          bool               `json:"success"`
+	Suspect          bool               `json:"suspect"`
+	Partial          bool               `json:"partial,omitempty"`
+	RemoteIncomplete bool               `json:"remoteIncomplete,omitempty"`
+	Matches          int                `json:"matches"`
+	Misses           int                `json:"misses"`
+	Score            *verificationScore `json:"score"`
+}
+
+func newResultStream(w io.Writer) *resultStream {
+	return &resultStream{enc: json.NewEncoder(w)}
+}
+
+func (s *resultStream) write(record interface{}) {
+	if s.err == nil {
+		s.err = s.enc.Encode(record)
+	}
+}
+
+// match writes a matched file as it's found during comparison
+func (s *resultStream) match(remote, local *File) {
+	s.write(&streamFileRecord{Type: "file", jsonFileResult: newJSONFileResult(&FileResult{Path: local.Path, Status: StatusMatch, Remote: remote, Local: local})})
+}
+
+// mismatch returns a callback writing mismatched pairs as they're found
+// during comparison, acknowledged if b (which may be nil) covers them, and
+// leaves them out of finish
+func (s *resultStream) mismatch(b *baseline) func(result *FileResult) {
+	s.mismatches = make(map[*File]bool)
+	return func(result *FileResult) {
+		if b != nil && b.covers(result) {
+			acknowledged := *result
+			acknowledged.Status = StatusAcknowledged
+			result = &acknowledged
+		}
+		s.mismatches[result.Local] = true
+		s.write(&streamFileRecord{Type: "file", jsonFileResult: newJSONFileResult(result)})
+	}
+}
+
+// written reports whether result was already written during comparison
+func (s *resultStream) written(result *FileResult) bool {
+	switch result.Status {
+	case StatusMatch:
+		return true
+	case StatusMismatch, StatusEmptyLocal, StatusHashUnavailable, StatusPlaceholder, StatusAcknowledged:
+		return result.Remote != nil && s.mismatches[result.Local]
+	}
+	return false
+}
+
+// finish writes the rest of the results and the summary, returning the first
+// error writing any of them
+func (s *resultStream) finish(mc *ManifestComparison) error {
+	for _, result := range mc.Results() {
+		if !s.written(result) {
+			s.write(&streamFileRecord{Type: "file", jsonFileResult: newJSONFileResult(result)})
+		}
+	}
+	s.write(&streamSummaryRecord{
+		Type:             "summary",
+		Success:          mc.IsSuccessful(),
+		Suspect:          mc.IsSuspect(),
+		Partial:          mc.Partial,
+		RemoteIncomplete: mc.RemoteIncomplete,
+		Matches:          mc.Matches,
+		Misses:           mc.Misses,
+		Score:            mc.Score(),
+	})
+	return s.err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// findMatchingRevision looks for a revision of a Drive file with the given
+// MD5 checksum, returning nil if there is none. Drive only keeps MD5
+// checksums for revisions.
+func (g *DriveListing) findMatchingRevision(fileId string, md5 string) (*drive.Revision, error) {
+	nextPageToken := ""
+	for {
+		var result *drive.RevisionList
+		err := g.call(func(ctx context.Context) (err error) {
+			result, err = g.service.Revisions.List(fileId).
+				PageToken(nextPageToken).
+				PageSize(1000).
+				Fields("nextPageToken, revisions(id, md5Checksum, modifiedTime)").
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range result.Revisions {
+			if revision.Md5Checksum == md5 {
+				return revision, nil
+			}
+		}
+		nextPageToken = result.NextPageToken
+		if nextPageToken == "" {
+			return nil, nil
+		}
+	}
+}
+
+// FindStaleRevisions looks up the remote revisions of each content mismatch,
+// marking those whose local copy matches an earlier revision: the local file
+// is out of date rather than corrupted. Each file takes at least one API call,
+// so nothing is looked up if there are more than maxFiles mismatches. Stale
+// files still count as misses.
+func (mc *ManifestComparison) FindStaleRevisions(listing *DriveListing, maxFiles int) error {
+	if len(mc.ContentMismatch) == 0 || len(mc.ContentMismatch) > maxFiles {
+		return nil
+	}
+	for _, pair := range mc.ContentMismatch {
+		if pair.Remote.Id == "" || pair.Local.ContentHash == "" {
+			continue
+		}
+		revision, err := listing.findMatchingRevision(pair.Remote.Id, pair.Local.ContentHash)
+		if err != nil {
+			return err
+		}
+		if revision != nil {
+			// a missing or malformed time is left as the zero value
+			pair.StaleRevision, _ = time.Parse(time.RFC3339, revision.ModifiedTime)
+			pair.Stale = true
+			mc.Stale++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// shardFilter deterministically partitions files into Count shards by their
+// parent folder, so that files whose names only differ slightly (possible
+// matches) always land in the same shard
+type shardFilter struct {
+	Index int
+	Count int
+}
+
+// includes reports whether a normalized manifest path belongs to this shard
+func (s *shardFilter) includes(filePath string) bool {
+	if s == nil || s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(path.Dir(filePath)))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+func (s *shardFilter) String() string {
+	return fmt.Sprintf("%d/%d", s.Index+1, s.Count)
+}
+
+// rotationState records the next shard to verify for each rotation
+// configuration, persisted between runs
+type rotationState struct {
+	path string
+	Next map[string]int `json:"next"`
+}
+
+func loadRotationState(statePath string) (*rotationState, error) {
+	state := &rotationState{path: statePath, Next: make(map[string]int)}
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Unable to parse rotation state %s: %v", statePath, err)
+	}
+	if state.Next == nil {
+		state.Next = make(map[string]int)
+	}
+	return state, nil
+}
+
+func rotationKey(remoteRoot string, localRoot string, count int) string {
+	return fmt.Sprintf("%s|%s|%d", remoteRoot, localRoot, count)
+}
+
+// shard returns the shard to verify for this run
+func (r *rotationState) shard(key string, count int) *shardFilter {
+	return &shardFilter{Index: r.Next[key] % count, Count: count}
+}
+
+// advance records that a shard was verified so the next run moves on
+func (r *rotationState) advance(key string, shard *shardFilter) error {
+	r.Next[key] = (shard.Index + 1) % shard.Count
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0600)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// JSON-RPC 2.0 server over line-delimited stdin/stdout, so GUI front-ends and
+// other languages can drive verification.
+//
+// Methods:
+//   verify   - params: rpcVerifyParams, result: the same document as --json
+//   shutdown - stops the server
+//
+// While a verification runs, "progress" notifications are sent with the
+// request id and current scan counts.
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcVerifyFailed   = -32000
+)
+
+// Time between progress notifications for a request
+const rpcProgressInterval = 250 * time.Millisecond
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcVerifyParams struct {
+	Remote           string   `json:"remote"`
+	RemoteId         string   `json:"remoteId"`
+	RemoteQuery      string   `json:"remoteQuery"`
+	Local            string   `json:"local"`
+	Selective        bool     `json:"selective"`
+	SelectiveDepth   int      `json:"selectiveDepth"`
+	SelectiveList    string   `json:"selectiveList"`
+	SkipHash         bool     `json:"skipHash"`
+	Workers          *int     `json:"workers"`
+	IOConcurrency    int      `json:"ioConcurrency"`
+	WalkConcurrency  *int     `json:"walkConcurrency"`
+	MaxReadRate      string   `json:"maxReadRate"`
+	FollowSymlinks   bool     `json:"followSymlinks"`
+	HashTimeout      string   `json:"hashTimeout"`
+	HashAlgo         string   `json:"hashAlgo"`
+	Synology         bool     `json:"synology"`
+	Client           string   `json:"client"`
+	Presets          []string `json:"presets"`
+	ResolveShortcuts bool     `json:"resolveShortcuts"`
+	RecheckFolders   *int     `json:"recheckFolders"`
+}
+
+type rpcProgress struct {
+	RequestID json.RawMessage `json:"requestId"`
+	Remote    int             `json:"remote"`
+	Local     int             `json:"local"`
+	Errored   int             `json:"errored"`
+}
+
+type rpcServer struct {
+	srv     *drive.Service
+	out     *json.Encoder
+	outLock sync.Mutex
+}
+
+// serveRPC handles requests from in until EOF or a shutdown request
+func serveRPC(srv *drive.Service, in io.Reader, out io.Writer) error {
+	server := &rpcServer{srv: srv, out: json.NewEncoder(out)}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			server.sendError(json.RawMessage("null"), rpcParseError, err.Error())
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			server.sendError(req.ID, rpcInvalidRequest, "expected a JSON-RPC 2.0 request")
+			continue
+		}
+
+		switch req.Method {
+		case "verify":
+			server.handleVerify(&req)
+		case "shutdown":
+			server.sendResult(req.ID, true)
+			return nil
+		default:
+			server.sendError(req.ID, rpcMethodNotFound, fmt.Sprintf("unknown method %q", req.Method))
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *rpcServer) handleVerify(req *rpcRequest) {
+	params := rpcVerifyParams{}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			s.sendError(req.ID, rpcInvalidParams, err.Error())
+			return
+		}
+	}
+	config, err := params.config()
+	if err != nil {
+		s.sendError(req.ID, rpcInvalidParams, err.Error())
+		return
+	}
+
+	progress := newScanProgress()
+	progressDone := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(rpcProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sendNotification("progress", newRPCProgress(req.ID, progress.Snapshot()))
+			case <-progress.Done():
+				s.sendNotification("progress", newRPCProgress(req.ID, progress.Snapshot()))
+				close(progressDone)
+				return
+			}
+		}
+	}()
+
+	result, err := runVerification(context.Background(), s.srv, config, progress)
+	// make sure the final notification is sent even if verification failed
+	progress.finish()
+	<-progressDone
+	if err != nil {
+		s.sendError(req.ID, rpcVerifyFailed, err.Error())
+		return
+	}
+	s.sendResult(req.ID, result.Comparison.JSONReport(result.Stats))
+}
+
+func newRPCProgress(id json.RawMessage, totals progressTotals) *rpcProgress {
+	return &rpcProgress{RequestID: id, Remote: totals.RemoteListed, Local: totals.LocalFiles, Errored: totals.LocalErrored}
+}
+
+func (p *rpcVerifyParams) config() (*verifyConfig, error) {
+	if p.Local == "" {
+		return nil, fmt.Errorf("missing required param \"local\"")
+	}
+	selection := selectiveSync{Depth: p.SelectiveDepth, ListFile: p.SelectiveList}
+	if p.Selective && selection.Depth == 0 {
+		selection.Depth = 1
+	}
+	remoteArg := p.Remote
+	if p.RemoteId != "" {
+		if p.Remote != "" {
+			return nil, fmt.Errorf("only one of \"remote\" and \"remoteId\" can be given")
+		}
+		remoteArg = "/"
+	}
+	localRoot, remoteRoot, localDirs, err := resolveRoots(p.Local, remoteArg, selection)
+	if err != nil {
+		return nil, err
+	}
+	workers := 8
+	if p.Workers != nil {
+		workers = *p.Workers
+	}
+	walkConcurrency := 8
+	if p.WalkConcurrency != nil {
+		walkConcurrency = *p.WalkConcurrency
+	}
+	recheckFolders := 100
+	if p.RecheckFolders != nil {
+		recheckFolders = *p.RecheckFolders
+	}
+	var hashTimeout time.Duration
+	if p.HashTimeout != "" {
+		hashTimeout, err = time.ParseDuration(p.HashTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hashAlgo, err := parseHashAlgorithm(p.HashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	readLimiter, err := parseReadRate(p.MaxReadRate)
+	if err != nil {
+		return nil, err
+	}
+	client := p.Client
+	if client == "" {
+		client = defaultClient
+	}
+	rules, err := newPathRules(client, p.Presets, p.Synology, "")
+	if err != nil {
+		return nil, err
+	}
+	return &verifyConfig{
+		RemoteRoot:       remoteRoot,
+		RemoteRootId:     p.RemoteId,
+		RemoteQuery:      p.RemoteQuery,
+		LocalRoot:        localRoot,
+		LocalDirs:        localDirs,
+		ResolveShortcuts: p.ResolveShortcuts,
+		RecheckFolders:   recheckFolders,
+		RecordMatches:    true,
+		Local: localScanOptions{
+			SkipContentHash: p.SkipHash,
+			WorkerCount:     resolveWorkerCount(workers),
+			HashTimeout:     hashTimeout,
+			FollowSymlinks:  p.FollowSymlinks,
+			HashAlgo:        hashAlgo,
+			Spill:           spillConfig{Threshold: 100000},
+			IOConcurrency:   p.IOConcurrency,
+			WalkConcurrency: walkConcurrency,
+			ReadLimiter:     readLimiter,
+			PathRules:       rules,
+		},
+	}, nil
+}
+
+func (s *rpcServer) send(v interface{}) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	s.out.Encode(v)
+}
+
+func (s *rpcServer) sendResult(id json.RawMessage, result interface{}) {
+	s.send(&rpcResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *rpcServer) sendError(id json.RawMessage, code int, message string) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	s.send(&rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+func (s *rpcServer) sendNotification(method string, params interface{}) {
+	s.send(&rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
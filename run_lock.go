@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Verification takes a lock under the config directory, so that two runs
+// (e.g. from cron) don't hash the same disk at once. The lock is held by the
+// operating system (flock, fcntl where there's no flock, or LockFileEx on
+// Windows) for as long as the process runs, so it can't outlive a crashed or
+// killed run; the file itself is left behind and only says which run last
+// held it.
+
+// heldLock keeps the lock file open, and so locked, until the process exits
+var heldLock *os.File
+
+// lockHolder describes the run holding the lock
+type lockHolder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+func (h *lockHolder) String() string {
+	if h == nil {
+		return "another verification"
+	}
+	return fmt.Sprintf("another verification (process %d on %s, started %s)", h.PID, h.Host, h.Started.Format("2006-01-02 15:04:05"))
+}
+
+// lockedError is returned when another run holds the lock
+type lockedError struct {
+	holder *lockHolder
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("%s is already running; use --wait to wait for it to finish, or --force to run anyway", e.holder)
+}
+
+// acquireRunLock locks path for the rest of the process, waiting for another
+// run to release it if wait is set
+func acquireRunLock(path string, wait bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("Unable to create config directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to open lock file: %v", err)
+	}
+	locked, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Unable to lock %s: %v", path, err)
+	}
+	if !locked {
+		holder := readLockHolder(f)
+		if !wait {
+			f.Close()
+			return &lockedError{holder: holder}
+		}
+		fmt.Fprintf(os.Stderr, "Waiting for %s to finish...\n", holder)
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("Unable to lock %s: %v", path, err)
+		}
+	} else if previous := readLockHolder(f); previous != nil && previous.PID != os.Getpid() {
+		logger.Debug("previous run's lock was released", "pid", previous.PID, "host", previous.Host, "started", previous.Started)
+	}
+
+	host, _ := os.Hostname()
+	data, err := json.Marshal(&lockHolder{PID: os.Getpid(), Host: host, Started: time.Now()})
+	if err == nil {
+		if err = f.Truncate(0); err == nil {
+			_, err = f.WriteAt(data, 0)
+		}
+	}
+	if err != nil {
+		// the lock itself is what matters
+		logger.Warn("unable to record lock holder", "path", path, "error", err)
+	}
+	heldLock = f
+	return nil
+}
+
+// maxLockHolderSize is more than a lockHolder ever takes to record
+const maxLockHolderSize = 4096
+
+// readLockHolder reads who holds (or last held) the lock, or nil if unknown.
+// It reads through the lock file's own descriptor: opening the file again
+// and closing it would release a POSIX record lock held on it.
+func readLockHolder(f *os.File) *lockHolder {
+	data := make([]byte, maxLockHolderSize)
+	n, err := f.ReadAt(data, 0)
+	if (err != nil && err != io.EOF) || n == 0 {
+		return nil
+	}
+	holder := &lockHolder{}
+	if err := json.Unmarshal(data[:n], holder); err != nil {
+		return nil
+	}
+	return holder
+}
@@ -0,0 +1,38 @@
+//go:build aix || solaris
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// These platforms have no flock, so POSIX record locks over the whole file
+// are used instead. They're per process, which is all the run lock needs.
+
+// wholeFile is a write lock from the start to the end of the file (a Len of 0)
+var wholeFile = unix.Flock_t{Type: unix.F_WRLCK}
+
+// tryLockFile takes an exclusive lock on f, reporting false if another
+// process holds it
+func tryLockFile(f *os.File) (bool, error) {
+	lock := wholeFile
+	err := unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lock)
+	if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EACCES) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile takes an exclusive lock on f, waiting until it's free
+func lockFile(f *os.File) error {
+	for {
+		lock := wholeFile
+		err := unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &lock)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
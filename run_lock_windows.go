@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockFile takes an exclusive lock on f, reporting false if another
+// process holds it
+func tryLockFile(f *os.File) (bool, error) {
+	err := lockFileEx(f, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile takes an exclusive lock on f, waiting until it's free
+func lockFile(f *os.File) error {
+	return lockFileEx(f, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+func lockFileEx(f *os.File, flags uint32) error {
+	// Windows locks are mandatory, so lock a byte far past the end of the
+	// file, leaving the holder's details readable
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{OffsetHigh: 0x7fffffff})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// RunStats records where a verification run spent its time, to help tune
+// worker counts and spot slow phases
+type RunStats struct {
+	// RemoteListed counts remote files before filtering, RemoteFiles after
+	RemoteListed     int
+	RemoteFiles      int
+	RemoteAPICalls   int
+	RemoteDuration   time.Duration
+	LocalFiles       int
+	LocalErrored     int
+	LocalBytesHashed int64
+	// LocalHardLinks and LocalClones count local files whose hash was
+	// shared with another link to, or clone of, the same contents
+	LocalHardLinks int
+	LocalClones    int
+	// LocalNotHashed counts local files not hashed with --adaptive-hash
+	LocalNotHashed  int
+	LocalDuration   time.Duration
+	CompareDuration time.Duration
+	TotalDuration   time.Duration
+}
+
+func (s *RunStats) Print() {
+	fmt.Println("PERFORMANCE:")
+	fmt.Printf("Remote listing: %d files (%d listed), %d API calls in %s\n", s.RemoteFiles, s.RemoteListed, s.RemoteAPICalls, formatDuration(s.RemoteDuration))
+	fmt.Printf("Local scan: %d files, %d errored, %s hashed in %s (%s/s)\n",
+		s.LocalFiles,
+		s.LocalErrored,
+		humanize.Bytes(uint64(s.LocalBytesHashed)),
+		formatDuration(s.LocalDuration),
+		humanize.Bytes(uint64(perSecond(s.LocalBytesHashed, s.LocalDuration))),
+	)
+	if s.LocalHardLinks > 0 {
+		fmt.Printf("Hard links: %d files linked to ones already scanned, not read again\n", s.LocalHardLinks)
+	}
+	if s.LocalClones > 0 {
+		fmt.Printf("Clones: %d files sharing storage with ones already scanned, not read again\n", s.LocalClones)
+	}
+	if s.LocalNotHashed > 0 {
+		fmt.Printf("Adaptive hashing: %d files not read, their remote copies have no checksum\n", s.LocalNotHashed)
+	}
+	fmt.Printf("Comparison: %s\n", formatDuration(s.CompareDuration))
+	fmt.Printf("Total: %s\n", formatDuration(s.TotalDuration))
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func perSecond(count int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(count) / d.Seconds()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Provider lists a bucket or a prefix of it on S3 or an S3-compatible
+// service such as MinIO, comparing by MD5. Single-part uploads have their
+// MD5 as the ETag; multipart uploads only have one if the uploader stored it
+// in metadata, as rclone does.
+type s3Provider struct {
+	endpoint     *url.URL
+	pathStyle    bool
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Provider(opts providerOptions) (*s3Provider, error) {
+	region := opts.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := opts.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q", endpoint)
+	}
+	return &s3Provider{
+		endpoint: u,
+		// MinIO and most other S3-compatible services don't support
+		// bucket.host addressing
+		pathStyle:    !strings.HasSuffix(u.Hostname(), ".amazonaws.com"),
+		region:       region,
+		accessKey:    opts.S3AccessKey,
+		secretKey:    opts.S3SecretKey,
+		sessionToken: opts.S3SessionToken,
+		client:       &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (p *s3Provider) Name() string {
+	return "S3"
+}
+
+func (p *s3Provider) HashAlgo() hashAlgorithm {
+	return hashMD5
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+type s3ListResult struct {
+	Contents              []*s3Object `xml:"Contents"`
+	IsTruncated           bool        `xml:"IsTruncated"`
+	NextContinuationToken string      `xml:"NextContinuationToken"`
+}
+
+// List lists every object under root, given as /bucket/prefix
+func (p *s3Provider) List(ctx context.Context, root string, add func(*File) error) error {
+	bucket, prefix, _ := strings.Cut(strings.Trim(root, "/"), "/")
+	if bucket == "" {
+		return fmt.Errorf("S3 needs a bucket as the remote root, e.g. --remote mybucket/backups")
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	var withoutMD5 int
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		_, data, err := p.request(ctx, "GET", bucket, "", query)
+		if err != nil {
+			return fmt.Errorf("Unable to list S3 bucket %s: %v", bucket, err)
+		}
+		result := &s3ListResult{}
+		if err := xml.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("Unable to list S3 bucket %s: %v", bucket, err)
+		}
+		for _, object := range result.Contents {
+			// zero-length keys ending in / are folder markers
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			file := p.newFile(bucket, prefix, object)
+			if file.ContentHash == "" {
+				file.ContentHash, err = p.metadataMD5(ctx, bucket, object.Key)
+				if err != nil {
+					return err
+				}
+			}
+			if file.ContentHash == "" {
+				withoutMD5++
+			}
+			if err := add(file); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	if withoutMD5 > 0 {
+		logger.Warn("S3 multipart uploads have no MD5 unless uploaded by rclone, so only their presence will be checked", "count", withoutMD5)
+	}
+	return nil
+}
+
+// newFile makes a File for an object, with its ETag as ContentHash if it's
+// an MD5 (ETags of multipart uploads have a -partcount suffix)
+func (p *s3Provider) newFile(bucket, prefix string, object *s3Object) *File {
+	relPath := strings.TrimPrefix(object.Key, prefix)
+	file := &File{
+		Path:         strings.ToLower(normalizeUnicodeCharacters(relPath)),
+		RawPath:      relPath,
+		Size:         object.Size,
+		ModifiedTime: object.LastModified,
+	}
+	if etag := strings.Trim(object.ETag, `"`); len(etag) == 32 && !strings.Contains(etag, "-") {
+		file.ContentHash = etag
+	}
+	if !p.pathStyle {
+		file.WebLink = fmt.Sprintf("https://s3.console.aws.amazon.com/s3/object/%s?region=%s&prefix=%s", bucket, p.region, url.QueryEscape(object.Key))
+	}
+	return file
+}
+
+// metadataMD5 returns the MD5 rclone stores with multipart uploads, if any
+func (p *s3Provider) metadataMD5(ctx context.Context, bucket, key string) (string, error) {
+	resp, _, err := p.request(ctx, "HEAD", bucket, key, nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read S3 object %s: %v", key, err)
+	}
+	sum, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Amz-Meta-Md5chksum"))
+	if err != nil || len(sum) != 16 {
+		return "", nil
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// request makes a signed request for a bucket or an object in it, retrying
+// throttling, server errors and network failures
+func (p *s3Provider) request(ctx context.Context, method, bucket, key string, query url.Values) (*http.Response, []byte, error) {
+	u := *p.endpoint
+	if p.pathStyle {
+		u.Path = "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3Query(query)
+	return providerRequest(ctx, p.client, "S3", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		p.sign(req, time.Now().UTC())
+		return req, nil
+	})
+}
+
+// emptySHA256 is the hash of an empty request body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds an AWS Signature Version 4 authorization header to req
+func (p *s3Provider) sign(req *http.Request, now time.Time) {
+	if p.accessKey == "" {
+		// anonymous access to a public bucket
+		return
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + emptySHA256 + "\nx-amz-date:" + amzDate + "\n"
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + p.sessionToken + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	scope := date + "/" + p.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), date)
+	for _, part := range []string{p.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", p.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query encodes query parameters sorted by name with every reserved
+// character escaped, as signing requires
+func s3Query(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EscapePath escapes each segment of a path like s3Escape
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// z-score for the 95% confidence intervals of sample estimates
+const sampleConfidenceZ = 1.96
+
+// parseSample parses a --sample percentage such as 5% or 0.5
+func parseSample(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("Invalid sample percentage %q (expected e.g. 5%%)", value)
+	}
+	return percent, nil
+}
+
+// sampleFilter picks a random sample of Percent of files by path, the same
+// files on both sides and on every run with the same Seed. Unlike shards,
+// files are picked individually rather than by folder, so the sample isn't
+// skewed by a few large folders.
+type sampleFilter struct {
+	Percent float64
+	Seed    uint64
+}
+
+// includes reports whether a normalized manifest path is in the sample
+func (s *sampleFilter) includes(filePath string) bool {
+	if s == nil || s.Percent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], s.Seed)
+	h.Write(seed[:])
+	h.Write([]byte(filePath))
+	// in millionths, so fractions of a percent work
+	return float64(h.Sum64()%1000000) < s.Percent*10000
+}
+
+// newSampleFilter returns the filter for a --sample of percent, picking a
+// seed if none is given (nil if percent is 0)
+func newSampleFilter(percent float64, seed uint64) *sampleFilter {
+	if percent == 0 {
+		return nil
+	}
+	if seed == 0 {
+		seed = uint64(rand.Intn(999999)) + 1
+	}
+	return &sampleFilter{Percent: percent, Seed: seed}
+}
+
+func (s *sampleFilter) String() string {
+	return fmt.Sprintf("%s%% sample (seed %d)", strconv.FormatFloat(s.Percent, 'f', -1, 64), s.Seed)
+}
+
+// sampleEstimate extrapolates the results of a sample to every file, with a
+// 95% confidence interval for the rate of problems
+type sampleEstimate struct {
+	Percent  float64 `json:"percent"`
+	Seed     uint64  `json:"seed"`
+	Files    int     `json:"files"`
+	Problems int     `json:"problems"`
+	// Rate is the fraction of sampled files with problems, and RateLow and
+	// RateHigh bound the fraction across every file
+	Rate     float64 `json:"rate"`
+	RateLow  float64 `json:"rateLow"`
+	RateHigh float64 `json:"rateHigh"`
+	// EstimatedFiles is the number of files the sample was drawn from, and
+	// the problem estimates are for all of them
+	EstimatedFiles        int `json:"estimatedFiles"`
+	EstimatedProblems     int `json:"estimatedProblems"`
+	EstimatedProblemsLow  int `json:"estimatedProblemsLow"`
+	EstimatedProblemsHigh int `json:"estimatedProblemsHigh"`
+}
+
+// newSampleEstimate extrapolates mc, the comparison of the files in sample
+func newSampleEstimate(sample *sampleFilter, mc *ManifestComparison) *sampleEstimate {
+	files := mc.Matches + mc.Misses
+	e := &sampleEstimate{
+		Percent:        sample.Percent,
+		Seed:           sample.Seed,
+		Files:          files,
+		Problems:       mc.Misses,
+		EstimatedFiles: int(math.Round(float64(files) * 100 / sample.Percent)),
+	}
+	if files == 0 {
+		return e
+	}
+	e.Rate = float64(mc.Misses) / float64(files)
+	e.RateLow, e.RateHigh = wilsonInterval(mc.Misses, files, sampleConfidenceZ)
+	e.EstimatedProblems = int(math.Round(e.Rate * float64(e.EstimatedFiles)))
+	e.EstimatedProblemsLow = int(math.Floor(e.RateLow * float64(e.EstimatedFiles)))
+	e.EstimatedProblemsHigh = int(math.Ceil(e.RateHigh * float64(e.EstimatedFiles)))
+	return e
+}
+
+// wilsonInterval is the Wilson score interval for a proportion of successes
+// out of n trials, which unlike the normal approximation stays sensible with
+// few or no successes
+func wilsonInterval(successes, n int, z float64) (low, high float64) {
+	p := float64(successes) / float64(n)
+	nf := float64(n)
+	denominator := 1 + z*z/nf
+	center := (p + z*z/(2*nf)) / denominator
+	margin := z * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf)) / denominator
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+func (e *sampleEstimate) Print() {
+	fmt.Printf("Sampled files: %d of about %s (%s%%, seed %d), %d not matched\n", e.Files, humanize.Comma(int64(e.EstimatedFiles)), strconv.FormatFloat(e.Percent, 'f', -1, 64), e.Seed, e.Problems)
+	if e.Files == 0 {
+		return
+	}
+	fmt.Printf("Estimated files not matched overall: %s (95%% confidence: %s to %s), %s of files (%s to %s)\n",
+		humanize.Comma(int64(e.EstimatedProblems)),
+		humanize.Comma(int64(e.EstimatedProblemsLow)),
+		humanize.Comma(int64(e.EstimatedProblemsHigh)),
+		formatRate(e.Rate), formatRate(e.RateLow), formatRate(e.RateHigh),
+	)
+}
+
+// formatRate formats a fraction as a percentage to three significant
+// figures, since problem rates are often well under 0.01%
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate*100, 'g', 3, 64) + "%"
+}
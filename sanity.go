@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+)
+
+// sanityCheck catches a side that's far smaller than it should be, e.g. an
+// unmounted share scanned as an empty folder, before it's reported as
+// thousands of files only on the other side
+type sanityCheck struct {
+	// MinFiles is the fewest files each side may have (0 to not check)
+	MinFiles int
+	// MaxMissingPct is the largest percentage of either side's files that
+	// may be missing from the other (0 to not check)
+	MaxMissingPct float64
+}
+
+// problems describes every way mc fails the check
+func (c sanityCheck) problems(mc *ManifestComparison) []string {
+	var problems []string
+	if c.MinFiles > 0 {
+		if mc.LocalCount < c.MinFiles {
+			problems = append(problems, fmt.Sprintf("only %d local files, expected at least %d", mc.LocalCount, c.MinFiles))
+		}
+		if mc.RemoteCount < c.MinFiles {
+			problems = append(problems, fmt.Sprintf("only %d remote files, expected at least %d", mc.RemoteCount, c.MinFiles))
+		}
+	}
+	if c.MaxMissingPct > 0 {
+		if pct := missingPct(len(mc.OnlyRemote), mc.RemoteCount); pct > c.MaxMissingPct {
+			problems = append(problems, fmt.Sprintf("%.1f%% of remote files are missing locally (%d of %d)", pct, len(mc.OnlyRemote), mc.RemoteCount))
+		}
+		if pct := missingPct(len(mc.OnlyLocal), mc.LocalCount); pct > c.MaxMissingPct {
+			problems = append(problems, fmt.Sprintf("%.1f%% of local files are missing remotely (%d of %d)", pct, len(mc.OnlyLocal), mc.LocalCount))
+		}
+	}
+	return problems
+}
+
+func missingPct(missing, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(missing) * 100 / float64(total)
+}
+
+func printSanityProblems(problems []string) {
+	fmt.Println("❌ SANITY CHECK FAILED: one side looks incomplete.")
+	for _, problem := range problems {
+		fmt.Printf("- %s\n", problem)
+	}
+	fmt.Println("Is the local folder mounted, and is the remote root correct?")
+	fmt.Println("")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mitchellh/go-homedir"
+)
+
+// runScan lists Google Drive or scans a local directory and saves the result
+// as a manifest, for use with compare or verify's --load-*-manifest options.
+// It returns the process exit code.
+func runScan(args []string) int {
+	var opts struct {
+		RemoteRoot          string   `short:"r" long:"remote" description:"Directory in Google Drive to list"`
+		RemoteId            string   `long:"remote-id" description:"ID of the Google Drive folder to list, instead of --remote"`
+		ResolveShortcuts    bool     `long:"resolve-shortcuts" description:"List Drive shortcuts as copies of their targets at the shortcut's path"`
+		SkipGooglePhotos    bool     `long:"skip-google-photos" description:"Leave out the legacy Google Photos folder"`
+		ExcludeRemote       []string `long:"exclude-remote" description:"Leave out this Google Drive folder, by path relative to the remote root (wildcards work, e.g. Archive/**); can be given more than once"`
+		ExcludeRemoteIds    []string `long:"exclude-remote-id" description:"Leave out the Google Drive folder with this ID; can be given more than once"`
+		Spaces              string   `long:"spaces" description:"Comma-separated Drive spaces to list: drive, appDataFolder" default:"drive"`
+		LocalRoot           string   `short:"l" long:"local" description:"Local directory to scan, instead of listing Google Drive"`
+		SkipContentHash     bool     `long:"skip-hash" description:"Skip hashing local files"`
+		SkipPlaceholders    bool     `long:"skip-placeholders" description:"Don't hash cloud-only placeholder files (which would download them)"`
+		WorkerCount         int      `short:"w" long:"workers" description:"Number of workers hashing local files (0 for number of CPU cores)" default:"8"`
+		WalkConcurrency     int      `long:"walk-concurrency" description:"Number of local directories listed at once (1 to list one at a time)" default:"8"`
+		FollowSymlinks      bool     `long:"follow-symlinks" description:"Scan the targets of symlinks to directories"`
+		HashAlgo            string   `long:"hash-algo" description:"Hash algorithm: md5, sha1 or sha256" default:"md5"`
+		Output              string   `short:"o" long:"output" description:"Manifest file to write (gzipped if it ends in .gz)" required:"yes"`
+		Scope               string   `long:"scope" description:"Read-only Google Drive access to use: readonly or metadata (see auth login)" choice:"readonly" choice:"metadata" default:"metadata"`
+		TokenStore          string   `long:"token-store" description:"Where the Google Drive token is kept: file (token.json in the config directory) or keychain (the OS credential store)" choice:"file" choice:"keychain" default:"file"`
+		TokenPassphraseFile string   `long:"token-passphrase-file" description:"Encrypt the token file with the passphrase (or key) in this file, for systems without a keychain"`
+	}
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "scan [OPTIONS]"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Extra arguments provided!")
+		return 1
+	}
+	remote := opts.RemoteRoot != "" || opts.RemoteId != ""
+	if remote == (opts.LocalRoot != "") || (opts.RemoteRoot != "" && opts.RemoteId != "") {
+		fmt.Fprintln(os.Stderr, "Give one of --remote, --remote-id or --local")
+		return 1
+	}
+	hashAlgo, err := parseHashAlgorithm(opts.HashAlgo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	spaces, err := parseSpaces(opts.Spaces)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	excludeRemote, err := newRemoteExclusions(opts.ExcludeRemote, opts.ExcludeRemoteIds)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if tokens, err = newTokenStore(opts.TokenStore, opts.TokenPassphraseFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	config := &verifyConfig{
+		RemoteRoot:       opts.RemoteRoot,
+		RemoteRootId:     opts.RemoteId,
+		DriveScope:       opts.Scope,
+		ResolveShortcuts: opts.ResolveShortcuts,
+		SkipGooglePhotos: opts.SkipGooglePhotos,
+		ExcludeRemote:    excludeRemote,
+		Spaces:           spaces,
+		Local: localScanOptions{
+			SkipContentHash:  opts.SkipContentHash,
+			SkipPlaceholders: opts.SkipPlaceholders,
+			WorkerCount:      resolveWorkerCount(opts.WorkerCount),
+			WalkConcurrency:  opts.WalkConcurrency,
+			FollowSymlinks:   opts.FollowSymlinks,
+			HashAlgo:         hashAlgo,
+			Spill:            spillConfig{Threshold: 100000},
+		},
+	}
+
+	var count int
+	if remote {
+		count, err = scanRemoteToFile(opts.Output, config)
+	} else {
+		count, err = scanLocalToFile(opts.Output, opts.LocalRoot, config)
+	}
+	if err != nil {
+		printFailure(err)
+		return 1
+	}
+	fmt.Printf("Saved %d files to %s\n", count, opts.Output)
+	return 0
+}
+
+func scanRemoteToFile(path string, config *verifyConfig) (int, error) {
+	if config.RemoteRootId != "" {
+		config.RemoteRoot = "/"
+	} else if config.RemoteRoot[0] != '/' {
+		config.RemoteRoot = "/" + config.RemoteRoot
+	}
+	srv, err := NewDriveService(filepath.Join(getConfigDir(), "credentials.json"), filepath.Join(getConfigDir(), "token.json"), config.DriveScope)
+	if err != nil {
+		return 0, err
+	}
+	listing := NewDriveListing(srv, config.RemoteRoot, nil)
+	listing.RootId = config.RemoteRootId
+	listing.ResolveShortcuts = config.ResolveShortcuts
+	listing.HashAlgo = config.Local.HashAlgo
+	listing.SkipGooglePhotos = config.SkipGooglePhotos
+	listing.Exclude = config.ExcludeRemote
+	listing.Spaces = config.Spaces
+
+	updateChan := make(chan int)
+	go func() {
+		for count := range updateChan {
+			fmt.Fprintf(os.Stderr, "\rListed %d remote files", count)
+		}
+	}()
+	w, err := createRemoteManifestFile(path, config)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = listing.Each(updateChan, func(file *File) error {
+		count++
+		return w.Write(file)
+	})
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		w.Discard()
+		return 0, fmt.Errorf("Unable to list Google Drive folder %s: %w", config.RemoteRoot, err)
+	}
+	return count, w.Commit()
+}
+
+func scanLocalToFile(path string, localArg string, config *verifyConfig) (int, error) {
+	localArg, err := homedir.Expand(localArg)
+	if err != nil {
+		return 0, err
+	}
+	config.LocalRoot, err = filepath.Abs(localArg)
+	if err != nil {
+		return 0, err
+	}
+	config.SaveLocalManifest = path
+
+	progress := newScanProgress()
+	progressDone := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(os.Stderr, "\rScanned %d local files", progress.Snapshot().LocalFiles)
+			case <-progress.Done():
+				fmt.Fprintf(os.Stderr, "\rScanned %d local files\n", progress.Snapshot().LocalFiles)
+				close(progressDone)
+				return
+			}
+		}
+	}()
+	scan, err := scanLocal(context.Background(), progress, config)
+	progress.finish()
+	<-progressDone
+	if err != nil {
+		return 0, err
+	}
+	defer scan.Manifest.Close()
+	if len(scan.Errored) > 0 {
+		fmt.Fprintf(os.Stderr, "%d local files couldn't be read; they're recorded as errors\n", len(scan.Errored))
+	}
+	return scan.Manifest.Len(), nil
+}
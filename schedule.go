@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// scheduleOptions are install-schedule's own options; verify's options come
+// after --
+type scheduleOptions struct {
+	Interval  string `long:"interval" description:"How often to verify" choice:"hourly" choice:"daily" choice:"weekly" default:"daily"`
+	At        string `long:"at" description:"Time of day (HH:MM) for daily and weekly runs; weekly runs are on Mondays" default:"03:00"`
+	ReportDir string `long:"report-dir" description:"Directory for each run's JSON and HTML reports and log (default: reports in the config directory)"`
+	Name      string `long:"name" description:"Name of the systemd units or launchd job, to schedule more than one verification" default:"googledrive-sync-verifier"`
+	DryRun    bool   `long:"dry-run" description:"Print the files that would be installed instead of installing them"`
+	Remove    bool   `long:"remove" description:"Stop and remove a previously installed schedule"`
+}
+
+// scheduledJob is what the templates below need
+type scheduledJob struct {
+	Name      string
+	Label     string
+	Args      []string
+	ReportDir string
+	Interval  string
+	Hour      int
+	Minute    int
+}
+
+// runInstallSchedule installs a systemd user timer (Linux) or launchd agent
+// (macOS) that runs verify with the options given after --, writing reports
+// to the report directory. It returns the process exit code.
+func runInstallSchedule(args []string) int {
+	var opts scheduleOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "install-schedule [OPTIONS] -- VERIFY-OPTIONS"
+	verifyArgs, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(verifyArgs) == 0 && !opts.Remove {
+		fmt.Fprintln(os.Stderr, "Give the options to verify with after --, e.g. install-schedule --interval daily -- --local ~/Google\\ Drive --quiet")
+		return 1
+	}
+	hour, minute, err := parseTimeOfDay(opts.At)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	reportDir := opts.ReportDir
+	if reportDir == "" {
+		reportDir = filepath.Join(getConfigDir(), "reports")
+	}
+	if reportDir, err = filepath.Abs(reportDir); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to find the verifier's own path: %v\n", err)
+		return 1
+	}
+
+	job := &scheduledJob{
+		Name:      opts.Name,
+		Label:     "com.github.ggilder." + opts.Name,
+		Args:      append([]string{executable, "verify"}, withReportOptions(verifyArgs, reportDir)...),
+		ReportDir: reportDir,
+		Interval:  opts.Interval,
+		Hour:      hour,
+		Minute:    minute,
+	}
+	var scheduler jobScheduler
+	switch runtime.GOOS {
+	case "linux":
+		scheduler = systemdScheduler{}
+	case "darwin":
+		scheduler = launchdScheduler{}
+	default:
+		fmt.Fprintf(os.Stderr, "install-schedule supports systemd (Linux) and launchd (macOS), not %s; schedule this command instead:\n%s\n", runtime.GOOS, strings.Join(job.Args, " "))
+		return 1
+	}
+
+	if opts.Remove {
+		if err := scheduler.Remove(job); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		return 0
+	}
+	files, err := scheduler.Files(job)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if opts.DryRun {
+		for path, contents := range files {
+			fmt.Printf("# %s\n%s\n", path, contents)
+		}
+		return 0
+	}
+	if err := os.MkdirAll(reportDir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create report directory: %v\n", err)
+		return 1
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	if err := scheduler.Activate(job); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("Scheduled %s verification; reports will be written to %s\n", opts.Interval, reportDir)
+	return 0
+}
+
+// withReportOptions adds --json, --html and --log-file in reportDir, unless
+// the verify options already set them
+func withReportOptions(args []string, reportDir string) []string {
+	reports := []struct{ option, file string }{
+		{"--json", "latest.json"},
+		{"--html", "latest.html"},
+		{"--log-file", "verify.log"},
+	}
+	result := append([]string{}, args...)
+	for _, report := range reports {
+		given := false
+		for _, arg := range args {
+			if arg == report.option || strings.HasPrefix(arg, report.option+"=") {
+				given = true
+			}
+		}
+		if !given {
+			result = append(result, report.option, filepath.Join(reportDir, report.file))
+		}
+	}
+	return result
+}
+
+func parseTimeOfDay(s string) (hour, minute int, err error) {
+	h, m, ok := strings.Cut(s, ":")
+	if ok {
+		hour, err = strconv.Atoi(h)
+		if err == nil {
+			minute, err = strconv.Atoi(m)
+		}
+	}
+	if !ok || err != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("Invalid --at %q: expected a time of day such as 03:00", s)
+	}
+	return hour, minute, nil
+}
+
+// jobScheduler installs a job with the system's scheduler
+type jobScheduler interface {
+	// Files returns the contents of the files to install, by path
+	Files(job *scheduledJob) (map[string]string, error)
+	// Activate loads the installed files
+	Activate(job *scheduledJob) error
+	// Remove stops the job and deletes its files
+	Remove(job *scheduledJob) error
+}
+
+// systemdScheduler installs a user service and timer
+type systemdScheduler struct{}
+
+var systemdService = template.Must(template.New("service").Parse(`[Unit]
+Description=Verify Google Drive sync ({{.Name}})
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart={{.ExecStart}}
+`))
+
+var systemdTimer = template.Must(template.New("timer").Parse(`[Unit]
+Description=Verify Google Drive sync {{.Interval}} ({{.Name}})
+
+[Timer]
+OnCalendar={{.OnCalendar}}
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+func (systemdScheduler) unitDir() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "systemd", "user"), nil
+}
+
+func (s systemdScheduler) Files(job *scheduledJob) (map[string]string, error) {
+	dir, err := s.unitDir()
+	if err != nil {
+		return nil, err
+	}
+	var quoted []string
+	for _, arg := range job.Args {
+		quoted = append(quoted, systemdQuote(arg))
+	}
+	onCalendar := "hourly"
+	switch job.Interval {
+	case "daily":
+		onCalendar = fmt.Sprintf("*-*-* %02d:%02d:00", job.Hour, job.Minute)
+	case "weekly":
+		onCalendar = fmt.Sprintf("Mon *-*-* %02d:%02d:00", job.Hour, job.Minute)
+	}
+	data := map[string]string{"Name": job.Name, "Interval": job.Interval, "ExecStart": strings.Join(quoted, " "), "OnCalendar": onCalendar}
+	var service, timer strings.Builder
+	if err := systemdService.Execute(&service, data); err != nil {
+		return nil, err
+	}
+	if err := systemdTimer.Execute(&timer, data); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		filepath.Join(dir, job.Name+".service"): service.String(),
+		filepath.Join(dir, job.Name+".timer"):   timer.String(),
+	}, nil
+}
+
+func (systemdScheduler) Activate(job *scheduledJob) error {
+	if err := runScheduler("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runScheduler("systemctl", "--user", "enable", "--now", job.Name+".timer")
+}
+
+func (s systemdScheduler) Remove(job *scheduledJob) error {
+	// the timer may already be gone
+	runScheduler("systemctl", "--user", "disable", "--now", job.Name+".timer")
+	dir, err := s.unitDir()
+	if err != nil {
+		return err
+	}
+	for _, unit := range []string{job.Name + ".timer", job.Name + ".service"} {
+		if err := removeScheduleFile(filepath.Join(dir, unit)); err != nil {
+			return err
+		}
+	}
+	return runScheduler("systemctl", "--user", "daemon-reload")
+}
+
+// systemdQuote quotes a command line argument for ExecStart, where % and $
+// are also special
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg)
+	return `"` + arg + `"`
+}
+
+// launchdScheduler installs a launch agent
+type launchdScheduler struct{}
+
+var launchdPlist = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{.}}</string>
+{{- end}}
+	</array>
+	<key>StartCalendarInterval</key>
+	<dict>
+{{- if ne .Interval "hourly"}}
+		<key>Hour</key>
+		<integer>{{.Hour}}</integer>
+{{- end}}
+		<key>Minute</key>
+		<integer>{{.Minute}}</integer>
+{{- if eq .Interval "weekly"}}
+		<key>Weekday</key>
+		<integer>1</integer>
+{{- end}}
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{.ReportDir}}/latest.txt</string>
+	<key>StandardErrorPath</key>
+	<string>{{.ReportDir}}/latest.txt</string>
+</dict>
+</plist>
+`))
+
+func (launchdScheduler) plistPath(job *scheduledJob) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", job.Label+".plist"), nil
+}
+
+func (l launchdScheduler) Files(job *scheduledJob) (map[string]string, error) {
+	path, err := l.plistPath(job)
+	if err != nil {
+		return nil, err
+	}
+	// text/template doesn't escape, and paths may contain & or <
+	escaped := *job
+	escaped.Args = nil
+	for _, arg := range job.Args {
+		escaped.Args = append(escaped.Args, template.HTMLEscapeString(arg))
+	}
+	escaped.ReportDir = template.HTMLEscapeString(job.ReportDir)
+	var plist strings.Builder
+	if err := launchdPlist.Execute(&plist, &escaped); err != nil {
+		return nil, err
+	}
+	return map[string]string{path: plist.String()}, nil
+}
+
+func (l launchdScheduler) Activate(job *scheduledJob) error {
+	path, err := l.plistPath(job)
+	if err != nil {
+		return err
+	}
+	// reinstalling replaces a job that's already loaded
+	exec.Command("launchctl", "unload", path).Run()
+	return runScheduler("launchctl", "load", "-w", path)
+}
+
+func (l launchdScheduler) Remove(job *scheduledJob) error {
+	path, err := l.plistPath(job)
+	if err != nil {
+		return err
+	}
+	exec.Command("launchctl", "unload", "-w", path).Run()
+	return removeScheduleFile(path)
+}
+
+// runScheduler runs a systemctl or launchctl command, including its output
+// in the error if it fails
+func runScheduler(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func removeScheduleFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Printf("Removed %s\n", path)
+	return nil
+}
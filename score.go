@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Grades for a verification score
+const (
+	gradeOK   = "OK"
+	gradeWarn = "WARN"
+	gradeCrit = "CRIT"
+)
+
+// gradeThresholds are the scores, as percentages, below which a run is
+// graded WARN or CRIT
+type gradeThresholds struct {
+	Warn float64
+	Crit float64
+}
+
+var defaultGradeThresholds = gradeThresholds{Warn: 99.9, Crit: 99}
+
+func newGradeThresholds(warn, crit float64) (*gradeThresholds, error) {
+	if warn < 0 || warn > 100 || crit < 0 || crit > 100 {
+		return nil, fmt.Errorf("Score thresholds must be percentages between 0 and 100")
+	}
+	if crit > warn {
+		return nil, fmt.Errorf("--score-crit (%g%%) can't be above --score-warn (%g%%)", crit, warn)
+	}
+	return &gradeThresholds{Warn: warn, Crit: crit}, nil
+}
+
+// verificationScore is the share of files, and of bytes, verified as
+// matching, graded by the lower of the two
+type verificationScore struct {
+	FilesPct float64 `json:"filesPct"`
+	BytesPct float64 `json:"bytesPct"`
+	Grade    string  `json:"grade"`
+}
+
+// Score computes the verification score. Files count towards it as Matches
+// and Misses do; bytes are those of matches and of the files on either side
+// that aren't matched (errored files have no known size).
+func (mc *ManifestComparison) Score() *verificationScore {
+	var missedBytes int64
+	for _, files := range [][]*File{mc.OnlyRemote, mc.OnlyLocal} {
+		for _, file := range files {
+			missedBytes += file.Size
+		}
+	}
+	for _, pairs := range [][]*FilePair{mc.ContentMismatch, mc.EmptyLocal, mc.SpotCheckFailed} {
+		for _, pair := range pairs {
+			missedBytes += pair.Remote.Size
+		}
+	}
+	score := &verificationScore{
+		FilesPct: percentOf(int64(mc.Matches), int64(mc.Matches+mc.Misses)),
+		BytesPct: percentOf(mc.MatchedBytes, mc.MatchedBytes+missedBytes),
+	}
+	thresholds := mc.grade
+	if thresholds == nil {
+		thresholds = &defaultGradeThresholds
+	}
+	lowest := math.Min(score.FilesPct, score.BytesPct)
+	switch {
+	case lowest < thresholds.Crit:
+		score.Grade = gradeCrit
+	case lowest < thresholds.Warn:
+		score.Grade = gradeWarn
+	default:
+		score.Grade = gradeOK
+	}
+	return score
+}
+
+// gradeExitCode is the exit code for a comparison's grade, for --grade-exit
+func (mc *ManifestComparison) gradeExitCode() int {
+	switch mc.Score().Grade {
+	case gradeCrit:
+		return exitGradeCrit
+	case gradeWarn:
+		return exitSyncFailure
+	}
+	return 0
+}
+
+// percentOf is part as a percentage of total, 100 for nothing at all
+func percentOf(part, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// formatPercent shows a score without rounding up to 100% while anything is
+// missing
+func formatPercent(pct float64) string {
+	return fmt.Sprintf("%.2f%%", math.Floor(pct*100)/100)
+}
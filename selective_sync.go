@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// selectiveSync describes which local folders are synced when only part of
+// Google Drive is synced locally
+type selectiveSync struct {
+	// Depth selects every local folder this many levels below the root
+	// (0 disables selective sync unless ListFile is set)
+	Depth int
+	// ListFile names a file listing selected folders, one relative path per line
+	ListFile string
+}
+
+func (s selectiveSync) enabled() bool {
+	return s.Depth > 0 || s.ListFile != ""
+}
+
+// folders returns the selected folders relative to localRoot. Listed full
+// paths, as sync clients show them in their preferences, are made relative.
+// Selecting nothing is an error, since an empty selection would verify
+// everything.
+func (s selectiveSync) folders(localRoot string) ([]string, error) {
+	if s.ListFile == "" {
+		found, err := listFolders(localRoot, s.Depth)
+		if err == nil && len(found) == 0 {
+			err = fmt.Errorf("Selective sync found no local folders at depth %d under %s", s.Depth, localRoot)
+		}
+		return found, err
+	}
+	listed, err := readFolderList(s.ListFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("%s doesn't list any folders to verify", s.ListFile)
+	}
+	for i, folder := range listed {
+		if !filepath.IsAbs(folder) {
+			continue
+		}
+		rel, err := filepath.Rel(localRoot, folder)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("Selected folder %s isn't under the local root %s", folder, localRoot)
+		}
+		listed[i] = filepath.ToSlash(rel)
+	}
+	return listed, nil
+}
+
+// clientSettingsMagic starts the files sync clients keep their selective
+// sync settings in: Backup and Sync's and Drive for Desktop's SQLite
+// databases, and binary property lists on macOS
+var clientSettingsMagic = []string{"SQLite format 3\x00", "bplist"}
+
+// readFolderList reads folder paths from a file, ignoring blank lines and
+// lines starting with #. Relative paths get forward slashes; full paths are
+// kept as they are.
+func readFolderList(listPath string) (folders []string, err error) {
+	f, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	// the clients' settings formats are private and change between versions,
+	// so they're refused rather than guessed at
+	head, _ := in.Peek(16)
+	for _, magic := range clientSettingsMagic {
+		if strings.HasPrefix(string(head), magic) {
+			return nil, fmt.Errorf("%s is a sync client's settings file, which can't be read; list the selected folders in a text file instead, one per line", listPath)
+		}
+	}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line = filepath.Clean(line); !filepath.IsAbs(line) {
+			line = strings.Trim(filepath.ToSlash(line), "/")
+		}
+		folders = append(folders, line)
+	}
+	return folders, scanner.Err()
+}
+
+// UnselectedFolders returns remote folders that aren't covered by the
+// selected Subdirectories, limited to siblings of selected folders and their
+// ancestors so that each unselected branch is only reported once
+func (g *DriveListing) UnselectedFolders() []string {
+	if len(g.Subdirectories) == 0 {
+		return nil
+	}
+	selected := make(map[string]bool)
+	ancestors := map[string]bool{".": true}
+	for _, dir := range g.Subdirectories {
+		dir = strings.ToLower(dir)
+		selected[dir] = true
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			ancestors[parent] = true
+		}
+	}
+
+	var unselected []string
+	for id := range g.driveFolders {
+		fullPath, err := g.buildPath(id)
+		if err != nil {
+			continue
+		}
+		relPath, err := remoteRel(g.RootPath, fullPath)
+		if err != nil || relPath == "." || strings.HasPrefix(relPath, "../") {
+			continue
+		}
+		key := strings.ToLower(normalizeUnicodeCharacters(relPath))
+		if selected[key] || ancestors[key] || !ancestors[path.Dir(key)] {
+			continue
+		}
+		unselected = append(unselected, relPath)
+	}
+	sort.Strings(unselected)
+	return unselected
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileID identifies a file on disk independently of its paths
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// Clone checks cost an extra open of each file, so small files are just read
+const minCloneCheckSize = 1 << 20
+
+// sharedContents tracks local files whose contents are stored once for
+// several paths, so they're only hashed once during a scan: hard links to
+// the same file (e.g. photo libraries deduplicated with hard links) and,
+// with detectClones, copy-on-write clones such as APFS clones and btrfs or
+// XFS reflinks. Files are identified by what's on disk rather than by path,
+// so this is a cache addressed by contents that needs no reading.
+type sharedContents struct {
+	lock         sync.Mutex
+	seen         map[string]*sharedFile
+	detectClones bool
+}
+
+// sharedFile is the hash of contents shared by several paths, once the
+// first of them has been hashed
+type sharedFile struct {
+	// clone is set for clones, rather than hard links
+	clone    bool
+	done     chan struct{}
+	hash     string
+	fastHash string
+}
+
+func newSharedContents(detectClones bool) *sharedContents {
+	return &sharedContents{seen: make(map[string]*sharedFile), detectClones: detectClones}
+}
+
+// claim returns the entry for the contents of the file at path, or nil if
+// they aren't known to be shared, and whether this is the first of its
+// paths to be seen. The first must call finish once it's been hashed.
+// Clones are only looked for if hashing is set, since nothing is gained
+// otherwise.
+func (s *sharedContents) claim(path string, info os.FileInfo, hashing bool) (shared *sharedFile, first bool) {
+	var key string
+	clone := false
+	if id, ok := hardLinkID(info); ok {
+		key = fmt.Sprintf("link:%d:%d", id.dev, id.ino)
+	} else if s.detectClones && hashing && info.Size() >= minCloneCheckSize {
+		cloned, ok := cloneKey(path, info)
+		if !ok {
+			return nil, false
+		}
+		key, clone = "clone:"+cloned, true
+	} else {
+		return nil, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if shared, ok := s.seen[key]; ok {
+		return shared, false
+	}
+	shared = &sharedFile{clone: clone, done: make(chan struct{})}
+	s.seen[key] = shared
+	return shared, true
+}
+
+// finish records the first path's hash ("" if it couldn't be hashed) for
+// the others
+func (f *sharedFile) finish(hash, fastHash string) {
+	f.hash, f.fastHash = hash, fastHash
+	close(f.done)
+}
+
+// wait returns the first path's hash once it's been hashed, or "" if it
+// couldn't be or ctx is cancelled first
+func (f *sharedFile) wait(ctx context.Context) (hash, fastHash string) {
+	select {
+	case <-f.done:
+		return f.hash, f.fastHash
+	case <-ctx.Done():
+		return "", ""
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Maximum number of spot check downloads running at once
+const spotCheckWorkers = 4
+
+// parseSpotCheck parses a --spot-check percentage such as 5% or 0.5
+func parseSpotCheck(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("Invalid spot check percentage %q (expected e.g. 5%%)", value)
+	}
+	return percent, nil
+}
+
+// SpotCheckResult summarizes downloading matched files to compare them byte
+// for byte
+type SpotCheckResult struct {
+	Checked int
+	// Errors counts files that couldn't be downloaded or read; they don't
+	// change the comparison
+	Errors int
+}
+
+// SpotCheck downloads a random percent of matched files from Google Drive and
+// compares them byte for byte with the local copies, which catches a stale
+// Drive checksum or a local copy that changed without its hash being noticed.
+// Files that differ are moved from Matched to SpotCheckFailed and counted as
+// misses. Matches must have been recorded, with local full paths.
+func (mc *ManifestComparison) SpotCheck(listing *DriveListing, percent float64) (*SpotCheckResult, error) {
+	var candidates []*FilePair
+	for _, pair := range mc.Matched {
+		if pair.Remote.Id != "" && pair.Local.FullPath != "" {
+			candidates = append(candidates, pair)
+		}
+	}
+	count := int(math.Ceil(float64(len(candidates)) * percent / 100))
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	sample := candidates[:count]
+
+	result := &SpotCheckResult{Checked: len(sample)}
+	failed := make(map[*FilePair]bool)
+	var mu, callMu sync.Mutex
+	var scopeErr error
+	work := make(chan *FilePair)
+	var wg sync.WaitGroup
+	for i := 0; i < spotCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range work {
+				same, err := spotCheckFile(listing, &callMu, pair)
+				mu.Lock()
+				if err != nil {
+					result.Errors++
+					logger.Warn("unable to spot check file", "path", pair.Local.Path, "error", err)
+					if isInsufficientScopeError(err) && scopeErr == nil {
+						scopeErr = withCategory(CategoryAuth, errors.New("Spot checks need permission to download files (the drive.readonly scope); run auth login --force --scope readonly"))
+					}
+				} else if !same {
+					failed[pair] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, pair := range sample {
+		work <- pair
+	}
+	close(work)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		var matched []*FilePair
+		for _, pair := range mc.Matched {
+			if failed[pair] {
+				mc.SpotCheckFailed = append(mc.SpotCheckFailed, pair)
+				mc.Matches--
+				mc.MatchedBytes -= pair.Remote.Size
+				mc.Misses++
+			} else {
+				matched = append(matched, pair)
+			}
+		}
+		mc.Matched = matched
+	}
+	return result, scopeErr
+}
+
+// spotCheckFile reports whether the remote file's contents are identical to
+// the local file's
+func spotCheckFile(listing *DriveListing, callMu *sync.Mutex, pair *FilePair) (bool, error) {
+	local, err := os.Open(pair.Local.FullPath)
+	if err != nil {
+		return false, err
+	}
+	defer local.Close()
+
+	var resp *http.Response
+	// DriveListing.call isn't safe for concurrent use; only starting the
+	// download is serialized
+	callMu.Lock()
+	// the body is read after the call returns, so the download can't be
+	// bound by the request timeout
+	err = listing.call(func(context.Context) error {
+		resp, err = listing.service.Files.Get(pair.Remote.Id).Context(listing.context()).Download()
+		return err
+	})
+	callMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return sameContents(bufio.NewReader(local), bufio.NewReader(resp.Body))
+}
+
+// sameContents compares two readers byte for byte
+func sameContents(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB[:n])
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if m != n || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA != nil {
+			// a is done; b must be too
+			extra, err := b.Read(bufB[:1])
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			return extra == 0, nil
+		}
+	}
+}
+
+func isInsufficientScopeError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 403 && strings.Contains(apiErr.Message, "scope")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// Tokens saved with --token-passphrase-file are encrypted with AES-256-GCM,
+// with a key derived from the passphrase by PBKDF2-SHA256 and a fresh salt
+// and nonce each time the token is saved.
+const (
+	tokenKDFIterations = 600000
+	tokenSaltSize      = 16
+)
+
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptedFileTokenStore keeps each token encrypted in its token file,
+// decrypting it only in memory
+type encryptedFileTokenStore struct {
+	passphrase []byte
+}
+
+// readPassphraseFile reads a passphrase or key file, ignoring a trailing
+// newline
+func readPassphraseFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read token passphrase: %v", err)
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Token passphrase file %s is empty", path)
+	}
+	return data, nil
+}
+
+func (s encryptedFileTokenStore) Load(path string) (*oauth2.Token, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := &encryptedToken{}
+	if err := json.Unmarshal(data, encrypted); err != nil {
+		return nil, fmt.Errorf("Unable to parse token %s: %v", path, err)
+	}
+	if encrypted.Version == 0 {
+		// a token saved before encryption was turned on
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal(data, tok); err != nil || tok.AccessToken == "" {
+			return nil, fmt.Errorf("%s is neither an encrypted nor a plain token", path)
+		}
+		if err := s.Save(path, tok); err != nil {
+			return nil, err
+		}
+		logger.Info("encrypted previously unencrypted token", "path", path)
+		return tok, nil
+	}
+	if encrypted.Version != 1 || encrypted.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("%s is encrypted in an unsupported format", path)
+	}
+	gcm, err := s.cipher(encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%s has an invalid nonce", path)
+	}
+	plain, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt token %s: wrong passphrase, or the file was changed", path)
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(plain, tok); err != nil {
+		return nil, fmt.Errorf("Unable to parse decrypted token %s: %v", path, err)
+	}
+	return tok, nil
+}
+
+func (s encryptedFileTokenStore) Save(path string, token *oauth2.Token) error {
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	encrypted := &encryptedToken{Version: 1, KDF: "pbkdf2-sha256", Iterations: tokenKDFIterations, Salt: make([]byte, tokenSaltSize)}
+	if _, err := rand.Read(encrypted.Salt); err != nil {
+		return err
+	}
+	gcm, err := s.cipher(encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return err
+	}
+	encrypted.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(encrypted.Nonce); err != nil {
+		return err
+	}
+	encrypted.Ciphertext = gcm.Seal(nil, encrypted.Nonce, plain, nil)
+	data, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (s encryptedFileTokenStore) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	if len(salt) != tokenSaltSize || iterations <= 0 {
+		return nil, errors.New("Invalid token encryption parameters")
+	}
+	key, err := pbkdf2.Key(sha256.New, string(s.passphrase), salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (encryptedFileTokenStore) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (encryptedFileTokenStore) Describe(path string) string {
+	return path + " (encrypted)"
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// keychainService names the verifier's items in the OS credential store
+const keychainService = "googledrive-sync-verifier"
+
+// tokenStore keeps OAuth tokens, each identified by the path its token file
+// has (or would have) in the config directory
+type tokenStore interface {
+	// Load returns an error satisfying os.IsNotExist if there's no token
+	Load(path string) (*oauth2.Token, error)
+	Save(path string, token *oauth2.Token) error
+	Remove(path string) error
+	// Describe says where the token for path is kept, for messages
+	Describe(path string) string
+}
+
+// tokens is where tokens are kept, chosen with --token-store
+var tokens tokenStore = fileTokenStore{}
+
+// newTokenStore returns the store for --token-store, encrypting token files
+// with the passphrase in passphraseFile if given
+func newTokenStore(kind string, passphraseFile string) (tokenStore, error) {
+	if passphraseFile != "" {
+		if kind == "keychain" {
+			return nil, errors.New("--token-passphrase-file only applies to token files, not --token-store keychain")
+		}
+		passphrase, err := readPassphraseFile(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return encryptedFileTokenStore{passphrase: passphrase}, nil
+	}
+	switch kind {
+	case "", "file":
+		return fileTokenStore{}, nil
+	case "keychain":
+		return keychainTokenStore{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown token store %q (expected file or keychain)", kind)
+	}
+}
+
+// fileTokenStore keeps each token as plain JSON in its token file
+type fileTokenStore struct{}
+
+func (fileTokenStore) Load(path string) (*oauth2.Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+func (fileTokenStore) Save(path string, token *oauth2.Token) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (fileTokenStore) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (fileTokenStore) Describe(path string) string {
+	return path
+}
+
+// keychainTokenStore keeps tokens in the OS credential store (macOS
+// Keychain, the Secret Service on Linux, Windows Credential Manager), as
+// items of keychainService named by the token file path. The platform's
+// keychainGet, keychainSet and keychainDelete do the work.
+type keychainTokenStore struct{}
+
+func (keychainTokenStore) Load(path string) (*oauth2.Token, error) {
+	data, err := keychainGet(path)
+	if err == errKeychainNotFound {
+		return nil, &os.PathError{Op: "load", Path: "keychain " + path, Err: os.ErrNotExist}
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read token from the keychain: %v", err)
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(data, tok); err != nil {
+		return nil, fmt.Errorf("Unable to parse token from the keychain: %v", err)
+	}
+	return tok, nil
+}
+
+func (keychainTokenStore) Save(path string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := keychainSet(path, data); err != nil {
+		return fmt.Errorf("Unable to save token to the keychain: %v", err)
+	}
+	return nil
+}
+
+func (keychainTokenStore) Remove(path string) error {
+	err := keychainDelete(path)
+	if err == errKeychainNotFound {
+		return &os.PathError{Op: "remove", Path: "keychain " + path, Err: os.ErrNotExist}
+	} else if err != nil {
+		return fmt.Errorf("Unable to remove token from the keychain: %v", err)
+	}
+	return nil
+}
+
+func (keychainTokenStore) Describe(path string) string {
+	return fmt.Sprintf("the keychain (%s, %s)", keychainService, path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errKeychainNotFound is returned when the credential store has no item
+var errKeychainNotFound = errors.New("not found in keychain")
+
+// security exits with this status when an item isn't found
+const securityItemNotFound = 44
+
+// The Keychain is driven with the security command. The token is passed on
+// standard input, in hex, so it never appears in the process list.
+
+func keychainGet(account string) ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w").Output()
+	if err != nil {
+		return nil, securityError(err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func keychainSet(account string, data []byte) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", securityQuote(keychainService), securityQuote(account), hex.EncodeToString(data)))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	// interactive mode exits successfully even if the command failed
+	if len(bytes.TrimSpace(out)) > 0 {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func keychainDelete(account string) error {
+	if err := exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", account).Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for security's interactive mode
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func securityError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+		return errKeychainNotFound
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errKeychainNotFound is returned when the credential store has no item
+var errKeychainNotFound = errors.New("not found in keychain")
+
+// The Secret Service (GNOME Keyring, KWallet) is driven with secret-tool,
+// from libsecret. The token is passed on standard input, so it never
+// appears in the process list.
+
+func keychainGet(account string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// a missing item is an exit status of 1 with nothing printed
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 && stderr.Len() == 0 {
+			return nil, errKeychainNotFound
+		}
+		return nil, secretToolError(err, stderr.String())
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func keychainSet(account string, data []byte) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label", keychainService+" token", "service", keychainService, "account", account)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError(err, stderr.String())
+	}
+	return nil
+}
+
+func keychainDelete(account string) error {
+	// clear succeeds whether or not there was an item, so look first
+	if _, err := keychainGet(account); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "clear", "service", keychainService, "account", account)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError(err, stderr.String())
+	}
+	return nil
+}
+
+func secretToolError(err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("secret-tool not found; install libsecret-tools (or libsecret) to use --token-store keychain")
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%v: %s", err, stderr)
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "errors"
+
+// errKeychainNotFound is returned when the credential store has no item
+var errKeychainNotFound = errors.New("not found in keychain")
+
+var errNoKeychain = errors.New("--token-store keychain isn't supported on this platform")
+
+func keychainGet(account string) ([]byte, error) {
+	return nil, errNoKeychain
+}
+
+func keychainSet(account string, data []byte) error {
+	return errNoKeychain
+}
+
+func keychainDelete(account string) error {
+	return errNoKeychain
+}
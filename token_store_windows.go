@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// errKeychainNotFound is returned when the credential store has no item
+var errKeychainNotFound = errors.New("not found in keychain")
+
+// Windows Credential Manager is called directly through advapi32, with
+// generic credentials named keychainService:<token file path>.
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+// credential is CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credentialTarget(account string) (*uint16, error) {
+	return windows.UTF16PtrFromString(keychainService + ":" + account)
+}
+
+func keychainGet(account string) ([]byte, error) {
+	target, err := credentialTarget(account)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	ok, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		return nil, credentialError(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	data := make([]byte, cred.CredentialBlobSize)
+	copy(data, unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize))
+	return data, nil
+}
+
+func keychainSet(account string, data []byte) error {
+	target, err := credentialTarget(account)
+	if err != nil {
+		return err
+	}
+	cred := &credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(data)),
+		Persist:            credPersistLocalMachine,
+	}
+	if len(data) > 0 {
+		cred.CredentialBlob = &data[0]
+	}
+	ok, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(cred)), 0)
+	if ok == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
+
+func keychainDelete(account string) error {
+	target, err := credentialTarget(account)
+	if err != nil {
+		return err
+	}
+	ok, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if ok == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
+
+func credentialError(err error) error {
+	if errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return errKeychainNotFound
+	}
+	return err
+}
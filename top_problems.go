@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// PrintTopProblems lists the n largest files in each problem category, since
+// a big missing file usually matters more than many small ones
+func (mc *ManifestComparison) PrintTopProblems(n int) {
+	fmt.Printf("LARGEST PROBLEM FILES (top %d per category):\n\n", n)
+	printLargestFiles(mc.OnlyRemote, n, "Only in remote")
+	printLargestFiles(mc.OnlyLocal, n, "Only in local")
+	printLargestFiles(remoteFiles(mc.ContentMismatch), n, "Contents don't match")
+	printLargestFiles(remoteFiles(mc.EmptyLocal), n, "Empty local files")
+	printLargestFiles(remoteFiles(mc.SpotCheckFailed), n, "Downloaded contents differ")
+}
+
+// remoteFiles returns the remote side of each pair, whose size is what the
+// local file should have
+func remoteFiles(pairs []*FilePair) []*File {
+	files := make([]*File, len(pairs))
+	for i, pair := range pairs {
+		files[i] = pair.Remote
+	}
+	return files
+}
+
+func printLargestFiles(files []*File, n int, description string) {
+	if len(files) == 0 {
+		return
+	}
+	largest := make([]*File, len(files))
+	copy(largest, files)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	fmt.Printf("%s: %d files, %s\n", description, len(files), humanize.Bytes(uint64(total)))
+	if len(largest) > n {
+		largest = largest[:n]
+	}
+	for _, file := range largest {
+		fmt.Printf("%10s  %s\n", humanize.Bytes(uint64(file.Size)), file.Path)
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// TrashedFiles lists the trashed files that were under the root, at the paths
+// they had before being trashed. Files must have been called first so the
+// folder tree is known.
+func (g *DriveListing) TrashedFiles() (files []*File, err error) {
+	trashed, err := g.listQuery("trashed = true")
+	if err != nil {
+		return nil, err
+	}
+
+	// trashed folders aren't part of the folder tree, but files inside them
+	// are trashed along with them, so resolve paths against a tree including
+	// them
+	folders := make(map[string]*googleDriveFolder, len(g.driveFolders))
+	for id, folder := range g.driveFolders {
+		folders[id] = folder
+	}
+	for _, file := range trashed {
+		if file.MimeType == folderMimeType && len(file.Parents) > 0 {
+			folders[file.Id] = &googleDriveFolder{ParentId: file.Parents[0], Name: file.Name}
+		}
+	}
+	tree := g.driveFolders
+	g.driveFolders = folders
+	defer func() { g.driveFolders = tree }()
+
+	for _, file := range trashed {
+		if file.MimeType == folderMimeType || file.MimeType == shortcutMimeType || len(file.Parents) == 0 {
+			continue
+		}
+		parentPath, err := g.buildPath(file.Parents[0])
+		if err != nil {
+			// trashed from a folder outside the tree, e.g. shared with us
+			continue
+		}
+		relPath, err := remoteRel(g.RootPath, path.Join(parentPath, filterFileName(file.Name)))
+		if err != nil || strings.HasPrefix(relPath, "..") || !g.includePath(relPath) {
+			continue
+		}
+		files = append(files, g.newRemoteFile(relPath, file))
+	}
+	return files, nil
+}
+
+// AddTrashed lists Drive's trash as a separate, informational section of the
+// comparison, and moves local-only files that were trashed at the same path
+// into DeletedRemotely (where they still count as misses)
+func (mc *ManifestComparison) AddTrashed(listing *DriveListing, rules *pathRules) error {
+	files, err := listing.TrashedFiles()
+	if err != nil {
+		return err
+	}
+	trashed := make(map[string]*File)
+	for _, file := range files {
+		if prepareRemoteFile(file, rules) {
+			mc.Trashed = append(mc.Trashed, file)
+			trashed[file.Path] = file
+		}
+	}
+
+	var onlyLocal []*File
+	for _, file := range mc.OnlyLocal {
+		if remote, ok := trashed[file.Path]; ok {
+			mc.DeletedRemotely = append(mc.DeletedRemotely, &DeletedFile{Local: file, Remote: remote})
+		} else {
+			onlyLocal = append(onlyLocal, file)
+		}
+	}
+	mc.OnlyLocal = onlyLocal
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/jessevdk/go-flags"
+)
+
+// runTree lists a Google Drive folder and prints its folder hierarchy with
+// file counts and sizes, to see what the verifier finds remotely before a
+// full comparison. It returns the process exit code.
+func runTree(args []string) int {
+	var opts struct {
+		RemoteRoot          string   `short:"r" long:"remote" description:"Directory in Google Drive to list" default:"/"`
+		RemoteId            string   `long:"remote-id" description:"ID of the Google Drive folder to list, instead of --remote"`
+		ResolveShortcuts    bool     `long:"resolve-shortcuts" description:"List Drive shortcuts as copies of their targets at the shortcut's path"`
+		SkipGooglePhotos    bool     `long:"skip-google-photos" description:"Leave out the legacy Google Photos folder"`
+		ExcludeRemote       []string `long:"exclude-remote" description:"Leave out this Google Drive folder, by path relative to the remote root (wildcards work, e.g. Archive/**); can be given more than once"`
+		ExcludeRemoteIds    []string `long:"exclude-remote-id" description:"Leave out the Google Drive folder with this ID; can be given more than once"`
+		Spaces              string   `long:"spaces" description:"Comma-separated Drive spaces to list: drive, appDataFolder" default:"drive"`
+		Depth               int      `short:"d" long:"depth" description:"Only print folders this many levels deep (0 for all); totals still include everything below"`
+		JSONPath            string   `long:"json" description:"Write the full folder tree as JSON to this path instead of printing it"`
+		Scope               string   `long:"scope" description:"Read-only Google Drive access to use: readonly or metadata (see auth login)" choice:"readonly" choice:"metadata" default:"metadata"`
+		TokenStore          string   `long:"token-store" description:"Where the Google Drive token is kept: file (token.json in the config directory) or keychain (the OS credential store)" choice:"file" choice:"keychain" default:"file"`
+		TokenPassphraseFile string   `long:"token-passphrase-file" description:"Encrypt the token file with the passphrase (or key) in this file, for systems without a keychain"`
+	}
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Usage = "tree [OPTIONS]"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		return 1
+	}
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Extra arguments provided!")
+		return 1
+	}
+	spaces, err := parseSpaces(opts.Spaces)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	excludeRemote, err := newRemoteExclusions(opts.ExcludeRemote, opts.ExcludeRemoteIds)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if tokens, err = newTokenStore(opts.TokenStore, opts.TokenPassphraseFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	root := opts.RemoteRoot
+	if opts.RemoteId != "" {
+		root = "/"
+	} else if !strings.HasPrefix(root, "/") {
+		root = "/" + root
+	}
+
+	srv, err := NewDriveService(filepath.Join(getConfigDir(), "credentials.json"), filepath.Join(getConfigDir(), "token.json"), opts.Scope)
+	if err != nil {
+		printFailure(err)
+		return 1
+	}
+	listing := NewDriveListing(srv, root, nil)
+	listing.RootId = opts.RemoteId
+	listing.ResolveShortcuts = opts.ResolveShortcuts
+	listing.SkipGooglePhotos = opts.SkipGooglePhotos
+	listing.Exclude = excludeRemote
+	listing.Spaces = spaces
+
+	updateChan := make(chan int)
+	go func() {
+		for count := range updateChan {
+			fmt.Fprintf(os.Stderr, "\rListed %d remote files", count)
+		}
+	}()
+	files, err := listing.Files(updateChan)
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		printFailure(fmt.Errorf("Unable to list Google Drive folder %s: %w", root, err))
+		return 1
+	}
+
+	tree := buildFolderTree(root, listing.FolderPaths(), files)
+	if opts.JSONPath != "" {
+		data, err := json.MarshalIndent(tree, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(opts.JSONPath, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write folder tree: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %d folders to %s\n", tree.countFolders(), opts.JSONPath)
+		return 0
+	}
+	tree.print(0, opts.Depth)
+	return 0
+}
+
+// FolderPaths returns the paths of every folder found under the root by
+// Files, relative to the root, as named in Drive
+func (g *DriveListing) FolderPaths() []string {
+	var folders []string
+	for id := range g.driveFolders {
+		fullPath, err := g.buildPath(id)
+		if err != nil {
+			continue
+		}
+		relPath, err := remoteRel(g.RootPath, fullPath)
+		if err != nil || relPath == "." || strings.HasPrefix(relPath, "../") {
+			continue
+		}
+		folders = append(folders, relPath)
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// folderNode is a remote folder with the files directly in it, and totals
+// including its subfolders
+type folderNode struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Files      int           `json:"files"`
+	Size       int64         `json:"size"`
+	TotalFiles int           `json:"totalFiles"`
+	TotalSize  int64         `json:"totalSize"`
+	Folders    []*folderNode `json:"folders,omitempty"`
+	children   map[string]*folderNode
+}
+
+// buildFolderTree arranges folder paths and files (by their paths as named
+// in Drive) into a tree under root
+func buildFolderTree(root string, folderPaths []string, files []*File) *folderNode {
+	tree := &folderNode{Name: root, children: make(map[string]*folderNode)}
+	for _, folderPath := range folderPaths {
+		tree.folder(folderPath)
+	}
+	for _, file := range files {
+		folder := tree.folder(path.Dir(file.RawPath))
+		folder.Files++
+		folder.Size += file.Size
+	}
+	tree.total()
+	return tree
+}
+
+// folder returns the node for a path relative to n, creating it and its
+// ancestors if needed
+func (n *folderNode) folder(relPath string) *folderNode {
+	if relPath == "." || relPath == "" {
+		return n
+	}
+	parent := n.folder(path.Dir(relPath))
+	name := path.Base(relPath)
+	child, ok := parent.children[name]
+	if !ok {
+		child = &folderNode{Name: name, Path: relPath, children: make(map[string]*folderNode)}
+		parent.children[name] = child
+	}
+	return child
+}
+
+// total sorts subfolders by name and adds up the totals
+func (n *folderNode) total() {
+	n.TotalFiles, n.TotalSize = n.Files, n.Size
+	n.Folders = nil
+	for _, child := range n.children {
+		child.total()
+		n.Folders = append(n.Folders, child)
+		n.TotalFiles += child.TotalFiles
+		n.TotalSize += child.TotalSize
+	}
+	sort.Slice(n.Folders, func(i, j int) bool { return n.Folders[i].Name < n.Folders[j].Name })
+}
+
+func (n *folderNode) countFolders() int {
+	count := 1
+	for _, child := range n.Folders {
+		count += child.countFolders()
+	}
+	return count
+}
+
+// print prints n and its subfolders down to maxDepth levels (0 for all)
+func (n *folderNode) print(depth int, maxDepth int) {
+	name := n.Name
+	if depth > 0 {
+		name += "/"
+	}
+	fmt.Printf("%s%s (%s files, %s)\n", strings.Repeat("  ", depth), name, humanize.Comma(int64(n.TotalFiles)), humanize.Bytes(uint64(n.TotalSize)))
+	if maxDepth > 0 && depth >= maxDepth {
+		return
+	}
+	for _, child := range n.Folders {
+		child.print(depth+1, maxDepth)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// runTUI shows scan progress while verify runs, then lets the user browse the
+// results by category and directory, mark files as expected, and export the
+// rest to exportPath. It returns verify's result once the user quits.
+func runTUI(progress *scanProgress, verify func() (*verifyResult, error), exportPath string) (*verifyResult, error) {
+	model := &tuiModel{
+		progress:   progress,
+		verify:     verify,
+		exportPath: exportPath,
+		expected:   make(map[string]bool),
+		category:   -1,
+	}
+	final, err := tea.NewProgram(model, tea.WithAltScreen()).Run()
+	if err != nil {
+		return nil, err
+	}
+	model = final.(*tuiModel)
+	if model.result == nil && model.err == nil {
+		return nil, errors.New("Cancelled before verification finished")
+	}
+	return model.result, model.err
+}
+
+type tuiTickMsg time.Time
+
+type tuiDoneMsg struct {
+	result *verifyResult
+	err    error
+}
+
+// tuiCategory holds the results with one status
+type tuiCategory struct {
+	Title   string
+	Results []*FileResult
+}
+
+// tuiEntry is a row in the directory view: a subdirectory or a file
+type tuiEntry struct {
+	Name   string
+	Path   string
+	Result *FileResult
+	// Count is the number of files under a subdirectory not marked expected
+	Count int
+}
+
+type tuiModel struct {
+	progress   *scanProgress
+	verify     func() (*verifyResult, error)
+	exportPath string
+
+	result     *verifyResult
+	err        error
+	categories []*tuiCategory
+	// expected holds files marked as expected, by tuiKey
+	expected map[string]bool
+
+	// category is the open category, or -1 for the category list
+	category int
+	dir      string
+	cursor   int
+	height   int
+	message  string
+}
+
+func tuiKey(result *FileResult) string {
+	return string(result.Status) + ":" + result.Path
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(tuiTick(), func() tea.Msg {
+		result, err := m.verify()
+		return tuiDoneMsg{result, err}
+	})
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(progressInterval, func(t time.Time) tea.Msg { return tuiTickMsg(t) })
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tuiTickMsg:
+		if m.result == nil && m.err == nil {
+			return m, tuiTick()
+		}
+	case tuiDoneMsg:
+		m.result, m.err = msg.result, msg.err
+		if m.err != nil {
+			return m, tea.Quit
+		}
+		m.loadCategories()
+	case tea.KeyMsg:
+		return m, m.handleKey(msg.String())
+	}
+	return m, nil
+}
+
+func (m *tuiModel) loadCategories() {
+	byStatus := make(map[FileStatus][]*FileResult)
+	for _, result := range m.result.Comparison.Results() {
+		byStatus[result.Status] = append(byStatus[result.Status], result)
+	}
+	for _, section := range htmlSections {
+		if results := byStatus[section.Status]; len(results) > 0 {
+			sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+			m.categories = append(m.categories, &tuiCategory{Title: section.Title, Results: results})
+		}
+	}
+}
+
+func (m *tuiModel) handleKey(key string) tea.Cmd {
+	if key == "ctrl+c" || key == "q" {
+		return tea.Quit
+	}
+	if m.result == nil {
+		return nil
+	}
+	m.message = ""
+	rows := m.rowCount()
+	switch key {
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < rows-1 {
+			m.cursor++
+		}
+	case "enter", "right", "l":
+		m.open()
+	case "left", "h", "backspace":
+		m.back()
+	case " ", "x":
+		m.toggleExpected()
+	case "e":
+		count, err := m.export()
+		if err != nil {
+			m.message = fmt.Sprintf("Unable to export: %v", err)
+		} else {
+			m.message = fmt.Sprintf("Exported %d files to %s", count, m.exportPath)
+		}
+	}
+	return nil
+}
+
+func (m *tuiModel) rowCount() int {
+	if m.category < 0 {
+		return len(m.categories)
+	}
+	return len(m.entries())
+}
+
+// entries lists the subdirectories and files directly in the current
+// directory of the open category
+func (m *tuiModel) entries() []*tuiEntry {
+	var dirs, files []*tuiEntry
+	dirIndex := make(map[string]*tuiEntry)
+	for _, result := range m.categories[m.category].Results {
+		if !strings.HasPrefix(result.Path, m.dir) {
+			continue
+		}
+		rest := strings.TrimPrefix(result.Path, m.dir)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name := rest[:i]
+			dir, ok := dirIndex[name]
+			if !ok {
+				dir = &tuiEntry{Name: name + "/", Path: m.dir + name + "/"}
+				dirIndex[name] = dir
+				dirs = append(dirs, dir)
+			}
+			if !m.expected[tuiKey(result)] {
+				dir.Count++
+			}
+			continue
+		}
+		files = append(files, &tuiEntry{Name: rest, Path: result.Path, Result: result})
+	}
+	return append(dirs, files...)
+}
+
+func (m *tuiModel) open() {
+	if m.category < 0 {
+		if m.cursor < len(m.categories) {
+			m.category, m.dir, m.cursor = m.cursor, "", 0
+		}
+		return
+	}
+	entries := m.entries()
+	if m.cursor < len(entries) && entries[m.cursor].Result == nil {
+		m.dir, m.cursor = entries[m.cursor].Path, 0
+	}
+}
+
+func (m *tuiModel) back() {
+	if m.category < 0 {
+		return
+	}
+	if m.dir == "" {
+		m.category, m.cursor = -1, m.category
+		return
+	}
+	parent := strings.TrimSuffix(m.dir, "/")
+	if i := strings.LastIndex(parent, "/"); i >= 0 {
+		m.dir = parent[:i+1]
+	} else {
+		m.dir = ""
+	}
+	m.cursor = 0
+}
+
+// toggleExpected marks the selected file, or every file under the selected
+// directory, as expected (or clears the mark)
+func (m *tuiModel) toggleExpected() {
+	if m.category < 0 {
+		return
+	}
+	entries := m.entries()
+	if m.cursor >= len(entries) {
+		return
+	}
+	entry := entries[m.cursor]
+	if entry.Result != nil {
+		key := tuiKey(entry.Result)
+		m.expected[key] = !m.expected[key]
+		return
+	}
+	// mark the whole directory unless it's already entirely marked
+	mark := entry.Count > 0
+	for _, result := range m.categories[m.category].Results {
+		if strings.HasPrefix(result.Path, entry.Path) {
+			m.expected[tuiKey(result)] = mark
+		}
+	}
+}
+
+// export writes the results not marked as expected as a JSON array
+func (m *tuiModel) export() (int, error) {
+	remaining := []*jsonFileResult{}
+	for _, category := range m.categories {
+		for _, result := range category.Results {
+			if result.Status != StatusMatch && !m.expected[tuiKey(result)] {
+				remaining = append(remaining, newJSONFileResult(result))
+			}
+		}
+	}
+	data, err := json.MarshalIndent(remaining, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(remaining), ioutil.WriteFile(m.exportPath, data, 0644)
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	if m.result == nil {
+		totals := m.progress.Snapshot()
+		fmt.Fprintf(&b, "Scanning: %d (remote) %d (local) %d (errored)\n\nq to cancel\n", totals.RemoteListed, totals.LocalFiles, totals.LocalErrored)
+		return b.String()
+	}
+
+	var rows []string
+	if m.category < 0 {
+		mc := m.result.Comparison
+		fmt.Fprintf(&b, "Files matched: %d/%d\n\n", mc.Matches, mc.Matches+mc.Misses)
+		for _, category := range m.categories {
+			expected := 0
+			for _, result := range category.Results {
+				if m.expected[tuiKey(result)] {
+					expected++
+				}
+			}
+			rows = append(rows, fmt.Sprintf("%s: %d (%d expected)", category.Title, len(category.Results), expected))
+		}
+		if len(rows) == 0 {
+			rows = append(rows, "Nothing to review.")
+		}
+	} else {
+		fmt.Fprintf(&b, "%s: /%s\n\n", m.categories[m.category].Title, m.dir)
+		for _, entry := range m.entries() {
+			if entry.Result == nil {
+				rows = append(rows, fmt.Sprintf("    %s (%d)", entry.Name, entry.Count))
+			} else if m.expected[tuiKey(entry.Result)] {
+				rows = append(rows, "[x] "+entry.Name)
+			} else {
+				rows = append(rows, "[ ] "+entry.Name)
+			}
+		}
+	}
+
+	// scroll to keep the cursor in view
+	visible := len(rows)
+	if m.height > 6 && visible > m.height-6 {
+		visible = m.height - 6
+	}
+	start := 0
+	if m.cursor >= visible {
+		start = m.cursor - visible + 1
+	}
+	for i := start; i < start+visible && i < len(rows); i++ {
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		b.WriteString(cursor + rows[i] + "\n")
+	}
+
+	b.WriteString("\n")
+	if m.message != "" {
+		b.WriteString(m.message + "\n")
+	}
+	b.WriteString("↑/↓ move  enter open  ← back  space mark expected  e export  q quit\n")
+	return b.String()
+}
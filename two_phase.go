@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// With --two-phase, files are first compared by path and size only, which
+// needs no reading, and what that finds is printed straight away. Local files
+// whose size matched are then hashed, and content mismatches are printed as
+// they're found, so a multi-hour run gives early feedback. Only-local files
+// that could be possible matches are hashed last, before looking for them.
+
+// sizeFirst returns the strategies for the first phase: sizes are compared
+// wherever s would compare hashes
+func (s *compareStrategies) sizeFirst() *compareStrategies {
+	var rules []*strategyRule
+	if s != nil {
+		for _, rule := range s.rules {
+			strategy := rule.Strategy
+			if strategy == strategyHash {
+				strategy = strategySize
+			}
+			rules = append(rules, &strategyRule{Prefix: rule.Prefix, Strategy: strategy})
+		}
+	}
+	// everything else, after the longer prefixes
+	rules = append(rules, &strategyRule{Prefix: "", Strategy: strategySize})
+	return &compareStrategies{rules: rules}
+}
+
+// PrintStructuralDifferences prints what the first phase found: files only on
+// one side and files whose sizes differ
+func (mc *ManifestComparison) PrintStructuralDifferences() {
+	fmt.Printf("PHASE 1: compared paths and sizes (%d files to hash)\n\n", mc.Matches)
+	if len(mc.OnlyRemote) > 0 {
+		printFileList(mc.OnlyRemote, "Files only in remote")
+	}
+	if len(mc.OnlyLocal) > 0 {
+		printFileList(mc.OnlyLocal, "Files only in local")
+	}
+	if len(mc.ContentMismatch) > 0 {
+		printMismatchList(mc.ContentMismatch, "Files whose sizes don't match")
+	}
+	if len(mc.EmptyLocal) > 0 {
+		printMismatchList(mc.EmptyLocal, "Empty local files (need re-downloading)")
+	}
+	fmt.Println("PHASE 2: comparing contents")
+	fmt.Println("")
+}
+
+// HashMatched is the second phase: the local files of matches are hashed and
+// compared with the remote checksums, where strategies call for it, and
+// mismatches are printed (unless quiet) and written to stream, if set, as
+// they're found.
+// Matches must have been recorded, with local full paths. If ctx is
+// cancelled, the files not yet hashed are set aside as unverified and
+// complete is false.
+func (mc *ManifestComparison) HashMatched(ctx context.Context, opts localScanOptions, stream *resultStream, quiet bool) (bytesHashed int64, complete bool) {
+	workers := opts.WorkerCount
+	if opts.IOConcurrency > 0 && opts.IOConcurrency < workers {
+		workers = opts.IOConcurrency
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var matched []*FilePair
+	var mu sync.Mutex
+	unmatch := func(pair *FilePair) {
+		mc.Matches--
+		mc.MatchedBytes -= pair.Remote.Size
+		mc.fileTypes.unmatch(pair.Remote)
+	}
+	work := make(chan *FilePair)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range work {
+				hash := ""
+				var err error
+				hashing := opts.Strategies.hashes(pair.Local.Path) && !isHashUnavailable(pair.Remote) && !(pair.Local.Placeholder && opts.SkipPlaceholders)
+				if hashing {
+					hash, err = hashLocalFileWithTimeout(ctx, pair.Local.FullPath, opts.HashTimeout, opts.HashAlgo, opts.ReadLimiter)
+				}
+
+				mu.Lock()
+				switch {
+				case !opts.Strategies.hashes(pair.Local.Path):
+					matched = append(matched, pair)
+					if stream != nil {
+						stream.match(pair.Remote, pair.Local)
+					}
+				case isHashUnavailable(pair.Remote):
+					unmatch(pair)
+					mc.HashUnavailable = append(mc.HashUnavailable, pair)
+				case !hashing:
+					unmatch(pair)
+					mc.Placeholders = append(mc.Placeholders, pair)
+				case err != nil && ctx.Err() != nil:
+					unmatch(pair)
+					mc.Unverified++
+				case err != nil:
+					logger.Warn("unable to read local file", "path", pair.Local.FullPath, "error", err)
+					unmatch(pair)
+					mc.Errored = append(mc.Errored, &FileError{Path: pair.Local.Path, Error: categorized(err), Attempts: 1})
+				default:
+					pair.Local.ContentHash = hash
+					bytesHashed += pair.Local.Size
+					if compareFileContents(pair.Remote, pair.Local) {
+						matched = append(matched, pair)
+						if stream != nil {
+							stream.match(pair.Remote, pair.Local)
+						}
+					} else {
+						unmatch(pair)
+						mc.ContentMismatch = append(mc.ContentMismatch, pair)
+						mc.Misses++
+						if !quiet {
+							fmt.Printf("Contents don't match: %s\n", pair.Local.Path)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, pair := range mc.Matched {
+		work <- pair
+	}
+	close(work)
+	wg.Wait()
+
+	// the workers finish in any order
+	for _, pairs := range [][]*FilePair{matched, mc.ContentMismatch, mc.HashUnavailable, mc.Placeholders} {
+		sortPairsByPath(pairs)
+	}
+	sort.Slice(mc.Errored, func(i, j int) bool { return mc.Errored[i].Path < mc.Errored[j].Path })
+	mc.Matched = matched
+	return bytesHashed, ctx.Err() == nil
+}
+
+// HashPossibleMatches hashes the only-local files that could be possible
+// matches, those whose path rewritten by the possible match rules is the same
+// as an only-remote file's, so FindPossibleMatches can pair them. A file that
+// can't be read is left unhashed, and stays only in local.
+func (mc *ManifestComparison) HashPossibleMatches(ctx context.Context, opts localScanOptions) (bytesHashed int64) {
+	rules := opts.PathRules
+	if rules == nil || len(rules.PossibleMatches) == 0 || len(mc.OnlyRemote) == 0 {
+		return 0
+	}
+	remotePaths := make(map[string]bool)
+	for _, file := range mc.OnlyRemote {
+		if !isHashUnavailable(file) {
+			remotePaths[rules.possibleMatch(file.Path)] = true
+		}
+	}
+	workers := opts.WorkerCount
+	if opts.IOConcurrency > 0 && opts.IOConcurrency < workers {
+		workers = opts.IOConcurrency
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	work := make(chan *File)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range work {
+				hash, err := hashLocalFileWithTimeout(ctx, file.FullPath, opts.HashTimeout, opts.HashAlgo, opts.ReadLimiter)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Warn("unable to read local file", "path", file.FullPath, "error", err)
+					}
+					continue
+				}
+				mu.Lock()
+				file.ContentHash = hash
+				bytesHashed += file.Size
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range mc.OnlyLocal {
+		if ctx.Err() != nil {
+			break
+		}
+		hashing := opts.Strategies.hashes(file.Path) && !(file.Placeholder && opts.SkipPlaceholders)
+		if hashing && file.ContentHash == "" && file.FullPath != "" && remotePaths[rules.possibleMatch(file.Path)] {
+			work <- file
+		}
+	}
+	close(work)
+	wg.Wait()
+	return bytesHashed
+}
+
+func sortPairsByPath(pairs []*FilePair) {
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Local.Path < pairs[j].Local.Path })
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// verifyConfig describes a single verification of a local directory against
+// a Google Drive directory
+type verifyConfig struct {
+	// RemoteRoot is an absolute Drive path and LocalRoot an absolute local path
+	RemoteRoot string
+	LocalRoot  string
+	// RemoteRootId, if set, identifies the remote root folder directly and
+	// RemoteRoot is "/"
+	RemoteRootId string
+	// RemoteQuery limits the remote listing to files matching a Drive query;
+	// local files outside it aren't reported
+	RemoteQuery string
+	// LocalRoots, if set, are several local roots (the first being
+	// LocalRoot) verified together against RemoteRoot
+	LocalRoots []localRootSpec
+	// LocalDirs restricts verification to these folders (selective sync)
+	LocalDirs        []string
+	ResolveShortcuts bool
+	// SkipGooglePhotos leaves the legacy Google Photos folder out of the
+	// remote listing
+	SkipGooglePhotos bool
+	// ExcludeRemote leaves remote folders out of the listing (nil to list
+	// everything)
+	ExcludeRemote *remoteExclusions
+	// ListGoogleNative lists the Google-native files that weren't verified,
+	// not just how many there were
+	ListGoogleNative bool
+	// Spaces lists the Drive spaces to list (see DriveListing.Spaces)
+	Spaces string
+	// OwnedOnly and IncludeShared control which shared files are listed
+	// (see DriveListing)
+	OwnedOnly      bool
+	IncludeShared  bool
+	RecheckFolders int
+	// CheckTrash is the maximum number of local-only files to look up in
+	// Drive's trash (0 to disable)
+	CheckTrash int
+	// IncludeTrashed lists Drive's trash alongside the comparison
+	IncludeTrashed bool
+	// CheckRevisions is the maximum number of content mismatches to compare
+	// against earlier remote revisions (0 to disable)
+	CheckRevisions int
+	// SpotCheck is the percentage of matched files to download and compare
+	// byte for byte (0 to disable); it needs RecordMatches and
+	// Local.RecordFullPaths
+	SpotCheck float64
+	// Baseline holds accepted differences, which don't count as misses (nil
+	// for none)
+	Baseline      *baseline
+	RecordMatches bool
+	// FileTypes counts results by extension and size
+	FileTypes bool
+	// LocalDuplicates reports local files with the same contents
+	LocalDuplicates bool
+	// AdaptiveHash waits for the remote listing before hashing, and skips
+	// local files whose remote copy has no checksum
+	AdaptiveHash bool
+	// Paths, if set, are the only files verified (relative to the roots,
+	// with forward slashes), looked up directly rather than listing and
+	// scanning everything
+	Paths []string
+	// TwoPhase compares paths and sizes before hashing the local files that
+	// matched (which needs RecordMatches and Local.RecordFullPaths)
+	TwoPhase bool
+	// Quiet leaves out the notes printed while verifying, for --quiet
+	Quiet bool
+	// Grade sets the score thresholds (nil for the defaults)
+	Grade *gradeThresholds
+	// Stream writes results as they're found (nil to not stream)
+	Stream *resultStream
+	// SaveRemoteManifest writes the remote listing to this path, and
+	// LoadRemoteManifest reads one instead of listing Google Drive
+	SaveRemoteManifest string
+	LoadRemoteManifest string
+	// RemoteCache reuses a recent Google Drive listing, or saves this one
+	// for later runs (nil for no cache)
+	RemoteCache *remoteCache
+	// Provider lists the remote root (nil for Google Drive, which is listed
+	// with a DriveListing and supports the extra checks)
+	Provider RemoteProvider
+	// RemoteTimeout bounds each Drive API request (0 for no limit)
+	RemoteTimeout time.Duration
+	// Likewise for the local scan, so files needn't be hashed again
+	SaveLocalManifest string
+	LoadLocalManifest string
+	// Checkpoint is where the local scan is saved if verification is
+	// cancelled ("" to not save), and Resume reuses hashes from it
+	Checkpoint string
+	Resume     bool
+	// HashCache is where local hashes are saved with an xxHash, to be
+	// reused by the next run for files whose xxHash is unchanged ("" for
+	// no cache)
+	HashCache string
+	// DriveScope names the read-only scope to authorize Google Drive with
+	DriveScope string
+	Local      localScanOptions
+}
+
+// verifyResult holds the outcome of runVerification
+type verifyResult struct {
+	Comparison *ManifestComparison
+	Stats      *RunStats
+	// Recheck is nil if no re-check was attempted
+	Recheck    *RecheckResult
+	RecheckErr error
+	// TrashErr is set if looking up local-only files in the trash failed
+	TrashErr error
+	// RevisionsErr is set if looking up revisions of mismatches failed
+	RevisionsErr error
+	// SpotCheck is nil if no spot check was made
+	SpotCheck    *SpotCheckResult
+	SpotCheckErr error
+	// NotSelected lists remote folders skipped by selective sync
+	NotSelected []string
+	// Listing is nil if a saved remote manifest or cached listing was loaded
+	// or Provider was set
+	Listing *DriveListing
+	// Partial is set if verification was cancelled before the scans
+	// finished; SavedCheckpoint is set if the local scan was saved for
+	// --resume
+	Partial         bool
+	SavedCheckpoint bool
+}
+
+// resolveRoots turns user-provided roots into the absolute forms used by
+// verifyConfig, listing selected local folders for selective sync
+func resolveRoots(localArg string, remoteArg string, selection selectiveSync) (localRoot string, remoteRoot string, localDirs []string, err error) {
+	localRoot, err = filepath.Abs(localArg)
+	if err != nil {
+		return
+	}
+	if selection.enabled() {
+		localDirs, err = selection.folders(localRoot)
+		if err != nil {
+			return
+		}
+	}
+
+	remoteRoot = remoteArg
+	if remoteRoot == "" {
+		remoteRoot = defaultRemoteRoot(localRoot)
+	}
+	if remoteRoot[0] != '/' {
+		remoteRoot = "/" + remoteRoot
+	}
+	return
+}
+
+// resolveWorkerCount treats counts of 0 or less as "use all CPU cores"
+func resolveWorkerCount(workerCount int) int {
+	if workerCount <= 0 {
+		return int(math.Max(1, float64(runtime.NumCPU())))
+	}
+	return workerCount
+}
+
+// scanLocal builds the local manifest from the local directory or from a saved
+// local manifest, saving it if configured
+func scanLocal(ctx context.Context, progress *scanProgress, config *verifyConfig) (*localScanResult, error) {
+	if config.LoadLocalManifest != "" {
+		return loadLocalManifest(progress, config.LoadLocalManifest, config)
+	}
+	if len(config.LocalRoots) > 0 {
+		return scanLocalRoots(ctx, progress, config)
+	}
+	if len(config.Paths) > 0 {
+		return scanLocalPaths(ctx, progress, config)
+	}
+	opts := config.Local
+	if config.Resume && config.Checkpoint != "" {
+		resume, err := loadCheckpoint(config)
+		if os.IsNotExist(err) && !config.Quiet {
+			fmt.Println("No checkpoint to resume from; scanning everything.")
+		} else if err != nil && !os.IsNotExist(err) {
+			logger.Warn("unable to resume from checkpoint", "error", err)
+		} else if err == nil {
+			if !config.Quiet {
+				fmt.Printf("Resuming with %d files hashed by the cancelled run.\n", len(resume))
+			}
+			opts.resume = resume
+		}
+	}
+	if config.HashCache != "" {
+		cached, err := loadHashCache(config)
+		if os.IsNotExist(err) {
+			logger.Info("no hash cache yet; hashing everything", "path", config.HashCache)
+		} else if err != nil {
+			logger.Warn("unable to load hash cache", "error", err)
+		} else {
+			logger.Info("loaded hash cache", "path", config.HashCache, "hashes", len(cached))
+			opts.cached = cached
+		}
+		cache, err := createHashCache(config, opts.cached)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to save hash cache: %v", err)
+		}
+		opts.cache = cache
+	}
+	if config.SaveLocalManifest != "" {
+		save, err := createLocalManifestFile(config.SaveLocalManifest, config)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to save local manifest: %v", err)
+		}
+		opts.save = save
+	}
+	scan, err := getLocalManifest(ctx, progress, config.LocalRoot, config.LocalDirs, opts)
+	if opts.save != nil {
+		saveErr := opts.save.Close()
+		if err == nil && saveErr != nil {
+			scan.Manifest.Close()
+			return nil, fmt.Errorf("Unable to save local manifest: %v", saveErr)
+		}
+	}
+	if opts.cache != nil {
+		// a cancelled scan would drop the files it didn't reach
+		if err != nil || !scan.Complete {
+			opts.cache.Discard()
+		} else if cacheErr := opts.cache.Commit(); cacheErr != nil {
+			logger.Warn("unable to save hash cache", "error", cacheErr)
+		}
+	}
+	return scan, err
+}
+
+// includesRemote reports whether a listed remote file is within the shard,
+// sample, size and time filters and comparison strategies being verified,
+// noting why it's skipped if not
+func (config *verifyConfig) includesRemote(file *File) bool {
+	if !prepareRemoteFile(file, config.Local.PathRules) {
+		return false
+	}
+	skip := func(reason, note string) bool {
+		logger.Debug("skipped remote file", "path", file.Path, "reason", reason)
+		if explainer.wants(file.Path) {
+			explainer.note("remote: skipped, %s", note)
+		}
+		return false
+	}
+	switch {
+	case !config.Local.Shard.includes(file.Path):
+		return skip("outside shard", "outside the shard being verified")
+	case !config.Local.Sample.includes(file.Path):
+		return skip("not sampled", "not in the sample being verified")
+	case !config.Local.Filter.includes(file.Size, file.ModifiedTime):
+		return skip("outside size or modification time filter", "outside the size or modification time filter")
+	case config.Local.Strategies.skips(file.Path):
+		return skip("comparison strategy is skip", "comparison strategy is skip")
+	}
+	return true
+}
+
+// runVerification scans Google Drive and the local directory concurrently,
+// recording progress in progress (which is finished once both scans are),
+// then compares the results. If ctx is cancelled the scans stop early and
+// whatever they found is compared, marked as partial.
+func runVerification(ctx context.Context, srv *drive.Service, config *verifyConfig, progress *scanProgress) (*verifyResult, error) {
+	runStart := time.Now()
+	stats := &RunStats{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	listing := NewDriveListing(srv, config.RemoteRoot, config.LocalDirs)
+	listing.ResolveShortcuts = config.ResolveShortcuts
+	listing.RootId = config.RemoteRootId
+	listing.Query = config.RemoteQuery
+	listing.HashAlgo = config.Local.HashAlgo
+	listing.SkipGooglePhotos = config.SkipGooglePhotos
+	listing.Exclude = config.ExcludeRemote
+	listing.ListGoogleNative = config.ListGoogleNative
+	listing.CountsNative = config.includesRemote
+	listing.Spaces = config.Spaces
+	listing.OwnedOnly = config.OwnedOnly
+	listing.IncludeShared = config.IncludeShared
+	listing.RequestTimeout = config.RemoteTimeout
+	provider := config.Provider
+	if provider == nil && len(config.Paths) > 0 {
+		provider = &drivePathsProvider{listing: listing, paths: config.Paths}
+	} else if provider == nil {
+		provider = &driveProvider{listing: listing}
+	}
+	var driveManifest *sortedManifest
+	var driveError error
+	if config.AdaptiveHash && config.LoadLocalManifest == "" {
+		config.Local.remoteHashes = newRemoteHashes()
+	}
+	cachedListing := ""
+	if config.RemoteCache != nil && config.LoadRemoteManifest == "" && config.Provider == nil {
+		cachedListing, _ = config.RemoteCache.lookup(config)
+	}
+	go func() {
+		start := time.Now()
+		if config.LoadRemoteManifest != "" {
+			driveManifest, driveError = loadRemoteManifest(progress, config.LoadRemoteManifest, config)
+		} else if cachedListing != "" {
+			driveManifest, driveError = loadRemoteManifest(progress, cachedListing, config)
+		} else {
+			driveManifest, driveError = getRemoteManifest(ctx, progress, provider, config)
+		}
+		config.Local.remoteHashes.finish(driveError == nil)
+		stats.RemoteDuration = time.Since(start)
+		wg.Done()
+	}()
+
+	localConfig := config
+	if config.TwoPhase {
+		// the first phase hashes nothing
+		phaseOne := *config
+		phaseOne.Local.Strategies = config.Local.Strategies.sizeFirst()
+		localConfig = &phaseOne
+	}
+	var localScan *localScanResult
+	var localErr error
+	go func() {
+		start := time.Now()
+		localScan, localErr = scanLocal(ctx, progress, localConfig)
+		stats.LocalDuration = time.Since(start)
+		wg.Done()
+	}()
+
+	// wait until remote and local scans are complete, then finalize progress
+	wg.Wait()
+	totals := progress.finish()
+
+	if driveManifest != nil {
+		defer driveManifest.Close()
+	}
+	if localScan != nil {
+		defer localScan.Manifest.Close()
+	}
+	if driveError != nil {
+		return nil, driveError
+	}
+	if localErr != nil {
+		return nil, localErr
+	}
+
+	stats.RemoteListed = totals.RemoteListed
+	stats.RemoteFiles = totals.RemoteFiles
+	stats.LocalFiles = totals.LocalFiles
+	stats.LocalErrored = totals.LocalErrored
+	stats.LocalBytesHashed = totals.LocalBytesHashed
+	stats.LocalHardLinks = localScan.HardLinks
+	stats.LocalClones = localScan.Clones
+	stats.LocalNotHashed = config.Local.remoteHashes.Skipped()
+	compareStart := time.Now()
+	partial := ctx.Err() != nil
+	var localManifest manifestReader = localScan.Manifest
+	var checkpoint *checkpointManifest
+	if partial && config.Checkpoint != "" && config.LoadLocalManifest == "" {
+		var err error
+		if checkpoint, err = createCheckpoint(config, localScan.Manifest); err != nil {
+			logger.Error("unable to save checkpoint", "error", err)
+		} else {
+			localManifest = checkpoint
+		}
+	}
+	comparisonOpts := ComparisonOptions{
+		PathRules:       config.Local.PathRules,
+		RecordMatches:   config.RecordMatches,
+		Strategies:      localConfig.Local.Strategies,
+		FileTypes:       config.FileTypes,
+		Grade:           config.Grade,
+		LocalDuplicates: config.LocalDuplicates,
+		// only-local files are hashed in the second phase
+		DeferPossibleMatches: config.TwoPhase,
+	}
+	if config.Stream != nil && !config.TwoPhase {
+		comparisonOpts.OnMatch = config.Stream.match
+		// rechecking folders and revisions can still change a mismatch
+		if config.RecheckFolders == 0 && config.CheckRevisions == 0 {
+			comparisonOpts.OnMismatch = config.Stream.mismatch(config.Baseline)
+		}
+	}
+	comparison := compareManifests(driveManifest, localManifest, localScan.Errored, comparisonOpts)
+	if err := driveManifest.Err(); err != nil {
+		return nil, err
+	}
+	if err := localScan.Manifest.Err(); err != nil {
+		return nil, err
+	}
+	if config.TwoPhase {
+		if !partial && !config.Quiet {
+			comparison.PrintStructuralDifferences()
+		}
+		// once cancelled, files that matched by size are left unverified
+		start := time.Now()
+		hashed, complete := comparison.HashMatched(ctx, config.Local, config.Stream, config.Quiet)
+		candidates := comparison.HashPossibleMatches(ctx, config.Local)
+		hashed += candidates
+		comparison.FindPossibleMatches(config.Local.PathRules)
+		hashing := time.Since(start)
+		stats.LocalBytesHashed += hashed
+		stats.LocalDuration += hashing
+		compareStart = compareStart.Add(hashing)
+		partial = partial || !complete
+	}
+	result := &verifyResult{Comparison: comparison, Stats: stats, Partial: partial}
+	if checkpoint != nil {
+		if err := checkpoint.Close(); err != nil {
+			logger.Error("unable to save checkpoint", "error", err)
+		} else {
+			result.SavedCheckpoint = true
+		}
+	}
+	comparison.SkippedSymlinks = localScan.SkippedSymlinks
+	comparison.IgnoredLocal = localScan.Ignored
+	comparison.Recovered = localScan.Recovered
+	comparison.Xattrs = localScan.Xattrs
+	if config.RemoteQuery != "" {
+		comparison.IgnoreOnlyLocal()
+	}
+
+	// whether Google Drive was listed, rather than a loaded manifest, a
+	// cached listing, a few paths or another provider
+	listed := config.LoadRemoteManifest == "" && cachedListing == "" && config.Provider == nil && len(config.Paths) == 0
+	if partial {
+		remoteComplete := !listed || !listing.Incomplete
+		localComplete := config.LoadLocalManifest != "" || localScan.Complete
+		comparison.markPartial(remoteComplete, localComplete)
+	} else if config.Resume {
+		removeCheckpoint(config)
+	}
+
+	// without a Drive listing there's no folder tree to re-check against,
+	// and the follow-up lookups aren't worth waiting for once cancelled
+	if listed {
+		result.Listing = listing
+		result.NotSelected = listing.UnselectedFolders()
+		comparison.GoogleNative = listing.GoogleNative
+	}
+	if listed && !partial && config.Local.RecordFolders {
+		comparison.CompareFolders(listing.Folders(config.Local.PathRules), localScan.Folders)
+	}
+	if listed && !partial {
+		if config.RecheckFolders > 0 && !comparison.IsSuspect() {
+			result.Recheck, result.RecheckErr = comparison.RecheckRemote(listing, config.Local.PathRules, config.RecheckFolders)
+		}
+		if config.IncludeTrashed && !comparison.IsSuspect() {
+			result.TrashErr = comparison.AddTrashed(listing, config.Local.PathRules)
+		}
+		if config.CheckRevisions > 0 && !comparison.IsSuspect() {
+			if config.Local.HashAlgo != hashMD5 {
+				logger.Warn("revisions can only be checked with md5 hashes", "hashAlgo", config.Local.HashAlgo)
+			} else {
+				result.RevisionsErr = comparison.FindStaleRevisions(listing, config.CheckRevisions)
+			}
+		}
+		if config.CheckTrash > 0 && !comparison.IsSuspect() && result.TrashErr == nil {
+			result.TrashErr = comparison.FindDeletedRemotely(listing, config.CheckTrash)
+		}
+		if config.SpotCheck > 0 && !comparison.IsSuspect() {
+			result.SpotCheck, result.SpotCheckErr = comparison.SpotCheck(listing, config.SpotCheck)
+		}
+	}
+	if config.Baseline != nil {
+		comparison.Acknowledge(config.Baseline)
+	}
+	if config.Local.Sample != nil && !partial {
+		comparison.Sample = newSampleEstimate(config.Local.Sample, comparison)
+	}
+	explainer.noteResults(comparison)
+	stats.CompareDuration = time.Since(compareStart)
+	stats.RemoteAPICalls = listing.APICalls
+	stats.TotalDuration = time.Since(runStart)
+
+	return result, nil
+}
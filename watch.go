@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchState tracks the current classification of every known path so that
+// only paths affected by changes need to be re-verified
+type watchState struct {
+	config    *verifyConfig
+	listing   *DriveListing
+	remote    map[string]*File
+	remoteIds map[string]string
+	local     map[string]*File
+	status    map[string]FileStatus
+	// remoteByHash and localByHash index each side's paths by content hash,
+	// to find possible matches
+	remoteByHash map[string]map[string]bool
+	localByHash  map[string]map[string]bool
+}
+
+func newWatchState(config *verifyConfig, listing *DriveListing, mc *ManifestComparison) *watchState {
+	w := &watchState{
+		config:       config,
+		listing:      listing,
+		remote:       make(map[string]*File),
+		remoteIds:    make(map[string]string),
+		local:        make(map[string]*File),
+		status:       make(map[string]FileStatus),
+		remoteByHash: make(map[string]map[string]bool),
+		localByHash:  make(map[string]map[string]bool),
+	}
+	// nothing is reported for the initial state
+	affected := make(map[string]bool)
+	for _, result := range mc.Results() {
+		if result.Remote != nil {
+			w.setRemote(result.Remote, affected)
+			w.status[result.Remote.Path] = result.Status
+		}
+		if result.Local != nil {
+			w.setLocal(result.Local, affected)
+			w.status[result.Local.Path] = result.Status
+		}
+		if result.Status == StatusError {
+			w.status[result.Path] = result.Status
+		}
+	}
+	return w
+}
+
+func (w *watchState) setRemote(file *File, affected map[string]bool) {
+	w.removeRemote(file.Path, affected)
+	w.remote[file.Path] = file
+	if file.Id != "" {
+		w.remoteIds[file.Id] = file.Path
+	}
+	indexByHash(w.remoteByHash, file)
+	w.affectPossibleMatches(file, affected)
+	affected[file.Path] = true
+}
+
+func (w *watchState) removeRemote(filePath string, affected map[string]bool) {
+	file, ok := w.remote[filePath]
+	if !ok {
+		return
+	}
+	delete(w.remote, filePath)
+	if w.remoteIds[file.Id] == filePath {
+		delete(w.remoteIds, file.Id)
+	}
+	unindexByHash(w.remoteByHash, file)
+	w.affectPossibleMatches(file, affected)
+	affected[filePath] = true
+}
+
+func (w *watchState) setLocal(file *File, affected map[string]bool) {
+	w.removeLocal(file.Path, affected)
+	w.local[file.Path] = file
+	indexByHash(w.localByHash, file)
+	w.affectPossibleMatches(file, affected)
+	affected[file.Path] = true
+}
+
+func (w *watchState) removeLocal(filePath string, affected map[string]bool) {
+	file, ok := w.local[filePath]
+	if !ok {
+		return
+	}
+	delete(w.local, filePath)
+	unindexByHash(w.localByHash, file)
+	w.affectPossibleMatches(file, affected)
+	affected[filePath] = true
+}
+
+func indexByHash(index map[string]map[string]bool, file *File) {
+	if file.ContentHash == "" {
+		return
+	}
+	if index[file.ContentHash] == nil {
+		index[file.ContentHash] = make(map[string]bool)
+	}
+	index[file.ContentHash][file.Path] = true
+}
+
+func unindexByHash(index map[string]map[string]bool, file *File) {
+	if paths := index[file.ContentHash]; paths != nil {
+		delete(paths, file.Path)
+		if len(paths) == 0 {
+			delete(index, file.ContentHash)
+		}
+	}
+}
+
+// affectPossibleMatches marks the paths of files with the same contents as
+// affected, since a file appearing or going away can make or break their
+// possible match with it
+func (w *watchState) affectPossibleMatches(file *File, affected map[string]bool) {
+	if file.ContentHash == "" {
+		return
+	}
+	for filePath := range w.remoteByHash[file.ContentHash] {
+		affected[filePath] = true
+	}
+	for filePath := range w.localByHash[file.ContentHash] {
+		affected[filePath] = true
+	}
+}
+
+// classify reports a path's status as a full comparison would, including
+// acknowledgements from the baseline and files with extended attributes
+func (w *watchState) classify(filePath string) FileStatus {
+	remote, local := w.remote[filePath], w.local[filePath]
+	status := w.compare(filePath, remote, local)
+	switch status {
+	case StatusOnlyLocal, StatusOnlyRemote, StatusMismatch, StatusEmptyLocal:
+		if w.config.Baseline != nil && w.config.Baseline.covers(&FileResult{Path: filePath, Status: status, Remote: remote, Local: local}) {
+			return StatusAcknowledged
+		}
+	}
+	if local != nil && len(local.Xattrs) > 0 {
+		return StatusXattrs
+	}
+	return status
+}
+
+// compare classifies the files at a path the way compareManifests does
+func (w *watchState) compare(filePath string, remote, local *File) FileStatus {
+	switch {
+	case remote != nil && local != nil:
+		if w.config.Local.Strategies.matches(remote, local) {
+			return StatusMatch
+		}
+		if isEmptyLocal(remote, local) {
+			return StatusEmptyLocal
+		}
+		if isHashUnavailable(remote) {
+			return StatusHashUnavailable
+		}
+		if isUnhashedPlaceholder(remote, local) {
+			return StatusPlaceholder
+		}
+		return StatusMismatch
+	case remote != nil:
+		if w.knownSyncIssue(filePath) {
+			return StatusKnownIssue
+		}
+		for localPath := range w.localByHash[remote.ContentHash] {
+			if w.remote[localPath] == nil && isPossibleMatch(remote, w.local[localPath], w.config.Local.PathRules) {
+				return StatusPossibleMatch
+			}
+		}
+		return StatusOnlyRemote
+	case local != nil:
+		for remotePath := range w.remoteByHash[local.ContentHash] {
+			if w.local[remotePath] == nil && !w.knownSyncIssue(remotePath) && isPossibleMatch(w.remote[remotePath], local, w.config.Local.PathRules) {
+				return StatusPossibleMatch
+			}
+		}
+		return StatusOnlyLocal
+	}
+	return ""
+}
+
+func (w *watchState) knownSyncIssue(filePath string) bool {
+	return w.config.Local.PathRules.knownSyncIssues() && hasKnownSyncIssue(filePath)
+}
+
+// runWatch keeps verifying after the initial run, re-checking paths affected
+// by local filesystem events and Drive changes every interval and reporting
+// any drift. It only returns on setup errors.
+func runWatch(config *verifyConfig, listing *DriveListing, pageToken string, mc *ManifestComparison, interval time.Duration) error {
+	state := newWatchState(config, listing, mc)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	roots := []string{config.LocalRoot}
+	if len(config.LocalDirs) > 0 {
+		roots = nil
+		for _, dir := range config.LocalDirs {
+			roots = append(roots, filepath.Join(config.LocalRoot, dir))
+		}
+	}
+	for _, root := range roots {
+		if err := addWatches(watcher, root, config.Local.PathRules); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nWatching for changes every %s (Ctrl-C to stop)...\n", interval)
+	pendingLocal := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			pendingLocal[event.Name] = true
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+		case <-ticker.C:
+			affected := make(map[string]bool)
+			for entryPath := range pendingLocal {
+				state.applyLocalChange(watcher, entryPath, affected)
+			}
+			pendingLocal = make(map[string]bool)
+
+			changes, nextToken, err := listing.Changes(pageToken)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to list remote changes: %v\n", err)
+			} else {
+				pageToken = nextToken
+				state.applyRemoteChanges(changes, affected)
+			}
+
+			state.reportDrift(affected)
+		}
+	}
+}
+
+func addWatches(watcher *fsnotify.Watcher, root string, rules *pathRules) error {
+	return filepath.Walk(root, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if skipLocalDir(entryPath, rules) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(entryPath)
+		}
+		return nil
+	})
+}
+
+func (w *watchState) applyLocalChange(watcher *fsnotify.Watcher, entryPath string, affected map[string]bool) {
+	localRootLowercase := strings.ToLower(w.config.LocalRoot)
+	_, filteredPath, err := localManifestPath(localRootLowercase, entryPath, w.config.Local.PathRules)
+	if err != nil {
+		return
+	}
+
+	info, err := os.Lstat(entryPath)
+	if err != nil {
+		// removed: drop the path and anything that was under it
+		for localPath := range w.local {
+			if localPath == filteredPath || strings.HasPrefix(localPath, filteredPath+"/") {
+				w.removeLocal(localPath, affected)
+			}
+		}
+		return
+	}
+
+	if info.IsDir() {
+		if skipLocalDir(entryPath, w.config.Local.PathRules) {
+			return
+		}
+		// new directory: watch it and pick up anything already inside
+		addWatches(watcher, entryPath, w.config.Local.PathRules)
+		filepath.Walk(entryPath, func(subPath string, subInfo os.FileInfo, err error) error {
+			if err == nil && subInfo.Mode().IsRegular() {
+				w.updateLocalFile(subPath, subInfo, affected)
+			}
+			return nil
+		})
+		return
+	}
+	if info.Mode().IsRegular() {
+		w.updateLocalFile(entryPath, info, affected)
+	}
+}
+
+func (w *watchState) updateLocalFile(entryPath string, info os.FileInfo, affected map[string]bool) {
+	if skipLocalFile(entryPath, w.config.Local.PathRules) {
+		return
+	}
+	file, fileErr := processLocalFile(context.Background(), strings.ToLower(w.config.LocalRoot), w.config.Local, &localEntry{Path: entryPath, Info: info})
+	if fileErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fileErr.Path, fileErr.Error)
+		return
+	}
+	if file == nil {
+		return
+	}
+	w.setLocal(file, affected)
+}
+
+func (w *watchState) applyRemoteChanges(changes []*remoteChange, affected map[string]bool) {
+	folderChanged := false
+	for _, change := range changes {
+		if change.FolderChanged {
+			folderChanged = true
+			continue
+		}
+		if oldPath, ok := w.remoteIds[change.Id]; ok {
+			w.removeRemote(oldPath, affected)
+		}
+		// the sample, filters and comparison strategies apply as to a listing
+		if change.File != nil && w.config.includesRemote(change.File) {
+			w.setRemote(change.File, affected)
+		}
+	}
+	if folderChanged {
+		fmt.Println("Remote folders were renamed or moved; run a full verification to re-check their contents.")
+	}
+}
+
+func (w *watchState) reportDrift(affected map[string]bool) {
+	var paths []string
+	for filePath := range affected {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	timestamp := time.Now().Format("15:04:05")
+	for _, filePath := range paths {
+		oldStatus := w.status[filePath]
+		newStatus := w.classify(filePath)
+		if oldStatus == newStatus {
+			continue
+		}
+		fmt.Printf("[%s] %s: %s -> %s\n", timestamp, filePath, describeStatus(oldStatus), describeStatus(newStatus))
+		if newStatus == "" {
+			delete(w.status, filePath)
+		} else {
+			w.status[filePath] = newStatus
+		}
+	}
+}
+
+func describeStatus(status FileStatus) string {
+	if status == "" {
+		return "absent"
+	}
+	return string(status)
+}
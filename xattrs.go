@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Google Drive keeps only file contents, so extended attributes (including
+// macOS resource forks and Finder info) are lost when a file round-trips
+// through Drive. With --check-xattrs, local files carrying attributes that
+// matter are listed in an informational section. listXattrs is implemented
+// per platform.
+
+// ignoredXattrPrefixes are attributes that are transient, recreated by the
+// system, or Drive's own bookkeeping
+var ignoredXattrPrefixes = []string{
+	"com.apple.quarantine",
+	"com.apple.lastuseddate",
+	"com.apple.macl",
+	"com.apple.provenance",
+	"com.apple.metadata:kMDItemWhereFroms",
+	"com.apple.metadata:kMDItemDownloadedDate",
+	"com.google.drivefs.",
+	"security.",
+	"system.",
+}
+
+// xattrFinderInfo is often present but all zeros, which means nothing
+const xattrFinderInfo = "com.apple.FinderInfo"
+
+func ignoredXattr(name string) bool {
+	for _, prefix := range ignoredXattrPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// xattrSummary describes attributes for display, e.g.
+// "com.apple.ResourceFork (12 kB)"
+func xattrSummary(attrs []fileXattr) string {
+	parts := make([]string, len(attrs))
+	for i, attr := range attrs {
+		parts[i] = fmt.Sprintf("%s (%s)", attr.Name, humanize.Bytes(uint64(attr.Size)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fileXattr is an extended attribute on a local file
+type fileXattr struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+func printXattrList(files []*File) {
+	fmt.Printf("Extended attributes not kept by Google Drive (not counted): %d\n\n", len(files))
+	for _, file := range files {
+		fmt.Printf("%s: %s\n", file.Path, xattrSummary(file.Xattrs))
+	}
+	if len(files) > 0 {
+		fmt.Print("\n\n")
+	}
+}
@@ -0,0 +1,47 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const xattrsSupported = true
+
+// listXattrs returns the extended attributes on a file that Google Drive
+// won't keep, skipping ignored ones
+func listXattrs(path string) ([]fileXattr, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []fileXattr
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || ignoredXattr(name) {
+			continue
+		}
+		attrSize, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		if name == xattrFinderInfo {
+			value := make([]byte, attrSize)
+			if _, err := unix.Getxattr(path, name, value); err != nil {
+				return nil, err
+			}
+			if len(bytes.Trim(value, "\x00")) == 0 {
+				continue
+			}
+		}
+		attrs = append(attrs, fileXattr{Name: name, Size: attrSize})
+	}
+	return attrs, nil
+}